@@ -0,0 +1,121 @@
+// Comando dlq lista, inspeciona e reenvia as mensagens do tópico de
+// dead-letter. Usa as mesmas variáveis de ambiente do serviço.
+//
+//	dlq partitions
+//	dlq list -partition 0 [-offset 0] [-limit 50]
+//	dlq show -partition 0 -offset 42
+//	dlq redrive -partition 0 -offset 42
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"golang-payment-microservice/config"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+const commandTimeout = 30 * time.Second
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+
+	cfg := config.Load()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	command, args := os.Args[1], os.Args[2:]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	partition := flags.Int("partition", 0, "partição do tópico de dead-letter")
+	offset := flags.Int64("offset", 0, "offset da mensagem (em list, o primeiro listado)")
+	limit := flags.Int("limit", 50, "número máximo de mensagens listadas")
+	flags.Parse(args)
+
+	// Sem produtor: a CLI só lê o tópico e o reenvio passa pelo outbox
+	dlq := queue.NewDeadLetterQueue(nil, cfg.Kafka.Brokers, cfg.Kafka.DeadLetterTopic)
+
+	var (
+		result interface{}
+		err    error
+	)
+
+	switch command {
+	case "partitions":
+		result, err = newService(dlq, nil, cfg, logger).Partitions(ctx)
+	case "list":
+		result, err = newService(dlq, nil, cfg, logger).List(ctx, *partition, *offset, *limit)
+	case "show":
+		result, err = newService(dlq, nil, cfg, logger).Get(ctx, *partition, *offset)
+	case "redrive":
+		dbPool, dbErr := connectDatabase(ctx, cfg)
+		if dbErr != nil {
+			fatal(dbErr)
+		}
+		defer dbPool.Close()
+
+		result, err = newService(dlq, repository.NewOutboxRepository(dbPool), cfg, logger).Redrive(ctx, *partition, *offset)
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fatal(err)
+	}
+}
+
+func newService(dlq queue.DeadLetterQueue, outbox repository.OutboxRepository, cfg *config.Config, logger *logrus.Logger) service.DeadLetterService {
+	return service.NewDeadLetterService(dlq, outbox, cfg.Kafka.Topic, logger)
+}
+
+func connectDatabase(ctx context.Context, cfg *config.Config) (*pgxpool.Pool, error) {
+	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
+		cfg.Database.User,
+		cfg.Database.Password,
+		cfg.Database.Host,
+		cfg.Database.Port,
+		cfg.Database.DBName,
+		cfg.Database.SSLMode,
+	)
+
+	dbPool, err := pgxpool.New(ctx, dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := dbPool.Ping(ctx); err != nil {
+		dbPool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return dbPool, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dlq <partitions|list|show|redrive> [-partition N] [-offset N] [-limit N]")
+	os.Exit(2)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
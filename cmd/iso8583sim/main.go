@@ -0,0 +1,48 @@
+// Comando iso8583sim simula o emissor da rede ISO 8583, para rodar localmente
+// o fluxo do gateway iso8583 (ACQUIRER_GATEWAY=iso8583). As autorizações
+// seguem os cartões e valores mágicos do sandbox.
+//
+//	iso8583sim [-addr :8583] [-latency fast]
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang-payment-microservice/internal/gateway"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	addr := flag.String("addr", ":8583", "endereço TCP em que o emissor escuta")
+	latency := flag.String("latency", string(gateway.LatencyFast), "perfil de latência: none, fast, normal ou slow")
+	flag.Parse()
+
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+
+	profile, err := gateway.ParseSandboxLatency(*latency)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid latency profile")
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to listen")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.WithField("address", listener.Addr().String()).Info("ISO 8583 issuer simulator listening")
+
+	issuer := gateway.NewISO8583Issuer(logger, gateway.WithIssuerLatency(profile))
+	if err := issuer.Serve(ctx, listener); err != nil {
+		logger.WithError(err).Fatal("ISO 8583 issuer simulator stopped")
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,10 +12,17 @@ import (
 	"time"
 
 	"golang-payment-microservice/config"
+	"golang-payment-microservice/internal/gateway"
 	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/installment"
+	"golang-payment-microservice/internal/kms"
+	"golang-payment-microservice/internal/metrics"
+	"golang-payment-microservice/internal/model"
 	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/redact"
 	"golang-payment-microservice/internal/repository"
 	"golang-payment-microservice/internal/service"
+	"golang-payment-microservice/internal/vault"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -26,6 +35,10 @@ func main() {
 	logger.SetFormatter(&logrus.JSONFormatter{})
 	logger.SetLevel(logrus.InfoLevel)
 
+	// Remover PANs e CVVs de todas as mensagens e campos de log
+	logger.AddHook(redact.NewHook())
+	logrus.AddHook(redact.NewHook())
+
 	// Carregar configurações
 	cfg := config.Load()
 	logger.Info("Configuration loaded successfully")
@@ -52,21 +65,102 @@ func main() {
 	}
 	logger.Info("Database connection established")
 
-	// Inicializar repositório
-	paymentRepo := repository.NewPaymentRepository(dbPool)
+	// Contexto dos jobs em background, cancelado no shutdown
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	// Carregar chaves mestras e inicializar o gerenciador de chaves
+	keyfile, err := cfg.LoadKeyfile()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load keyfile")
+	}
+
+	keyManager, err := kms.NewKeyManager(context.Background(), repository.NewDataKeyRepository(dbPool), keyfile,
+		kms.WithLegacyKey(cfg.KMS.LegacyVaultKey))
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize key manager")
+	}
+	logger.WithField("key_id", keyManager.ActiveKeyID()).Info("Key manager initialized")
+
+	// Inicializar repositórios
+	paymentRepo := repository.NewPaymentRepository(dbPool, keyManager)
+	cardRepo := repository.NewCardRepository(dbPool)
+	idempotencyRepo := repository.NewIdempotencyRepository(dbPool)
+
+	// Inicializar cofre de cartões
+	cardVault := vault.NewCardVault(cardRepo, keyManager)
+	// CVVs só em memória, até a autorização do pagamento
+	cvvCache := vault.NewCVVCache(cfg.Vault.CVVTTL)
 
 	// Inicializar produtor Kafka
-	kafkaProducer := queue.NewKafkaProducer(cfg.Kafka.Brokers, cfg.Kafka.Topic, logger)
+	kafkaProducer := queue.NewKafkaProducer(cfg.Kafka.Brokers, logger)
 	defer kafkaProducer.Close()
 
+	// Gateway do adquirente que autoriza os pagamentos
+	acquirer, err := newAcquirerGateway(cfg.Acquirer, repository.NewTraceNumberRepository(dbPool), logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize acquirer gateway")
+	}
+	if closer, ok := acquirer.(io.Closer); ok {
+		defer closer.Close()
+	}
+	logger.WithField("gateway", acquirer.Name()).Info("Acquirer gateway initialized")
+
+	// Regras de parcelamento dos merchants sem regras próprias
+	installmentRules := model.InstallmentRules{
+		MaxInstallments:          cfg.Installments.MaxInstallments,
+		MinInstallmentAmount:     model.NewMoney(cfg.Installments.MinInstallmentAmount, installment.Currency),
+		InterestFreeInstallments: cfg.Installments.InterestFreeInstallments,
+		Interest:                 model.InstallmentInterest(cfg.Installments.Interest),
+		MonthlyRateBPS:           int64(cfg.Installments.MonthlyRateBPS),
+	}
+	if err := installmentRules.Validate(); err != nil {
+		logger.WithError(err).Fatal("Invalid default installment rules")
+	}
+
 	// Inicializar serviço
-	paymentService := service.NewPaymentService(paymentRepo, kafkaProducer, logger)
+	paymentService := service.NewPaymentService(paymentRepo, cardVault, logger,
+		service.WithAcquirer(acquirer),
+		service.WithPaymentTopic(cfg.Kafka.Topic),
+		service.WithHoldTTL(cfg.Holds.TTL),
+		service.WithAuthorizationTTL(cfg.Authorizations.TTL),
+		service.WithCVVCache(cvvCache),
+		service.WithInstallmentRules(installmentRules),
+		service.WithMerchantFee(int64(cfg.Ledger.MerchantFeeBPS)),
+		service.WithMaxAttempts(cfg.Kafka.Retry.MaxAttempts))
+
+	// Dead-letter das mensagens que não puderam ser processadas
+	deadLetterQueue := queue.NewDeadLetterQueue(kafkaProducer, cfg.Kafka.Brokers, cfg.Kafka.DeadLetterTopic)
+	deadLetterService := service.NewDeadLetterService(deadLetterQueue, paymentRepo, cfg.Kafka.Topic, logger)
+
+	// Novas tentativas de falhas transitórias pelos tópicos de retry
+	retryPolicy := queue.RetryPolicy{
+		MaxAttempts: cfg.Kafka.Retry.MaxAttempts,
+		BaseDelay:   cfg.Kafka.Retry.BaseDelay,
+		MaxDelay:    cfg.Kafka.Retry.MaxDelay,
+		Jitter:      cfg.Kafka.Retry.Jitter,
+		Tiers:       cfg.Kafka.Retry.Tiers,
+	}
+	retryQueue, err := queue.NewRetryQueue(kafkaProducer, cfg.Kafka.Topic, retryPolicy)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid retry policy")
+	}
 
 	// Inicializar consumidor Kafka
-	kafkaConsumer := queue.NewKafkaConsumer(cfg.Kafka.Brokers, cfg.Kafka.Topic, "payment-processor", paymentService, logger)
+	kafkaConsumer := queue.NewKafkaConsumer(cfg.Kafka.Brokers, cfg.Kafka.Topic, "payment-processor", paymentService, logger,
+		queue.WithWorkers(cfg.Kafka.ConsumerWorkers, cfg.Kafka.ConsumerQueueSize),
+		queue.WithProcessingTimeout(cfg.Kafka.ProcessingTimeout),
+		queue.WithLoadStats(metrics.RecordConsumerLoad),
+		queue.WithDeadLetterQueue(deadLetterQueue),
+		queue.WithDeadLetterStats(metrics.RecordDeadLetter),
+		queue.WithRetryQueue(retryQueue),
+		queue.WithRetryStats(metrics.RecordRetryScheduled))
 
 	// Inicializar handler HTTP
-	httpHandler := handler.NewHTTPHandler(paymentService, logger)
+	httpHandler := handler.NewHTTPHandler(paymentService, logger,
+		handler.WithIdempotency(idempotencyRepo, keyManager, cfg.Idempotency.TTL),
+		handler.WithIdempotencyLease(cfg.Idempotency.Lease),
+		handler.WithDeadLetters(deadLetterService))
 	router := httpHandler.SetupRoutes()
 
 	// Servidor HTTP
@@ -103,34 +197,127 @@ func main() {
 	// Iniciar consumidor Kafka
 	go func() {
 		logger.Info("Starting Kafka consumer")
-		if err := kafkaConsumer.Start(context.Background()); err != nil {
+		if err := kafkaConsumer.Start(jobsCtx); err != nil && !errors.Is(err, context.Canceled) {
 			logger.WithError(err).Error("Kafka consumer stopped with error")
 		}
 	}()
 
+	// Encaminhadores dos tópicos de retry de volta ao tópico principal
+	for _, tier := range retryPolicy.Tiers {
+		forwarder := queue.NewRetryForwarder(cfg.Kafka.Brokers, queue.RetryTopic(cfg.Kafka.Topic, tier),
+			"payment-processor-retry", cfg.Kafka.Topic, kafkaProducer, logger)
+		defer forwarder.Close()
+
+		go func() {
+			if err := forwarder.Start(jobsCtx); err != nil && !errors.Is(err, context.Canceled) {
+				logger.WithError(err).Error("Retry forwarder stopped with error")
+			}
+		}()
+	}
+
+	// Job de recifragem e rotação de chaves
+	reencryptor := kms.NewReencryptor(keyManager, repository.NewRotationTargets(dbPool),
+		cfg.KMS.ReencryptInterval, cfg.KMS.ReencryptBatchSize, cfg.KMS.DataKeyMaxAge, logger)
+	reencryptor.OnProgress(metrics.RecordRowsReencrypted)
+	go reencryptor.Start(jobsCtx)
+
+	// Job de expiração de reservas de saldo
+	holdExpirer := service.NewHoldExpirer(paymentRepo, cfg.Holds.ExpiryInterval, cfg.Holds.ExpiryBatchSize, logger)
+	holdExpirer.OnExpired(func(hold *model.Hold) {
+		metrics.RecordHoldExpired(hold)
+		cvvCache.Release(hold.PaymentID)
+	})
+	go holdExpirer.Start(jobsCtx)
+
+	// Job de expiração de autorizações de captura manual
+	authorizationExpirer := service.NewAuthorizationExpirer(paymentRepo, acquirer, cfg.Authorizations.ExpiryInterval,
+		cfg.Authorizations.ExpiryBatchSize, logger)
+	authorizationExpirer.OnExpired(metrics.RecordAuthorizationExpired)
+	go authorizationExpirer.Start(jobsCtx)
+
+	// Job de pagamentos parados em pending ou processing
+	paymentReaper := service.NewPaymentReaper(paymentRepo, cfg.Kafka.Topic, cfg.Reaper.Interval, cfg.Reaper.BatchSize,
+		cfg.Reaper.PendingAfter, cfg.Reaper.ProcessingAfter, cfg.Reaper.MaxRequeues, logger)
+	paymentReaper.OnReaped(func(event *model.PaymentEvent) {
+		metrics.RecordPaymentReaped(event)
+		if event.ToStatus.IsTerminal() {
+			cvvCache.Release(event.PaymentID)
+		}
+	})
+	go paymentReaper.Start(jobsCtx)
+
+	// Job de expurgo de Idempotency-Keys expiradas
+	idempotencyPurger := service.NewIdempotencyPurger(idempotencyRepo, cfg.Idempotency.PurgeInterval,
+		cfg.Idempotency.PurgeBatchSize, logger)
+	go idempotencyPurger.Start(jobsCtx)
+
+	// Relay do outbox para o Kafka
+	outboxRelay := service.NewOutboxRelay(paymentRepo, kafkaProducer, cfg.Outbox.RelayInterval,
+		cfg.Outbox.BatchSize, cfg.Outbox.MaxBackoff, cfg.Outbox.MaxAttempts, cfg.Outbox.Retention, logger)
+	outboxRelay.OnBacklog(metrics.RecordOutboxBacklog)
+	go outboxRelay.Start(jobsCtx)
+
 	logger.Info("Payment microservice started successfully")
 
 	// Aguardar sinal de parada
 	<-quit
 	logger.Info("Shutting down servers...")
 
+	// Parar jobs em background e a busca de mensagens do Kafka
+	cancelJobs()
+
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Parar servidores
+	// Parar de aceitar requisições
 	if err := httpServer.Shutdown(ctx); err != nil {
 		logger.WithError(err).Error("HTTP server forced to shutdown")
 	}
 
-	if err := metricsServer.Shutdown(ctx); err != nil {
-		logger.WithError(err).Error("Metrics server forced to shutdown")
+	// Esperar os pagamentos em processamento; os interrompidos pelo prazo
+	// ficam sem commit e são retomados na reentrega
+	drainCtx, cancelDrain := context.WithTimeout(ctx, cfg.Kafka.ShutdownTimeout)
+	defer cancelDrain()
+	if err := kafkaConsumer.Shutdown(drainCtx); err != nil {
+		logger.WithError(err).Error("Failed to close Kafka consumer")
 	}
 
-	// Fechar consumidor Kafka
-	if err := kafkaConsumer.Close(); err != nil {
-		logger.WithError(err).Error("Failed to close Kafka consumer")
+	// Métricas por último, para acompanhar o drain
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		logger.WithError(err).Error("Metrics server forced to shutdown")
 	}
 
 	logger.Info("Payment microservice stopped")
-}
\ No newline at end of file
+}
+
+// newAcquirerGateway cria o gateway do adquirente escolhido em
+// ACQUIRER_GATEWAY. traceNumbers só é usado pelo ISO 8583
+func newAcquirerGateway(cfg config.AcquirerConfig, traceNumbers gateway.TraceNumberSource, logger *logrus.Logger) (gateway.AcquirerGateway, error) {
+	switch cfg.Gateway {
+	case gateway.SimulatorName:
+		return gateway.NewSimulator(
+			gateway.WithLatency(cfg.Simulator.MinLatency, cfg.Simulator.MaxLatency),
+			gateway.WithFailureRate(cfg.Simulator.FailureRate),
+			gateway.WithDeclineRate(cfg.Simulator.DeclineRate),
+		), nil
+	case gateway.SandboxName:
+		latency, err := gateway.ParseSandboxLatency(cfg.Sandbox.Latency)
+		if err != nil {
+			return nil, err
+		}
+		return gateway.NewSandbox(
+			gateway.WithLatencyProfile(latency),
+			gateway.WithSandboxTimeout(cfg.Sandbox.Timeout),
+		), nil
+	case gateway.ISO8583Name:
+		return gateway.NewISO8583Gateway(cfg.ISO8583.Address, logger,
+			gateway.WithDialTimeout(cfg.ISO8583.DialTimeout),
+			gateway.WithResponseTimeout(cfg.ISO8583.ResponseTimeout),
+			gateway.WithTerminalID(cfg.ISO8583.TerminalID),
+			gateway.WithTraceNumbers(traceNumbers),
+		), nil
+	default:
+		return nil, fmt.Errorf("unknown acquirer gateway %q", cfg.Gateway)
+	}
+}
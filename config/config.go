@@ -3,17 +3,32 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	Kafka    KafkaConfig
-	Metrics  MetricsConfig
+	// Env é o ambiente do serviço (APP_ENV). Só development e sandbox
+	// aceitam o keyfile de desenvolvimento versionado no repositório
+	Env            string
+	Server         ServerConfig
+	Database       DatabaseConfig
+	Redis          RedisConfig
+	Kafka          KafkaConfig
+	Metrics        MetricsConfig
+	Vault          VaultConfig
+	KMS            KMSConfig
+	Holds          HoldsConfig
+	Authorizations AuthorizationsConfig
+	Installments   InstallmentsConfig
+	Ledger         LedgerConfig
+	Idempotency    IdempotencyConfig
+	Outbox         OutboxConfig
+	Reaper         ReaperConfig
+	Acquirer       AcquirerConfig
 }
 
 type ServerConfig struct {
@@ -39,8 +54,22 @@ type RedisConfig struct {
 }
 
 type KafkaConfig struct {
-	Brokers []string
-	Topic   string
+	Brokers           []string
+	Topic             string
+	DeadLetterTopic   string
+	ConsumerWorkers   int
+	ConsumerQueueSize int
+	ProcessingTimeout time.Duration
+	ShutdownTimeout   time.Duration
+	Retry             RetryConfig
+}
+
+type RetryConfig struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+	Tiers       []time.Duration
 }
 
 type MetricsConfig struct {
@@ -48,6 +77,104 @@ type MetricsConfig struct {
 	Path string
 }
 
+type VaultConfig struct {
+	CVVTTL time.Duration
+}
+
+type KMSConfig struct {
+	KeyfilePath        string
+	LegacyVaultKey     string
+	DataKeyMaxAge      time.Duration
+	ReencryptInterval  time.Duration
+	ReencryptBatchSize int
+}
+
+type HoldsConfig struct {
+	TTL             time.Duration
+	ExpiryInterval  time.Duration
+	ExpiryBatchSize int
+}
+
+// AuthorizationsConfig controla as autorizações de captura manual. TTL é o
+// prazo para a captura, depois do qual o job cancela a autorização
+type AuthorizationsConfig struct {
+	TTL             time.Duration
+	ExpiryInterval  time.Duration
+	ExpiryBatchSize int
+}
+
+// InstallmentsConfig são as regras de parcelamento dos merchants sem regras
+// próprias. MinInstallmentAmount é em centavos de BRL e MonthlyRateBPS em
+// pontos-base ao mês
+type InstallmentsConfig struct {
+	MaxInstallments          int
+	MinInstallmentAmount     int64
+	InterestFreeInstallments int
+	Interest                 string
+	MonthlyRateBPS           int
+}
+
+type LedgerConfig struct {
+	MerchantFeeBPS int
+}
+
+type IdempotencyConfig struct {
+	TTL            time.Duration
+	Lease          time.Duration
+	PurgeInterval  time.Duration
+	PurgeBatchSize int
+}
+
+type OutboxConfig struct {
+	RelayInterval time.Duration
+	BatchSize     int
+	MaxBackoff    time.Duration
+	MaxAttempts   int
+	Retention     time.Duration
+}
+
+// ReaperConfig controla o job de pagamentos parados. ProcessingAfter deve
+// passar da maior espera entre tentativas (KAFKA_RETRY_MAX_DELAY), para que
+// um pagamento aguardando retry não seja tratado como parado
+type ReaperConfig struct {
+	Interval        time.Duration
+	BatchSize       int
+	PendingAfter    time.Duration
+	ProcessingAfter time.Duration
+	MaxRequeues     int
+}
+
+// AcquirerConfig escolhe o gateway do adquirente e configura cada implementação
+type AcquirerConfig struct {
+	Gateway   string
+	Simulator SimulatorConfig
+	Sandbox   SandboxConfig
+	ISO8583   ISO8583Config
+}
+
+// SimulatorConfig configura o adquirente simulado
+type SimulatorConfig struct {
+	MinLatency  time.Duration
+	MaxLatency  time.Duration
+	FailureRate float64
+	DeclineRate float64
+}
+
+// SandboxConfig configura o adquirente de testes determinístico
+type SandboxConfig struct {
+	// Latency é o perfil de latência: none, fast, normal ou slow
+	Latency string
+	Timeout time.Duration
+}
+
+// ISO8583Config configura o gateway que fala ISO 8583 com a rede por TCP
+type ISO8583Config struct {
+	Address         string
+	DialTimeout     time.Duration
+	ResponseTimeout time.Duration
+	TerminalID      string
+}
+
 func Load() *Config {
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
@@ -57,6 +184,7 @@ func Load() *Config {
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
 
 	return &Config{
+		Env: getEnv("APP_ENV", "production"),
 		Server: ServerConfig{
 			HTTPPort: getEnv("HTTP_PORT", "8080"),
 			GRPCPort: getEnv("GRPC_PORT", "9090"),
@@ -77,13 +205,94 @@ func Load() *Config {
 			DB:       redisDB,
 		},
 		Kafka: KafkaConfig{
-			Brokers: []string{getEnv("KAFKA_BROKERS", "localhost:9092")},
-			Topic:   getEnv("KAFKA_TOPIC", "payment-processing"),
+			Brokers:           []string{getEnv("KAFKA_BROKERS", "localhost:9092")},
+			Topic:             getEnv("KAFKA_TOPIC", "payment-processing"),
+			DeadLetterTopic:   getEnv("KAFKA_DLQ_TOPIC", "payment-processing.dlq"),
+			ConsumerWorkers:   getIntEnv("KAFKA_CONSUMER_WORKERS", 8),
+			ConsumerQueueSize: getIntEnv("KAFKA_CONSUMER_QUEUE_SIZE", 16),
+			ProcessingTimeout: getDurationEnv("KAFKA_PROCESSING_TIMEOUT", 30*time.Second),
+			ShutdownTimeout:   getDurationEnv("KAFKA_SHUTDOWN_TIMEOUT", 20*time.Second),
+			Retry: RetryConfig{
+				MaxAttempts: getIntEnv("KAFKA_RETRY_MAX_ATTEMPTS", 5),
+				BaseDelay:   getDurationEnv("KAFKA_RETRY_BASE_DELAY", 30*time.Second),
+				MaxDelay:    getDurationEnv("KAFKA_RETRY_MAX_DELAY", 5*time.Minute),
+				Jitter:      getFloatEnv("KAFKA_RETRY_JITTER", 0.2),
+				Tiers:       getDurationListEnv("KAFKA_RETRY_TIERS", []time.Duration{30 * time.Second, 5 * time.Minute}),
+			},
 		},
 		Metrics: MetricsConfig{
 			Port: getEnv("METRICS_PORT", "2112"),
 			Path: getEnv("METRICS_PATH", "/metrics"),
 		},
+		Vault: VaultConfig{
+			CVVTTL: getDurationEnv("VAULT_CVV_TTL", 15*time.Minute),
+		},
+		KMS: KMSConfig{
+			KeyfilePath:        getEnv("KMS_KEYFILE", ""),
+			LegacyVaultKey:     getEnv("VAULT_ENCRYPTION_KEY", ""),
+			DataKeyMaxAge:      getDurationEnv("KMS_DATA_KEY_MAX_AGE", 30*24*time.Hour),
+			ReencryptInterval:  getDurationEnv("KMS_REENCRYPT_INTERVAL", time.Minute),
+			ReencryptBatchSize: getIntEnv("KMS_REENCRYPT_BATCH_SIZE", 100),
+		},
+		Holds: HoldsConfig{
+			TTL:             getDurationEnv("HOLD_TTL", 30*time.Minute),
+			ExpiryInterval:  getDurationEnv("HOLD_EXPIRY_INTERVAL", time.Minute),
+			ExpiryBatchSize: getIntEnv("HOLD_EXPIRY_BATCH_SIZE", 100),
+		},
+		Authorizations: AuthorizationsConfig{
+			TTL:             getDurationEnv("AUTHORIZATION_TTL", 168*time.Hour),
+			ExpiryInterval:  getDurationEnv("AUTHORIZATION_EXPIRY_INTERVAL", time.Minute),
+			ExpiryBatchSize: getIntEnv("AUTHORIZATION_EXPIRY_BATCH_SIZE", 100),
+		},
+		Installments: InstallmentsConfig{
+			MaxInstallments:          getIntEnv("INSTALLMENTS_MAX", 12),
+			MinInstallmentAmount:     int64(getIntEnv("INSTALLMENTS_MIN_AMOUNT", 500)),
+			InterestFreeInstallments: getIntEnv("INSTALLMENTS_INTEREST_FREE", 12),
+			Interest:                 getEnv("INSTALLMENTS_INTEREST", "issuer"),
+			MonthlyRateBPS:           getIntEnv("INSTALLMENTS_MONTHLY_RATE_BPS", 199),
+		},
+		Ledger: LedgerConfig{
+			MerchantFeeBPS: getIntEnv("LEDGER_MERCHANT_FEE_BPS", 0),
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:            getDurationEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+			Lease:          getDurationEnv("IDEMPOTENCY_LEASE", 30*time.Second),
+			PurgeInterval:  getDurationEnv("IDEMPOTENCY_PURGE_INTERVAL", 10*time.Minute),
+			PurgeBatchSize: getIntEnv("IDEMPOTENCY_PURGE_BATCH_SIZE", 1000),
+		},
+		Outbox: OutboxConfig{
+			RelayInterval: getDurationEnv("OUTBOX_RELAY_INTERVAL", time.Second),
+			BatchSize:     getIntEnv("OUTBOX_BATCH_SIZE", 100),
+			MaxBackoff:    getDurationEnv("OUTBOX_MAX_BACKOFF", 5*time.Minute),
+			MaxAttempts:   getIntEnv("OUTBOX_MAX_ATTEMPTS", 50),
+			Retention:     getDurationEnv("OUTBOX_RETENTION", 72*time.Hour),
+		},
+		Reaper: ReaperConfig{
+			Interval:        getDurationEnv("REAPER_INTERVAL", time.Minute),
+			BatchSize:       getIntEnv("REAPER_BATCH_SIZE", 100),
+			PendingAfter:    getDurationEnv("REAPER_PENDING_AFTER", 10*time.Minute),
+			ProcessingAfter: getDurationEnv("REAPER_PROCESSING_AFTER", 15*time.Minute),
+			MaxRequeues:     getIntEnv("REAPER_MAX_REQUEUES", 3),
+		},
+		Acquirer: AcquirerConfig{
+			Gateway: getEnv("ACQUIRER_GATEWAY", "simulator"),
+			Simulator: SimulatorConfig{
+				MinLatency:  getDurationEnv("ACQUIRER_SIMULATOR_MIN_LATENCY", time.Second),
+				MaxLatency:  getDurationEnv("ACQUIRER_SIMULATOR_MAX_LATENCY", 4*time.Second),
+				FailureRate: getFloatEnv("ACQUIRER_SIMULATOR_FAILURE_RATE", 0.1),
+				DeclineRate: getFloatEnv("ACQUIRER_SIMULATOR_DECLINE_RATE", 0),
+			},
+			Sandbox: SandboxConfig{
+				Latency: getEnv("ACQUIRER_SANDBOX_LATENCY", "fast"),
+				Timeout: getDurationEnv("ACQUIRER_SANDBOX_TIMEOUT", 10*time.Second),
+			},
+			ISO8583: ISO8583Config{
+				Address:         getEnv("ACQUIRER_ISO8583_ADDRESS", "localhost:8583"),
+				DialTimeout:     getDurationEnv("ACQUIRER_ISO8583_DIAL_TIMEOUT", 5*time.Second),
+				ResponseTimeout: getDurationEnv("ACQUIRER_ISO8583_RESPONSE_TIMEOUT", 10*time.Second),
+				TerminalID:      getEnv("ACQUIRER_ISO8583_TERMINAL_ID", "PAYSVC01"),
+			},
+		},
 	}
 }
 
@@ -92,4 +301,53 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-} 
\ No newline at end of file
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+		logrus.WithField("key", key).Warn("Invalid integer, using default")
+	}
+	return defaultValue
+}
+
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+		logrus.WithField("key", key).Warn("Invalid number, using default")
+	}
+	return defaultValue
+}
+
+// getDurationListEnv lê uma lista de durações separadas por vírgula, como "30s,5m"
+func getDurationListEnv(key string, defaultValue []time.Duration) []time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var durations []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || duration <= 0 {
+			logrus.WithField("key", key).Warn("Invalid duration list, using default")
+			return defaultValue
+		}
+		durations = append(durations, duration)
+	}
+	return durations
+}
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+		logrus.WithField("key", key).Warn("Invalid duration, using default")
+	}
+	return defaultValue
+}
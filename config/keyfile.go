@@ -0,0 +1,48 @@
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+
+	"golang-payment-microservice/internal/kms"
+)
+
+// devKeyfile é o keyfile de desenvolvimento versionado no repositório. Suas
+// chaves são públicas e não podem proteger dados reais
+//
+//go:embed dev-keyfile.json
+var devKeyfile []byte
+
+var (
+	ErrKeyfileRequired = errors.New("KMS_KEYFILE is required")
+	ErrDevKeyfile      = errors.New("keyfile uses the development keys")
+)
+
+// IsDevelopment indica se o serviço roda em desenvolvimento ou sandbox
+func (c *Config) IsDevelopment() bool {
+	return c.Env == "development" || c.Env == "sandbox"
+}
+
+// LoadKeyfile lê o keyfile de KMS_KEYFILE. Um keyfile com alguma chave do
+// keyfile de desenvolvimento só é aceito com APP_ENV development ou sandbox
+func (c *Config) LoadKeyfile() (*kms.Keyfile, error) {
+	if c.KMS.KeyfilePath == "" {
+		return nil, ErrKeyfileRequired
+	}
+
+	keyfile, err := kms.LoadKeyfile(c.KMS.KeyfilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dev, err := kms.ParseKeyfile(devKeyfile)
+	if err != nil {
+		return nil, err
+	}
+	if keyfile.SharesKeys(dev) && !c.IsDevelopment() {
+		return nil, fmt.Errorf("%w: set APP_ENV=development or sandbox to use %s", ErrDevKeyfile, c.KMS.KeyfilePath)
+	}
+
+	return keyfile, nil
+}
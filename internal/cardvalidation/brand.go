@@ -0,0 +1,114 @@
+package cardvalidation
+
+// Brand representa a bandeira do cartão
+type Brand string
+
+const (
+	BrandUnknown    Brand = ""
+	BrandVisa       Brand = "visa"
+	BrandMastercard Brand = "mastercard"
+	BrandAmex       Brand = "amex"
+	BrandElo        Brand = "elo"
+	BrandHipercard  Brand = "hipercard"
+	BrandDiners     Brand = "diners"
+)
+
+// Rule define os comprimentos de PAN e CVV aceitos por uma bandeira
+type Rule struct {
+	PANLengths []int
+	CVVLength  int
+}
+
+var rules = map[Brand]Rule{
+	BrandVisa:       {PANLengths: []int{13, 16, 19}, CVVLength: 3},
+	BrandMastercard: {PANLengths: []int{16}, CVVLength: 3},
+	BrandAmex:       {PANLengths: []int{15}, CVVLength: 4},
+	BrandElo:        {PANLengths: []int{16}, CVVLength: 3},
+	BrandHipercard:  {PANLengths: []int{13, 16, 19}, CVVLength: 3},
+	BrandDiners:     {PANLengths: []int{14, 16, 19}, CVVLength: 3},
+}
+
+// RuleFor retorna as regras da bandeira
+func RuleFor(brand Brand) (Rule, bool) {
+	rule, ok := rules[brand]
+	return rule, ok
+}
+
+// binRange é um intervalo inclusivo de prefixos de mesmo comprimento
+type binRange struct {
+	low   string
+	high  string
+	brand Brand
+}
+
+// binRanges é avaliada em ordem: faixas mais específicas (Elo, Hipercard)
+// vêm antes das genéricas de Visa e Mastercard, com as quais se sobrepõem
+var binRanges = []binRange{
+	// Elo
+	{"401178", "401179", BrandElo},
+	{"431274", "431274", BrandElo},
+	{"438935", "438935", BrandElo},
+	{"451416", "451416", BrandElo},
+	{"457393", "457393", BrandElo},
+	{"457631", "457632", BrandElo},
+	{"504175", "504175", BrandElo},
+	{"506699", "506778", BrandElo},
+	{"509000", "509999", BrandElo},
+	{"627780", "627780", BrandElo},
+	{"636297", "636297", BrandElo},
+	{"636368", "636368", BrandElo},
+	{"650031", "650033", BrandElo},
+	{"650035", "650051", BrandElo},
+	{"650405", "650439", BrandElo},
+	{"650485", "650538", BrandElo},
+	{"650541", "650598", BrandElo},
+	{"650700", "650718", BrandElo},
+	{"650720", "650727", BrandElo},
+	{"650901", "650978", BrandElo},
+	{"651652", "651679", BrandElo},
+	{"655000", "655019", BrandElo},
+	{"655021", "655058", BrandElo},
+
+	// Hipercard
+	{"384100", "384100", BrandHipercard},
+	{"384140", "384140", BrandHipercard},
+	{"384160", "384160", BrandHipercard},
+	{"606282", "606282", BrandHipercard},
+	{"637095", "637095", BrandHipercard},
+	{"637568", "637568", BrandHipercard},
+	{"637599", "637599", BrandHipercard},
+	{"637609", "637609", BrandHipercard},
+	{"637612", "637612", BrandHipercard},
+
+	// American Express
+	{"34", "34", BrandAmex},
+	{"37", "37", BrandAmex},
+
+	// Diners Club
+	{"300", "305", BrandDiners},
+	{"3095", "3095", BrandDiners},
+	{"36", "36", BrandDiners},
+	{"38", "39", BrandDiners},
+
+	// Mastercard
+	{"51", "55", BrandMastercard},
+	{"2221", "2720", BrandMastercard},
+
+	// Visa
+	{"4", "4", BrandVisa},
+}
+
+// DetectBrand identifica a bandeira pelo BIN; retorna BrandUnknown se
+// nenhuma faixa corresponder
+func DetectBrand(pan string) Brand {
+	for _, r := range binRanges {
+		if len(pan) < len(r.low) {
+			continue
+		}
+		prefix := pan[:len(r.low)]
+		if prefix >= r.low && prefix <= r.high {
+			return r.brand
+		}
+	}
+	return BrandUnknown
+}
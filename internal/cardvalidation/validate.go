@@ -0,0 +1,167 @@
+package cardvalidation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Reason identifica o motivo de uma falha de validação
+type Reason string
+
+const (
+	ReasonMissingNumber Reason = "missing_number"
+	ReasonNonNumeric    Reason = "non_numeric_number"
+	ReasonLuhnFailed    Reason = "luhn_check_failed"
+	ReasonUnknownBrand  Reason = "unknown_brand"
+	ReasonInvalidLength Reason = "invalid_pan_length"
+	ReasonInvalidCVV    Reason = "invalid_cvv"
+	ReasonInvalidExpiry Reason = "invalid_expiry_date"
+	ReasonExpired       Reason = "card_expired"
+)
+
+// Failure descreve uma falha de validação
+type Failure struct {
+	Reason  Reason `json:"reason"`
+	Message string `json:"message"`
+}
+
+// ValidationError agrupa todas as falhas encontradas em um cartão
+type ValidationError struct {
+	Brand    Brand
+	Failures []Failure
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		reasons = append(reasons, string(f.Reason))
+	}
+	return "card validation failed: " + strings.Join(reasons, ", ")
+}
+
+// HasReason verifica se a validação falhou pelo motivo informado
+func (e *ValidationError) HasReason(reason Reason) bool {
+	for _, f := range e.Failures {
+		if f.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// Luhn verifica o dígito verificador do PAN (mod 10)
+func Luhn(pan string) bool {
+	if pan == "" {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(pan) - 1; i >= 0; i-- {
+		d := int(pan[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// ValidatePAN valida o número do cartão e retorna a bandeira detectada
+func ValidatePAN(pan string) (Brand, []Failure) {
+	if pan == "" {
+		return BrandUnknown, []Failure{{ReasonMissingNumber, "card number is required"}}
+	}
+
+	for _, r := range pan {
+		if r < '0' || r > '9' {
+			return BrandUnknown, []Failure{{ReasonNonNumeric, "card number must contain only digits"}}
+		}
+	}
+
+	var failures []Failure
+
+	brand := DetectBrand(pan)
+	rule, ok := RuleFor(brand)
+	if !ok {
+		failures = append(failures, Failure{ReasonUnknownBrand, "card brand is not supported"})
+	} else if !containsInt(rule.PANLengths, len(pan)) {
+		failures = append(failures, Failure{
+			ReasonInvalidLength,
+			fmt.Sprintf("%s card number must have %s digits", brand, joinInts(rule.PANLengths)),
+		})
+	}
+
+	if !Luhn(pan) {
+		failures = append(failures, Failure{ReasonLuhnFailed, "card number failed the Luhn check"})
+	}
+
+	return brand, failures
+}
+
+// ValidateCVV valida o código de segurança conforme a bandeira
+func ValidateCVV(brand Brand, cvv string) []Failure {
+	expected := 3
+	if rule, ok := RuleFor(brand); ok {
+		expected = rule.CVVLength
+	}
+
+	if len(cvv) != expected || strings.Trim(cvv, "0123456789") != "" {
+		return []Failure{{ReasonInvalidCVV, fmt.Sprintf("security code must have %d digits", expected)}}
+	}
+	return nil
+}
+
+// ValidateExpiry valida a data de expiração; o cartão vale até o fim do mês
+func ValidateExpiry(month, year int, now time.Time) []Failure {
+	if month < 1 || month > 12 || year < 1000 {
+		return []Failure{{ReasonInvalidExpiry, "expiry date is invalid"}}
+	}
+
+	if year < now.Year() || (year == now.Year() && month < int(now.Month())) {
+		return []Failure{{ReasonExpired, "card is expired"}}
+	}
+	return nil
+}
+
+// Validate executa todas as regras e retorna a bandeira detectada. Um CVV
+// vazio é ignorado quando requireCVV é falso (cartões já tokenizados)
+func Validate(pan, cvv string, month, year int, requireCVV bool, now time.Time) (Brand, error) {
+	brand, failures := ValidatePAN(pan)
+
+	if cvv != "" || requireCVV {
+		failures = append(failures, ValidateCVV(brand, cvv)...)
+	}
+	failures = append(failures, ValidateExpiry(month, year, now)...)
+
+	if len(failures) > 0 {
+		return brand, &ValidationError{Brand: brand, Failures: failures}
+	}
+	return brand, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, " or ")
+}
@@ -0,0 +1,39 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang-payment-microservice/internal/model"
+)
+
+// ErrUnavailable indica que o adquirente não respondeu ou não pôde processar
+// a operação. É uma falha transitória: a operação pode ser repetida
+var ErrUnavailable = errors.New("acquirer unavailable")
+
+var (
+	// ErrTimeout indica que o adquirente não respondeu dentro do prazo
+	ErrTimeout = fmt.Errorf("%w: acquirer timed out", ErrUnavailable)
+	// ErrNetwork indica uma falha de comunicação com o adquirente
+	ErrNetwork = fmt.Errorf("%w: network error", ErrUnavailable)
+)
+
+// AcquirerGateway envia as operações de um pagamento ao adquirente. Uma
+// recusa é uma resposta com Approved falso; erros indicam que não houve
+// resposta e que a operação pode ser repetida
+type AcquirerGateway interface {
+	// Name identifica o gateway, gravado junto da resposta no pagamento
+	Name() string
+	// Authorize reserva o valor no cartão junto ao emissor
+	Authorize(ctx context.Context, req *model.AuthorizationRequest) (*model.AcquirerResponse, error)
+	// IncrementAuthorization aumenta em req.Amount uma autorização aprovada e
+	// ainda não encerrada
+	IncrementAuthorization(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error)
+	// Capture efetiva uma autorização aprovada, no valor total ou parcial
+	Capture(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error)
+	// Void cancela uma autorização ou uma captura ainda não liquidada
+	Void(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error)
+	// Refund devolve ao cartão um valor já capturado
+	Refund(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error)
+}
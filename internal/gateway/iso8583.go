@@ -0,0 +1,511 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-payment-microservice/internal/iso8583"
+	"golang-payment-microservice/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ISO8583Name identifica o gateway ISO 8583 nas configurações e nos pagamentos
+const ISO8583Name = "iso8583"
+
+// posEntryEcommerce é o modo de entrada do campo 22 para PAN digitado em
+// comércio eletrônico
+const posEntryEcommerce = "812"
+
+// declineReasons descreve os códigos de recusa do campo 39
+var declineReasons = map[model.AcquirerResponseCode]string{
+	model.ResponseDoNotHonor:             "Do not honor",
+	model.ResponseStolenCard:             "Stolen card",
+	model.ResponseInsufficientFunds:      "Insufficient funds",
+	model.ResponseAuthenticationRequired: "Authentication required (3DS challenge)",
+	"14":                                 "Invalid card number",
+	"54":                                 "Expired card",
+}
+
+// transientCodes são as respostas em que o emissor não decidiu: a operação
+// pode ser repetida
+var transientCodes = map[model.AcquirerResponseCode]bool{
+	"91": true, // emissor indisponível
+	"96": true, // falha do sistema
+}
+
+// TraceNumberSource fornece os números de rastreio (STAN) dos pedidos, dos
+// quais também sai o RRN das autorizações. Réplicas que usam o mesmo terminal
+// precisam compartilhar a origem, ou dois pedidos sairiam com o mesmo STAN e
+// o mesmo RRN
+type TraceNumberSource interface {
+	// NextTraceNumber retorna o próximo número, de 1 a 999999
+	NextTraceNumber(ctx context.Context) (int64, error)
+}
+
+// localTraceNumbers conta os STANs em memória; só serve a uma réplica
+type localTraceNumbers struct {
+	mu   sync.Mutex
+	stan int64
+}
+
+func (l *localTraceNumbers) NextTraceNumber(ctx context.Context) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stan = l.stan%999999 + 1
+	return l.stan, nil
+}
+
+// ISO8583Gateway fala ISO 8583 com a rede por uma conexão TCP persistente.
+// Os pedidos são multiplexados na conexão e cada resposta é casada com o seu
+// pedido pelo STAN, conferindo o MTI e o RRN. A conexão é aberta na primeira
+// operação e reaberta na seguinte se cair
+type ISO8583Gateway struct {
+	address         string
+	dialTimeout     time.Duration
+	responseTimeout time.Duration
+	terminalID      string
+	traceNumbers    TraceNumberSource
+	spec            iso8583.Spec
+	logger          *logrus.Logger
+
+	mu      sync.Mutex
+	conn    net.Conn
+	pending map[string]*pendingRequest
+	closed  bool
+
+	writeMu sync.Mutex
+}
+
+// pendingRequest é um pedido esperando a resposta na conexão conn. O canal é
+// fechado sem resposta se a conexão cair
+type pendingRequest struct {
+	mti      string
+	rrn      string
+	conn     net.Conn
+	response chan *iso8583.Message
+}
+
+// ISO8583Option configura parâmetros opcionais do gateway ISO 8583
+type ISO8583Option func(*ISO8583Gateway)
+
+// WithDialTimeout define o prazo para abrir a conexão com a rede
+func WithDialTimeout(timeout time.Duration) ISO8583Option {
+	return func(g *ISO8583Gateway) {
+		if timeout > 0 {
+			g.dialTimeout = timeout
+		}
+	}
+}
+
+// WithResponseTimeout define quanto tempo cada pedido espera a resposta
+// antes de falhar com ErrTimeout
+func WithResponseTimeout(timeout time.Duration) ISO8583Option {
+	return func(g *ISO8583Gateway) {
+		if timeout > 0 {
+			g.responseTimeout = timeout
+		}
+	}
+}
+
+// WithTerminalID define o terminal enviado no campo 41
+func WithTerminalID(terminalID string) ISO8583Option {
+	return func(g *ISO8583Gateway) {
+		if terminalID != "" {
+			g.terminalID = terminalID
+		}
+	}
+}
+
+// WithTraceNumbers define a origem dos STANs, compartilhada entre as réplicas
+func WithTraceNumbers(source TraceNumberSource) ISO8583Option {
+	return func(g *ISO8583Gateway) {
+		if source != nil {
+			g.traceNumbers = source
+		}
+	}
+}
+
+// NewISO8583Gateway cria o gateway para a rede em address. Sem opções ele
+// espera 5 segundos pela conexão e 10 pelas respostas, e conta os STANs em
+// memória
+func NewISO8583Gateway(address string, logger *logrus.Logger, opts ...ISO8583Option) *ISO8583Gateway {
+	g := &ISO8583Gateway{
+		address:         address,
+		dialTimeout:     5 * time.Second,
+		responseTimeout: 10 * time.Second,
+		terminalID:      "PAYSVC01",
+		traceNumbers:    &localTraceNumbers{},
+		spec:            iso8583.DefaultSpec,
+		logger:          logger,
+		pending:         make(map[string]*pendingRequest),
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g
+}
+
+func (g *ISO8583Gateway) Name() string {
+	return ISO8583Name
+}
+
+// Authorize envia um 0100. Se o pedido foi escrito na conexão e a resposta
+// não chegou, seja por timeout, queda da conexão ou cancelamento do contexto,
+// a autorização pode ter sido aprovada no emissor sem que a saibamos: um 0400
+// a desfaz antes que a nova tentativa autorize o valor de novo
+func (g *ISO8583Gateway) Authorize(ctx context.Context, req *model.AuthorizationRequest) (*model.AcquirerResponse, error) {
+	if req.Card == nil {
+		return nil, errors.New("authorization request without card")
+	}
+
+	now := time.Now().UTC()
+	stan, err := g.nextSTAN(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := g.newRequest(iso8583.MTIAuthorizationRequest, iso8583.ProcessingPurchase, req.PaymentID.String(), req.Amount, now)
+	if err != nil {
+		return nil, err
+	}
+	msg.Set(iso8583.FieldSTAN, stan).
+		Set(iso8583.FieldRRN, retrievalReference(now, stan)).
+		Set(iso8583.FieldPAN, req.Card.Number).
+		Set(iso8583.FieldExpiry, fmt.Sprintf("%02d%02d", req.Card.ExpiryYear%100, req.Card.ExpiryMonth)).
+		Set(iso8583.FieldPOSEntryMode, posEntryEcommerce).
+		Set(iso8583.FieldMerchantID, truncate(req.MerchantID, 15))
+	// O número de parcelas vai no código de pagamento estendido; à vista o
+	// campo é omitido
+	if req.Installments != nil && req.Installments.Count > 1 {
+		msg.Set(iso8583.FieldExtendedPaymentCode, fmt.Sprintf("%02d", req.Installments.Count))
+	}
+
+	response, sent, err := g.exchange(ctx, msg)
+	if err != nil {
+		if sent {
+			go g.reverseUnanswered(context.WithoutCancel(ctx), msg)
+		}
+		return nil, err
+	}
+
+	return g.acquirerResponse(response)
+}
+
+// IncrementAuthorization envia um 0100 com o valor adicional sobre a
+// autorização identificada pelo RRN
+func (g *ISO8583Gateway) IncrementAuthorization(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return g.followUp(ctx, iso8583.MTIAuthorizationRequest, iso8583.ProcessingPurchase, req)
+}
+
+// Capture envia um 0200 sobre a autorização identificada pelo RRN
+func (g *ISO8583Gateway) Capture(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return g.followUp(ctx, iso8583.MTIFinancialRequest, iso8583.ProcessingPurchase, req)
+}
+
+// Void envia um 0400 que desfaz a autorização identificada pelo RRN
+func (g *ISO8583Gateway) Void(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return g.followUp(ctx, iso8583.MTIReversalRequest, iso8583.ProcessingPurchase, req)
+}
+
+// Refund envia um 0200 de devolução sobre a transação identificada pelo RRN,
+// com a referência do estorno no campo 48
+func (g *ISO8583Gateway) Refund(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return g.followUp(ctx, iso8583.MTIFinancialRequest, iso8583.ProcessingRefund, req)
+}
+
+// Close fecha a conexão com a rede. Pedidos em andamento falham com ErrNetwork
+func (g *ISO8583Gateway) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	conn := g.conn
+	g.mu.Unlock()
+
+	if conn != nil {
+		g.drop(conn, nil)
+	}
+	return nil
+}
+
+func (g *ISO8583Gateway) followUp(ctx context.Context, mti, processingCode string, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	stan, err := g.nextSTAN(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// O campo 48 leva a referência da operação, se houver, para a rede
+	// reconhecer a repetição de um pedido sem resposta
+	additionalData := req.PaymentID.String()
+	if req.Reference != "" {
+		additionalData = req.Reference
+	}
+	msg, err := g.newRequest(mti, processingCode, additionalData, req.Amount, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	msg.Set(iso8583.FieldSTAN, stan).
+		Set(iso8583.FieldRRN, req.NetworkReference)
+
+	response, _, err := g.exchange(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return g.acquirerResponse(response)
+}
+
+// reverseUnanswered desfaz uma autorização sem resposta. O 0400 leva no campo
+// 90 o MTI, o STAN e a data de transmissão do pedido original; se o emissor
+// nunca recebeu o pedido, ele recusa a reversão e nada muda
+func (g *ISO8583Gateway) reverseUnanswered(ctx context.Context, original *iso8583.Message) {
+	ctx, cancel := context.WithTimeout(ctx, g.responseTimeout)
+	defer cancel()
+
+	logger := g.logger.WithField("rrn", original.Get(iso8583.FieldRRN))
+
+	stan, err := g.nextSTAN(ctx)
+	if err != nil {
+		logger.WithError(err).Error("Failed to reverse unanswered authorization")
+		return
+	}
+
+	msg := iso8583.NewMessage(iso8583.MTIReversalRequest)
+	for _, field := range []int{iso8583.FieldProcessingCode, iso8583.FieldAmount, iso8583.FieldTransmissionDateTime,
+		iso8583.FieldLocalTime, iso8583.FieldLocalDate, iso8583.FieldRRN, iso8583.FieldTerminalID,
+		iso8583.FieldAdditionalData, iso8583.FieldCurrency} {
+		if original.Has(field) {
+			msg.Set(field, original.Get(field))
+		}
+	}
+	msg.Set(iso8583.FieldSTAN, stan).
+		Set(iso8583.FieldOriginalData, original.MTI+original.Get(iso8583.FieldSTAN)+
+			original.Get(iso8583.FieldTransmissionDateTime)+strings.Repeat("0", 22))
+
+	if _, _, err := g.exchange(ctx, msg); err != nil {
+		logger.WithError(err).Error("Failed to reverse unanswered authorization")
+		return
+	}
+	logger.Warn("Reversed unanswered authorization")
+}
+
+// newRequest monta os campos comuns a todos os pedidos
+func (g *ISO8583Gateway) newRequest(mti, processingCode, paymentID string, amount model.Money, now time.Time) (*iso8583.Message, error) {
+	currency, ok := iso8583.NumericCurrency(string(amount.Currency))
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", model.ErrUnknownCurrency, string(amount.Currency))
+	}
+
+	return iso8583.NewMessage(mti).
+		Set(iso8583.FieldProcessingCode, processingCode).
+		Set(iso8583.FieldAmount, fmt.Sprintf("%012d", amount.Value)).
+		Set(iso8583.FieldTransmissionDateTime, now.Format("0102150405")).
+		Set(iso8583.FieldLocalTime, now.Format("150405")).
+		Set(iso8583.FieldLocalDate, now.Format("0102")).
+		Set(iso8583.FieldTerminalID, g.terminalID).
+		Set(iso8583.FieldAdditionalData, paymentID).
+		Set(iso8583.FieldCurrency, currency), nil
+}
+
+// exchange envia o pedido e espera a resposta com o mesmo STAN. sent indica
+// se o pedido chegou a ser escrito na conexão, mesmo que a escrita tenha
+// falhado no meio: a partir daí a rede pode tê-lo recebido
+func (g *ISO8583Gateway) exchange(ctx context.Context, msg *iso8583.Message) (response *iso8583.Message, sent bool, err error) {
+	responseMTI, err := iso8583.ResponseMTI(msg.MTI)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := g.spec.Pack(msg)
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := g.connect(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	stan := msg.Get(iso8583.FieldSTAN)
+	request := &pendingRequest{
+		mti:      responseMTI,
+		rrn:      msg.Get(iso8583.FieldRRN),
+		conn:     conn,
+		response: make(chan *iso8583.Message, 1),
+	}
+
+	g.mu.Lock()
+	g.pending[stan] = request
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		if g.pending[stan] == request {
+			delete(g.pending, stan)
+		}
+		g.mu.Unlock()
+	}()
+
+	g.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(g.responseTimeout))
+	err = iso8583.WriteFrame(conn, data)
+	g.writeMu.Unlock()
+	if err != nil {
+		g.drop(conn, err)
+		return nil, true, fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+
+	timer := time.NewTimer(g.responseTimeout)
+	defer timer.Stop()
+
+	select {
+	case response, ok := <-request.response:
+		if !ok {
+			return nil, true, fmt.Errorf("%w: connection lost waiting for STAN %s", ErrNetwork, stan)
+		}
+		return response, true, nil
+	case <-timer.C:
+		return nil, true, fmt.Errorf("%w: no response for STAN %s", ErrTimeout, stan)
+	case <-ctx.Done():
+		return nil, true, ctx.Err()
+	}
+}
+
+// connect retorna a conexão aberta, abrindo uma nova se preciso
+func (g *ISO8583Gateway) connect(ctx context.Context) (net.Conn, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return nil, fmt.Errorf("%w: gateway closed", ErrUnavailable)
+	}
+	if g.conn != nil {
+		return g.conn, nil
+	}
+
+	dialer := net.Dialer{Timeout: g.dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", g.address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+
+	g.conn = conn
+	go g.readLoop(conn)
+
+	g.logger.WithField("address", g.address).Info("Connected to ISO 8583 network")
+	return conn, nil
+}
+
+// readLoop entrega cada resposta da conexão ao pedido com o mesmo STAN até a
+// conexão cair
+func (g *ISO8583Gateway) readLoop(conn net.Conn) {
+	for {
+		data, err := iso8583.ReadFrame(conn)
+		if err != nil {
+			g.drop(conn, err)
+			return
+		}
+
+		msg, err := g.spec.Unpack(data)
+		if err != nil {
+			g.logger.WithError(err).Warn("Discarding malformed ISO 8583 message")
+			continue
+		}
+		g.deliver(msg)
+	}
+}
+
+// deliver casa a resposta com o pedido pendente. Respostas atrasadas, de um
+// pedido que já expirou, ou com MTI ou RRN diferentes do pedido são descartadas
+func (g *ISO8583Gateway) deliver(msg *iso8583.Message) {
+	stan := msg.Get(iso8583.FieldSTAN)
+	rrn := msg.Get(iso8583.FieldRRN)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	request, ok := g.pending[stan]
+	if !ok || request.mti != msg.MTI || (request.rrn != "" && request.rrn != rrn) {
+		g.logger.WithFields(logrus.Fields{
+			"mti":  msg.MTI,
+			"stan": stan,
+			"rrn":  rrn,
+		}).Warn("Discarding unmatched ISO 8583 response")
+		return
+	}
+
+	delete(g.pending, stan)
+	request.response <- msg
+}
+
+// drop fecha a conexão e falha os pedidos que esperavam resposta nela
+func (g *ISO8583Gateway) drop(conn net.Conn, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn != conn {
+		return
+	}
+	g.conn = nil
+	conn.Close()
+
+	for stan, request := range g.pending {
+		if request.conn == conn {
+			delete(g.pending, stan)
+			close(request.response)
+		}
+	}
+
+	if err != nil && !g.closed {
+		g.logger.WithError(err).Warn("ISO 8583 connection lost")
+	}
+}
+
+// nextSTAN retorna o próximo número de rastreio, de 000001 a 999999. Sem
+// ele nada é enviado, e a operação pode ser repetida
+func (g *ISO8583Gateway) nextSTAN(ctx context.Context) (string, error) {
+	stan, err := g.traceNumbers.NextTraceNumber(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to get trace number: %v", ErrUnavailable, err)
+	}
+	return fmt.Sprintf("%06d", stan%1000000), nil
+}
+
+func (g *ISO8583Gateway) acquirerResponse(msg *iso8583.Message) (*model.AcquirerResponse, error) {
+	code := model.AcquirerResponseCode(msg.Get(iso8583.FieldResponseCode))
+	if transientCodes[code] {
+		return nil, fmt.Errorf("%w: issuer response code %s", ErrUnavailable, code)
+	}
+
+	response := &model.AcquirerResponse{
+		Approved:         code == model.ResponseApproved,
+		ResponseCode:     code,
+		NetworkReference: strings.TrimSpace(msg.Get(iso8583.FieldRRN)),
+	}
+	if response.Approved {
+		response.ApprovalCode = strings.TrimSpace(msg.Get(iso8583.FieldApprovalCode))
+		return response, nil
+	}
+
+	response.DeclineReason = declineReasons[code]
+	if response.DeclineReason == "" {
+		response.DeclineReason = fmt.Sprintf("Declined with response code %s", code)
+	}
+	return response, nil
+}
+
+// retrievalReference gera o RRN no formato usual das redes: último dígito do
+// ano, dia do ano, hora e STAN
+func retrievalReference(now time.Time, stan string) string {
+	return fmt.Sprintf("%d%03d%02d%s", now.Year()%10, now.YearDay(), now.Hour(), stan)
+}
+
+func truncate(value string, n int) string {
+	if len(value) > n {
+		return value[:n]
+	}
+	return value
+}
@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang-payment-microservice/internal/iso8583"
+	"golang-payment-microservice/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ISO8583Issuer simula o lado do emissor da rede ISO 8583, para rodar o fluxo
+// do ISO8583Gateway localmente. As autorizações seguem as regras de cartões e
+// valores do sandbox: um timeout fica sem resposta e um erro de rede derruba
+// a conexão. Capturas, devoluções e reversões são sempre aprovadas
+type ISO8583Issuer struct {
+	spec       iso8583.Spec
+	minLatency time.Duration
+	maxLatency time.Duration
+	logger     *logrus.Logger
+}
+
+// ISO8583IssuerOption configura parâmetros opcionais do simulador do emissor
+type ISO8583IssuerOption func(*ISO8583Issuer)
+
+// WithIssuerLatency define a latência das respostas por um perfil do sandbox
+func WithIssuerLatency(profile SandboxLatency) ISO8583IssuerOption {
+	return func(i *ISO8583Issuer) {
+		if r, ok := latencyRanges[profile]; ok {
+			i.minLatency, i.maxLatency = r[0], r[1]
+		}
+	}
+}
+
+// NewISO8583Issuer cria o simulador do emissor. Sem opções ele usa o perfil
+// de latência fast
+func NewISO8583Issuer(logger *logrus.Logger, opts ...ISO8583IssuerOption) *ISO8583Issuer {
+	r := latencyRanges[LatencyFast]
+	i := &ISO8583Issuer{
+		spec:       iso8583.DefaultSpec,
+		minLatency: r[0],
+		maxLatency: r[1],
+		logger:     logger,
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i
+}
+
+// Serve atende as conexões do listener até o contexto ser cancelado
+func (i *ISO8583Issuer) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go i.serveConn(ctx, conn)
+	}
+}
+
+// serveConn responde aos pedidos de uma conexão. Cada pedido é respondido na
+// sua goroutine, então as respostas podem sair fora de ordem, como na rede
+func (i *ISO8583Issuer) serveConn(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer conn.Close()
+
+	logger := i.logger.WithField("remote", conn.RemoteAddr().String())
+	logger.Info("Acquirer connected")
+
+	var writeMu sync.Mutex
+	for {
+		data, err := iso8583.ReadFrame(conn)
+		if err != nil {
+			logger.WithError(err).Info("Acquirer disconnected")
+			return
+		}
+
+		request, err := i.spec.Unpack(data)
+		if err != nil {
+			logger.WithError(err).Warn("Discarding malformed ISO 8583 message")
+			continue
+		}
+
+		go func() {
+			response, err := i.respond(ctx, request)
+			if errors.Is(err, ErrNetwork) {
+				logger.WithField("stan", request.Get(iso8583.FieldSTAN)).Warn("Simulating network error, closing connection")
+				conn.Close()
+				return
+			}
+			if err != nil || response == nil {
+				return
+			}
+
+			data, err := i.spec.Pack(response)
+			if err != nil {
+				logger.WithError(err).Error("Failed to pack ISO 8583 response")
+				return
+			}
+
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if err := iso8583.WriteFrame(conn, data); err != nil {
+				logger.WithError(err).Warn("Failed to write ISO 8583 response")
+			}
+		}()
+	}
+}
+
+// respond decide a resposta a um pedido. Sem resposta e sem erro, o pedido
+// fica sem resposta de propósito
+func (i *ISO8583Issuer) respond(ctx context.Context, request *iso8583.Message) (*iso8583.Message, error) {
+	mti, err := iso8583.ResponseMTI(request.MTI)
+	if err != nil {
+		return nil, err
+	}
+
+	outcome := SandboxApprove
+	if request.MTI == iso8583.MTIAuthorizationRequest {
+		amount, _ := strconv.ParseInt(request.Get(iso8583.FieldAmount), 10, 64)
+		outcome = SandboxOutcomeFor(request.Get(iso8583.FieldPAN), model.Money{Value: amount})
+	}
+
+	i.logger.WithFields(logrus.Fields{
+		"mti":     request.MTI,
+		"stan":    request.Get(iso8583.FieldSTAN),
+		"rrn":     request.Get(iso8583.FieldRRN),
+		"outcome": outcome,
+	}).Info("ISO 8583 request received")
+
+	if outcome == SandboxTimeout {
+		return nil, nil
+	}
+	if err := wait(ctx, randomLatency(i.minLatency, i.maxLatency)); err != nil {
+		return nil, err
+	}
+	if outcome == SandboxNetworkError {
+		return nil, ErrNetwork
+	}
+
+	response := iso8583.NewMessage(mti)
+	for _, field := range []int{iso8583.FieldProcessingCode, iso8583.FieldAmount, iso8583.FieldTransmissionDateTime,
+		iso8583.FieldSTAN, iso8583.FieldLocalTime, iso8583.FieldLocalDate, iso8583.FieldRRN,
+		iso8583.FieldTerminalID, iso8583.FieldMerchantID, iso8583.FieldExtendedPaymentCode, iso8583.FieldCurrency} {
+		if request.Has(field) {
+			response.Set(field, request.Get(field))
+		}
+	}
+
+	code := issuerResponseCodes[outcome]
+	response.Set(iso8583.FieldResponseCode, string(code))
+	if code == model.ResponseApproved {
+		response.Set(iso8583.FieldApprovalCode, fmt.Sprintf("%06d", sandboxHash("approval:"+request.Get(iso8583.FieldRRN))%1000000))
+	}
+
+	return response, nil
+}
+
+// issuerResponseCodes é o campo 39 de cada resultado do sandbox
+var issuerResponseCodes = map[SandboxOutcome]model.AcquirerResponseCode{
+	SandboxApprove:           model.ResponseApproved,
+	SandboxInsufficientFunds: model.ResponseInsufficientFunds,
+	SandboxStolenCard:        model.ResponseStolenCard,
+	SandboxDoNotHonor:        model.ResponseDoNotHonor,
+	SandboxChallenge:         model.ResponseAuthenticationRequired,
+}
@@ -0,0 +1,269 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+)
+
+// SandboxName identifica o sandbox nas configurações e nos pagamentos
+const SandboxName = "sandbox"
+
+// SandboxOutcome é o resultado que o sandbox produz para uma autorização
+type SandboxOutcome string
+
+const (
+	SandboxApprove           SandboxOutcome = "approve"
+	SandboxInsufficientFunds SandboxOutcome = "insufficient_funds"
+	SandboxStolenCard        SandboxOutcome = "stolen_card"
+	SandboxDoNotHonor        SandboxOutcome = "do_not_honor"
+	SandboxTimeout           SandboxOutcome = "timeout"
+	SandboxChallenge         SandboxOutcome = "3ds_challenge"
+	SandboxNetworkError      SandboxOutcome = "network_error"
+)
+
+// sandboxCards são os PANs de teste com resultado fixo. Todos passam no Luhn
+// e são Visa, então são aceitos na criação do pagamento
+var sandboxCards = map[string]SandboxOutcome{
+	"4242424242424242": SandboxApprove,
+	"4000000000009995": SandboxInsufficientFunds,
+	"4000000000009979": SandboxStolenCard,
+	"4000000000000002": SandboxDoNotHonor,
+	"4000000000000069": SandboxTimeout,
+	"4000000000003220": SandboxChallenge,
+	"4000000000000119": SandboxNetworkError,
+}
+
+// sandboxAmounts são os centavos do valor com resultado fixo, para cartões
+// fora de sandboxCards. Seguem os códigos de resposta das redes quando há um
+var sandboxAmounts = map[int64]SandboxOutcome{
+	5:  SandboxDoNotHonor,
+	43: SandboxStolenCard,
+	51: SandboxInsufficientFunds,
+	65: SandboxChallenge,
+	68: SandboxTimeout,
+	96: SandboxNetworkError,
+}
+
+// SandboxLatency é um perfil de latência das respostas do sandbox
+type SandboxLatency string
+
+const (
+	LatencyNone   SandboxLatency = "none"
+	LatencyFast   SandboxLatency = "fast"
+	LatencyNormal SandboxLatency = "normal"
+	LatencySlow   SandboxLatency = "slow"
+)
+
+// latencyRanges são os intervalos de cada perfil de latência
+var latencyRanges = map[SandboxLatency][2]time.Duration{
+	LatencyNone:   {0, 0},
+	LatencyFast:   {10 * time.Millisecond, 50 * time.Millisecond},
+	LatencyNormal: {200 * time.Millisecond, 800 * time.Millisecond},
+	LatencySlow:   {time.Second, 4 * time.Second},
+}
+
+// Sandbox é um adquirente de testes determinístico: o resultado de cada
+// autorização depende só do PAN e do valor, e os códigos de aprovação e as
+// referências derivam do ID do pagamento. Capturas, cancelamentos e estornos
+// são sempre aprovados
+type Sandbox struct {
+	minLatency time.Duration
+	maxLatency time.Duration
+	timeout    time.Duration
+}
+
+// SandboxOption configura parâmetros opcionais do sandbox
+type SandboxOption func(*Sandbox)
+
+// WithLatencyProfile define a latência das respostas por um perfil
+func WithLatencyProfile(profile SandboxLatency) SandboxOption {
+	return func(s *Sandbox) {
+		if r, ok := latencyRanges[profile]; ok {
+			s.minLatency, s.maxLatency = r[0], r[1]
+		}
+	}
+}
+
+// WithSandboxLatency define o intervalo da latência das respostas
+func WithSandboxLatency(min, max time.Duration) SandboxOption {
+	return func(s *Sandbox) {
+		if min >= 0 && max >= min {
+			s.minLatency, s.maxLatency = min, max
+		}
+	}
+}
+
+// WithSandboxTimeout define quanto tempo uma autorização com resultado
+// timeout espera antes de falhar com ErrTimeout
+func WithSandboxTimeout(timeout time.Duration) SandboxOption {
+	return func(s *Sandbox) {
+		if timeout >= 0 {
+			s.timeout = timeout
+		}
+	}
+}
+
+// NewSandbox cria o sandbox. Sem opções ele usa o perfil de latência fast e
+// espera 10 segundos nos timeouts
+func NewSandbox(opts ...SandboxOption) *Sandbox {
+	r := latencyRanges[LatencyFast]
+	s := &Sandbox{
+		minLatency: r[0],
+		maxLatency: r[1],
+		timeout:    10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// ParseSandboxLatency valida o nome de um perfil de latência
+func ParseSandboxLatency(name string) (SandboxLatency, error) {
+	profile := SandboxLatency(name)
+	if _, ok := latencyRanges[profile]; !ok {
+		return "", fmt.Errorf("unknown sandbox latency profile %q", name)
+	}
+	return profile, nil
+}
+
+// SandboxOutcomeFor retorna o resultado da autorização de um cartão e valor.
+// O PAN tem precedência sobre os centavos do valor; sem regra, aprova
+func SandboxOutcomeFor(pan string, amount model.Money) SandboxOutcome {
+	if outcome, ok := sandboxCards[pan]; ok {
+		return outcome
+	}
+	if outcome, ok := sandboxAmounts[amount.Value%100]; ok {
+		return outcome
+	}
+	return SandboxApprove
+}
+
+func (s *Sandbox) Name() string {
+	return SandboxName
+}
+
+func (s *Sandbox) Authorize(ctx context.Context, req *model.AuthorizationRequest) (*model.AcquirerResponse, error) {
+	pan := ""
+	if req.Card != nil {
+		pan = req.Card.Number
+	}
+	return s.authorize(ctx, SandboxOutcomeFor(pan, req.Amount), req.PaymentID.String(), sandboxReference(req.PaymentID.String()))
+}
+
+// IncrementAuthorization segue os valores mágicos, aplicados ao valor adicional
+func (s *Sandbox) IncrementAuthorization(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return s.authorize(ctx, SandboxOutcomeFor("", req.Amount), req.PaymentID.String(), req.NetworkReference)
+}
+
+// authorize responde a uma autorização com o resultado do sandbox
+func (s *Sandbox) authorize(ctx context.Context, outcome SandboxOutcome, paymentID, reference string) (*model.AcquirerResponse, error) {
+	if outcome == SandboxTimeout {
+		if err := wait(ctx, s.timeout); err != nil {
+			return nil, err
+		}
+		return nil, ErrTimeout
+	}
+
+	if err := wait(ctx, s.latency()); err != nil {
+		return nil, err
+	}
+
+	switch outcome {
+	case SandboxNetworkError:
+		return nil, ErrNetwork
+	case SandboxInsufficientFunds:
+		return declined(model.ResponseInsufficientFunds, "Insufficient funds", reference), nil
+	case SandboxStolenCard:
+		return declined(model.ResponseStolenCard, "Stolen card", reference), nil
+	case SandboxDoNotHonor:
+		return declined(model.ResponseDoNotHonor, "Do not honor", reference), nil
+	case SandboxChallenge:
+		return declined(model.ResponseAuthenticationRequired, "Authentication required (3DS challenge)", reference), nil
+	default:
+		return s.approved(paymentID, reference), nil
+	}
+}
+
+func (s *Sandbox) Capture(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return s.followUp(ctx, req)
+}
+
+func (s *Sandbox) Void(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return s.followUp(ctx, req)
+}
+
+func (s *Sandbox) Refund(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return s.followUp(ctx, req)
+}
+
+func (s *Sandbox) followUp(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	if err := wait(ctx, s.latency()); err != nil {
+		return nil, err
+	}
+	return s.approved(req.PaymentID.String(), req.NetworkReference), nil
+}
+
+func (s *Sandbox) latency() time.Duration {
+	return randomLatency(s.minLatency, s.maxLatency)
+}
+
+func (s *Sandbox) approved(paymentID, reference string) *model.AcquirerResponse {
+	return &model.AcquirerResponse{
+		Approved:         true,
+		ResponseCode:     model.ResponseApproved,
+		ApprovalCode:     fmt.Sprintf("%06d", sandboxHash("approval:"+paymentID)%1000000),
+		NetworkReference: reference,
+	}
+}
+
+func declined(code model.AcquirerResponseCode, reason, reference string) *model.AcquirerResponse {
+	return &model.AcquirerResponse{
+		ResponseCode:     code,
+		DeclineReason:    reason,
+		NetworkReference: reference,
+	}
+}
+
+// sandboxReference deriva do pagamento uma referência de 12 dígitos
+func sandboxReference(paymentID string) string {
+	return fmt.Sprintf("%012d", sandboxHash("reference:"+paymentID)%1000000000000)
+}
+
+func sandboxHash(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return h.Sum64()
+}
+
+// randomLatency sorteia uma latência entre min e max
+func randomLatency(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(rand.Int63n(int64(max-min)))
+}
+
+// wait espera d ou até o contexto ser cancelado
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
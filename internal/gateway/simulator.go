@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+)
+
+// SimulatorName identifica o simulador nas configurações e nos pagamentos
+const SimulatorName = "simulator"
+
+// Simulator é um adquirente falso, sem rede, que responde depois de uma
+// latência aleatória. Uma fração das chamadas falha como indisponibilidade e
+// uma fração das autorizações é recusada
+type Simulator struct {
+	minLatency  time.Duration
+	maxLatency  time.Duration
+	failureRate float64
+	declineRate float64
+}
+
+// SimulatorOption configura parâmetros opcionais do simulador
+type SimulatorOption func(*Simulator)
+
+// WithLatency define o intervalo da latência de cada resposta
+func WithLatency(min, max time.Duration) SimulatorOption {
+	return func(s *Simulator) {
+		if min >= 0 && max >= min {
+			s.minLatency = min
+			s.maxLatency = max
+		}
+	}
+}
+
+// WithFailureRate define a fração de chamadas que falham com ErrUnavailable
+func WithFailureRate(rate float64) SimulatorOption {
+	return func(s *Simulator) {
+		s.failureRate = rate
+	}
+}
+
+// WithDeclineRate define a fração de autorizações recusadas pelo emissor
+func WithDeclineRate(rate float64) SimulatorOption {
+	return func(s *Simulator) {
+		s.declineRate = rate
+	}
+}
+
+// NewSimulator cria o simulador. Sem opções ele responde entre 1 e 4
+// segundos, com 10% de indisponibilidade e nenhuma recusa
+func NewSimulator(opts ...SimulatorOption) *Simulator {
+	s := &Simulator{
+		minLatency:  time.Second,
+		maxLatency:  4 * time.Second,
+		failureRate: 0.1,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *Simulator) Name() string {
+	return SimulatorName
+}
+
+func (s *Simulator) Authorize(ctx context.Context, req *model.AuthorizationRequest) (*model.AcquirerResponse, error) {
+	if err := s.respond(ctx); err != nil {
+		return nil, err
+	}
+	return s.decide(networkReference()), nil
+}
+
+// IncrementAuthorization é recusado na mesma taxa das autorizações
+func (s *Simulator) IncrementAuthorization(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	if err := s.respond(ctx); err != nil {
+		return nil, err
+	}
+	return s.decide(req.NetworkReference), nil
+}
+
+func (s *Simulator) Capture(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return s.followUp(ctx, req)
+}
+
+func (s *Simulator) Void(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return s.followUp(ctx, req)
+}
+
+func (s *Simulator) Refund(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	return s.followUp(ctx, req)
+}
+
+// decide sorteia a recusa de uma autorização
+func (s *Simulator) decide(reference string) *model.AcquirerResponse {
+	if rand.Float64() < s.declineRate {
+		return &model.AcquirerResponse{
+			ResponseCode:     model.ResponseDoNotHonor,
+			DeclineReason:    "Do not honor",
+			NetworkReference: reference,
+		}
+	}
+	return approved(reference)
+}
+
+// followUp responde às operações sobre uma autorização, sempre aprovadas
+// quando não há falha simulada
+func (s *Simulator) followUp(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	if err := s.respond(ctx); err != nil {
+		return nil, err
+	}
+	return approved(req.NetworkReference), nil
+}
+
+// respond espera a latência simulada e sorteia a indisponibilidade. Se o
+// contexto for cancelado antes, retorna o erro do contexto
+func (s *Simulator) respond(ctx context.Context) error {
+	if err := wait(ctx, randomLatency(s.minLatency, s.maxLatency)); err != nil {
+		return err
+	}
+
+	if rand.Float64() < s.failureRate {
+		return fmt.Errorf("%w: simulated external service error", ErrUnavailable)
+	}
+	return nil
+}
+
+func approved(reference string) *model.AcquirerResponse {
+	return &model.AcquirerResponse{
+		Approved:         true,
+		ResponseCode:     model.ResponseApproved,
+		ApprovalCode:     fmt.Sprintf("%06d", rand.Intn(1000000)),
+		NetworkReference: reference,
+	}
+}
+
+// networkReference gera uma referência de 12 dígitos, como o RRN das redes
+func networkReference() string {
+	return fmt.Sprintf("%012d", rand.Int63n(1000000000000))
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"golang-payment-microservice/internal/queue"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// getDeadLetterPartitions mostra quantas mensagens há em cada partição do
+// dead-letter
+func (h *HTTPHandler) getDeadLetterPartitions(c *gin.Context) {
+	partitions, err := h.deadLetters.Partitions(c.Request.Context())
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to read dead letter partitions")
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to read dead letter topic",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"partitions": partitions,
+	})
+}
+
+// listDeadLetters lista as mensagens de uma partição do dead-letter a partir
+// do offset informado
+func (h *HTTPHandler) listDeadLetters(c *gin.Context) {
+	partition, err := strconv.Atoi(c.Param("partition"))
+	if err != nil || partition < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid partition",
+		})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	letters, err := h.deadLetters.List(c.Request.Context(), partition, offset, limit)
+	if err != nil {
+		h.logger.WithError(err).WithField("partition", partition).Error("Failed to list dead letters")
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to read dead letter topic",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":  letters,
+		"partition": partition,
+		"offset":    offset,
+		"limit":     limit,
+		"count":     len(letters),
+	})
+}
+
+// getDeadLetter mostra uma mensagem do dead-letter com os metadados da falha
+func (h *HTTPHandler) getDeadLetter(c *gin.Context) {
+	partition, offset, ok := h.deadLetterPosition(c)
+	if !ok {
+		return
+	}
+
+	letter, err := h.deadLetters.Get(c.Request.Context(), partition, offset)
+	if err != nil {
+		h.handleDeadLetterError(c, err, partition, offset)
+		return
+	}
+
+	c.JSON(http.StatusOK, letter)
+}
+
+// redriveDeadLetter reenvia uma mensagem do dead-letter ao tópico de origem
+func (h *HTTPHandler) redriveDeadLetter(c *gin.Context) {
+	partition, offset, ok := h.deadLetterPosition(c)
+	if !ok {
+		return
+	}
+
+	redrive, err := h.deadLetters.Redrive(c.Request.Context(), partition, offset)
+	if err != nil {
+		h.handleDeadLetterError(c, err, partition, offset)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, redrive)
+}
+
+func (h *HTTPHandler) deadLetterPosition(c *gin.Context) (int, int64, bool) {
+	partition, err := strconv.Atoi(c.Param("partition"))
+	if err != nil || partition < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid partition",
+		})
+		return 0, 0, false
+	}
+
+	offset, err := strconv.ParseInt(c.Param("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid offset",
+		})
+		return 0, 0, false
+	}
+
+	return partition, offset, true
+}
+
+func (h *HTTPHandler) handleDeadLetterError(c *gin.Context, err error, partition int, offset int64) {
+	if errors.Is(err, queue.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Dead letter not found",
+		})
+		return
+	}
+
+	h.logger.WithError(err).WithFields(logrus.Fields{
+		"partition": partition,
+		"offset":    offset,
+	}).Error("Failed to handle dead letter")
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": "Failed to handle dead letter",
+	})
+}
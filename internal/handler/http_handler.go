@@ -1,10 +1,18 @@
 package handler
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
+	"golang-payment-microservice/internal/cardvalidation"
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/installment"
+	"golang-payment-microservice/internal/kms"
 	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
 	"golang-payment-microservice/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -14,16 +22,58 @@ import (
 
 type HTTPHandler struct {
 	paymentService service.PaymentService
-	logger         *logrus.Logger
+	idempotency    repository.IdempotencyRepository
+	idempotencyTTL time.Duration
+	// idempotencyLease é por quanto tempo uma requisição em andamento segura
+	// a chave antes de uma repetição poder reservá-la de novo
+	idempotencyLease time.Duration
+	keys             kms.KeyManager
+	deadLetters      service.DeadLetterService
+	logger           *logrus.Logger
 }
 
-func NewHTTPHandler(paymentService service.PaymentService, logger *logrus.Logger) *HTTPHandler {
-	return &HTTPHandler{
-		paymentService: paymentService,
-		logger:         logger,
+// Option configura parâmetros opcionais do handler
+type Option func(*HTTPHandler)
+
+// WithIdempotency habilita a Idempotency-Key na criação de pagamentos,
+// guardando as chaves e respostas por ttl. O hash do corpo usa a chave de
+// índice de keys
+func WithIdempotency(store repository.IdempotencyRepository, keys kms.KeyManager, ttl time.Duration) Option {
+	return func(h *HTTPHandler) {
+		h.idempotency = store
+		h.keys = keys
+		h.idempotencyTTL = ttl
 	}
 }
 
+// WithIdempotencyLease define o lease das requisições em andamento
+func WithIdempotencyLease(lease time.Duration) Option {
+	return func(h *HTTPHandler) {
+		h.idempotencyLease = lease
+	}
+}
+
+// WithDeadLetters habilita as rotas de administração do dead-letter
+func WithDeadLetters(deadLetters service.DeadLetterService) Option {
+	return func(h *HTTPHandler) {
+		h.deadLetters = deadLetters
+	}
+}
+
+func NewHTTPHandler(paymentService service.PaymentService, logger *logrus.Logger, opts ...Option) *HTTPHandler {
+	h := &HTTPHandler{
+		paymentService:   paymentService,
+		logger:           logger,
+		idempotencyLease: defaultIdempotencyLease,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
 func (h *HTTPHandler) SetupRoutes() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
@@ -39,9 +89,33 @@ func (h *HTTPHandler) SetupRoutes() *gin.Engine {
 	// Payment routes
 	v1 := router.Group("/api/v1")
 	{
-		v1.POST("/payments", h.createPayment)
+		if h.idempotency != nil {
+			v1.POST("/payments", h.idempotencyMiddleware(), h.createPayment)
+		} else {
+			v1.POST("/payments", h.createPayment)
+		}
 		v1.GET("/payments/:id", h.getPayment)
+		v1.GET("/payments/:id/history", h.getPaymentHistory)
+		v1.POST("/payments/:id/cancel", h.cancelPayment)
+		v1.POST("/payments/:id/capture", h.capturePayment)
+		v1.GET("/payments/:id/captures", h.getCaptures)
+		v1.POST("/payments/:id/authorization/increment", h.incrementAuthorization)
+		v1.POST("/payments/:id/void", h.voidPayment)
+		v1.POST("/payments/:id/refunds", h.createRefund)
+		v1.GET("/payments/:id/refunds", h.getRefunds)
 		v1.GET("/merchants/:merchant_id/payments", h.getPaymentsByMerchant)
+		v1.GET("/accounts/:account_id/holds", h.getAccountHolds)
+		v1.POST("/merchants/:merchant_id/settlements", h.settleMerchant)
+		v1.GET("/merchants/:merchant_id/installment-rules", h.getInstallmentRules)
+		v1.PUT("/merchants/:merchant_id/installment-rules", h.setInstallmentRules)
+		v1.GET("/ledger/check", h.checkLedger)
+
+		if h.deadLetters != nil {
+			v1.GET("/admin/dlq", h.getDeadLetterPartitions)
+			v1.GET("/admin/dlq/:partition", h.listDeadLetters)
+			v1.GET("/admin/dlq/:partition/:offset", h.getDeadLetter)
+			v1.POST("/admin/dlq/:partition/:offset/redrive", h.redriveDeadLetter)
+		}
 	}
 
 	return router
@@ -65,7 +139,7 @@ func (h *HTTPHandler) createPayment(c *gin.Context) {
 	}
 
 	// Validação básica
-	if req.CardNumber == "" || req.Amount <= 0 || req.Currency == "" || req.MerchantID == "" {
+	if (req.CardNumber == "" && req.CardToken == "") || !req.Amount.IsPositive() || req.Amount.Currency == "" || req.MerchantID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Missing required fields",
 		})
@@ -75,8 +149,29 @@ func (h *HTTPHandler) createPayment(c *gin.Context) {
 	response, err := h.paymentService.CreatePayment(c.Request.Context(), &req)
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to create payment")
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
+
+		var verr *cardvalidation.ValidationError
+		if errors.As(err, &verr) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Invalid card data",
+				"card_brand": verr.Brand,
+				"reasons":    verr.Failures,
+			})
+			return
+		}
+
+		// Só as recusas do pedido são 400: com um 5xx a Idempotency-Key é
+		// liberada e o cliente pode repetir o pedido
+		var rerr *service.RequestError
+		if errors.As(err, &rerr) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create payment",
 		})
 		return
 	}
@@ -103,7 +198,394 @@ func (h *HTTPHandler) getPayment(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, payment)
+	c.JSON(http.StatusOK, model.NewPaymentDetails(payment))
+}
+
+// getPaymentHistory mostra as ações registradas sobre o pagamento, como os
+// reenvios e encerramentos feitos pelo reaper
+func (h *HTTPHandler) getPaymentHistory(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid payment ID",
+		})
+		return
+	}
+
+	payment, events, err := h.paymentService.GetPaymentHistory(c.Request.Context(), id)
+	if err != nil {
+		h.logger.WithError(err).WithField("payment_id", id).Error("Failed to get payment history")
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Payment not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_id": payment.ID,
+		"status":     payment.Status,
+		"events":     events,
+	})
+}
+
+// cancelPayment cancela um pagamento que ainda não chegou a um estado final
+func (h *HTTPHandler) cancelPayment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid payment ID",
+		})
+		return
+	}
+
+	var req model.CancelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	payment, err := h.paymentService.CancelPayment(c.Request.Context(), id, req.Reason, req.CancelledBy)
+	if err != nil {
+		var transitionErr *model.TransitionError
+		switch {
+		case errors.Is(err, repository.ErrPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Payment not found",
+			})
+		case errors.As(err, &transitionErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "Payment cannot be cancelled",
+				"status": transitionErr.Current,
+			})
+		default:
+			h.logger.WithError(err).WithField("payment_id", id).Error("Failed to cancel payment")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to cancel payment",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewPaymentDetails(payment))
+}
+
+// capturePayment captura um pagamento authorized, no saldo não capturado ou
+// num valor menor, numa captura final ou parcial
+func (h *HTTPHandler) capturePayment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid payment ID",
+		})
+		return
+	}
+
+	// O corpo é opcional: sem ele, a captura final é do saldo não capturado
+	var req model.CaptureRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+	if req.Amount != nil && (!req.Amount.IsPositive() || req.Amount.Currency == "") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid capture amount",
+		})
+		return
+	}
+
+	payment, err := h.paymentService.CapturePayment(c.Request.Context(), id, &req)
+	if err != nil {
+		var transitionErr *model.TransitionError
+		switch {
+		case errors.Is(err, repository.ErrPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Payment not found",
+			})
+		case errors.As(err, &transitionErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "Payment cannot be captured",
+				"status": transitionErr.Current,
+			})
+		case errors.Is(err, repository.ErrCaptureInProgress):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Another capture is in progress",
+			})
+		case errors.Is(err, service.ErrAuthorizationExpired):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Authorization expired",
+			})
+		case errors.Is(err, service.ErrInvalidCaptureAmount), errors.Is(err, repository.ErrCaptureExceedsAuthorization),
+			errors.Is(err, model.ErrUnknownCurrency), errors.Is(err, model.ErrCurrencyMismatch):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid capture amount",
+				"details": err.Error(),
+			})
+		case errors.Is(err, service.ErrCaptureDeclined):
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":   "Capture declined",
+				"details": err.Error(),
+			})
+		case errors.Is(err, gateway.ErrUnavailable):
+			h.logger.WithError(err).WithField("payment_id", id).Error("Acquirer unavailable for capture")
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Acquirer unavailable",
+			})
+		default:
+			h.logger.WithError(err).WithField("payment_id", id).Error("Failed to capture payment")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to capture payment",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewPaymentDetails(payment))
+}
+
+// getCaptures lista as capturas do pagamento
+func (h *HTTPHandler) getCaptures(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid payment ID",
+		})
+		return
+	}
+
+	captures, err := h.paymentService.GetCaptures(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Payment not found",
+			})
+			return
+		}
+		h.logger.WithError(err).WithField("payment_id", id).Error("Failed to get captures")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve captures",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_id": id,
+		"captures":   captures,
+		"count":      len(captures),
+	})
+}
+
+// incrementAuthorization aumenta a autorização de um pagamento authorized
+func (h *HTTPHandler) incrementAuthorization(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid payment ID",
+		})
+		return
+	}
+
+	var req model.IncrementAuthorizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	payment, err := h.paymentService.IncrementAuthorization(c.Request.Context(), id, &req)
+	if err != nil {
+		var transitionErr *model.TransitionError
+		switch {
+		case errors.Is(err, repository.ErrPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Payment not found",
+			})
+		case errors.As(err, &transitionErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "Payment authorization cannot be increased",
+				"status": transitionErr.Current,
+			})
+		case errors.Is(err, repository.ErrCaptureInProgress):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "A capture is in progress",
+			})
+		case errors.Is(err, service.ErrAuthorizationExpired):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Authorization expired",
+			})
+		case errors.Is(err, service.ErrInvalidIncrementAmount), errors.Is(err, model.ErrUnknownCurrency), errors.Is(err, model.ErrCurrencyMismatch):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid increment amount",
+				"details": err.Error(),
+			})
+		case errors.Is(err, service.ErrIncrementDeclined), errors.Is(err, repository.ErrInsufficientFunds), errors.Is(err, repository.ErrAccountInactive):
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":   "Authorization increment declined",
+				"details": err.Error(),
+			})
+		case errors.Is(err, gateway.ErrUnavailable):
+			h.logger.WithError(err).WithField("payment_id", id).Error("Acquirer unavailable for authorization increment")
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Acquirer unavailable",
+			})
+		default:
+			h.logger.WithError(err).WithField("payment_id", id).Error("Failed to increment authorization")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to increment authorization",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewPaymentDetails(payment))
+}
+
+// voidPayment cancela a autorização de um pagamento authorized
+func (h *HTTPHandler) voidPayment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid payment ID",
+		})
+		return
+	}
+
+	var req model.VoidRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	payment, err := h.paymentService.VoidPayment(c.Request.Context(), id, req.Reason, req.VoidedBy)
+	if err != nil {
+		var transitionErr *model.TransitionError
+		switch {
+		case errors.Is(err, repository.ErrPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Payment not found",
+			})
+		case errors.As(err, &transitionErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "Payment cannot be voided",
+				"status": transitionErr.Current,
+			})
+		case errors.Is(err, repository.ErrCaptureInProgress):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "A capture is in progress",
+			})
+		default:
+			h.logger.WithError(err).WithField("payment_id", id).Error("Failed to void payment")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to void payment",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewPaymentDetails(payment))
+}
+
+// createRefund cria um estorno total ou parcial de um pagamento concluído,
+// processado de forma assíncrona
+func (h *HTTPHandler) createRefund(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid payment ID",
+		})
+		return
+	}
+
+	// O corpo é opcional: sem ele, o estorno é total
+	var req model.RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+	if req.Amount != nil && (!req.Amount.IsPositive() || req.Amount.Currency == "") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid refund amount",
+		})
+		return
+	}
+
+	refund, err := h.paymentService.CreateRefund(c.Request.Context(), id, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Payment not found",
+			})
+		case errors.Is(err, model.ErrUnknownCurrency), errors.Is(err, model.ErrCurrencyMismatch):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid refund amount",
+				"details": err.Error(),
+			})
+		case errors.Is(err, repository.ErrPaymentNotRefundable):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "Payment cannot be refunded",
+				"details": err.Error(),
+			})
+		case errors.Is(err, repository.ErrRefundExceedsPayment):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "Refund exceeds refundable amount",
+				"details": err.Error(),
+			})
+		default:
+			h.logger.WithError(err).WithField("payment_id", id).Error("Failed to create refund")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to create refund",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, refund)
+}
+
+// getRefunds lista os estornos do pagamento e o status de cada um
+func (h *HTTPHandler) getRefunds(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid payment ID",
+		})
+		return
+	}
+
+	refunds, err := h.paymentService.GetRefunds(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Payment not found",
+			})
+			return
+		}
+		h.logger.WithError(err).WithField("payment_id", id).Error("Failed to get refunds")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve refunds",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_id": id,
+		"refunds":    refunds,
+		"count":      len(refunds),
+	})
 }
 
 func (h *HTTPHandler) getPaymentsByMerchant(c *gin.Context) {
@@ -138,19 +620,182 @@ func (h *HTTPHandler) getPaymentsByMerchant(c *gin.Context) {
 		return
 	}
 
+	details := make([]*model.PaymentDetails, 0, len(payments))
+	for _, payment := range payments {
+		details = append(details, model.NewPaymentDetails(payment))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"payments": payments,
+		"payments": details,
 		"limit":    limit,
 		"offset":   offset,
 		"count":    len(payments),
 	})
 }
 
+// getAccountHolds mostra ao suporte os saldos da conta e as reservas que
+// bloqueiam o saldo disponível
+func (h *HTTPHandler) getAccountHolds(c *gin.Context) {
+	accountID, err := uuid.Parse(c.Param("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid account ID",
+		})
+		return
+	}
+
+	status := model.HoldStatus(c.Query("status"))
+	if status != "" && !status.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid hold status",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	account, holds, err := h.paymentService.GetAccountHolds(c.Request.Context(), accountID, status, limit, offset)
+	if err != nil {
+		h.logger.WithError(err).WithField("account_id", accountID).Error("Failed to get account holds")
+		if errors.Is(err, repository.ErrAccountNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Account not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve holds",
+		})
+		return
+	}
+
+	if holds == nil {
+		holds = []*model.Hold{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"account_id":        account.ID,
+		"ledger_balance":    account.LedgerBalance,
+		"available_balance": account.AvailableBalance,
+		"is_active":         account.IsActive,
+		"holds":             holds,
+		"limit":             limit,
+		"offset":            offset,
+		"count":             len(holds),
+	})
+}
+
+// settleMerchant liquida o saldo do merchant registrado no razão
+func (h *HTTPHandler) settleMerchant(c *gin.Context) {
+	merchantID := c.Param("merchant_id")
+
+	var req struct {
+		Currency model.Currency `json:"currency" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	entry, err := h.paymentService.SettleMerchant(c.Request.Context(), merchantID, req.Currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrUnknownCurrency):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid currency",
+			})
+		case errors.Is(err, service.ErrNothingToSettle):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "Nothing to settle",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to settle merchant",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// getInstallmentRules retorna as regras de parcelamento do merchant
+func (h *HTTPHandler) getInstallmentRules(c *gin.Context) {
+	merchantID := c.Param("merchant_id")
+
+	rules, err := h.paymentService.GetInstallmentRules(c.Request.Context(), merchantID)
+	if err != nil {
+		h.logger.WithError(err).WithField("merchant_id", merchantID).Error("Failed to get installment rules")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve installment rules",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// setInstallmentRules substitui as regras de parcelamento do merchant
+func (h *HTTPHandler) setInstallmentRules(c *gin.Context) {
+	var rules model.InstallmentRules
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+	rules.MerchantID = c.Param("merchant_id")
+
+	saved, err := h.paymentService.SetInstallmentRules(c.Request.Context(), &rules)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidInstallmentRules), errors.Is(err, installment.ErrCurrencyNotSupported):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid installment rules",
+				"details": err.Error(),
+			})
+		default:
+			h.logger.WithError(err).WithField("merchant_id", rules.MerchantID).Error("Failed to save installment rules")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to save installment rules",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// checkLedger executa o verificador de invariantes do razão sob demanda
+func (h *HTTPHandler) checkLedger(c *gin.Context) {
+	report, err := h.paymentService.CheckLedger(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check ledger",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
 func (h *HTTPHandler) corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Idempotency-Key")
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+	defaultIdempotencyLease = 30 * time.Second
+	// idempotencyHashPrefix separa estes hashes dos outros blind indexes
+	// calculados com a mesma chave
+	idempotencyHashPrefix = "idempotency-request:"
+)
+
+// responseRecorder copia o corpo da resposta enquanto ela é escrita
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyMiddleware faz requisições repetidas com a mesma Idempotency-Key
+// e o mesmo corpo receberem a resposta original em vez de executar de novo.
+// As chaves são isoladas por merchant_id, lido do corpo da requisição
+func (h *HTTPHandler) idempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Idempotency-Key is too long",
+			})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request body",
+			})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Sem merchant a requisição é rejeitada pelo handler e não há o que guardar
+		var scope struct {
+			MerchantID string `json:"merchant_id"`
+		}
+		if err := json.Unmarshal(body, &scope); err != nil || scope.MerchantID == "" {
+			c.Next()
+			return
+		}
+
+		hash, err := h.requestHash(body)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		lockedUntil := now.Add(h.idempotencyLease)
+		record := &model.IdempotencyKey{
+			MerchantID:  scope.MerchantID,
+			Key:         key,
+			RequestHash: hash,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(h.idempotencyTTL),
+			LockedUntil: &lockedUntil,
+		}
+
+		logger := h.logger.WithFields(logrus.Fields{
+			"merchant_id":     record.MerchantID,
+			"idempotency_key": record.Key,
+		})
+
+		existing, reserved, err := h.idempotency.Reserve(c.Request.Context(), record)
+		if err != nil {
+			logger.WithError(err).Error("Failed to reserve idempotency key")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to process idempotency key",
+			})
+			return
+		}
+
+		if !reserved {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "Idempotency-Key was already used with a different request body",
+				})
+			case !existing.IsCompleted():
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+					"error": "A request with this Idempotency-Key is still in progress",
+				})
+			default:
+				logger.Info("Replaying idempotent response")
+				c.Header(idempotentReplayHeader, "true")
+				c.Data(existing.ResponseStatus, "application/json; charset=utf-8", existing.ResponseBody)
+				c.Abort()
+			}
+			return
+		}
+
+		// O registro gravado traz o locked_until como o banco o guardou, que
+		// identifica este lease em Complete e Release
+		record = existing
+
+		// A resposta é gravada mesmo se o cliente desconectar no meio
+		ctx := context.WithoutCancel(c.Request.Context())
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		completed := false
+		defer func() {
+			// Um panic ou erro interno libera a chave para o cliente tentar de novo
+			if completed {
+				return
+			}
+			if err := h.idempotency.Release(ctx, record); err != nil {
+				logger.WithError(err).Error("Failed to release idempotency key")
+			}
+		}()
+
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+
+		if err := h.idempotency.Complete(ctx, record, status, recorder.body.Bytes()); err != nil {
+			// Com o lease vencido e a chave tomada por uma repetição, a
+			// resposta guardada é a dela
+			logger.WithError(err).Error("Failed to store idempotent response")
+			return
+		}
+		completed = true
+	}
+}
+
+// requestHash calcula o hash com chave do corpo em forma canônica. O corpo
+// traz PAN e CVV, então o hash usa a chave de índice do KMS em vez de um
+// SHA-256 que poderia ser testado por força bruta; a forma canônica faz o
+// mesmo pedido com outros espaços ou outra ordem de chaves ter o mesmo hash
+func (h *HTTPHandler) requestHash(body []byte) (string, error) {
+	canonical, err := canonicalJSON(body)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.keys.BlindIndex(idempotencyHashPrefix + string(canonical))), nil
+}
+
+// canonicalJSON reescreve o JSON sem espaços e com as chaves dos objetos em
+// ordem, mantendo os números como foram enviados
+func canonicalJSON(body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
@@ -0,0 +1,120 @@
+package installment
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+)
+
+var (
+	ErrInvalidInstallments     = errors.New("invalid number of installments")
+	ErrInstallmentBelowMinimum = errors.New("installment below minimum amount")
+	ErrCurrencyNotSupported    = errors.New("installments are not supported for currency")
+)
+
+// Currency é a única moeda em que o parcelamento é oferecido
+const Currency model.Currency = "BRL"
+
+// basisPoints corresponde a 100%
+const basisPoints = 10000
+
+// Plan calcula o parcelamento do valor em count parcelas segundo as regras
+// do merchant. Sem juros, o valor é dividido igualmente e a diferença de
+// arredondamento fica na primeira parcela; com juros, as parcelas são fixas
+// pela tabela Price. A primeira parcela vence um mês depois de start
+func Plan(amount model.Money, count int, rules *model.InstallmentRules, start time.Time) (*model.InstallmentPlan, error) {
+	if amount.Currency != Currency {
+		return nil, fmt.Errorf("%w: %s", ErrCurrencyNotSupported, amount.Currency)
+	}
+	if count < 1 || count > rules.MaxInstallments {
+		return nil, fmt.Errorf("%w: %d, merchant allows 1 to %d", ErrInvalidInstallments, count, rules.MaxInstallments)
+	}
+
+	plan := &model.InstallmentPlan{
+		Count:     count,
+		Interest:  model.InstallmentInterestFree,
+		Principal: amount,
+	}
+
+	var amounts []int64
+	if count <= rules.InterestFreeInstallments || rules.MonthlyRateBPS == 0 {
+		amounts = split(amount.Value, count)
+	} else {
+		plan.Interest = rules.Interest
+		plan.MonthlyRateBPS = rules.MonthlyRateBPS
+		amounts = price(amount.Value, count, rules.MonthlyRateBPS)
+	}
+
+	// A última parcela é a menor; à vista não há mínimo
+	if count > 1 && amounts[count-1] < rules.MinInstallmentAmount.Value {
+		return nil, fmt.Errorf("%w: %s per installment, merchant minimum is %s", ErrInstallmentBelowMinimum,
+			model.NewMoney(amounts[count-1], amount.Currency), rules.MinInstallmentAmount)
+	}
+
+	total := model.NewMoney(0, amount.Currency)
+	due := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	for i, value := range amounts {
+		installment := model.NewMoney(value, amount.Currency)
+		var err error
+		if total, err = total.Add(installment); err != nil {
+			return nil, err
+		}
+		plan.Schedule = append(plan.Schedule, model.Installment{
+			Number:  i + 1,
+			Amount:  installment,
+			DueDate: addMonths(due, i+1),
+		})
+	}
+	plan.Total = total
+
+	return plan, nil
+}
+
+// addMonths soma months meses a date mantendo o dia, limitado ao último dia
+// do mês de destino: 31/01 mais um mês vence em 28/02 (ou 29/02), não em 03/03
+func addMonths(date time.Time, months int) time.Time {
+	target := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	lastDay := target.AddDate(0, 1, -1).Day()
+	return time.Date(target.Year(), target.Month(), min(date.Day(), lastDay), 0, 0, 0, 0, time.UTC)
+}
+
+// split divide o valor em parcelas iguais, com o resto na primeira
+func split(value int64, count int) []int64 {
+	amounts := make([]int64, count)
+	base := value / int64(count)
+	for i := range amounts {
+		amounts[i] = base
+	}
+	amounts[0] += value % int64(count)
+	return amounts
+}
+
+// price calcula as parcelas fixas da tabela Price, PV·i·(1+i)^n / ((1+i)^n − 1),
+// arredondando metade para cima na unidade menor da moeda
+func price(value int64, count int, rateBPS int64) []int64 {
+	// big.Rat mantém o cálculo exato até o arredondamento final
+	rate := big.NewRat(rateBPS, basisPoints)
+	factor := new(big.Rat).Add(big.NewRat(1, 1), rate)
+	growth := big.NewRat(1, 1)
+	for i := 0; i < count; i++ {
+		growth.Mul(growth, factor)
+	}
+
+	installment := new(big.Rat).SetInt64(value)
+	installment.Mul(installment, rate)
+	installment.Mul(installment, growth)
+	installment.Quo(installment, new(big.Rat).Sub(growth, big.NewRat(1, 1)))
+
+	rounded := new(big.Int).Mul(installment.Num(), big.NewInt(2))
+	rounded.Add(rounded, installment.Denom())
+	rounded.Quo(rounded, new(big.Int).Mul(installment.Denom(), big.NewInt(2)))
+
+	amounts := make([]int64, count)
+	for i := range amounts {
+		amounts[i] = rounded.Int64()
+	}
+	return amounts
+}
@@ -0,0 +1,268 @@
+package iso8583
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidMTI indica um MTI fora do formato de quatro dígitos
+	ErrInvalidMTI = errors.New("invalid MTI")
+	// ErrUnknownField indica um campo sem formato na spec
+	ErrUnknownField = errors.New("unknown field")
+	// ErrInvalidValue indica um valor com tamanho ou caracteres inválidos
+	ErrInvalidValue = errors.New("invalid field value")
+	// ErrMalformed indica uma mensagem truncada ou com sobra de bytes
+	ErrMalformed = errors.New("malformed message")
+)
+
+// maxFrameSize é o maior frame que cabe no prefixo de dois bytes
+const maxFrameSize = 1<<16 - 1
+
+// Message é uma mensagem ISO 8583: o MTI e os valores dos campos presentes.
+// O bitmap é derivado dos campos na codificação
+type Message struct {
+	MTI    string
+	fields map[int]string
+}
+
+// NewMessage cria uma mensagem vazia do tipo informado
+func NewMessage(mti string) *Message {
+	return &Message{MTI: mti, fields: make(map[int]string)}
+}
+
+// Set define o valor de um campo
+func (m *Message) Set(field int, value string) *Message {
+	m.fields[field] = value
+	return m
+}
+
+// Get retorna o valor de um campo, ou vazio se ausente
+func (m *Message) Get(field int) string {
+	return m.fields[field]
+}
+
+// Has indica se o campo está presente
+func (m *Message) Has(field int) bool {
+	_, ok := m.fields[field]
+	return ok
+}
+
+// Fields retorna os números dos campos presentes em ordem crescente
+func (m *Message) Fields() []int {
+	fields := make([]int, 0, len(m.fields))
+	for field := range m.fields {
+		fields = append(fields, field)
+	}
+	sort.Ints(fields)
+	return fields
+}
+
+// Pack codifica a mensagem: MTI, bitmap primário, bitmap secundário se houver
+// campos acima de 64 e os campos em ordem. Valores fixos mais curtos que o
+// campo são completados conforme o charset
+func (s Spec) Pack(m *Message) ([]byte, error) {
+	if err := validateMTI(m.MTI); err != nil {
+		return nil, err
+	}
+
+	fields := m.Fields()
+	bitmap := make([]byte, 8)
+	if len(fields) > 0 && fields[len(fields)-1] > 64 {
+		bitmap = make([]byte, 16)
+		bitmap[0] |= 0x80
+	}
+
+	var body []byte
+	for _, field := range fields {
+		spec, ok := s[field]
+		if !ok || field < 2 || field > 128 {
+			return nil, errorf(ErrUnknownField, "field %d", field)
+		}
+
+		encoded, err := spec.encode(m.fields[field])
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", field, err)
+		}
+
+		bitmap[(field-1)/8] |= 0x80 >> ((field - 1) % 8)
+		body = append(body, encoded...)
+	}
+
+	data := make([]byte, 0, 4+len(bitmap)+len(body))
+	data = append(data, m.MTI...)
+	data = append(data, bitmap...)
+	return append(data, body...), nil
+}
+
+// Unpack decodifica uma mensagem codificada por Pack. Valores fixos são
+// devolvidos como estão na mensagem, com o preenchimento
+func (s Spec) Unpack(data []byte) (*Message, error) {
+	if len(data) < 12 {
+		return nil, errorf(ErrMalformed, "message too short (%d bytes)", len(data))
+	}
+
+	m := NewMessage(string(data[:4]))
+	if err := validateMTI(m.MTI); err != nil {
+		return nil, err
+	}
+
+	bitmap := data[4:12]
+	pos := 12
+	if bitmap[0]&0x80 != 0 {
+		if len(data) < 20 {
+			return nil, errorf(ErrMalformed, "missing secondary bitmap")
+		}
+		bitmap = data[4:20]
+		pos = 20
+	}
+
+	for field := 2; field <= len(bitmap)*8; field++ {
+		if bitmap[(field-1)/8]&(0x80>>((field-1)%8)) == 0 {
+			continue
+		}
+
+		spec, ok := s[field]
+		if !ok {
+			return nil, errorf(ErrUnknownField, "field %d", field)
+		}
+
+		value, n, err := spec.decode(data[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", field, err)
+		}
+		m.fields[field] = value
+		pos += n
+	}
+
+	if pos != len(data) {
+		return nil, errorf(ErrMalformed, "%d trailing bytes", len(data)-pos)
+	}
+
+	return m, nil
+}
+
+func (f Field) encode(value string) ([]byte, error) {
+	if len(value) > f.Length {
+		return nil, errorf(ErrInvalidValue, "length %d exceeds %d", len(value), f.Length)
+	}
+	if err := f.Charset.validate(value); err != nil {
+		return nil, err
+	}
+
+	switch f.Type {
+	case LLVAR:
+		return []byte(fmt.Sprintf("%02d%s", len(value), value)), nil
+	case LLLVAR:
+		return []byte(fmt.Sprintf("%03d%s", len(value), value)), nil
+	default:
+		padding := f.Length - len(value)
+		if f.Charset == Numeric {
+			return []byte(strings.Repeat("0", padding) + value), nil
+		}
+		return []byte(value + strings.Repeat(" ", padding)), nil
+	}
+}
+
+// decode lê o campo do início de data e retorna o valor e quantos bytes leu
+func (f Field) decode(data []byte) (string, int, error) {
+	prefix := 0
+	switch f.Type {
+	case LLVAR:
+		prefix = 2
+	case LLLVAR:
+		prefix = 3
+	}
+
+	length := f.Length
+	if prefix > 0 {
+		if len(data) < prefix {
+			return "", 0, errorf(ErrMalformed, "truncated length prefix")
+		}
+		n, err := strconv.Atoi(string(data[:prefix]))
+		if err != nil || n < 0 || n > f.Length {
+			return "", 0, errorf(ErrInvalidValue, "bad length prefix %q", data[:prefix])
+		}
+		length = n
+	}
+
+	if len(data) < prefix+length {
+		return "", 0, errorf(ErrMalformed, "truncated value")
+	}
+
+	value := string(data[prefix : prefix+length])
+	if f.Type == Fixed && f.Charset != Numeric {
+		// O preenchimento com espaços é do formato, não do valor
+		if err := f.Charset.validate(strings.TrimRight(value, " ")); err != nil {
+			return "", 0, err
+		}
+	} else if err := f.Charset.validate(value); err != nil {
+		return "", 0, err
+	}
+
+	return value, prefix + length, nil
+}
+
+func (c Charset) validate(value string) error {
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		valid := false
+		switch c {
+		case Numeric:
+			valid = ch >= '0' && ch <= '9'
+		case AlphaNumeric:
+			valid = ch >= '0' && ch <= '9' || ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z'
+		default:
+			valid = ch >= 0x20 && ch <= 0x7e
+		}
+		if !valid {
+			return errorf(ErrInvalidValue, "invalid character %q", ch)
+		}
+	}
+	return nil
+}
+
+func validateMTI(mti string) error {
+	if len(mti) != 4 || Numeric.validate(mti) != nil {
+		return errorf(ErrInvalidMTI, "%q", mti)
+	}
+	return nil
+}
+
+func errorf(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
+
+// WriteFrame escreve uma mensagem codificada precedida do seu tamanho em dois
+// bytes big-endian
+func WriteFrame(w io.Writer, data []byte) error {
+	if len(data) > maxFrameSize {
+		return errorf(ErrMalformed, "frame of %d bytes exceeds %d", len(data), maxFrameSize)
+	}
+
+	frame := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(frame, uint16(len(data)))
+	copy(frame[2:], data)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame lê a próxima mensagem escrita por WriteFrame
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,159 @@
+// Package iso8583 codifica e decodifica mensagens ISO 8583 (versão 1987),
+// o formato usado pelas redes de cartão entre adquirente e emissor. Os campos
+// são ASCII, os bitmaps binários e cada mensagem trafega num frame com o
+// tamanho em dois bytes na frente
+package iso8583
+
+// FieldType é a forma como o tamanho de um campo é codificado
+type FieldType int
+
+const (
+	// Fixed tem sempre Length caracteres
+	Fixed FieldType = iota
+	// LLVAR tem até 99 caracteres, precedidos do tamanho em dois dígitos
+	LLVAR
+	// LLLVAR tem até 999 caracteres, precedidos do tamanho em três dígitos
+	LLLVAR
+)
+
+// Charset restringe os caracteres aceitos num campo
+type Charset int
+
+const (
+	// Numeric aceita só dígitos (n). Campos fixos são completados com zeros
+	// à esquerda
+	Numeric Charset = iota
+	// AlphaNumeric aceita letras e dígitos (an). Campos fixos são completados
+	// com espaços à direita
+	AlphaNumeric
+	// AlphaNumericSpecial aceita qualquer caractere ASCII imprimível (ans)
+	AlphaNumericSpecial
+)
+
+// Field descreve o formato de um campo. Em campos variáveis, Length é o
+// tamanho máximo
+type Field struct {
+	Type        FieldType
+	Length      int
+	Charset     Charset
+	Description string
+}
+
+// Spec mapeia o número de cada campo (2 a 128) ao seu formato. Campos fora
+// da spec não podem ser codificados nem decodificados
+type Spec map[int]Field
+
+// Campos usados pelo gateway e pelo simulador do emissor
+const (
+	FieldPAN                  = 2
+	FieldProcessingCode       = 3
+	FieldAmount               = 4
+	FieldTransmissionDateTime = 7
+	FieldSTAN                 = 11
+	FieldLocalTime            = 12
+	FieldLocalDate            = 13
+	FieldExpiry               = 14
+	FieldPOSEntryMode         = 22
+	FieldExtendedPaymentCode  = 67
+	FieldRRN                  = 37
+	FieldApprovalCode         = 38
+	FieldResponseCode         = 39
+	FieldTerminalID           = 41
+	FieldMerchantID           = 42
+	FieldAdditionalData       = 48
+	FieldCurrency             = 49
+	FieldOriginalData         = 90
+)
+
+// DefaultSpec é o subconjunto dos campos da ISO 8583:1987 trocado com a rede
+var DefaultSpec = Spec{
+	FieldPAN:                  {LLVAR, 19, Numeric, "Primary account number"},
+	FieldProcessingCode:       {Fixed, 6, Numeric, "Processing code"},
+	FieldAmount:               {Fixed, 12, Numeric, "Amount, transaction"},
+	FieldTransmissionDateTime: {Fixed, 10, Numeric, "Transmission date and time (MMDDhhmmss)"},
+	FieldSTAN:                 {Fixed, 6, Numeric, "System trace audit number"},
+	FieldLocalTime:            {Fixed, 6, Numeric, "Time, local transaction (hhmmss)"},
+	FieldLocalDate:            {Fixed, 4, Numeric, "Date, local transaction (MMDD)"},
+	FieldExpiry:               {Fixed, 4, Numeric, "Date, expiration (YYMM)"},
+	FieldPOSEntryMode:         {Fixed, 3, Numeric, "Point of service entry mode"},
+	FieldExtendedPaymentCode:  {Fixed, 2, Numeric, "Extended payment code (installments)"},
+	FieldRRN:                  {Fixed, 12, AlphaNumeric, "Retrieval reference number"},
+	FieldApprovalCode:         {Fixed, 6, AlphaNumeric, "Authorization identification response"},
+	FieldResponseCode:         {Fixed, 2, AlphaNumeric, "Response code"},
+	FieldTerminalID:           {Fixed, 8, AlphaNumericSpecial, "Card acceptor terminal identification"},
+	FieldMerchantID:           {Fixed, 15, AlphaNumericSpecial, "Card acceptor identification code"},
+	FieldAdditionalData:       {LLLVAR, 999, AlphaNumericSpecial, "Additional data, private"},
+	FieldCurrency:             {Fixed, 3, Numeric, "Currency code, transaction"},
+	FieldOriginalData:         {Fixed, 42, Numeric, "Original data elements"},
+}
+
+// Tipos de mensagem (MTI). O terceiro dígito distingue o pedido (par) da
+// resposta (ímpar)
+const (
+	MTIAuthorizationRequest  = "0100"
+	MTIAuthorizationResponse = "0110"
+	MTIFinancialRequest      = "0200"
+	MTIFinancialResponse     = "0210"
+	MTIReversalRequest       = "0400"
+	MTIReversalResponse      = "0410"
+)
+
+// Códigos de processamento do campo 3
+const (
+	ProcessingPurchase = "000000"
+	ProcessingRefund   = "200000"
+)
+
+// ResponseMTI retorna o MTI da resposta a um pedido
+func ResponseMTI(mti string) (string, error) {
+	if err := validateMTI(mti); err != nil {
+		return "", err
+	}
+	if IsResponse(mti) {
+		return "", errorf(ErrInvalidMTI, "%s is already a response", mti)
+	}
+	return mti[:2] + string(mti[2]+1) + mti[3:], nil
+}
+
+// IsResponse indica se o MTI é de uma resposta
+func IsResponse(mti string) bool {
+	return len(mti) == 4 && (mti[2]-'0')%2 == 1
+}
+
+// currencyCodes mapeia o código alfabético ISO 4217 ao numérico do campo 49
+var currencyCodes = map[string]string{
+	"BRL": "986",
+	"USD": "840",
+	"EUR": "978",
+	"GBP": "826",
+	"ARS": "032",
+	"MXN": "484",
+	"CAD": "124",
+	"CHF": "756",
+	"UYU": "858",
+	"PYG": "600",
+	"CLP": "152",
+	"JPY": "392",
+	"KRW": "410",
+	"BHD": "048",
+	"KWD": "414",
+	"JOD": "400",
+	"OMR": "512",
+	"TND": "788",
+}
+
+// NumericCurrency retorna o código numérico ISO 4217 de uma moeda
+func NumericCurrency(alpha string) (string, bool) {
+	code, ok := currencyCodes[alpha]
+	return code, ok
+}
+
+// AlphaCurrency retorna o código alfabético ISO 4217 de um código numérico
+func AlphaCurrency(numeric string) (string, bool) {
+	for alpha, code := range currencyCodes {
+		if code == numeric {
+			return alpha, true
+		}
+	}
+	return "", false
+}
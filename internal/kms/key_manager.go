@@ -0,0 +1,366 @@
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const keySize = 32
+
+var (
+	ErrInvalidKey     = errors.New("key must be 32 bytes encoded in base64")
+	ErrUnknownKey     = errors.New("unknown data key")
+	ErrNoActiveKey    = errors.New("no active data key")
+	ErrInvalidPayload = errors.New("invalid ciphertext")
+)
+
+// Ciphertext é um valor cifrado junto com o ID versionado da chave de dados
+// que o cifrou. KeyID vazio indica um valor cifrado com a chave legada do cofre
+type Ciphertext struct {
+	KeyID string
+	Data  []byte
+}
+
+// DataKey é uma chave de dados cifrada (wrapped) pela chave mestra
+type DataKey struct {
+	ID          string
+	Version     int
+	WrappedKey  []byte
+	MasterKeyID string
+	Active      bool
+	CreatedAt   time.Time
+}
+
+// DataKeyStore persiste as chaves de dados cifradas
+type DataKeyStore interface {
+	ListDataKeys(ctx context.Context) ([]*DataKey, error)
+	// CreateDataKey grava a nova chave e, se ativa, desativa as demais
+	CreateDataKey(ctx context.Context, key *DataKey) error
+	UpdateWrappedKey(ctx context.Context, id string, wrappedKey []byte, masterKeyID string) error
+}
+
+// KeyManager implementa criptografia de envelope: valores são cifrados com
+// chaves de dados e as chaves de dados são cifradas pela chave mestra
+type KeyManager interface {
+	Encrypt(ctx context.Context, plaintext, aad []byte) (Ciphertext, error)
+	Decrypt(ctx context.Context, ciphertext Ciphertext, aad []byte) ([]byte, error)
+	// BlindIndex calcula o hash com chave usado nas buscas por igualdade
+	BlindIndex(value string) []byte
+	ActiveKeyID() string
+	// Rotate cria uma nova chave de dados e a torna ativa
+	Rotate(ctx context.Context) (string, error)
+	// RotateIfOlderThan rotaciona a chave ativa se ela for mais antiga que maxAge
+	RotateIfOlderThan(ctx context.Context, maxAge time.Duration) (bool, error)
+	// Refresh recarrega as chaves de dados (rotações feitas por outras réplicas)
+	Refresh(ctx context.Context) error
+}
+
+type Option func(*keyManager) error
+
+// WithLegacyKey registra a chave AES usada pelo cofre antes da criptografia
+// de envelope, para que valores antigos possam ser lidos e recifrados
+func WithLegacyKey(encoded string) Option {
+	return func(m *keyManager) error {
+		if encoded == "" {
+			return nil
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return fmt.Errorf("legacy key: %w", err)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		m.legacy = aead
+		return nil
+	}
+}
+
+type keyManager struct {
+	store  DataKeyStore
+	master *masterKeys
+	legacy cipher.AEAD
+
+	mu         sync.RWMutex
+	dataKeys   map[string]cipher.AEAD
+	active     *DataKey
+	maxVersion int
+}
+
+// NewKeyManager carrega as chaves de dados, recifra com a chave mestra ativa
+// as que ainda usam chaves mestras antigas e cria a primeira chave se necessário
+func NewKeyManager(ctx context.Context, store DataKeyStore, keyfile *Keyfile, opts ...Option) (KeyManager, error) {
+	master, err := keyfile.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &keyManager{
+		store:    store,
+		master:   master,
+		dataKeys: make(map[string]cipher.AEAD),
+	}
+
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	if m.ActiveKeyID() == "" {
+		if _, err := m.Rotate(ctx); err != nil {
+			return nil, fmt.Errorf("failed to create initial data key: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *keyManager) Encrypt(_ context.Context, plaintext, aad []byte) (Ciphertext, error) {
+	m.mu.RLock()
+	active := m.active
+	var aead cipher.AEAD
+	if active != nil {
+		aead = m.dataKeys[active.ID]
+	}
+	m.mu.RUnlock()
+
+	if aead == nil {
+		return Ciphertext{}, ErrNoActiveKey
+	}
+
+	data, err := seal(aead, plaintext, aad)
+	if err != nil {
+		return Ciphertext{}, err
+	}
+
+	return Ciphertext{KeyID: active.ID, Data: data}, nil
+}
+
+func (m *keyManager) Decrypt(ctx context.Context, ciphertext Ciphertext, aad []byte) ([]byte, error) {
+	if ciphertext.KeyID == "" {
+		if m.legacy == nil {
+			return nil, fmt.Errorf("%w: legacy key not configured", ErrUnknownKey)
+		}
+		return open(m.legacy, ciphertext.Data, aad)
+	}
+
+	aead, err := m.dataKey(ctx, ciphertext.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, ciphertext.Data, aad)
+}
+
+func (m *keyManager) BlindIndex(value string) []byte {
+	mac := hmac.New(sha256.New, m.master.indexKey)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+func (m *keyManager) ActiveKeyID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.active == nil {
+		return ""
+	}
+	return m.active.ID
+}
+
+func (m *keyManager) Rotate(ctx context.Context) (string, error) {
+	m.mu.RLock()
+	version := m.maxVersion + 1
+	m.mu.RUnlock()
+
+	plainKey := make([]byte, keySize)
+	if _, err := rand.Read(plainKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	key := &DataKey{
+		ID:          fmt.Sprintf("dk-v%d", version),
+		Version:     version,
+		MasterKeyID: m.master.activeID,
+		Active:      true,
+		CreatedAt:   time.Now(),
+	}
+
+	wrapped, err := m.wrap(plainKey, key.ID, m.master.activeID)
+	if err != nil {
+		return "", err
+	}
+	key.WrappedKey = wrapped
+
+	if err := m.store.CreateDataKey(ctx, key); err != nil {
+		return "", fmt.Errorf("failed to store data key: %w", err)
+	}
+
+	if err := m.Refresh(ctx); err != nil {
+		return "", err
+	}
+
+	return key.ID, nil
+}
+
+func (m *keyManager) RotateIfOlderThan(ctx context.Context, maxAge time.Duration) (bool, error) {
+	m.mu.RLock()
+	active := m.active
+	m.mu.RUnlock()
+
+	if maxAge <= 0 || (active != nil && time.Since(active.CreatedAt) < maxAge) {
+		return false, nil
+	}
+
+	if _, err := m.Rotate(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *keyManager) Refresh(ctx context.Context) error {
+	keys, err := m.store.ListDataKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list data keys: %w", err)
+	}
+
+	dataKeys := make(map[string]cipher.AEAD, len(keys))
+	var active *DataKey
+	maxVersion := 0
+
+	for _, key := range keys {
+		plainKey, err := m.unwrap(key.WrappedKey, key.ID, key.MasterKeyID)
+		if err != nil {
+			return fmt.Errorf("failed to unwrap data key %s: %w", key.ID, err)
+		}
+
+		// Chaves de dados ainda protegidas por uma chave mestra antiga são
+		// recifradas com a chave mestra ativa
+		if key.MasterKeyID != m.master.activeID {
+			wrapped, err := m.wrap(plainKey, key.ID, m.master.activeID)
+			if err != nil {
+				return err
+			}
+			if err := m.store.UpdateWrappedKey(ctx, key.ID, wrapped, m.master.activeID); err != nil {
+				return fmt.Errorf("failed to rewrap data key %s: %w", key.ID, err)
+			}
+		}
+
+		aead, err := newAEAD(plainKey)
+		if err != nil {
+			return err
+		}
+		dataKeys[key.ID] = aead
+
+		if key.Active {
+			active = key
+		}
+		if key.Version > maxVersion {
+			maxVersion = key.Version
+		}
+	}
+
+	m.mu.Lock()
+	m.dataKeys = dataKeys
+	m.active = active
+	m.maxVersion = maxVersion
+	m.mu.Unlock()
+
+	return nil
+}
+
+// dataKey retorna a chave de dados, recarregando do banco se ela tiver sido
+// criada por outra réplica depois do último carregamento
+func (m *keyManager) dataKey(ctx context.Context, id string) (cipher.AEAD, error) {
+	m.mu.RLock()
+	aead, ok := m.dataKeys[id]
+	m.mu.RUnlock()
+
+	if ok {
+		return aead, nil
+	}
+
+	if err := m.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if aead, ok := m.dataKeys[id]; ok {
+		return aead, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+}
+
+func (m *keyManager) wrap(plainKey []byte, keyID, masterKeyID string) ([]byte, error) {
+	masterKey, ok := m.master.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %q not found in keyfile", masterKeyID)
+	}
+
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(aead, plainKey, []byte(keyID))
+}
+
+func (m *keyManager) unwrap(wrapped []byte, keyID, masterKeyID string) ([]byte, error) {
+	masterKey, ok := m.master.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %q not found in keyfile", masterKeyID)
+	}
+
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, wrapped, []byte(keyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal cifra com AES-GCM e prefixa o nonce ao resultado
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, ErrInvalidPayload
+	}
+
+	plaintext, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return plaintext, nil
+}
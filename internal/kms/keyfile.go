@@ -0,0 +1,103 @@
+package kms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Keyfile representa o arquivo local com as chaves mestras. Exemplo:
+//
+//	{
+//	  "active_master_key": "mk-2",
+//	  "master_keys": {"mk-1": "<base64>", "mk-2": "<base64>"},
+//	  "index_key": "<base64>"
+//	}
+//
+// Todas as chaves têm 32 bytes. A chave de índice é usada apenas para os
+// hashes de busca (blind index) e não participa da rotação.
+type Keyfile struct {
+	ActiveMasterKey string            `json:"active_master_key"`
+	MasterKeys      map[string]string `json:"master_keys"`
+	IndexKey        string            `json:"index_key"`
+}
+
+type masterKeys struct {
+	activeID string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+// LoadKeyfile lê e valida o arquivo de chaves mestras
+func LoadKeyfile(path string) (*Keyfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	return ParseKeyfile(data)
+}
+
+// ParseKeyfile interpreta o conteúdo de um keyfile
+func ParseKeyfile(data []byte) (*Keyfile, error) {
+	var keyfile Keyfile
+	if err := json.Unmarshal(data, &keyfile); err != nil {
+		return nil, fmt.Errorf("failed to parse keyfile: %w", err)
+	}
+
+	return &keyfile, nil
+}
+
+// SharesKeys indica se os dois keyfiles têm alguma chave em comum, mestra ou
+// de índice, mesmo com IDs diferentes
+func (k *Keyfile) SharesKeys(other *Keyfile) bool {
+	if k.IndexKey != "" && k.IndexKey == other.IndexKey {
+		return true
+	}
+
+	for _, key := range k.MasterKeys {
+		for _, otherKey := range other.MasterKeys {
+			if key == otherKey {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (k *Keyfile) decode() (*masterKeys, error) {
+	if _, ok := k.MasterKeys[k.ActiveMasterKey]; !ok {
+		return nil, fmt.Errorf("active master key %q not found in keyfile", k.ActiveMasterKey)
+	}
+
+	decoded := &masterKeys{
+		activeID: k.ActiveMasterKey,
+		keys:     make(map[string][]byte, len(k.MasterKeys)),
+	}
+
+	for id, encoded := range k.MasterKeys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		decoded.keys[id] = key
+	}
+
+	indexKey, err := decodeKey(k.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("index key: %w", err)
+	}
+	decoded.indexKey = indexKey
+
+	return decoded, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
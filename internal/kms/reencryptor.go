@@ -0,0 +1,110 @@
+package kms
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RotationTarget é um conjunto de colunas cifradas que pode ser recifrado em
+// lotes. Cada implementação recifra, com a chave ativa, as linhas cifradas
+// com outras chaves (ou ainda em texto claro) e retorna quantas alterou
+type RotationTarget interface {
+	Name() string
+	ReencryptBatch(ctx context.Context, keys KeyManager, limit int) (int, error)
+}
+
+// Reencryptor é o job em background que mantém todos os valores cifrados
+// com a chave de dados ativa, rotacionando-a quando ela expira
+type Reencryptor struct {
+	keys       KeyManager
+	targets    []RotationTarget
+	interval   time.Duration
+	batchSize  int
+	maxKeyAge  time.Duration
+	logger     *logrus.Logger
+	onProgress func(target string, rows int)
+}
+
+func NewReencryptor(keys KeyManager, targets []RotationTarget, interval time.Duration, batchSize int, maxKeyAge time.Duration, logger *logrus.Logger) *Reencryptor {
+	return &Reencryptor{
+		keys:      keys,
+		targets:   targets,
+		interval:  interval,
+		batchSize: batchSize,
+		maxKeyAge: maxKeyAge,
+		logger:    logger,
+	}
+}
+
+// OnProgress registra um callback chamado a cada lote recifrado (métricas)
+func (r *Reencryptor) OnProgress(fn func(target string, rows int)) {
+	r.onProgress = fn
+}
+
+// Start executa o job até o contexto ser cancelado
+func (r *Reencryptor) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).Error("Re-encryption run failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce recarrega as chaves, rotaciona a chave ativa se expirada e recifra
+// todos os alvos até não restarem linhas pendentes
+func (r *Reencryptor) RunOnce(ctx context.Context) error {
+	if err := r.keys.Refresh(ctx); err != nil {
+		return err
+	}
+
+	rotated, err := r.keys.RotateIfOlderThan(ctx, r.maxKeyAge)
+	if err != nil {
+		return err
+	}
+	if rotated {
+		r.logger.WithField("key_id", r.keys.ActiveKeyID()).Info("Data key rotated")
+	}
+
+	for _, target := range r.targets {
+		total := 0
+		for {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			n, err := target.ReencryptBatch(ctx, r.keys, r.batchSize)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+
+			total += n
+			if r.onProgress != nil {
+				r.onProgress(target.Name(), n)
+			}
+		}
+
+		if total > 0 {
+			r.logger.WithFields(logrus.Fields{
+				"target": target.Name(),
+				"rows":   total,
+				"key_id": r.keys.ActiveKeyID(),
+			}).Info("Re-encrypted rows with active data key")
+		}
+	}
+
+	return nil
+}
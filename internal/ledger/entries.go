@@ -0,0 +1,101 @@
+package ledger
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// maxBasisPoints corresponde a 100%
+const maxBasisPoints = 10000
+
+// Fee calcula a taxa em pontos-base sobre o valor, arredondando metade para
+// cima na unidade menor da moeda
+func Fee(amount model.Money, basisPoints int64) (model.Money, error) {
+	if basisPoints < 0 || basisPoints > maxBasisPoints {
+		return model.Money{}, fmt.Errorf("fee must be between 0 and %d basis points", maxBasisPoints)
+	}
+
+	// big.Int evita overflow em valor * pontos-base
+	fee := new(big.Int).Mul(big.NewInt(amount.Value), big.NewInt(basisPoints))
+	fee.Add(fee, big.NewInt(maxBasisPoints/2))
+	fee.Quo(fee, big.NewInt(maxBasisPoints))
+
+	return model.NewMoney(fee.Int64(), amount.Currency), nil
+}
+
+// PaymentCompleted lança a conclusão de um pagamento: debita os fundos do
+// portador e credita o merchant pelo valor líquido e a conta de taxas pela taxa
+func PaymentCompleted(paymentID uuid.UUID, amount, fee model.Money, cardholder, merchant, fees uuid.UUID) (*model.JournalEntry, error) {
+	net, err := amount.Sub(fee)
+	if err != nil {
+		return nil, err
+	}
+
+	postings := []model.Posting{
+		posting(cardholder, model.PostingDebit, amount),
+	}
+	if net.IsPositive() {
+		postings = append(postings, posting(merchant, model.PostingCredit, net))
+	}
+	if fee.IsPositive() {
+		postings = append(postings, posting(fees, model.PostingCredit, fee))
+	}
+
+	return newEntry(model.JournalEntryPaymentCompleted, &paymentID,
+		fmt.Sprintf("payment %s completed", paymentID), postings)
+}
+
+// Refund lança a devolução de um pagamento: o valor sai do merchant e volta
+// aos fundos do portador. A taxa já cobrada não é estornada
+func Refund(paymentID uuid.UUID, amount model.Money, cardholder, merchant uuid.UUID) (*model.JournalEntry, error) {
+	return newEntry(model.JournalEntryRefund, &paymentID,
+		fmt.Sprintf("payment %s refunded", paymentID), []model.Posting{
+			posting(merchant, model.PostingDebit, amount),
+			posting(cardholder, model.PostingCredit, amount),
+		})
+}
+
+// Settlement lança a liquidação do saldo de um merchant: o valor sai da conta
+// do merchant para a suspense até o banco confirmar o repasse
+func Settlement(merchantID string, amount model.Money, merchant, suspense uuid.UUID) (*model.JournalEntry, error) {
+	return newEntry(model.JournalEntrySettlement, nil,
+		fmt.Sprintf("merchant %s settled", merchantID), []model.Posting{
+			posting(merchant, model.PostingDebit, amount),
+			posting(suspense, model.PostingCredit, amount),
+		})
+}
+
+func newEntry(kind model.JournalEntryKind, paymentID *uuid.UUID, description string, postings []model.Posting) (*model.JournalEntry, error) {
+	entry := &model.JournalEntry{
+		ID:          uuid.New(),
+		Kind:        kind,
+		PaymentID:   paymentID,
+		Description: description,
+		CreatedAt:   time.Now(),
+	}
+
+	for _, p := range postings {
+		p.ID = uuid.New()
+		p.EntryID = entry.ID
+		entry.Postings = append(entry.Postings, p)
+	}
+
+	if err := entry.Validate(); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func posting(account uuid.UUID, direction model.PostingDirection, amount model.Money) model.Posting {
+	return model.Posting{
+		LedgerAccountID: account,
+		Direction:       direction,
+		Amount:          amount,
+	}
+}
@@ -1,6 +1,10 @@
 package metrics
 
 import (
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -78,6 +82,100 @@ var (
 		},
 		[]string{"topic", "operation", "status"},
 	)
+
+	// Contador de linhas recifradas com a chave de dados ativa
+	EncryptionRowsReencryptedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "encryption_rows_reencrypted_total",
+			Help: "Total number of rows re-encrypted with the active data key",
+		},
+		[]string{"target"},
+	)
+
+	// Contador de reservas de saldo expiradas antes do processamento
+	BalanceHoldsExpiredTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "balance_holds_expired_total",
+			Help: "Total number of balance holds released by expiry",
+		},
+		[]string{"currency"},
+	)
+
+	// Contador de autorizações de captura manual canceladas por expiração
+	AuthorizationsExpiredTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payment_authorizations_expired_total",
+			Help: "Total number of manual-capture authorizations voided by expiry",
+		},
+		[]string{"currency"},
+	)
+
+	// Gauge de mensagens consumidas aguardando um worker
+	KafkaConsumerQueuedMessages = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kafka_consumer_queued_messages",
+			Help: "Number of consumed messages waiting for a worker",
+		},
+	)
+
+	// Gauge de mensagens consumidas em processamento
+	KafkaConsumerInFlightMessages = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kafka_consumer_in_flight_messages",
+			Help: "Number of consumed messages being processed",
+		},
+	)
+
+	// Contador de mensagens enviadas ao tópico de dead-letter
+	KafkaDeadLettersTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_dead_letters_total",
+			Help: "Total number of messages sent to the dead letter topic",
+		},
+		[]string{"error_class"},
+	)
+
+	// Contador de novas tentativas agendadas nos tópicos de retry
+	KafkaRetriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_retries_total",
+			Help: "Total number of messages scheduled for retry",
+		},
+		[]string{"topic"},
+	)
+
+	// Contador de ações do reaper sobre pagamentos parados
+	PaymentsReapedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payments_reaped_total",
+			Help: "Total number of stuck payments requeued, cancelled or failed by the reaper",
+		},
+		[]string{"status", "action"},
+	)
+
+	// Gauge de mensagens do outbox ainda não publicadas no Kafka
+	OutboxPendingMessages = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_pending_messages",
+			Help: "Number of outbox messages waiting to be published",
+		},
+	)
+
+	// Gauge da idade da mensagem pendente mais antiga do outbox
+	OutboxOldestPendingAge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_oldest_pending_age_seconds",
+			Help: "Age of the oldest outbox message waiting to be published",
+		},
+	)
+
+	// Gauge de mensagens do outbox que esgotaram as tentativas de publicação
+	OutboxDeadMessages = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_dead_messages",
+			Help: "Number of outbox messages that exhausted their publish attempts",
+		},
+	)
 )
 
 // RecordPaymentCreated registra a criação de um pagamento
@@ -90,9 +188,9 @@ func RecordPaymentProcessed(status, merchantID string) {
 	PaymentsProcessedTotal.WithLabelValues(status, merchantID).Inc()
 }
 
-// RecordPaymentAmount registra o valor de um pagamento
-func RecordPaymentAmount(currency string, amount float64) {
-	PaymentAmountTotal.WithLabelValues(currency).Add(amount)
+// RecordPaymentAmount registra o valor de um pagamento em unidades maiores da moeda
+func RecordPaymentAmount(amount model.Money) {
+	PaymentAmountTotal.WithLabelValues(string(amount.Currency)).Add(amount.Float64())
 }
 
 // RecordHTTPRequest registra uma requisição HTTP
@@ -103,4 +201,52 @@ func RecordHTTPRequest(method, endpoint, statusCode string) {
 // RecordKafkaMessage registra uma mensagem Kafka
 func RecordKafkaMessage(topic, operation, status string) {
 	KafkaMessagesTotal.WithLabelValues(topic, operation, status).Inc()
-} 
\ No newline at end of file
+}
+
+// RecordRowsReencrypted registra linhas recifradas pelo job de rotação
+func RecordRowsReencrypted(target string, rows int) {
+	EncryptionRowsReencryptedTotal.WithLabelValues(target).Add(float64(rows))
+}
+
+// RecordHoldExpired registra uma reserva de saldo expirada
+func RecordHoldExpired(hold *model.Hold) {
+	BalanceHoldsExpiredTotal.WithLabelValues(string(hold.Amount.Currency)).Inc()
+}
+
+// RecordAuthorizationExpired registra uma autorização cancelada por expiração
+func RecordAuthorizationExpired(payment *model.Payment) {
+	AuthorizationsExpiredTotal.WithLabelValues(string(payment.Amount.Currency)).Inc()
+}
+
+// RecordOutboxBacklog registra o backlog do outbox
+func RecordOutboxBacklog(backlog *model.OutboxBacklog) {
+	OutboxPendingMessages.Set(float64(backlog.Pending))
+	OutboxDeadMessages.Set(float64(backlog.Dead))
+
+	age := 0.0
+	if backlog.OldestCreatedAt != nil {
+		age = time.Since(*backlog.OldestCreatedAt).Seconds()
+	}
+	OutboxOldestPendingAge.Set(age)
+}
+
+// RecordConsumerLoad registra as mensagens enfileiradas e em processamento no consumidor
+func RecordConsumerLoad(queued, inFlight int64) {
+	KafkaConsumerQueuedMessages.Set(float64(queued))
+	KafkaConsumerInFlightMessages.Set(float64(inFlight))
+}
+
+// RecordDeadLetter registra uma mensagem enviada ao dead-letter
+func RecordDeadLetter(class model.DeadLetterErrorClass) {
+	KafkaDeadLettersTotal.WithLabelValues(string(class)).Inc()
+}
+
+// RecordRetryScheduled registra uma nova tentativa agendada num tópico de retry
+func RecordRetryScheduled(topic string) {
+	KafkaRetriesTotal.WithLabelValues(topic).Inc()
+}
+
+// RecordPaymentReaped registra uma ação do reaper sobre um pagamento parado
+func RecordPaymentReaped(event *model.PaymentEvent) {
+	PaymentsReapedTotal.WithLabelValues(string(event.FromStatus), string(event.Type)).Inc()
+}
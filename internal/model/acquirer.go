@@ -0,0 +1,51 @@
+package model
+
+import "github.com/google/uuid"
+
+// AcquirerResponseCode é o código de resposta do adquirente, no padrão de
+// dois caracteres das redes de cartão
+type AcquirerResponseCode string
+
+const (
+	ResponseApproved          AcquirerResponseCode = "00"
+	ResponseDoNotHonor        AcquirerResponseCode = "05"
+	ResponseStolenCard        AcquirerResponseCode = "43"
+	ResponseInsufficientFunds AcquirerResponseCode = "51"
+	// ResponseAuthenticationRequired pede a autenticação do portador (3DS)
+	// antes de uma nova autorização
+	ResponseAuthenticationRequired AcquirerResponseCode = "1A"
+)
+
+// AuthorizationRequest pede ao adquirente a autorização de um pagamento
+type AuthorizationRequest struct {
+	PaymentID  uuid.UUID
+	MerchantID string
+	Amount     Money
+	Card       *Card
+	// Installments é o parcelamento, nil à vista
+	Installments *InstallmentPlan
+}
+
+// AcquirerRequest pede uma operação sobre uma autorização já aprovada:
+// captura, cancelamento ou estorno. NetworkReference identifica a
+// autorização na rede
+type AcquirerRequest struct {
+	PaymentID        uuid.UUID
+	Amount           Money
+	NetworkReference string
+	// Reference identifica a própria operação e se repete em cada tentativa,
+	// para o adquirente reconhecer um reenvio em vez de executá-lo de novo.
+	// Vazia, as tentativas não se distinguem de operações novas
+	Reference string
+}
+
+// AcquirerResponse é a resposta estruturada do adquirente a uma operação
+type AcquirerResponse struct {
+	Approved     bool                 `json:"approved"`
+	ResponseCode AcquirerResponseCode `json:"response_code"`
+	// ApprovalCode é o código de autorização do emissor, presente quando aprovada
+	ApprovalCode string `json:"approval_code,omitempty"`
+	// DeclineReason descreve a recusa, presente quando não aprovada
+	DeclineReason    string `json:"decline_reason,omitempty"`
+	NetworkReference string `json:"network_reference,omitempty"`
+}
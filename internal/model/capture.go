@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CaptureStatus representa o status de uma captura
+type CaptureStatus string
+
+const (
+	// CaptureStatusPending indica que a captura foi reservada e aguarda o
+	// adquirente
+	CaptureStatusPending CaptureStatus = "pending"
+	// CaptureStatusSucceeded indica que a captura foi aprovada e somada ao
+	// total capturado
+	CaptureStatusSucceeded CaptureStatus = "succeeded"
+	// CaptureStatusFailed indica que a captura foi recusada ou desfeita
+	CaptureStatusFailed CaptureStatus = "failed"
+)
+
+// PaymentCapture é uma captura de um pagamento. Uma autorização pode ser
+// capturada em várias parcelas; a última é marcada como final e encerra a
+// autorização
+type PaymentCapture struct {
+	ID               uuid.UUID            `json:"id" db:"id"`
+	PaymentID        uuid.UUID            `json:"payment_id" db:"payment_id"`
+	Amount           Money                `json:"amount" db:"amount"`
+	Final            bool                 `json:"final" db:"final"`
+	Status           CaptureStatus        `json:"status" db:"status"`
+	Acquirer         string               `json:"acquirer,omitempty" db:"acquirer"`
+	ResponseCode     AcquirerResponseCode `json:"response_code,omitempty" db:"response_code"`
+	NetworkReference string               `json:"network_reference,omitempty" db:"network_reference"`
+	CreatedAt        time.Time            `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,66 @@
+package model
+
+import "time"
+
+// DeadLetterErrorClass classifica o motivo de uma mensagem ter ido para o
+// tópico de dead-letter
+type DeadLetterErrorClass string
+
+const (
+	// DeadLetterMalformed indica um payload que não é uma mensagem de pagamento
+	DeadLetterMalformed DeadLetterErrorClass = "malformed_message"
+	// DeadLetterInvalidPaymentID indica uma mensagem com ID de pagamento inválido
+	DeadLetterInvalidPaymentID DeadLetterErrorClass = "invalid_payment_id"
+	// DeadLetterProcessingError indica que o processamento do pagamento falhou
+	DeadLetterProcessingError DeadLetterErrorClass = "processing_error"
+	// DeadLetterTimeout indica que o processamento passou do tempo máximo
+	DeadLetterTimeout DeadLetterErrorClass = "timeout"
+	// DeadLetterRetriesExhausted indica uma falha transitória que persistiu
+	// depois de todas as tentativas
+	DeadLetterRetriesExhausted DeadLetterErrorClass = "retries_exhausted"
+)
+
+// MessageFailure descreve a falha de processamento de uma mensagem, gravada
+// nos cabeçalhos quando ela vai para um tópico de retry ou para o dead-letter
+type MessageFailure struct {
+	Class    DeadLetterErrorClass
+	Err      error
+	Attempts int
+	FailedAt time.Time
+}
+
+// DeadLetter é uma mensagem do tópico de dead-letter: a mensagem original,
+// sem alterações, e os metadados da falha. Partition e Offset são a posição no
+// tópico de dead-letter; Original* são a posição no tópico de origem
+type DeadLetter struct {
+	Partition         int                  `json:"partition"`
+	Offset            int64                `json:"offset"`
+	Key               string               `json:"key"`
+	Value             []byte               `json:"-"`
+	Payload           string               `json:"payload"`
+	OriginalTopic     string               `json:"original_topic"`
+	OriginalPartition int                  `json:"original_partition"`
+	OriginalOffset    int64                `json:"original_offset"`
+	ErrorClass        DeadLetterErrorClass `json:"error_class"`
+	ErrorMessage      string               `json:"error_message"`
+	Attempts          int                  `json:"attempts"`
+	FailedAt          time.Time            `json:"failed_at"`
+}
+
+// DeadLetterPartition resume uma partição do tópico de dead-letter. Os
+// offsets vão de FirstOffset (inclusive) a LastOffset (exclusive)
+type DeadLetterPartition struct {
+	Partition   int   `json:"partition"`
+	FirstOffset int64 `json:"first_offset"`
+	LastOffset  int64 `json:"last_offset"`
+	Messages    int64 `json:"messages"`
+}
+
+// DeadLetterRedrive registra o reenvio de uma mensagem do dead-letter ao
+// tópico de origem, pelo outbox
+type DeadLetterRedrive struct {
+	Partition       int    `json:"partition"`
+	Offset          int64  `json:"offset"`
+	Topic           string `json:"topic"`
+	OutboxMessageID int64  `json:"outbox_message_id"`
+}
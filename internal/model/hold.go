@@ -0,0 +1,49 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HoldStatus representa o status de uma reserva de saldo
+type HoldStatus string
+
+const (
+	// HoldStatusActive bloqueia o valor no saldo disponível da conta
+	HoldStatusActive HoldStatus = "active"
+	// HoldStatusCaptured indica que a reserva virou débito no saldo contábil
+	HoldStatusCaptured HoldStatus = "captured"
+	// HoldStatusReleased indica que o valor voltou ao saldo disponível
+	HoldStatusReleased HoldStatus = "released"
+	// HoldStatusExpired indica que a reserva expirou antes do processamento
+	HoldStatusExpired HoldStatus = "expired"
+)
+
+// IsValid indica se o status é conhecido
+func (s HoldStatus) IsValid() bool {
+	switch s {
+	case HoldStatusActive, HoldStatusCaptured, HoldStatusReleased, HoldStatusExpired:
+		return true
+	}
+	return false
+}
+
+// Hold é uma reserva do valor de um pagamento no saldo disponível da conta,
+// feita na criação e encerrada na conclusão, falha, cancelamento ou expiração
+type Hold struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	AccountID  uuid.UUID  `json:"account_id" db:"account_id"`
+	PaymentID  uuid.UUID  `json:"payment_id" db:"payment_id"`
+	Amount     Money      `json:"amount" db:"amount"`
+	Status     HoldStatus `json:"status" db:"status"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+	ReleasedAt *time.Time `json:"released_at,omitempty" db:"released_at"`
+}
+
+// IsExpired indica se a reserva ativa já passou da validade
+func (h *Hold) IsExpired(now time.Time) bool {
+	return h.Status == HoldStatusActive && !now.Before(h.ExpiresAt)
+}
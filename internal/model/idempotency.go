@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// IdempotencyKey guarda, por merchant, a primeira requisição feita com uma
+// Idempotency-Key e a resposta devolvida a ela
+type IdempotencyKey struct {
+	MerchantID     string     `json:"merchant_id" db:"merchant_id"`
+	Key            string     `json:"key" db:"key"`
+	RequestHash    string     `json:"request_hash" db:"request_hash"`
+	ResponseStatus int        `json:"response_status,omitempty" db:"response_status"`
+	ResponseBody   []byte     `json:"-" db:"response_body"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	// LockedUntil é o fim do lease da requisição em andamento; depois dele a
+	// mesma requisição pode reservar a chave de novo
+	LockedUntil *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+// IsCompleted indica se a resposta da primeira requisição já foi gravada.
+// Enquanto não foi, a requisição original ainda está em andamento
+func (k *IdempotencyKey) IsCompleted() bool {
+	return k.CompletedAt != nil
+}
+
+// IsLeaseExpired indica se a requisição em andamento perdeu o lease da chave
+func (k *IdempotencyKey) IsLeaseExpired(now time.Time) bool {
+	return !k.IsCompleted() && (k.LockedUntil == nil || !now.Before(*k.LockedUntil))
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidInstallmentRules = errors.New("invalid installment rules")
+
+// MaxInstallments é o maior parcelamento aceito pelas bandeiras no Brasil
+const MaxInstallments = 24
+
+// InstallmentInterest define quem cobra os juros de um parcelamento
+type InstallmentInterest string
+
+const (
+	// InstallmentInterestFree é o parcelado sem juros: o portador paga o
+	// valor da compra dividido nas parcelas e o merchant absorve o custo
+	InstallmentInterestFree InstallmentInterest = "interest_free"
+	// InstallmentInterestIssuer é o parcelado emissor: o merchant recebe o
+	// valor da compra e o emissor cobra os juros do portador na fatura
+	InstallmentInterestIssuer InstallmentInterest = "issuer"
+	// InstallmentInterestMerchant é o parcelado com juros do lojista: os
+	// juros entram no valor cobrado do cartão
+	InstallmentInterestMerchant InstallmentInterest = "merchant"
+)
+
+// InstallmentRules são as regras de parcelamento de um merchant. Até
+// InterestFreeInstallments parcelas o parcelamento é sem juros; acima disso
+// Interest define quem cobra os juros, à taxa mensal MonthlyRateBPS
+type InstallmentRules struct {
+	MerchantID      string `json:"merchant_id" db:"merchant_id"`
+	MaxInstallments int    `json:"max_installments" db:"max_installments"`
+	// MinInstallmentAmount é o menor valor aceito para cada parcela
+	MinInstallmentAmount     Money               `json:"min_installment_amount" db:"min_installment_amount"`
+	InterestFreeInstallments int                 `json:"interest_free_installments" db:"interest_free_installments"`
+	Interest                 InstallmentInterest `json:"interest" db:"interest"`
+	// MonthlyRateBPS é a taxa de juros ao mês em pontos-base (199 = 1,99% a.m.)
+	MonthlyRateBPS int64      `json:"monthly_rate_bps" db:"monthly_rate_bps"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// Validate verifica se as regras são coerentes
+func (r *InstallmentRules) Validate() error {
+	switch {
+	case r.MaxInstallments < 1 || r.MaxInstallments > MaxInstallments:
+		return fmt.Errorf("%w: max_installments must be between 1 and %d", ErrInvalidInstallmentRules, MaxInstallments)
+	case r.InterestFreeInstallments < 1 || r.InterestFreeInstallments > r.MaxInstallments:
+		return fmt.Errorf("%w: interest_free_installments must be between 1 and max_installments", ErrInvalidInstallmentRules)
+	case r.Interest != InstallmentInterestIssuer && r.Interest != InstallmentInterestMerchant:
+		return fmt.Errorf("%w: interest must be issuer or merchant", ErrInvalidInstallmentRules)
+	case r.MonthlyRateBPS < 0 || r.MonthlyRateBPS > 10000:
+		return fmt.Errorf("%w: monthly_rate_bps must be between 0 and 10000", ErrInvalidInstallmentRules)
+	case r.MinInstallmentAmount.Value < 0:
+		return fmt.Errorf("%w: min_installment_amount must not be negative", ErrInvalidInstallmentRules)
+	}
+	if err := r.MinInstallmentAmount.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInstallmentRules, err)
+	}
+	return nil
+}
+
+// InstallmentPlan é o parcelamento de um pagamento, calculado na criação e
+// enviado ao adquirente junto com a autorização
+type InstallmentPlan struct {
+	Count    int                 `json:"count"`
+	Interest InstallmentInterest `json:"interest"`
+	// MonthlyRateBPS é a taxa aplicada; zero no parcelado sem juros
+	MonthlyRateBPS int64 `json:"monthly_rate_bps"`
+	// Principal é o valor da compra e Total a soma das parcelas
+	Principal Money         `json:"principal"`
+	Total     Money         `json:"total"`
+	Schedule  []Installment `json:"schedule"`
+}
+
+// Installment é uma parcela do plano
+type Installment struct {
+	Number  int       `json:"number"`
+	Amount  Money     `json:"amount"`
+	DueDate time.Time `json:"due_date"`
+}
+
+// Charged é o valor cobrado do cartão: com juros do lojista, o total das
+// parcelas; nos demais, o valor da compra
+func (p *InstallmentPlan) Charged() Money {
+	if p.Interest == InstallmentInterestMerchant {
+		return p.Total
+	}
+	return p.Principal
+}
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("journal entry is unbalanced")
+	ErrInvalidPosting  = errors.New("invalid posting")
+)
+
+// LedgerAccountType classifica as contas do razão
+type LedgerAccountType string
+
+const (
+	// LedgerAccountCardholder guarda os fundos de um portador (uma por conta)
+	LedgerAccountCardholder LedgerAccountType = "cardholder"
+	// LedgerAccountMerchant é o valor devido a um merchant até a liquidação
+	LedgerAccountMerchant LedgerAccountType = "merchant"
+	// LedgerAccountFees acumula as taxas cobradas dos merchants
+	LedgerAccountFees LedgerAccountType = "fees"
+	// LedgerAccountSuspense é a contrapartida de saldos de abertura e de
+	// valores em trânsito, como liquidações enviadas aos bancos
+	LedgerAccountSuspense LedgerAccountType = "suspense"
+)
+
+// SystemLedgerOwner é o dono das contas internas (taxas e suspense)
+const SystemLedgerOwner = "system"
+
+// PostingDirection indica o lado do lançamento
+type PostingDirection string
+
+const (
+	PostingDebit  PostingDirection = "debit"
+	PostingCredit PostingDirection = "credit"
+)
+
+// JournalEntryKind identifica o evento que originou o lançamento
+type JournalEntryKind string
+
+const (
+	JournalEntryOpeningBalance   JournalEntryKind = "opening_balance"
+	JournalEntryPaymentCompleted JournalEntryKind = "payment_completed"
+	JournalEntryRefund           JournalEntryKind = "refund"
+	JournalEntrySettlement       JournalEntryKind = "settlement"
+)
+
+// LedgerAccount é uma conta do razão. Todas as contas seguem a natureza
+// credora: o saldo é a soma dos créditos menos a soma dos débitos, e a soma
+// dos saldos de todas as contas é sempre zero
+type LedgerAccount struct {
+	ID        uuid.UUID         `json:"id" db:"id"`
+	Type      LedgerAccountType `json:"type" db:"type"`
+	OwnerID   string            `json:"owner_id" db:"owner_id"`
+	Balance   Money             `json:"balance" db:"balance"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at" db:"updated_at"`
+}
+
+// Posting é uma perna de um lançamento contábil
+type Posting struct {
+	ID              uuid.UUID        `json:"id" db:"id"`
+	EntryID         uuid.UUID        `json:"entry_id" db:"entry_id"`
+	LedgerAccountID uuid.UUID        `json:"ledger_account_id" db:"ledger_account_id"`
+	Direction       PostingDirection `json:"direction" db:"direction"`
+	Amount          Money            `json:"amount" db:"amount"`
+}
+
+// SignedAmount retorna o efeito da perna no saldo da conta (créditos somam)
+func (p *Posting) SignedAmount() int64 {
+	if p.Direction == PostingDebit {
+		return -p.Amount.Value
+	}
+	return p.Amount.Value
+}
+
+// JournalEntry é um lançamento contábil de partidas dobradas
+type JournalEntry struct {
+	ID          uuid.UUID        `json:"id" db:"id"`
+	Kind        JournalEntryKind `json:"kind" db:"kind"`
+	PaymentID   *uuid.UUID       `json:"payment_id,omitempty" db:"payment_id"`
+	Description string           `json:"description" db:"description"`
+	Postings    []Posting        `json:"postings"`
+	CreatedAt   time.Time        `json:"created_at" db:"created_at"`
+}
+
+// Validate garante que o lançamento tem ao menos duas pernas positivas, numa
+// única moeda, e que a soma dos débitos é igual à soma dos créditos
+func (e *JournalEntry) Validate() error {
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("%w: at least two postings are required", ErrUnbalancedEntry)
+	}
+
+	currency := e.Postings[0].Amount.Currency
+	var debits, credits Money
+	debits.Currency, credits.Currency = currency, currency
+
+	for _, p := range e.Postings {
+		if p.Amount.Currency != currency {
+			return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, currency, p.Amount.Currency)
+		}
+		if !p.Amount.IsPositive() {
+			return fmt.Errorf("%w: amount must be positive", ErrInvalidPosting)
+		}
+
+		var err error
+		switch p.Direction {
+		case PostingDebit:
+			debits, err = debits.Add(p.Amount)
+		case PostingCredit:
+			credits, err = credits.Add(p.Amount)
+		default:
+			return fmt.Errorf("%w: unknown direction %q", ErrInvalidPosting, p.Direction)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if debits.Value != credits.Value {
+		return fmt.Errorf("%w: debits %s, credits %s", ErrUnbalancedEntry, debits, credits)
+	}
+
+	return nil
+}
+
+// LedgerDrift é uma divergência encontrada pelo verificador de invariantes
+type LedgerDrift struct {
+	Check    string   `json:"check"`
+	Subject  string   `json:"subject"`
+	Currency Currency `json:"currency"`
+	Expected int64    `json:"expected"`
+	Actual   int64    `json:"actual"`
+}
+
+// LedgerReport é o resultado de uma verificação do razão
+type LedgerReport struct {
+	CheckedAt time.Time     `json:"checked_at"`
+	Balanced  bool          `json:"balanced"`
+	Drifts    []LedgerDrift `json:"drifts"`
+}
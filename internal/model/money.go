@@ -0,0 +1,198 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrAmountOverflow   = errors.New("amount overflow")
+)
+
+// Currency representa um código de moeda ISO 4217
+type Currency string
+
+// currencyExponents mapeia cada moeda suportada para o número de casas
+// decimais da sua unidade menor (ISO 4217)
+var currencyExponents = map[Currency]int{
+	"BRL": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"ARS": 2,
+	"MXN": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"UYU": 2,
+	"PYG": 0,
+	"CLP": 0,
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"JOD": 3,
+	"OMR": 3,
+	"TND": 3,
+}
+
+// Exponent retorna o número de casas decimais da moeda
+func (c Currency) Exponent() (int, error) {
+	exp, ok := currencyExponents[c]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, string(c))
+	}
+	return exp, nil
+}
+
+// IsValid verifica se a moeda é suportada
+func (c Currency) IsValid() bool {
+	_, ok := currencyExponents[c]
+	return ok
+}
+
+// Money representa um valor monetário em unidades menores da moeda
+// (centavos para BRL, ienes para JPY, fils para BHD)
+type Money struct {
+	Value    int64    `json:"value"`
+	Currency Currency `json:"currency"`
+}
+
+// NewMoney cria um valor a partir de unidades menores
+func NewMoney(value int64, currency Currency) Money {
+	return Money{Value: value, Currency: currency}
+}
+
+// ParseMoney converte um valor decimal ("100.50") para unidades menores,
+// rejeitando mais casas decimais do que a moeda permite
+func ParseMoney(amount string, currency Currency) (Money, error) {
+	exp, err := currency.Exponent()
+	if err != nil {
+		return Money{}, err
+	}
+
+	amount = strings.TrimSpace(amount)
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+
+	intPart, fracPart, _ := strings.Cut(amount, ".")
+	if intPart == "" || len(fracPart) > exp || !isDigits(intPart) || (fracPart != "" && !isDigits(fracPart)) {
+		return Money{}, fmt.Errorf("%w: %q for %s", ErrInvalidAmount, amount, currency)
+	}
+	fracPart += strings.Repeat("0", exp-len(fracPart))
+
+	value, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrAmountOverflow, amount)
+	}
+	if negative {
+		value = -value
+	}
+
+	return Money{Value: value, Currency: currency}, nil
+}
+
+// Validate verifica se a moeda do valor é suportada
+func (m Money) Validate() error {
+	if !m.Currency.IsValid() {
+		return fmt.Errorf("%w: %q", ErrUnknownCurrency, string(m.Currency))
+	}
+	return nil
+}
+
+// IsPositive verifica se o valor é maior que zero
+func (m Money) IsPositive() bool {
+	return m.Value > 0
+}
+
+// IsZero verifica se o valor é zero
+func (m Money) IsZero() bool {
+	return m.Value == 0
+}
+
+// Add soma dois valores da mesma moeda
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	if (other.Value > 0 && m.Value > math.MaxInt64-other.Value) ||
+		(other.Value < 0 && m.Value < math.MinInt64-other.Value) {
+		return Money{}, ErrAmountOverflow
+	}
+	return Money{Value: m.Value + other.Value, Currency: m.Currency}, nil
+}
+
+// Sub subtrai dois valores da mesma moeda
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Value == math.MinInt64 {
+		return Money{}, ErrAmountOverflow
+	}
+	return m.Add(Money{Value: -other.Value, Currency: other.Currency})
+}
+
+// Cmp compara dois valores da mesma moeda, retornando -1, 0 ou 1
+func (m Money) Cmp(other Money) (int, error) {
+	if m.Currency != other.Currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	switch {
+	case m.Value < other.Value:
+		return -1, nil
+	case m.Value > other.Value:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Decimal formata o valor em notação decimal respeitando o expoente da moeda
+func (m Money) Decimal() string {
+	exp, err := m.Currency.Exponent()
+	if err != nil {
+		return strconv.FormatInt(m.Value, 10)
+	}
+
+	sign := ""
+	abs := uint64(m.Value)
+	if m.Value < 0 {
+		sign = "-"
+		abs = uint64(-(m.Value + 1)) + 1
+	}
+
+	digits := strconv.FormatUint(abs, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// Float64 converte o valor para unidades maiores; usar apenas para métricas
+func (m Money) Float64() float64 {
+	exp, err := m.Currency.Exponent()
+	if err != nil {
+		return float64(m.Value)
+	}
+	return float64(m.Value) / math.Pow10(exp)
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + string(m.Currency)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
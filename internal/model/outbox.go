@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// OutboxStatus representa o status de uma mensagem do outbox
+type OutboxStatus string
+
+const (
+	// OutboxStatusPending aguarda publicação pelo relay
+	OutboxStatusPending OutboxStatus = "pending"
+	// OutboxStatusSent indica que a mensagem foi aceita pelo Kafka
+	OutboxStatusSent OutboxStatus = "sent"
+	// OutboxStatusDead indica que a mensagem esgotou as tentativas e saiu da fila
+	OutboxStatusDead OutboxStatus = "dead"
+)
+
+// OutboxMessage é uma mensagem gravada na mesma transação da mudança de
+// estado que a originou e publicada no Kafka depois pelo relay. Mensagens
+// com a mesma chave são publicadas na ordem de ID
+type OutboxMessage struct {
+	ID            int64        `json:"id" db:"id"`
+	Topic         string       `json:"topic" db:"topic"`
+	Key           string       `json:"key" db:"message_key"`
+	Payload       []byte       `json:"payload" db:"payload"`
+	Status        OutboxStatus `json:"status" db:"status"`
+	Attempts      int          `json:"attempts" db:"attempts"`
+	LastError     *string      `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt time.Time    `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt     time.Time    `json:"created_at" db:"created_at"`
+	SentAt        *time.Time   `json:"sent_at,omitempty" db:"sent_at"`
+}
+
+// OutboxBacklog resume as mensagens ainda não publicadas
+type OutboxBacklog struct {
+	Pending         int64
+	OldestCreatedAt *time.Time
+	// Dead conta as mensagens que esgotaram as tentativas
+	Dead int64
+}
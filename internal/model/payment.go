@@ -3,6 +3,9 @@ package model
 import (
 	"time"
 
+	"golang-payment-microservice/internal/cardvalidation"
+	"golang-payment-microservice/internal/redact"
+
 	"github.com/google/uuid"
 )
 
@@ -10,51 +13,254 @@ import (
 type PaymentStatus string
 
 const (
-	PaymentStatusPending   PaymentStatus = "pending"
+	PaymentStatusPending    PaymentStatus = "pending"
 	PaymentStatusProcessing PaymentStatus = "processing"
-	PaymentStatusCompleted PaymentStatus = "completed"
-	PaymentStatusFailed    PaymentStatus = "failed"
-	PaymentStatusCancelled PaymentStatus = "cancelled"
+	// PaymentStatusAuthorized indica uma autorização de captura manual
+	// aguardando a captura ou o cancelamento
+	PaymentStatusAuthorized PaymentStatus = "authorized"
+	PaymentStatusCompleted  PaymentStatus = "completed"
+	PaymentStatusFailed     PaymentStatus = "failed"
+	PaymentStatusCancelled  PaymentStatus = "cancelled"
+	// PaymentStatusVoided indica uma autorização cancelada antes da captura,
+	// pela API ou por ter expirado
+	PaymentStatusVoided PaymentStatus = "voided"
 )
 
+// CaptureMethod define quando o valor autorizado é capturado
+type CaptureMethod string
+
+const (
+	// CaptureAutomatic captura o valor junto com a autorização
+	CaptureAutomatic CaptureMethod = "automatic"
+	// CaptureManual deixa o pagamento authorized até a captura pela API
+	CaptureManual CaptureMethod = "manual"
+)
+
+// IsValid indica se o método de captura é conhecido
+func (m CaptureMethod) IsValid() bool {
+	return m == CaptureAutomatic || m == CaptureManual
+}
+
 // Payment representa uma transação de pagamento
 type Payment struct {
 	ID          uuid.UUID     `json:"id" db:"id"`
-	CardNumber  string        `json:"card_number" db:"card_number"`
+	CardToken   string        `json:"card_token" db:"card_token"`
+	CardBIN     string        `json:"card_bin" db:"card_bin"`
+	CardLast4   string        `json:"card_last4" db:"card_last4"`
+	CardBrand   string        `json:"card_brand" db:"card_brand"`
 	CardHolder  string        `json:"card_holder" db:"card_holder"`
 	ExpiryMonth int           `json:"expiry_month" db:"expiry_month"`
 	ExpiryYear  int           `json:"expiry_year" db:"expiry_year"`
-	CVV         string        `json:"cvv" db:"cvv"`
-	Amount      float64       `json:"amount" db:"amount"`
-	Currency    string        `json:"currency" db:"currency"`
+	Amount      Money         `json:"amount" db:"amount"`
 	MerchantID  string        `json:"merchant_id" db:"merchant_id"`
 	Status      PaymentStatus `json:"status" db:"status"`
 	CreatedAt   time.Time     `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time     `json:"updated_at" db:"updated_at"`
-	ProcessedAt *time.Time    `json:"processed_at,omitempty" db:"processed_at"`
-	ErrorMsg    *string       `json:"error_msg,omitempty" db:"error_msg"`
+	// CardFingerprint é o blind index do PAN, o mesmo para o cartão em todos
+	// os merchants; vazio nos pagamentos anteriores a ele
+	CardFingerprint []byte `json:"-" db:"card_fingerprint"`
+	// CaptureMethod é automatic, salvo quando pedido manual na criação
+	CaptureMethod CaptureMethod `json:"capture_method" db:"capture_method"`
+	// AuthorizedAmount é o total autorizado: Amount mais os incrementos
+	AuthorizedAmount Money `json:"authorized_amount" db:"authorized_amount"`
+	// CapturedAmount é a soma das capturas, que com capturas parciais fica
+	// abaixo de AuthorizedAmount; fica vazio até a primeira captura
+	CapturedAmount *Money `json:"captured_amount,omitempty" db:"captured_amount"`
+	// RefundedAmount é a soma dos estornos concluídos
+	RefundedAmount Money `json:"refunded_amount" db:"refunded_amount"`
+	// Installments é o número de parcelas, 1 à vista. InstallmentPlan traz
+	// as parcelas e fica vazio à vista
+	Installments    int              `json:"installments" db:"installments"`
+	InstallmentPlan *InstallmentPlan `json:"installment_plan,omitempty" db:"installment_plan"`
+	// AuthorizationExpiresAt é o prazo para capturar uma autorização manual;
+	// depois dele a autorização é cancelada e a reserva liberada
+	AuthorizationExpiresAt *time.Time `json:"authorization_expires_at,omitempty" db:"authorization_expires_at"`
+	// ProcessedAt é o momento em que o pagamento chegou a um estado final
+	ProcessedAt         *time.Time `json:"processed_at,omitempty" db:"processed_at"`
+	ProcessingStartedAt *time.Time `json:"processing_started_at,omitempty" db:"processing_started_at"`
+	CompletedAt         *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	FailedAt            *time.Time `json:"failed_at,omitempty" db:"failed_at"`
+	CancelledAt         *time.Time `json:"cancelled_at,omitempty" db:"cancelled_at"`
+	AuthorizedAt        *time.Time `json:"authorized_at,omitempty" db:"authorized_at"`
+	VoidedAt            *time.Time `json:"voided_at,omitempty" db:"voided_at"`
+	ErrorMsg            *string    `json:"error_msg,omitempty" db:"error_msg"`
+	// Attempts conta as tentativas de processamento, incluindo as repetidas
+	// após falhas transitórias
+	Attempts int `json:"attempts" db:"attempts"`
+	// Requeues conta os reenvios feitos pelo reaper com o pagamento parado
+	Requeues int `json:"requeues" db:"requeues"`
+	// HeartbeatAt é o último sinal de vida do processamento: a entrada em
+	// processing, cada tentativa e cada reenvio. O reaper o usa no lugar de
+	// UpdatedAt, que qualquer atualização do pagamento renova
+	HeartbeatAt time.Time `json:"-" db:"heartbeat_at"`
+	// Acquirer e os campos seguintes guardam a resposta do adquirente à
+	// autorização; ficam vazios até o pagamento ser enviado a ele
+	Acquirer         *string `json:"acquirer,omitempty" db:"acquirer"`
+	ResponseCode     *string `json:"response_code,omitempty" db:"response_code"`
+	ApprovalCode     *string `json:"approval_code,omitempty" db:"approval_code"`
+	NetworkReference *string `json:"network_reference,omitempty" db:"network_reference"`
 }
 
-// PaymentRequest representa uma solicitação de pagamento
+// IsAcquirerApproved indica se há uma resposta aprovada do adquirente gravada
+// no pagamento, isto é, se o valor pode já ter sido autorizado no emissor
+func (p *Payment) IsAcquirerApproved() bool {
+	return p.ResponseCode != nil && AcquirerResponseCode(*p.ResponseCode) == ResponseApproved
+}
+
+// PaymentRequest representa uma solicitação de pagamento. O cartão pode ser
+// informado com os dados brutos ou por um token emitido anteriormente pelo cofre
 type PaymentRequest struct {
-	CardNumber  string  `json:"card_number" validate:"required,len=16"`
-	CardHolder  string  `json:"card_holder" validate:"required,min=3,max=100"`
-	ExpiryMonth int     `json:"expiry_month" validate:"required,min=1,max=12"`
-	ExpiryYear  int     `json:"expiry_year" validate:"required,min=2024"`
-	CVV         string  `json:"cvv" validate:"required,len=3"`
-	Amount      float64 `json:"amount" validate:"required,gt=0"`
-	Currency    string  `json:"currency" validate:"required,len=3"`
-	MerchantID  string  `json:"merchant_id" validate:"required"`
+	CardToken   string `json:"card_token,omitempty" validate:"required_without=CardNumber"`
+	CardNumber  string `json:"card_number,omitempty" validate:"required_without=CardToken,omitempty,min=13,max=19,numeric"`
+	CardHolder  string `json:"card_holder" validate:"required,min=3,max=100"`
+	ExpiryMonth int    `json:"expiry_month,omitempty" validate:"required_without=CardToken,omitempty,min=1,max=12"`
+	ExpiryYear  int    `json:"expiry_year,omitempty" validate:"required_without=CardToken,omitempty,min=2024"`
+	CVV         string `json:"cvv,omitempty" validate:"required_without=CardToken,omitempty,min=3,max=4,numeric"`
+	Amount      Money  `json:"amount" validate:"required"`
+	MerchantID  string `json:"merchant_id" validate:"required"`
+	// CaptureMethod é automatic por padrão; manual só autoriza o valor, que é
+	// capturado depois por POST /payments/:id/capture
+	CaptureMethod CaptureMethod `json:"capture_method,omitempty"`
+	// Installments é o número de parcelas (parcelamento); sem ele, ou com 1,
+	// o pagamento é à vista, e negativo é recusado. As regras são as do merchant
+	Installments int `json:"installments,omitempty" validate:"omitempty,min=1,max=24"`
+}
+
+// CancelRequest representa o pedido de cancelamento de um pagamento
+type CancelRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+	// CancelledBy identifica quem pediu o cancelamento; sem ele, ActorAPI
+	CancelledBy string `json:"cancelled_by,omitempty" binding:"max=100"`
+}
+
+// CaptureRequest representa a captura de um pagamento autorizado. Sem valor,
+// o saldo ainda não capturado da autorização é capturado inteiro
+type CaptureRequest struct {
+	Amount *Money `json:"amount,omitempty"`
+	// FinalCapture encerra a autorização com esta captura, liberando o que
+	// não foi capturado. Padrão true; com false o pagamento continua
+	// authorized para novas capturas
+	FinalCapture *bool `json:"final_capture,omitempty"`
+}
+
+// IsFinal indica se a captura encerra a autorização
+func (r *CaptureRequest) IsFinal() bool {
+	return r.FinalCapture == nil || *r.FinalCapture
+}
+
+// IncrementAuthorizationRequest pede o aumento da autorização de um
+// pagamento authorized
+type IncrementAuthorizationRequest struct {
+	Amount Money  `json:"amount"`
+	Reason string `json:"reason,omitempty" binding:"max=500"`
+}
+
+// VoidRequest representa o cancelamento de uma autorização ainda não capturada
+type VoidRequest struct {
+	Reason string `json:"reason,omitempty" binding:"max=500"`
+	// VoidedBy identifica quem pediu o cancelamento; sem ele, ActorAPI
+	VoidedBy string `json:"voided_by,omitempty" binding:"max=100"`
 }
 
 // PaymentResponse representa a resposta de uma solicitação de pagamento
 type PaymentResponse struct {
-	ID        uuid.UUID     `json:"id"`
-	Status    PaymentStatus `json:"status"`
-	Amount    float64       `json:"amount"`
-	Currency  string        `json:"currency"`
-	CreatedAt time.Time     `json:"created_at"`
-	Message   string        `json:"message,omitempty"`
+	ID            uuid.UUID     `json:"id"`
+	Status        PaymentStatus `json:"status"`
+	Amount        Money         `json:"amount"`
+	CaptureMethod CaptureMethod `json:"capture_method"`
+	CardToken     string        `json:"card_token"`
+	CardBrand     string        `json:"card_brand,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Message       string        `json:"message,omitempty"`
+	// InstallmentPlan é o parcelamento calculado, ausente à vista
+	InstallmentPlan *InstallmentPlan `json:"installment_plan,omitempty"`
+}
+
+// PaymentDetails é a representação pública de um pagamento retornada pela
+// API: os dados do cartão e do portador aparecem apenas mascarados
+type PaymentDetails struct {
+	ID          uuid.UUID     `json:"id"`
+	CardToken   string        `json:"card_token,omitempty"`
+	MaskedPAN   string        `json:"masked_pan"`
+	CardBrand   string        `json:"card_brand,omitempty"`
+	CardHolder  string        `json:"card_holder"`
+	ExpiryMonth int           `json:"expiry_month"`
+	ExpiryYear  int           `json:"expiry_year"`
+	Amount      Money         `json:"amount"`
+	MerchantID  string        `json:"merchant_id"`
+	Status      PaymentStatus `json:"status"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	ProcessedAt *time.Time    `json:"processed_at,omitempty"`
+
+	CaptureMethod          CaptureMethod `json:"capture_method"`
+	AuthorizedAmount       Money         `json:"authorized_amount"`
+	CapturedAmount         *Money        `json:"captured_amount,omitempty"`
+	RefundedAmount         Money         `json:"refunded_amount"`
+	AuthorizationExpiresAt *time.Time    `json:"authorization_expires_at,omitempty"`
+
+	Installments    int              `json:"installments"`
+	InstallmentPlan *InstallmentPlan `json:"installment_plan,omitempty"`
+
+	ErrorMsg *string `json:"error_msg,omitempty"`
+	Attempts int     `json:"attempts"`
+	Requeues int     `json:"requeues"`
+
+	Acquirer         *string `json:"acquirer,omitempty"`
+	ResponseCode     *string `json:"response_code,omitempty"`
+	ApprovalCode     *string `json:"approval_code,omitempty"`
+	NetworkReference *string `json:"network_reference,omitempty"`
+
+	ProcessingStartedAt *time.Time `json:"processing_started_at,omitempty"`
+	CompletedAt         *time.Time `json:"completed_at,omitempty"`
+	FailedAt            *time.Time `json:"failed_at,omitempty"`
+	CancelledAt         *time.Time `json:"cancelled_at,omitempty"`
+	AuthorizedAt        *time.Time `json:"authorized_at,omitempty"`
+	VoidedAt            *time.Time `json:"voided_at,omitempty"`
+}
+
+// NewPaymentDetails converte um pagamento para a sua representação pública
+func NewPaymentDetails(p *Payment) *PaymentDetails {
+	return &PaymentDetails{
+		ID:          p.ID,
+		CardToken:   p.CardToken,
+		MaskedPAN:   redact.MaskedPAN(p.CardBIN, p.CardLast4),
+		CardBrand:   p.CardBrand,
+		CardHolder:  redact.MaskName(p.CardHolder),
+		ExpiryMonth: p.ExpiryMonth,
+		ExpiryYear:  p.ExpiryYear,
+		Amount:      p.Amount,
+		MerchantID:  p.MerchantID,
+		Status:      p.Status,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+		ProcessedAt: p.ProcessedAt,
+
+		CaptureMethod:          p.CaptureMethod,
+		AuthorizedAmount:       p.AuthorizedAmount,
+		CapturedAmount:         p.CapturedAmount,
+		RefundedAmount:         p.RefundedAmount,
+		AuthorizationExpiresAt: p.AuthorizationExpiresAt,
+
+		Installments:    p.Installments,
+		InstallmentPlan: p.InstallmentPlan,
+
+		ErrorMsg: p.ErrorMsg,
+		Attempts: p.Attempts,
+		Requeues: p.Requeues,
+
+		Acquirer:         p.Acquirer,
+		ResponseCode:     p.ResponseCode,
+		ApprovalCode:     p.ApprovalCode,
+		NetworkReference: p.NetworkReference,
+
+		ProcessingStartedAt: p.ProcessingStartedAt,
+		CompletedAt:         p.CompletedAt,
+		FailedAt:            p.FailedAt,
+		CancelledAt:         p.CancelledAt,
+		AuthorizedAt:        p.AuthorizedAt,
+		VoidedAt:            p.VoidedAt,
+	}
 }
 
 // Card representa informações de um cartão
@@ -64,45 +270,75 @@ type Card struct {
 	ExpiryMonth int    `json:"expiry_month"`
 	ExpiryYear  int    `json:"expiry_year"`
 	CVV         string `json:"cvv"`
+	// Fingerprint é o blind index do PAN no cofre, preenchido pelo cofre
+	Fingerprint []byte `json:"-"`
 }
 
-// IsValid verifica se o cartão é válido (validação básica)
+// IsValid verifica se o cartão é válido
 func (c *Card) IsValid() bool {
-	// Validação básica do número do cartão (Luhn algorithm seria ideal)
-	if len(c.Number) != 16 {
-		return false
-	}
-	
-	// Validação da data de expiração
+	return c.Validate() == nil
+}
+
+// Validate aplica Luhn, detecção de bandeira pelo BIN e as regras de tamanho
+// de PAN e CVV da bandeira. Em caso de falha retorna um
+// *cardvalidation.ValidationError com todos os motivos
+func (c *Card) Validate() error {
+	_, err := cardvalidation.Validate(c.Number, c.CVV, c.ExpiryMonth, c.ExpiryYear, true, time.Now())
+	return err
+}
+
+// Brand retorna a bandeira detectada a partir do BIN
+func (c *Card) Brand() cardvalidation.Brand {
+	return cardvalidation.DetectBrand(c.Number)
+}
+
+// IsExpired verifica se a data de expiração do cartão já passou
+func (c *Card) IsExpired() bool {
 	currentYear := time.Now().Year()
 	currentMonth := int(time.Now().Month())
-	
+
 	if c.ExpiryYear < currentYear {
-		return false
+		return true
 	}
-	
-	if c.ExpiryYear == currentYear && c.ExpiryMonth < currentMonth {
-		return false
+
+	return c.ExpiryYear == currentYear && c.ExpiryMonth < currentMonth
+}
+
+// BIN retorna os seis primeiros dígitos do cartão
+func (c *Card) BIN() string {
+	if len(c.Number) < 6 {
+		return c.Number
 	}
-	
-	// Validação do CVV
-	if len(c.CVV) != 3 {
-		return false
+	return c.Number[:6]
+}
+
+// Last4 retorna os quatro últimos dígitos do cartão
+func (c *Card) Last4() string {
+	if len(c.Number) < 4 {
+		return c.Number
 	}
-	
-	return true
+	return c.Number[len(c.Number)-4:]
 }
 
-// Account representa uma conta simulada para validação de saldo
+// Account representa uma conta simulada para validação de saldo. O saldo
+// contábil (LedgerBalance) só muda quando um pagamento é concluído; o saldo
+// disponível desconta também as reservas ativas
 type Account struct {
-	CardNumber string  `json:"card_number" db:"card_number"`
-	Balance    float64 `json:"balance" db:"balance"`
-	IsActive   bool    `json:"is_active" db:"is_active"`
-	CreatedAt  time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+	ID               uuid.UUID `json:"id" db:"id"`
+	CardNumber       string    `json:"card_number" db:"card_number"`
+	LedgerBalance    Money     `json:"ledger_balance" db:"balance"`
+	AvailableBalance Money     `json:"available_balance" db:"available_balance"`
+	IsActive         bool      `json:"is_active" db:"is_active"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
 }
 
-// HasSufficientBalance verifica se a conta tem saldo suficiente
-func (a *Account) HasSufficientBalance(amount float64) bool {
-	return a.IsActive && a.Balance >= amount
-} 
\ No newline at end of file
+// HasSufficientBalance verifica se o saldo disponível cobre o valor na mesma moeda
+func (a *Account) HasSufficientBalance(amount Money) bool {
+	if !a.IsActive {
+		return false
+	}
+
+	cmp, err := a.AvailableBalance.Cmp(amount)
+	return err == nil && cmp >= 0
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PaymentEventType identifica a ação registrada no histórico do pagamento
+type PaymentEventType string
+
+const (
+	// PaymentEventRequeued indica que o pagamento foi reenviado para processamento
+	PaymentEventRequeued PaymentEventType = "requeued"
+	// PaymentEventCancelled indica que o pagamento foi cancelado
+	PaymentEventCancelled PaymentEventType = "cancelled"
+	// PaymentEventFailed indica que o pagamento foi marcado como falho
+	PaymentEventFailed PaymentEventType = "failed"
+	// PaymentEventCaptured indica que a autorização foi capturada
+	PaymentEventCaptured PaymentEventType = "captured"
+	// PaymentEventVoided indica que a autorização foi cancelada antes da captura
+	PaymentEventVoided PaymentEventType = "voided"
+	// PaymentEventAuthorizationIncreased indica um incremento da autorização
+	PaymentEventAuthorizationIncreased PaymentEventType = "authorization_increased"
+)
+
+const (
+	// ActorReaper é o autor das ações do job de pagamentos parados
+	ActorReaper = "system:reaper"
+	// ActorAuthorizationExpirer é o autor dos cancelamentos de autorizações vencidas
+	ActorAuthorizationExpirer = "system:authorization-expirer"
+	// ActorAPI é o autor das ações pedidas pela API sem autor informado
+	ActorAPI = "api"
+)
+
+// PaymentEvent é uma entrada do histórico do pagamento
+type PaymentEvent struct {
+	ID         int64            `json:"id" db:"id"`
+	PaymentID  uuid.UUID        `json:"payment_id" db:"payment_id"`
+	Type       PaymentEventType `json:"type" db:"event_type"`
+	FromStatus PaymentStatus    `json:"from_status" db:"from_status"`
+	ToStatus   PaymentStatus    `json:"to_status" db:"to_status"`
+	Actor      string           `json:"actor" db:"actor"`
+	Reason     string           `json:"reason,omitempty" db:"reason"`
+	CreatedAt  time.Time        `json:"created_at" db:"created_at"`
+}
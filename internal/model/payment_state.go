@@ -0,0 +1,67 @@
+package model
+
+import "fmt"
+
+// paymentTransitions define as transições de status permitidas. Estados sem
+// entrada no mapa são finais
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentStatusPending:    {PaymentStatusProcessing, PaymentStatusFailed, PaymentStatusCancelled},
+	PaymentStatusProcessing: {PaymentStatusAuthorized, PaymentStatusCompleted, PaymentStatusFailed, PaymentStatusCancelled},
+	PaymentStatusAuthorized: {PaymentStatusCompleted, PaymentStatusVoided},
+}
+
+// TransitionError indica uma mudança de status recusada. Current é o status
+// encontrado no banco; quando difere de From, outro processo alterou o
+// pagamento antes (falha do compare-and-set)
+type TransitionError struct {
+	PaymentID string
+	From      PaymentStatus
+	To        PaymentStatus
+	Current   PaymentStatus
+}
+
+func (e *TransitionError) Error() string {
+	if e.Current != "" && e.Current != e.From {
+		return fmt.Sprintf("payment %s is %s, expected %s for transition to %s", e.PaymentID, e.Current, e.From, e.To)
+	}
+	return fmt.Sprintf("illegal payment status transition from %s to %s", e.From, e.To)
+}
+
+// IsTerminal indica se o status é final
+func (s PaymentStatus) IsTerminal() bool {
+	_, ok := paymentTransitions[s]
+	return !ok
+}
+
+// IsValid indica se o status é conhecido
+func (s PaymentStatus) IsValid() bool {
+	switch s {
+	case PaymentStatusPending, PaymentStatusProcessing, PaymentStatusAuthorized, PaymentStatusCompleted,
+		PaymentStatusFailed, PaymentStatusCancelled, PaymentStatusVoided:
+		return true
+	}
+	return false
+}
+
+// IsCancellable indica se um pagamento no status pode ser cancelado
+func (s PaymentStatus) IsCancellable() bool {
+	return s.CanTransitionTo(PaymentStatusCancelled)
+}
+
+// CanTransitionTo verifica se a transição para o status informado é permitida
+func (s PaymentStatus) CanTransitionTo(to PaymentStatus) bool {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTransition retorna um *TransitionError se a transição não for permitida
+func ValidateTransition(from, to PaymentStatus) error {
+	if !from.CanTransitionTo(to) {
+		return &TransitionError{From: from, To: to, Current: from}
+	}
+	return nil
+}
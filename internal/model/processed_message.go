@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageDelivery identifica uma mensagem consumida do Kafka
+type MessageDelivery struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// ProcessedMessage registra que o efeito de uma mensagem sobre o pagamento já
+// foi aplicado. É gravado na mesma transação que leva o pagamento ao estado
+// final, então uma reentrega da mesma mensagem não repete o débito
+type ProcessedMessage struct {
+	PaymentID   uuid.UUID       `json:"payment_id" db:"payment_id"`
+	Delivery    MessageDelivery `json:"delivery"`
+	Outcome     PaymentStatus   `json:"outcome" db:"outcome"`
+	ProcessedAt time.Time       `json:"processed_at" db:"processed_at"`
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefundStatus representa o status de um estorno
+type RefundStatus string
+
+const (
+	// RefundStatusPending indica que o estorno aguarda o processamento
+	RefundStatusPending RefundStatus = "pending"
+	// RefundStatusSucceeded indica que o valor foi devolvido à conta
+	RefundStatusSucceeded RefundStatus = "succeeded"
+	// RefundStatusFailed indica que o estorno foi recusado ou esgotou as tentativas
+	RefundStatusFailed RefundStatus = "failed"
+	// RefundStatusNeedsReconciliation indica que o adquirente aprovou o
+	// estorno mas o crédito local não foi concluído. O valor continua
+	// comprometido e a conciliação é manual
+	RefundStatusNeedsReconciliation RefundStatus = "needs_reconciliation"
+)
+
+// IsTerminal indica se o estorno não muda mais de status
+func (s RefundStatus) IsTerminal() bool {
+	return s == RefundStatusSucceeded || s == RefundStatusFailed || s == RefundStatusNeedsReconciliation
+}
+
+// Refund é a devolução, total ou parcial, do valor capturado de um pagamento
+type Refund struct {
+	ID        uuid.UUID    `json:"id" db:"id"`
+	PaymentID uuid.UUID    `json:"payment_id" db:"payment_id"`
+	Amount    Money        `json:"amount" db:"amount"`
+	Status    RefundStatus `json:"status" db:"status"`
+	Reason    string       `json:"reason,omitempty" db:"reason"`
+	ErrorMsg  *string      `json:"error_msg,omitempty" db:"error_msg"`
+	// Attempts conta as tentativas de processamento, como nos pagamentos
+	Attempts         int        `json:"attempts" db:"attempts"`
+	Acquirer         *string    `json:"acquirer,omitempty" db:"acquirer"`
+	ResponseCode     *string    `json:"response_code,omitempty" db:"response_code"`
+	NetworkReference *string    `json:"network_reference,omitempty" db:"network_reference"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
+	ProcessedAt      *time.Time `json:"processed_at,omitempty" db:"processed_at"`
+}
+
+// IsAcquirerApproved indica se há uma resposta aprovada do adquirente gravada
+// no estorno, isto é, se o valor já foi devolvido no emissor
+func (r *Refund) IsAcquirerApproved() bool {
+	return r.ResponseCode != nil && AcquirerResponseCode(*r.ResponseCode) == ResponseApproved
+}
+
+// RefundRequest representa o pedido de estorno de um pagamento. Sem valor,
+// o estorno é do saldo ainda não estornado
+type RefundRequest struct {
+	Amount *Money `json:"amount,omitempty"`
+	Reason string `json:"reason,omitempty" binding:"max=500"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import "errors"
+
+// ErrRetriesExhausted indica que o pagamento foi marcado como falho depois de
+// esgotar as tentativas de processamento
+var ErrRetriesExhausted = errors.New("processing retries exhausted")
+
+// RetryableError marca uma falha transitória, como um timeout do banco, que
+// pode ter sucesso numa nova tentativa. Erros sem essa marca são terminais
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable marca err como uma falha transitória
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{Err: err}
+}
+
+// IsRetryable informa se err, ou algum erro envolvido por ele, é transitório
+func IsRetryable(err error) bool {
+	var retryable *RetryableError
+	return errors.As(err, &retryable)
+}
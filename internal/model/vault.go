@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// VaultedCard representa um cartão armazenado no cofre. O PAN só existe
+// cifrado (PANKeyID identifica a chave de dados) e o CVV não fica no cofre.
+// O token pertence ao merchant que o criou
+type VaultedCard struct {
+	Token          string    `json:"token" db:"token"`
+	MerchantID     string    `json:"-" db:"merchant_id"`
+	PANCiphertext  []byte    `json:"-" db:"pan_ciphertext"`
+	PANKeyID       string    `json:"-" db:"pan_key_id"`
+	PANFingerprint []byte    `json:"-" db:"pan_fingerprint"`
+	BIN            string    `json:"bin" db:"bin"`
+	Last4          string    `json:"last4" db:"last4"`
+	ExpiryMonth    int       `json:"expiry_month" db:"expiry_month"`
+	ExpiryYear     int       `json:"expiry_year" db:"expiry_year"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
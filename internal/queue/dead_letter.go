@@ -0,0 +1,284 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/redact"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Cabeçalhos com os metadados da falha, gravados junto da mensagem original
+const (
+	HeaderAttempts          = "x-attempts"
+	HeaderErrorClass        = "x-error-class"
+	HeaderErrorMessage      = "x-error-message"
+	HeaderFailedAt          = "x-failed-at"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderRetryAt           = "x-retry-at"
+)
+
+const (
+	// deadLetterReadTimeout limita a leitura quando o contexto não tem prazo
+	deadLetterReadTimeout = 10 * time.Second
+	deadLetterMaxBytes    = 10e6 // 10MB
+)
+
+// ErrDeadLetterNotFound indica que não há mensagem no offset pedido
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetterQueue guarda no tópico de dead-letter as mensagens que não puderam
+// ser processadas e permite consultá-las
+type DeadLetterQueue interface {
+	// Send publica a mensagem original, com chave e payload intactos, e os
+	// metadados da falha nos cabeçalhos
+	Send(ctx context.Context, message kafka.Message, failure *model.MessageFailure) error
+	// Partitions lista as partições do tópico e seus offsets
+	Partitions(ctx context.Context) ([]*model.DeadLetterPartition, error)
+	// List retorna até limit mensagens da partição a partir de offset
+	List(ctx context.Context, partition int, offset int64, limit int) ([]*model.DeadLetter, error)
+	// Get retorna a mensagem no offset ou ErrDeadLetterNotFound
+	Get(ctx context.Context, partition int, offset int64) (*model.DeadLetter, error)
+}
+
+type deadLetterQueue struct {
+	producer KafkaProducer
+	brokers  []string
+	topic    string
+}
+
+func NewDeadLetterQueue(producer KafkaProducer, brokers []string, topic string) DeadLetterQueue {
+	return &deadLetterQueue{
+		producer: producer,
+		brokers:  brokers,
+		topic:    topic,
+	}
+}
+
+func (q *deadLetterQueue) Send(ctx context.Context, message kafka.Message, failure *model.MessageFailure) error {
+	headers := FailureHeaders(message, failure)
+	if err := q.producer.Publish(ctx, q.topic, message.Key, message.Value, headers...); err != nil {
+		return fmt.Errorf("failed to send message to dead letter topic: %w", err)
+	}
+	return nil
+}
+
+// FailureHeaders monta os cabeçalhos da mensagem enviada ao retry ou ao
+// dead-letter. Os cabeçalhos originais são mantidos, exceto os de uma falha
+// anterior
+func FailureHeaders(message kafka.Message, failure *model.MessageFailure) []kafka.Header {
+	headers := make([]kafka.Header, 0, len(message.Headers)+7)
+	for _, header := range message.Headers {
+		if !isFailureHeader(header.Key) {
+			headers = append(headers, header)
+		}
+	}
+
+	errorMessage := ""
+	if failure.Err != nil {
+		errorMessage = redact.String(failure.Err.Error())
+	}
+
+	failedAt := failure.FailedAt
+	if failedAt.IsZero() {
+		failedAt = time.Now()
+	}
+
+	return append(headers,
+		kafka.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(failure.Attempts))},
+		kafka.Header{Key: HeaderErrorClass, Value: []byte(failure.Class)},
+		kafka.Header{Key: HeaderErrorMessage, Value: []byte(errorMessage)},
+		kafka.Header{Key: HeaderFailedAt, Value: []byte(failedAt.UTC().Format(time.RFC3339))},
+		kafka.Header{Key: HeaderOriginalTopic, Value: []byte(message.Topic)},
+		kafka.Header{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(message.Partition))},
+		kafka.Header{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(message.Offset, 10))},
+	)
+}
+
+// DecodeDeadLetter lê os metadados da falha de uma mensagem do dead-letter. O
+// payload exibido passa pela redação; o original fica em Value
+func DecodeDeadLetter(message kafka.Message) *model.DeadLetter {
+	letter := &model.DeadLetter{
+		Partition:         message.Partition,
+		Offset:            message.Offset,
+		Key:               string(message.Key),
+		Value:             message.Value,
+		Payload:           redact.String(string(message.Value)),
+		OriginalTopic:     headerValue(message, HeaderOriginalTopic),
+		OriginalPartition: headerInt(message, HeaderOriginalPartition),
+		OriginalOffset:    int64(headerInt(message, HeaderOriginalOffset)),
+		ErrorClass:        model.DeadLetterErrorClass(headerValue(message, HeaderErrorClass)),
+		ErrorMessage:      headerValue(message, HeaderErrorMessage),
+		Attempts:          headerInt(message, HeaderAttempts),
+	}
+
+	if failedAt, err := time.Parse(time.RFC3339, headerValue(message, HeaderFailedAt)); err == nil {
+		letter.FailedAt = failedAt
+	}
+
+	return letter
+}
+
+// MessageAttempts retorna quantas vezes a mensagem já falhou, segundo o
+// cabeçalho de tentativas; zero se ela nunca falhou
+func MessageAttempts(message kafka.Message) int {
+	return headerInt(message, HeaderAttempts)
+}
+
+func (q *deadLetterQueue) Partitions(ctx context.Context) ([]*model.DeadLetterPartition, error) {
+	conn, err := kafka.DialContext(ctx, "tcp", q.brokers[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kafka: %w", err)
+	}
+	defer conn.Close()
+
+	partitions, err := conn.ReadPartitions(q.topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter partitions: %w", err)
+	}
+
+	summaries := make([]*model.DeadLetterPartition, 0, len(partitions))
+	for _, partition := range partitions {
+		first, last, err := q.readOffsets(ctx, partition.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		summaries = append(summaries, &model.DeadLetterPartition{
+			Partition:   partition.ID,
+			FirstOffset: first,
+			LastOffset:  last,
+			Messages:    last - first,
+		})
+	}
+
+	return summaries, nil
+}
+
+func (q *deadLetterQueue) readOffsets(ctx context.Context, partition int) (int64, int64, error) {
+	conn, err := q.dialPartition(ctx, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read offsets of dead letter partition %d: %w", partition, err)
+	}
+	return first, last, nil
+}
+
+func (q *deadLetterQueue) List(ctx context.Context, partition int, offset int64, limit int) ([]*model.DeadLetter, error) {
+	conn, err := q.dialPartition(ctx, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offsets of dead letter partition %d: %w", partition, err)
+	}
+	if offset < first {
+		offset = first
+	}
+
+	letters := []*model.DeadLetter{}
+	if offset >= last || limit <= 0 {
+		return letters, nil
+	}
+
+	if _, err := conn.Seek(offset, kafka.SeekAbsolute); err != nil {
+		return nil, fmt.Errorf("failed to seek dead letter partition %d: %w", partition, err)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(deadLetterReadTimeout)
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+
+	for offset < last && len(letters) < limit {
+		read := 0
+		batch := conn.ReadBatch(1, deadLetterMaxBytes)
+		for offset < last && len(letters) < limit {
+			message, err := batch.ReadMessage()
+			if err != nil {
+				break
+			}
+			read++
+			// Um lote comprimido pode começar antes do offset pedido
+			if message.Offset < offset {
+				continue
+			}
+			letters = append(letters, DecodeDeadLetter(message))
+			offset = message.Offset + 1
+		}
+
+		if err := batch.Close(); err != nil {
+			return nil, fmt.Errorf("failed to read dead letter partition %d: %w", partition, err)
+		}
+		// Lacunas de compactação ou marcadores de transação: nada mais a ler
+		if read == 0 {
+			break
+		}
+	}
+
+	return letters, nil
+}
+
+func (q *deadLetterQueue) Get(ctx context.Context, partition int, offset int64) (*model.DeadLetter, error) {
+	letters, err := q.List(ctx, partition, offset, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(letters) == 0 || letters[0].Offset != offset {
+		return nil, ErrDeadLetterNotFound
+	}
+	return letters[0], nil
+}
+
+func (q *deadLetterQueue) dialPartition(ctx context.Context, partition int) (*kafka.Conn, error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", q.brokers[0], q.topic, partition)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to dead letter partition %d: %w", partition, err)
+	}
+	return conn, nil
+}
+
+func isFailureHeader(key string) bool {
+	switch key {
+	case HeaderAttempts, HeaderErrorClass, HeaderErrorMessage, HeaderFailedAt,
+		HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset, HeaderRetryAt:
+		return true
+	}
+	return false
+}
+
+func headerValue(message kafka.Message, key string) string {
+	for _, header := range message.Headers {
+		if strings.EqualFold(header.Key, key) {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func headerInt(message kafka.Message, key string) int {
+	n, err := strconv.Atoi(headerValue(message, key))
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -3,29 +3,140 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 )
 
 // PaymentProcessor interface para evitar dependência circular
 type PaymentProcessor interface {
-	ProcessPaymentAsync(ctx context.Context, paymentID string) error
+	ProcessPaymentAsync(ctx context.Context, paymentID string, delivery *model.MessageDelivery) error
+	ProcessRefundAsync(ctx context.Context, refundID string, delivery *model.MessageDelivery) error
 }
 
 type KafkaConsumer interface {
+	// Start busca e despacha mensagens até o contexto ser cancelado ou
+	// Shutdown ser chamado
 	Start(ctx context.Context) error
+	// Shutdown para de buscar mensagens, espera o processamento em andamento
+	// até o prazo do contexto e fecha o consumidor. Mensagens ainda na fila
+	// não são iniciadas; só os offsets das concluídas são commitados
+	Shutdown(ctx context.Context) error
+	// Close encerra o consumidor sem esperar o processamento em andamento
 	Close() error
 }
 
+const (
+	defaultConsumerWorkers   = 8
+	defaultConsumerQueueSize = 16
+	defaultProcessingTimeout = 30 * time.Second
+)
+
 type kafkaConsumer struct {
-	reader           *kafka.Reader
-	paymentProcessor PaymentProcessor
-	logger           *logrus.Logger
+	reader            *kafka.Reader
+	paymentProcessor  PaymentProcessor
+	logger            *logrus.Logger
+	offsets           *offsetTracker
+	pool              *WorkerPool
+	workers           int
+	queueSize         int
+	onLoad            func(queued, inFlight int64)
+	processingTimeout time.Duration
+	deadLetters       DeadLetterQueue
+	onDeadLetter      func(class model.DeadLetterErrorClass)
+	retries           RetryQueue
+	onRetry           func(topic string)
+	// commitMu serializa os commits para que o offset commitado só avance
+	commitMu sync.Mutex
+
+	// stopFetch interrompe o loop de Start; stopped é fechado quando ele sai
+	fetchCtx  context.Context
+	stopFetch context.CancelFunc
+	started   atomic.Bool
+	stopped   chan struct{}
+	// draining impede que mensagens ainda na fila comecem a ser processadas
+	draining atomic.Bool
+	// O processamento não depende do contexto de Start: só é cancelado
+	// quando o prazo do Shutdown vence
+	processingCtx    context.Context
+	cancelProcessing context.CancelFunc
+}
+
+// ConsumerOption configura parâmetros opcionais do consumidor
+type ConsumerOption func(*kafkaConsumer)
+
+// WithWorkers define quantos workers processam mensagens em paralelo e
+// quantas mensagens cada um pode ter na fila antes de o consumidor parar de
+// buscar novas
+func WithWorkers(workers, queueSize int) ConsumerOption {
+	return func(c *kafkaConsumer) {
+		c.workers = workers
+		c.queueSize = queueSize
+	}
+}
+
+// WithProcessingTimeout define o tempo máximo de processamento de uma mensagem
+func WithProcessingTimeout(timeout time.Duration) ConsumerOption {
+	return func(c *kafkaConsumer) {
+		if timeout > 0 {
+			c.processingTimeout = timeout
+		}
+	}
+}
+
+// WithLoadStats registra um callback com o número de mensagens enfileiradas e
+// em processamento (métricas)
+func WithLoadStats(fn func(queued, inFlight int64)) ConsumerOption {
+	return func(c *kafkaConsumer) {
+		c.onLoad = fn
+	}
 }
 
-func NewKafkaConsumer(brokers []string, topic, groupID string, paymentProcessor PaymentProcessor, logger *logrus.Logger) KafkaConsumer {
+// WithDeadLetterQueue envia ao dead-letter as mensagens malformadas e as que
+// falharam no processamento, que então são commitadas. Sem ele, mensagens
+// malformadas são descartadas e as com falha ficam sem commit
+func WithDeadLetterQueue(dlq DeadLetterQueue) ConsumerOption {
+	return func(c *kafkaConsumer) {
+		c.deadLetters = dlq
+	}
+}
+
+// WithDeadLetterStats registra um callback chamado a cada mensagem enviada ao
+// dead-letter (métricas)
+func WithDeadLetterStats(fn func(class model.DeadLetterErrorClass)) ConsumerOption {
+	return func(c *kafkaConsumer) {
+		c.onDeadLetter = fn
+	}
+}
+
+// WithRetryQueue envia as mensagens com falha transitória a um tópico de retry,
+// com backoff, até o número máximo de tentativas; depois vão para o
+// dead-letter. Sem ele, falhas transitórias são tratadas como as demais
+func WithRetryQueue(retries RetryQueue) ConsumerOption {
+	return func(c *kafkaConsumer) {
+		c.retries = retries
+	}
+}
+
+// WithRetryStats registra um callback chamado com o tópico de retry de cada
+// nova tentativa agendada (métricas)
+func WithRetryStats(fn func(topic string)) ConsumerOption {
+	return func(c *kafkaConsumer) {
+		c.onRetry = fn
+	}
+}
+
+func NewKafkaConsumer(brokers []string, topic, groupID string, paymentProcessor PaymentProcessor, logger *logrus.Logger, opts ...ConsumerOption) KafkaConsumer {
+	// Sem commit automático: o offset só é commitado depois que a mensagem
+	// foi processada
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  brokers,
 		Topic:    topic,
@@ -34,55 +145,303 @@ func NewKafkaConsumer(brokers []string, topic, groupID string, paymentProcessor
 		MaxBytes: 10e6, // 10MB
 	})
 
-	return &kafkaConsumer{
-		reader:           reader,
-		paymentProcessor: paymentProcessor,
-		logger:           logger,
+	c := &kafkaConsumer{
+		reader:            reader,
+		paymentProcessor:  paymentProcessor,
+		logger:            logger,
+		offsets:           newOffsetTracker(),
+		workers:           defaultConsumerWorkers,
+		queueSize:         defaultConsumerQueueSize,
+		processingTimeout: defaultProcessingTimeout,
+		stopped:           make(chan struct{}),
+	}
+	c.fetchCtx, c.stopFetch = context.WithCancel(context.Background())
+	c.processingCtx, c.cancelProcessing = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	c.pool = NewWorkerPool(c.workers, c.queueSize)
+	c.pool.OnStats(c.onLoad)
+
+	return c
 }
 
 func (c *kafkaConsumer) Start(ctx context.Context) error {
 	c.logger.Info("Starting Kafka consumer")
+	c.started.Store(true)
+	defer close(c.stopped)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.fetchCtx, cancel)
+	defer stop()
+
+	c.pool.Start()
 
 	for {
 		select {
 		case <-ctx.Done():
-			c.logger.Info("Kafka consumer stopped")
+			c.logger.Info("Kafka consumer stopped fetching")
 			return ctx.Err()
 		default:
-			message, err := c.reader.ReadMessage(ctx)
+			message, err := c.reader.FetchMessage(ctx)
 			if err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
+				if errors.Is(err, io.EOF) {
+					c.logger.Info("Kafka reader closed")
+					return nil
+				}
 				c.logger.WithError(err).Error("Failed to read message from Kafka")
 				continue
 			}
 
+			c.offsets.track(message.Partition, message.Offset)
 			c.processMessage(ctx, message)
 		}
 	}
 }
 
-func (c *kafkaConsumer) processMessage(_ context.Context, message kafka.Message) {
+func (c *kafkaConsumer) processMessage(ctx context.Context, message kafka.Message) {
 	var paymentMsg PaymentMessage
 	if err := json.Unmarshal(message.Value, &paymentMsg); err != nil {
-		c.logger.WithError(err).Error("Failed to unmarshal payment message")
+		// O payload passa pelo hook de redação antes de ser escrito
+		c.logger.WithError(err).WithField("payload", string(message.Value)).Error("Failed to unmarshal payment message")
+		c.discard(message, model.DeadLetterMalformed, err)
 		return
 	}
 
-	c.logger.WithField("payment_id", paymentMsg.PaymentID).Info("Processing payment message")
+	if _, err := uuid.Parse(paymentMsg.PaymentID); err != nil {
+		c.logger.WithError(err).WithField("payment_id", paymentMsg.PaymentID).Error("Invalid payment ID in message")
+		c.discard(message, model.DeadLetterInvalidPaymentID, err)
+		return
+	}
 
-	// Simular processamento assíncrono
-	go func() {
-		processingCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	delivery := &model.MessageDelivery{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+	}
+
+	// Mensagens de estorno seguem pelo tópico do pagamento, com a mesma chave
+	process := func(ctx context.Context) error {
+		return c.paymentProcessor.ProcessPaymentAsync(ctx, paymentMsg.PaymentID, delivery)
+	}
+	fields := logrus.Fields{"payment_id": paymentMsg.PaymentID}
+	if paymentMsg.RefundID != "" {
+		if _, err := uuid.Parse(paymentMsg.RefundID); err != nil {
+			c.logger.WithError(err).WithField("refund_id", paymentMsg.RefundID).Error("Invalid refund ID in message")
+			c.discard(message, model.DeadLetterMalformed, err)
+			return
+		}
+		process = func(ctx context.Context) error {
+			return c.paymentProcessor.ProcessRefundAsync(ctx, paymentMsg.RefundID, delivery)
+		}
+		fields["refund_id"] = paymentMsg.RefundID
+	}
+
+	c.logger.WithFields(fields).Info("Processing payment message")
+
+	// Pagamentos do mesmo cartão, de qualquer merchant, vão para o mesmo
+	// worker e são processados em série. Com a fila do worker cheia, o Submit
+	// bloqueia e o consumidor deixa de buscar mensagens até haver espaço
+	key := paymentMsg.CardKey()
+
+	err := c.pool.Submit(ctx, key, func() {
+		// Durante o shutdown a mensagem fica sem commit e é reentregue
+		if c.draining.Load() {
+			return
+		}
+
+		processingCtx, cancel := context.WithTimeout(c.processingCtx, c.processingTimeout)
 		defer cancel()
 
-		if err := c.paymentProcessor.ProcessPaymentAsync(processingCtx, paymentMsg.PaymentID); err != nil {
-			c.logger.WithError(err).WithField("payment_id", paymentMsg.PaymentID).Error("Failed to process payment")
-		} else {
-			c.logger.WithField("payment_id", paymentMsg.PaymentID).Info("Payment processed successfully")
+		if err := process(processingCtx); err != nil {
+			// Sem commit: a mensagem é reentregue após reinício ou rebalanceamento
+			if c.processingCtx.Err() != nil {
+				c.logger.WithError(err).WithFields(fields).Warn("Payment processing interrupted by shutdown")
+				return
+			}
+			c.logger.WithError(err).WithFields(fields).Error("Failed to process payment")
+			c.handleFailure(message, err)
+			return
+		}
+
+		c.logger.WithFields(fields).Info("Payment processed successfully")
+		c.acknowledge(message)
+	})
+	if err != nil {
+		// Consumidor parando: a mensagem não foi processada nem commitada
+		c.logger.WithError(err).WithFields(fields).Warn("Payment message not dispatched")
+	}
+}
+
+// handleFailure agenda uma nova tentativa para falhas transitórias e envia as
+// demais ao dead-letter. A mensagem só é commitada se uma das duas der certo
+func (c *kafkaConsumer) handleFailure(message kafka.Message, cause error) {
+	class := model.DeadLetterProcessingError
+	switch {
+	case errors.Is(cause, model.ErrRetriesExhausted):
+		class = model.DeadLetterRetriesExhausted
+	case errors.Is(cause, context.DeadlineExceeded):
+		class = model.DeadLetterTimeout
+	}
+
+	retryable := model.IsRetryable(cause) || errors.Is(cause, context.DeadlineExceeded)
+	if retryable && c.retries != nil {
+		attempts := MessageAttempts(message) + 1
+		if attempts < c.retries.MaxAttempts() {
+			if c.scheduleRetry(message, class, cause, attempts) {
+				c.acknowledge(message)
+			}
+			return
 		}
+		class = model.DeadLetterRetriesExhausted
+	}
+
+	if c.deadLetter(message, class, cause) {
+		c.acknowledge(message)
+	}
+}
+
+// scheduleRetry publica a mensagem no tópico de retry e informa se ela pode
+// ser commitada
+func (c *kafkaConsumer) scheduleRetry(message kafka.Message, class model.DeadLetterErrorClass, cause error, attempts int) bool {
+	failure := &model.MessageFailure{
+		Class:    class,
+		Err:      cause,
+		Attempts: attempts,
+		FailedAt: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(c.processingCtx, c.processingTimeout)
+	defer cancel()
+
+	topic, err := c.retries.Schedule(ctx, message, failure)
+	if err != nil {
+		c.logger.WithError(err).WithFields(logrus.Fields{
+			"partition": message.Partition,
+			"offset":    message.Offset,
+		}).Error("Failed to schedule message retry")
+		return false
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"partition":   message.Partition,
+		"offset":      message.Offset,
+		"retry_topic": topic,
+		"attempt":     attempts,
+	}).Warn("Message scheduled for retry")
+	if c.onRetry != nil {
+		c.onRetry(topic)
+	}
+	return true
+}
+
+// discard descarta uma mensagem que nunca poderá ser processada, guardando-a
+// no dead-letter quando houver um
+func (c *kafkaConsumer) discard(message kafka.Message, class model.DeadLetterErrorClass, cause error) {
+	if c.deadLetters == nil || c.deadLetter(message, class, cause) {
+		c.acknowledge(message)
+	}
+}
+
+// deadLetter envia a mensagem ao dead-letter e informa se ela pode ser
+// commitada. Se o envio falhar, a mensagem fica sem commit e é reentregue
+func (c *kafkaConsumer) deadLetter(message kafka.Message, class model.DeadLetterErrorClass, cause error) bool {
+	if c.deadLetters == nil {
+		return false
+	}
+
+	failure := &model.MessageFailure{
+		Class:    class,
+		Err:      cause,
+		Attempts: MessageAttempts(message) + 1,
+		FailedAt: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(c.processingCtx, c.processingTimeout)
+	defer cancel()
+
+	fields := logrus.Fields{
+		"partition":   message.Partition,
+		"offset":      message.Offset,
+		"error_class": class,
+	}
+
+	if err := c.deadLetters.Send(ctx, message, failure); err != nil {
+		c.logger.WithError(err).WithFields(fields).Error("Failed to send message to dead letter topic")
+		return false
+	}
+
+	c.logger.WithFields(fields).Warn("Message sent to dead letter topic")
+	if c.onDeadLetter != nil {
+		c.onDeadLetter(class)
+	}
+	return true
+}
+
+// acknowledge marca a mensagem como concluída e commita o maior offset cujas
+// mensagens anteriores na partição também foram concluídas
+func (c *kafkaConsumer) acknowledge(message kafka.Message) {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+
+	offset, ok := c.offsets.done(message.Partition, message.Offset)
+	if !ok {
+		return
+	}
+
+	commit := kafka.Message{Topic: message.Topic, Partition: message.Partition, Offset: offset}
+	if err := c.reader.CommitMessages(context.Background(), commit); err != nil {
+		// Após um rebalanceamento a partição pode ser de outro consumidor;
+		// ele reentrega a mensagem e o registro de processadas a descarta
+		c.logger.WithError(err).WithFields(logrus.Fields{
+			"partition": message.Partition,
+			"offset":    offset,
+		}).Error("Failed to commit Kafka offset")
+	}
+}
+
+func (c *kafkaConsumer) Shutdown(ctx context.Context) error {
+	c.logger.Info("Draining Kafka consumer")
+
+	c.draining.Store(true)
+	c.stopFetch()
+
+	// Com o loop parado nada mais é submetido e as filas podem ser fechadas.
+	// Se Start nunca rodou, os workers também não
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		if !c.started.Load() {
+			return
+		}
+		<-c.stopped
+		c.pool.Stop()
 	}()
+
+	select {
+	case <-drained:
+		c.logger.Info("Kafka consumer drained")
+	case <-ctx.Done():
+		// Prazo vencido: o processamento em andamento é cancelado. A transação
+		// do débito é desfeita e o pagamento fica em processing, sem commit do
+		// offset, para ser retomado na reentrega ou pela recuperação
+		c.logger.Warn("Kafka consumer drain deadline exceeded, cancelling in-flight payments")
+		c.cancelProcessing()
+		<-drained
+	}
+
+	c.cancelProcessing()
+	return c.reader.Close()
 }
 
 func (c *kafkaConsumer) Close() error {
-	return c.reader.Close()
-} 
\ No newline at end of file
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return c.Shutdown(ctx)
+}
@@ -2,6 +2,7 @@ package queue
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 
@@ -12,14 +13,81 @@ import (
 )
 
 type PaymentMessage struct {
-	PaymentID string  `json:"payment_id"`
-	Amount    float64 `json:"amount"`
-	Currency  string  `json:"currency"`
-	Timestamp int64   `json:"timestamp"`
+	PaymentID string `json:"payment_id"`
+	// RefundID identifica as mensagens de estorno, que seguem pelo mesmo
+	// tópico do pagamento; fica vazio nas de processamento do pagamento
+	RefundID  string `json:"refund_id,omitempty"`
+	CardToken string `json:"card_token,omitempty"`
+	// CardFingerprint é o blind index do PAN em hexadecimal. Ao contrário do
+	// token, que é por merchant, identifica o cartão em todos os merchants
+	CardFingerprint string      `json:"card_fingerprint,omitempty"`
+	Amount          model.Money `json:"amount"`
+	Timestamp       int64       `json:"timestamp"`
+	// InstallmentPlan é o parcelamento do pagamento, ausente à vista e nas
+	// mensagens de estorno
+	InstallmentPlan *model.InstallmentPlan `json:"installment_plan,omitempty"`
+}
+
+// NewPaymentOutboxMessage monta a mensagem de processamento do pagamento para
+// o outbox. A chave é a do cartão (ver CardKey), para que pagamentos do mesmo
+// cartão caiam na mesma partição e sejam processados em ordem
+func NewPaymentOutboxMessage(topic string, payment *model.Payment) (*model.OutboxMessage, error) {
+	return newOutboxMessage(topic, PaymentMessage{
+		PaymentID:       payment.ID.String(),
+		CardToken:       payment.CardToken,
+		CardFingerprint: hex.EncodeToString(payment.CardFingerprint),
+		Amount:          payment.Amount,
+		Timestamp:       payment.CreatedAt.Unix(),
+
+		InstallmentPlan: payment.InstallmentPlan,
+	})
+}
+
+// NewRefundOutboxMessage monta a mensagem de processamento do estorno para o
+// outbox, com a mesma chave das mensagens do pagamento estornado
+func NewRefundOutboxMessage(topic string, payment *model.Payment, refund *model.Refund) (*model.OutboxMessage, error) {
+	return newOutboxMessage(topic, PaymentMessage{
+		PaymentID:       payment.ID.String(),
+		RefundID:        refund.ID.String(),
+		CardToken:       payment.CardToken,
+		CardFingerprint: hex.EncodeToString(payment.CardFingerprint),
+		Amount:          refund.Amount,
+		Timestamp:       refund.CreatedAt.Unix(),
+	})
+}
+
+// CardKey identifica o cartão da mensagem para ordenar o processamento: o
+// fingerprint do PAN, ou o token e o ID do pagamento nas mensagens sem ele
+func (m *PaymentMessage) CardKey() string {
+	switch {
+	case m.CardFingerprint != "":
+		return m.CardFingerprint
+	case m.CardToken != "":
+		return m.CardToken
+	default:
+		return m.PaymentID
+	}
+}
+
+func newOutboxMessage(topic string, message PaymentMessage) (*model.OutboxMessage, error) {
+	key := message.CardKey()
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payment message: %w", err)
+	}
+
+	return &model.OutboxMessage{
+		Topic:   topic,
+		Key:     key,
+		Payload: payload,
+	}, nil
 }
 
 type KafkaProducer interface {
-	SendPaymentMessage(ctx context.Context, payment *model.Payment) error
+	// Publish envia a mensagem e só retorna depois de confirmada por todas as
+	// réplicas. Mensagens com a mesma chave vão para a mesma partição
+	Publish(ctx context.Context, topic string, key, value []byte, headers ...kafka.Header) error
 	Close() error
 }
 
@@ -28,11 +96,12 @@ type kafkaProducer struct {
 	logger *logrus.Logger
 }
 
-func NewKafkaProducer(brokers []string, topic string, logger *logrus.Logger) KafkaProducer {
+func NewKafkaProducer(brokers []string, logger *logrus.Logger) KafkaProducer {
+	// O tópico vai em cada mensagem; o Hash mantém a ordem por chave
 	writer := &kafka.Writer{
-		Addr:     kafka.TCP(brokers...),
-		Topic:    topic,
-		Balancer: &kafka.LeastBytes{},
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
 	}
 
 	return &kafkaProducer{
@@ -41,35 +110,25 @@ func NewKafkaProducer(brokers []string, topic string, logger *logrus.Logger) Kaf
 	}
 }
 
-func (p *kafkaProducer) SendPaymentMessage(ctx context.Context, payment *model.Payment) error {
-	message := PaymentMessage{
-		PaymentID: payment.ID.String(),
-		Amount:    payment.Amount,
-		Currency:  payment.Currency,
-		Timestamp: payment.CreatedAt.Unix(),
-	}
-
-	messageBytes, err := json.Marshal(message)
-	if err != nil {
-		p.logger.WithError(err).Error("Failed to marshal payment message")
-		return fmt.Errorf("failed to marshal payment message: %w", err)
-	}
-
+func (p *kafkaProducer) Publish(ctx context.Context, topic string, key, value []byte, headers ...kafka.Header) error {
 	kafkaMessage := kafka.Message{
-		Key:   []byte(payment.ID.String()),
-		Value: messageBytes,
+		Topic:   topic,
+		Key:     key,
+		Value:   value,
+		Headers: headers,
 	}
 
-	err = p.writer.WriteMessages(ctx, kafkaMessage)
-	if err != nil {
-		p.logger.WithError(err).WithField("payment_id", payment.ID).Error("Failed to send payment message to Kafka")
-		return fmt.Errorf("failed to send payment message: %w", err)
+	if err := p.writer.WriteMessages(ctx, kafkaMessage); err != nil {
+		return fmt.Errorf("failed to publish message to %s: %w", topic, err)
 	}
 
-	p.logger.WithField("payment_id", payment.ID).Info("Payment message sent to Kafka successfully")
+	p.logger.WithFields(logrus.Fields{
+		"topic": topic,
+		"key":   string(key),
+	}).Debug("Message published to Kafka")
 	return nil
 }
 
 func (p *kafkaProducer) Close() error {
 	return p.writer.Close()
-} 
\ No newline at end of file
+}
@@ -0,0 +1,57 @@
+package queue
+
+import "sync"
+
+// offsetTracker acompanha, por partição, as mensagens buscadas e ainda não
+// concluídas. Como elas terminam fora de ordem, só o prefixo contíguo de
+// mensagens concluídas pode ser commitado: commitar um offset maior que o de
+// uma mensagem em andamento a perderia num crash
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	// inFlight guarda os offsets na ordem em que foram buscados
+	inFlight []int64
+	done     map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+// track registra uma mensagem buscada
+func (t *offsetTracker) track(partition int, offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[partition]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[partition] = p
+	}
+	p.inFlight = append(p.inFlight, offset)
+}
+
+// done marca a mensagem como concluída e retorna o maior offset que pode ser
+// commitado na partição, ou false se o prefixo concluído não avançou
+func (t *offsetTracker) done(partition int, offset int64) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[partition]
+	if !ok {
+		return 0, false
+	}
+	p.done[offset] = true
+
+	committable, advanced := int64(0), false
+	for len(p.inFlight) > 0 && p.done[p.inFlight[0]] {
+		committable, advanced = p.inFlight[0], true
+		delete(p.done, p.inFlight[0])
+		p.inFlight = p.inFlight[1:]
+	}
+
+	return committable, advanced
+}
@@ -0,0 +1,279 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// forwardRetryInterval é a espera entre tentativas de devolver uma mensagem
+// ao tópico principal quando a publicação falha
+const forwardRetryInterval = time.Second
+
+// RetryPolicy define quantas vezes uma mensagem com falha transitória é
+// repetida e quanto ela espera antes de cada nova tentativa
+type RetryPolicy struct {
+	// MaxAttempts conta a tentativa original; ao atingi-lo a mensagem vai
+	// para o dead-letter
+	MaxAttempts int
+	// BaseDelay é a espera antes da segunda tentativa; dobra a cada falha
+	// até MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter é a fração aleatória somada ou subtraída da espera, para que
+	// falhas simultâneas não voltem todas ao mesmo tempo
+	Jitter float64
+	// Tiers são os atrasos dos tópicos de retry, como 30s e 5m
+	Tiers []time.Duration
+}
+
+// ErrInvalidRetryPolicy indica uma política de retry sem tópicos ou tentativas
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+// Validate confere que há ao menos uma tentativa e um tópico de retry, e que
+// os atrasos dos tópicos são positivos
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("%w: max attempts must be at least 1", ErrInvalidRetryPolicy)
+	}
+	if len(p.Tiers) == 0 {
+		return fmt.Errorf("%w: at least one retry tier is required", ErrInvalidRetryPolicy)
+	}
+	for _, tier := range p.Tiers {
+		if tier <= 0 {
+			return fmt.Errorf("%w: retry tier %s must be positive", ErrInvalidRetryPolicy, tier)
+		}
+	}
+	return nil
+}
+
+// Backoff retorna a espera antes da próxima tentativa, depois de attempts falhas
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// Tier escolhe o tópico de retry de uma espera: o de maior atraso que não
+// passa dela. Assim nenhuma mensagem do tópico fica pronta antes do atraso
+// dele. Sem tópicos, o que Validate recusa, retorna zero
+func (p RetryPolicy) Tier(delay time.Duration) time.Duration {
+	if len(p.Tiers) == 0 {
+		return 0
+	}
+
+	tiers := append([]time.Duration(nil), p.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i] < tiers[j] })
+
+	tier := tiers[0]
+	for _, t := range tiers {
+		if t <= delay {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// RetryTopic retorna o nome do tópico de retry de um atraso, como
+// payment-processing.retry.30s
+func RetryTopic(topic string, tier time.Duration) string {
+	return topic + ".retry." + formatTier(tier)
+}
+
+func formatTier(tier time.Duration) string {
+	switch {
+	case tier%time.Hour == 0:
+		return fmt.Sprintf("%dh", tier/time.Hour)
+	case tier%time.Minute == 0:
+		return fmt.Sprintf("%dm", tier/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", tier/time.Second)
+	}
+}
+
+// RetryQueue agenda novas tentativas de mensagens com falha transitória
+type RetryQueue interface {
+	// Schedule publica a mensagem original no tópico de retry da espera da
+	// próxima tentativa, com os metadados da falha e o horário em que ela
+	// deve voltar ao tópico principal. Retorna o tópico usado
+	Schedule(ctx context.Context, message kafka.Message, failure *model.MessageFailure) (string, error)
+	// MaxAttempts é o número máximo de tentativas de uma mensagem
+	MaxAttempts() int
+}
+
+type retryQueue struct {
+	producer KafkaProducer
+	topic    string
+	policy   RetryPolicy
+}
+
+// NewRetryQueue cria a fila de retry das mensagens de topic. Retorna
+// ErrInvalidRetryPolicy se a política não passar em Validate
+func NewRetryQueue(producer KafkaProducer, topic string, policy RetryPolicy) (RetryQueue, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &retryQueue{
+		producer: producer,
+		topic:    topic,
+		policy:   policy,
+	}, nil
+}
+
+func (q *retryQueue) MaxAttempts() int {
+	return q.policy.MaxAttempts
+}
+
+func (q *retryQueue) Schedule(ctx context.Context, message kafka.Message, failure *model.MessageFailure) (string, error) {
+	failedAt := failure.FailedAt
+	if failedAt.IsZero() {
+		failedAt = time.Now()
+	}
+
+	delay := q.policy.Backoff(failure.Attempts)
+	topic := RetryTopic(q.topic, q.policy.Tier(delay))
+
+	headers := append(FailureHeaders(message, failure),
+		kafka.Header{Key: HeaderRetryAt, Value: []byte(failedAt.Add(delay).UTC().Format(time.RFC3339Nano))})
+
+	if err := q.producer.Publish(ctx, topic, message.Key, message.Value, headers...); err != nil {
+		return "", fmt.Errorf("failed to schedule retry on %s: %w", topic, err)
+	}
+	return topic, nil
+}
+
+// RetryAt retorna o horário em que uma mensagem de retry deve voltar ao
+// tópico principal; zero se ela não tiver um
+func RetryAt(message kafka.Message) time.Time {
+	retryAt, err := time.Parse(time.RFC3339Nano, headerValue(message, HeaderRetryAt))
+	if err != nil {
+		return time.Time{}
+	}
+	return retryAt
+}
+
+// RetryForwarder consome um tópico de retry e devolve cada mensagem ao tópico
+// principal quando chega a hora da nova tentativa. Mensagens de um mesmo
+// tópico de retry têm atrasos parecidos, então esperar pela primeira da
+// partição não atrasa muito as seguintes
+type RetryForwarder struct {
+	reader   *kafka.Reader
+	producer KafkaProducer
+	topic    string
+	logger   *logrus.Logger
+}
+
+// NewRetryForwarder cria o encaminhador de retryTopic para topic
+func NewRetryForwarder(brokers []string, retryTopic, groupID, topic string, producer KafkaProducer, logger *logrus.Logger) *RetryForwarder {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  brokers,
+		Topic:    retryTopic,
+		GroupID:  groupID,
+		MinBytes: 1,
+		MaxBytes: 10e6, // 10MB
+	})
+
+	return &RetryForwarder{
+		reader:   reader,
+		producer: producer,
+		topic:    topic,
+		logger:   logger,
+	}
+}
+
+// Start encaminha mensagens até o contexto ser cancelado. O offset só é
+// commitado depois que a mensagem foi publicada no tópico principal
+func (f *RetryForwarder) Start(ctx context.Context) error {
+	for {
+		message, err := f.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			f.logger.WithError(err).Error("Failed to read message from retry topic")
+			continue
+		}
+
+		// Sem publicar, a mensagem não pode ser pulada: um commit posterior
+		// da partição a perderia
+		for {
+			err := f.Forward(ctx, message)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			f.logger.WithError(err).WithField("topic", message.Topic).Error("Failed to forward retry message")
+
+			select {
+			case <-time.After(forwardRetryInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := f.reader.CommitMessages(ctx, message); err != nil && ctx.Err() == nil {
+			f.logger.WithError(err).WithField("topic", message.Topic).Error("Failed to commit retry offset")
+		}
+	}
+}
+
+// Forward espera até o horário da nova tentativa e publica a mensagem no
+// tópico principal, com a chave, o payload e os metadados da falha
+func (f *RetryForwarder) Forward(ctx context.Context, message kafka.Message) error {
+	if wait := time.Until(RetryAt(message)); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	headers := make([]kafka.Header, 0, len(message.Headers))
+	for _, header := range message.Headers {
+		if header.Key != HeaderRetryAt {
+			headers = append(headers, header)
+		}
+	}
+
+	if err := f.producer.Publish(ctx, f.topic, message.Key, message.Value, headers...); err != nil {
+		return err
+	}
+
+	f.logger.WithFields(logrus.Fields{
+		"retry_topic": message.Topic,
+		"attempts":    MessageAttempts(message),
+	}).Info("Retry message forwarded")
+	return nil
+}
+
+// Close fecha o consumidor do tópico de retry
+func (f *RetryForwarder) Close() error {
+	return f.reader.Close()
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// WorkerPool executa tarefas num número fixo de workers, cada um com uma fila
+// limitada. Tarefas com a mesma chave vão sempre para o mesmo worker e rodam
+// em ordem, uma de cada vez. Submit bloqueia enquanto a fila do worker
+// estiver cheia, o que faz o consumidor parar de buscar mensagens
+type WorkerPool struct {
+	queues   []chan func()
+	wg       sync.WaitGroup
+	queued   atomic.Int64
+	inFlight atomic.Int64
+	onStats  func(queued, inFlight int64)
+}
+
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	queues := make([]chan func(), workers)
+	for i := range queues {
+		queues[i] = make(chan func(), queueSize)
+	}
+
+	return &WorkerPool{queues: queues}
+}
+
+// OnStats registra um callback chamado a cada mudança no número de tarefas
+// enfileiradas ou em execução (métricas). Deve ser chamado antes de Start
+func (p *WorkerPool) OnStats(fn func(queued, inFlight int64)) {
+	p.onStats = fn
+}
+
+// Start inicia os workers
+func (p *WorkerPool) Start() {
+	for _, queue := range p.queues {
+		p.wg.Add(1)
+		go p.work(queue)
+	}
+}
+
+// Submit enfileira a tarefa no worker da chave. Bloqueia até haver espaço na
+// fila ou o contexto ser cancelado
+func (p *WorkerPool) Submit(ctx context.Context, key string, task func()) error {
+	queue := p.queues[p.index(key)]
+
+	p.queued.Add(1)
+	p.reportStats()
+
+	select {
+	case queue <- task:
+		return nil
+	case <-ctx.Done():
+		p.queued.Add(-1)
+		p.reportStats()
+		return ctx.Err()
+	}
+}
+
+// Stop fecha as filas e espera os workers terminarem as tarefas já
+// enfileiradas. Submit não pode ser chamado depois de Stop
+func (p *WorkerPool) Stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// Queued retorna quantas tarefas aguardam um worker
+func (p *WorkerPool) Queued() int64 {
+	return p.queued.Load()
+}
+
+// InFlight retorna quantas tarefas estão em execução
+func (p *WorkerPool) InFlight() int64 {
+	return p.inFlight.Load()
+}
+
+func (p *WorkerPool) work(queue chan func()) {
+	defer p.wg.Done()
+
+	for task := range queue {
+		p.queued.Add(-1)
+		p.inFlight.Add(1)
+		p.reportStats()
+
+		task()
+
+		p.inFlight.Add(-1)
+		p.reportStats()
+	}
+}
+
+func (p *WorkerPool) index(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+func (p *WorkerPool) reportStats() {
+	if p.onStats != nil {
+		p.onStats(p.queued.Load(), p.inFlight.Load())
+	}
+}
@@ -0,0 +1,26 @@
+package redact
+
+import "github.com/sirupsen/logrus"
+
+// Hook é um hook do logrus que remove PANs e CVVs da mensagem e de todos os
+// campos de cada entrada antes de ela ser formatada
+type Hook struct{}
+
+func NewHook() *Hook {
+	return &Hook{}
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	entry.Message = String(entry.Message)
+
+	// O logrus entrega aos hooks uma cópia dos campos, então é seguro alterar
+	for key, value := range entry.Data {
+		entry.Data[key] = Field(key, value)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package redact
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Redacted substitui valores sensíveis que não podem ser mascarados
+	Redacted = "[REDACTED]"
+	maskChar = "*"
+	// gapMask fica entre o BIN e os últimos 4 dígitos quando o comprimento
+	// do PAN não é conhecido
+	gapMask = "..."
+)
+
+var (
+	// panPattern encontra sequências de 13 a 19 dígitos, com ou sem
+	// separadores de espaço ou hífen entre eles
+	panPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+	// cvvPattern encontra pares chave/valor de código de segurança, como
+	// cvv=123, "cvc":"1234" ou security_code: 123
+	cvvPattern = regexp.MustCompile(`(?i)("?(?:cvv2?|cvc2?|cid|security[_ ]?code)"?\s*[:=]\s*"?)\d{3,4}`)
+)
+
+// sensitiveKeys são nomes de campos cujo valor nunca deve aparecer em logs
+var sensitiveKeys = map[string]bool{
+	"cvv":           true,
+	"cvv2":          true,
+	"cvc":           true,
+	"cvc2":          true,
+	"cid":           true,
+	"security_code": true,
+}
+
+// panKeys são nomes de campos que contêm números de cartão
+var panKeys = map[string]bool{
+	"card_number": true,
+	"pan":         true,
+	"number":      true,
+}
+
+// MaskPAN mantém apenas os seis primeiros e os quatro últimos dígitos
+func MaskPAN(pan string) string {
+	digits := onlyDigits(pan)
+	if len(digits) < 13 {
+		return strings.Repeat(maskChar, len(digits))
+	}
+	return digits[:6] + strings.Repeat(maskChar, len(digits)-10) + digits[len(digits)-4:]
+}
+
+// MaskedPAN monta o PAN mascarado a partir do BIN e dos últimos 4 dígitos
+// guardados no pagamento, quando o PAN completo não está disponível. O
+// comprimento do PAN não é guardado, então a máscara do meio é fixa e não
+// sugere um número de dígitos
+func MaskedPAN(bin, last4 string) string {
+	if bin == "" && last4 == "" {
+		return ""
+	}
+	return bin + gapMask + last4
+}
+
+// MaskName mantém apenas a inicial de cada parte do nome
+func MaskName(name string) string {
+	parts := strings.Fields(name)
+	for i, part := range parts {
+		runes := []rune(part)
+		parts[i] = string(runes[0]) + strings.Repeat(maskChar, len(runes)-1)
+	}
+	return strings.Join(parts, " ")
+}
+
+// String remove de um texto livre qualquer valor parecido com PAN ou CVV
+func String(s string) string {
+	s = cvvPattern.ReplaceAllString(s, "${1}"+Redacted)
+	return panPattern.ReplaceAllStringFunc(s, MaskPAN)
+}
+
+// Field retorna o valor de um campo de log com os dados sensíveis removidos
+func Field(key string, value interface{}) interface{} {
+	lower := strings.ToLower(key)
+
+	if sensitiveKeys[lower] {
+		return Redacted
+	}
+
+	switch v := value.(type) {
+	case string:
+		if panKeys[lower] {
+			return MaskPAN(v)
+		}
+		return String(v)
+	case error:
+		return scrub(v, v.Error())
+	case fmt.Stringer:
+		return scrub(v, v.String())
+	}
+
+	// Structs, mapas e listas podem carregar um cartão em algum campo; são
+	// formatados como o logrus faria e limpos como texto
+	switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		return scrub(value, fmt.Sprintf("%+v", value))
+	default:
+		return value
+	}
+}
+
+// scrub retorna o texto sem os dados sensíveis, ou o valor original se o
+// texto não tinha nenhum
+func scrub(value interface{}, text string) interface{} {
+	scrubbed := String(text)
+	if scrubbed == text {
+		return value
+	}
+	return scrubbed
+}
+
+func onlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
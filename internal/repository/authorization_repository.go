@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrPaymentNotAuthorized = errors.New("payment is not authorized")
+
+// AuthorizationRepository gerencia as autorizações de captura manual, que
+// ficam em authorized até a captura, o cancelamento ou a expiração
+type AuthorizationRepository interface {
+	// RecordAuthorization grava o prazo da autorização e estende até ele a
+	// reserva ativa do pagamento, para que o HoldExpirer não a libere antes
+	RecordAuthorization(ctx context.Context, id uuid.UUID, expiresAt time.Time) error
+	// IncrementAuthorization soma o valor ao total autorizado de um pagamento
+	// authorized e retorna o novo total. Retorna ErrPaymentNotAuthorized se o
+	// pagamento saiu de authorized
+	IncrementAuthorization(ctx context.Context, id uuid.UUID, amount model.Money) (model.Money, error)
+	// GetExpiredAuthorizations retorna até limit pagamentos em authorized com
+	// o prazo vencido em now, os mais antigos primeiro
+	GetExpiredAuthorizations(ctx context.Context, now time.Time, limit int) ([]*model.Payment, error)
+}
+
+func (r *paymentRepository) RecordAuthorization(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	return r.inTx(ctx, func(tx *paymentRepository) error {
+		tag, err := tx.db.Exec(ctx, `
+			UPDATE payments
+			SET authorization_expires_at = $2, updated_at = $3
+			WHERE id = $1
+		`, id, expiresAt, time.Now())
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrPaymentNotFound
+		}
+
+		_, err = tx.db.Exec(ctx, `
+			UPDATE holds
+			SET expires_at = GREATEST(expires_at, $2)
+			WHERE payment_id = $1 AND status = 'active'
+		`, id, expiresAt)
+		return err
+	})
+}
+
+func (r *paymentRepository) IncrementAuthorization(ctx context.Context, id uuid.UUID, amount model.Money) (model.Money, error) {
+	query := `
+		UPDATE payments
+		SET authorized_amount = authorized_amount + $2, updated_at = $4
+		WHERE id = $1 AND status = 'authorized' AND currency = $3
+		RETURNING authorized_amount
+	`
+
+	authorized := model.Money{Currency: amount.Currency}
+	err := r.db.QueryRow(ctx, query, id, amount.Value, amount.Currency, time.Now()).Scan(&authorized.Value)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return model.Money{}, r.notAuthorizedError(ctx, id, amount)
+		}
+		return model.Money{}, err
+	}
+
+	return authorized, nil
+}
+
+func (r *paymentRepository) GetExpiredAuthorizations(ctx context.Context, now time.Time, limit int) ([]*model.Payment, error) {
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE status = 'authorized' AND authorization_expires_at <= $1
+		ORDER BY authorization_expires_at
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []*model.Payment
+	for rows.Next() {
+		payment, err := r.scanPayment(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// notAuthorizedError explica por que uma operação sobre a autorização não
+// encontrou o pagamento em authorized
+func (r *paymentRepository) notAuthorizedError(ctx context.Context, id uuid.UUID, amount model.Money) error {
+	var status model.PaymentStatus
+	var currency model.Currency
+	err := r.db.QueryRow(ctx, `SELECT status, currency FROM payments WHERE id = $1`, id).Scan(&status, &currency)
+	switch {
+	case err == pgx.ErrNoRows:
+		return ErrPaymentNotFound
+	case err != nil:
+		return err
+	case currency != amount.Currency:
+		return fmt.Errorf("%w: %s and %s", model.ErrCurrencyMismatch, currency, amount.Currency)
+	default:
+		return fmt.Errorf("%w: payment is %s", ErrPaymentNotAuthorized, status)
+	}
+}
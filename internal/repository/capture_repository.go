@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrCaptureExceedsAuthorization = errors.New("capture exceeds authorized amount")
+	ErrCaptureInProgress           = errors.New("capture in progress")
+)
+
+// CaptureRepository gerencia as capturas dos pagamentos e o total capturado
+type CaptureRepository interface {
+	// ReserveCapture grava a captura como pending com o pagamento travado,
+	// antes do pedido ao adquirente. O pagamento precisa estar em authorized,
+	// sem outra captura pending, e o valor caber no saldo não capturado. Sem
+	// valor a captura leva todo esse saldo; a que o leva é sempre final. O
+	// valor e o Final decididos sob a trava ficam na captura. Retorna
+	// ErrPaymentNotAuthorized, ErrCaptureInProgress ou
+	// ErrCaptureExceedsAuthorization
+	ReserveCapture(ctx context.Context, capture *model.PaymentCapture) error
+	// RecordCapture grava a captura aprovada, ou confirma a reservada com o
+	// mesmo ID, e a soma ao total capturado do pagamento, que precisa estar em
+	// processing ou authorized. Retorna ErrCaptureExceedsAuthorization se o
+	// total passar do autorizado
+	RecordCapture(ctx context.Context, capture *model.PaymentCapture) error
+	// FailCapture marca como failed a captura reservada que não foi concluída
+	FailCapture(ctx context.Context, id uuid.UUID) error
+	// HasPendingCapture trava o pagamento e indica se ele tem uma captura
+	// reservada. Quem encerra a autorização chama antes, na mesma transação
+	HasPendingCapture(ctx context.Context, paymentID uuid.UUID) (bool, error)
+	// GetCaptures retorna as capturas do pagamento, da mais antiga à mais recente
+	GetCaptures(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentCapture, error)
+}
+
+func (r *paymentRepository) ReserveCapture(ctx context.Context, capture *model.PaymentCapture) error {
+	return r.inTx(ctx, func(tx *paymentRepository) error {
+		var status model.PaymentStatus
+		var currency model.Currency
+		var authorized, captured int64
+		err := tx.db.QueryRow(ctx, `
+			SELECT status, currency, authorized_amount, COALESCE(captured_amount, 0)
+			FROM payments
+			WHERE id = $1
+			FOR UPDATE
+		`, capture.PaymentID).Scan(&status, &currency, &authorized, &captured)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrPaymentNotFound
+			}
+			return err
+		}
+
+		// Consultado depois da trava, para ver a reserva de quem a segurava
+		pending, err := tx.pendingCapture(ctx, capture.PaymentID)
+		if err != nil {
+			return err
+		}
+
+		// O saldo vem da linha travada: um incremento confirmado depois da
+		// leitura do chamador entra aqui e não é encerrado sem ser capturado
+		remaining := model.Money{Value: authorized - captured, Currency: currency}
+		if capture.Amount == (model.Money{}) {
+			capture.Amount = remaining
+		}
+
+		switch {
+		case status != model.PaymentStatusAuthorized:
+			return fmt.Errorf("%w: payment is %s", ErrPaymentNotAuthorized, status)
+		case pending:
+			return ErrCaptureInProgress
+		case currency != capture.Amount.Currency:
+			return fmt.Errorf("%w: %s and %s", model.ErrCurrencyMismatch, currency, capture.Amount.Currency)
+		case !capture.Amount.IsPositive() || capture.Amount.Value > remaining.Value:
+			return fmt.Errorf("%w: %s remaining", ErrCaptureExceedsAuthorization, remaining)
+		}
+		capture.Final = capture.Final || capture.Amount.Value == remaining.Value
+
+		query := `
+			INSERT INTO payment_captures (id, payment_id, amount, currency, final, status, created_at)
+			VALUES ($1, $2, $3, $4, $5, 'pending', $6)
+		`
+
+		_, err = tx.db.Exec(ctx, query,
+			capture.ID,
+			capture.PaymentID,
+			capture.Amount.Value,
+			capture.Amount.Currency,
+			capture.Final,
+			capture.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		capture.Status = model.CaptureStatusPending
+		return nil
+	})
+}
+
+func (r *paymentRepository) RecordCapture(ctx context.Context, capture *model.PaymentCapture) error {
+	return r.inTx(ctx, func(tx *paymentRepository) error {
+		query := `
+			UPDATE payments
+			SET captured_amount = COALESCE(captured_amount, 0) + $2, updated_at = $4
+			WHERE id = $1 AND status IN ('processing', 'authorized') AND currency = $3
+			  AND COALESCE(captured_amount, 0) + $2 <= authorized_amount
+		`
+
+		tag, err := tx.db.Exec(ctx, query, capture.PaymentID, capture.Amount.Value, capture.Amount.Currency, capture.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return tx.captureError(ctx, capture)
+		}
+
+		// Uma captura reservada é confirmada com a resposta do adquirente
+		query = `
+			INSERT INTO payment_captures (
+				id, payment_id, amount, currency, final, acquirer, response_code, network_reference, status, created_at
+			) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), 'succeeded', $9)
+			ON CONFLICT (id) DO UPDATE
+			SET acquirer = EXCLUDED.acquirer, response_code = EXCLUDED.response_code,
+			    network_reference = EXCLUDED.network_reference, status = 'succeeded'
+			WHERE payment_captures.status = 'pending'
+		`
+
+		tag, err = tx.db.Exec(ctx, query,
+			capture.ID,
+			capture.PaymentID,
+			capture.Amount.Value,
+			capture.Amount.Currency,
+			capture.Final,
+			capture.Acquirer,
+			capture.ResponseCode,
+			capture.NetworkReference,
+			capture.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("capture %s is not pending", capture.ID)
+		}
+
+		capture.Status = model.CaptureStatusSucceeded
+		return nil
+	})
+}
+
+func (r *paymentRepository) FailCapture(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `UPDATE payment_captures SET status = 'failed' WHERE id = $1 AND status = 'pending'`, id)
+	return err
+}
+
+func (r *paymentRepository) HasPendingCapture(ctx context.Context, paymentID uuid.UUID) (bool, error) {
+	var pending bool
+	err := r.inTx(ctx, func(tx *paymentRepository) error {
+		var id uuid.UUID
+		err := tx.db.QueryRow(ctx, `SELECT id FROM payments WHERE id = $1 FOR UPDATE`, paymentID).Scan(&id)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrPaymentNotFound
+			}
+			return err
+		}
+
+		pending, err = tx.pendingCapture(ctx, paymentID)
+		return err
+	})
+	return pending, err
+}
+
+// pendingCapture indica se o pagamento tem uma captura reservada. Numa
+// consulta separada da trava, porque no READ COMMITTED a que trava enxerga o
+// snapshot de antes da espera
+func (r *paymentRepository) pendingCapture(ctx context.Context, paymentID uuid.UUID) (bool, error) {
+	var pending bool
+	err := r.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM payment_captures WHERE payment_id = $1 AND status = 'pending')`,
+		paymentID,
+	).Scan(&pending)
+	return pending, err
+}
+
+func (r *paymentRepository) GetCaptures(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentCapture, error) {
+	query := `
+		SELECT id, payment_id, amount, currency, final, status, COALESCE(acquirer, ''),
+			COALESCE(response_code, ''), COALESCE(network_reference, ''), created_at
+		FROM payment_captures
+		WHERE payment_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.Query(ctx, query, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	captures := []*model.PaymentCapture{}
+	for rows.Next() {
+		capture := &model.PaymentCapture{}
+		if err := rows.Scan(
+			&capture.ID,
+			&capture.PaymentID,
+			&capture.Amount.Value,
+			&capture.Amount.Currency,
+			&capture.Final,
+			&capture.Status,
+			&capture.Acquirer,
+			&capture.ResponseCode,
+			&capture.NetworkReference,
+			&capture.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		captures = append(captures, capture)
+	}
+
+	return captures, rows.Err()
+}
+
+// captureError explica por que a captura não pôde ser somada ao pagamento
+func (r *paymentRepository) captureError(ctx context.Context, capture *model.PaymentCapture) error {
+	var status model.PaymentStatus
+	var currency model.Currency
+	var authorized, captured int64
+	err := r.db.QueryRow(ctx,
+		`SELECT status, currency, authorized_amount, COALESCE(captured_amount, 0) FROM payments WHERE id = $1`,
+		capture.PaymentID,
+	).Scan(&status, &currency, &authorized, &captured)
+	switch {
+	case err == pgx.ErrNoRows:
+		return ErrPaymentNotFound
+	case err != nil:
+		return err
+	case currency != capture.Amount.Currency:
+		return fmt.Errorf("%w: %s and %s", model.ErrCurrencyMismatch, currency, capture.Amount.Currency)
+	case status != model.PaymentStatusProcessing && status != model.PaymentStatusAuthorized:
+		return fmt.Errorf("%w: payment is %s", ErrPaymentNotAuthorized, status)
+	default:
+		remaining := model.Money{Value: authorized - captured, Currency: currency}
+		return fmt.Errorf("%w: %s remaining", ErrCaptureExceedsAuthorization, remaining)
+	}
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrCardNotFound = errors.New("card token not found")
+
+type CardRepository interface {
+	SaveCard(ctx context.Context, card *model.VaultedCard) error
+	GetCardByToken(ctx context.Context, token string) (*model.VaultedCard, error)
+	// GetCardByFingerprint busca o token que o merchant já tem para o cartão
+	GetCardByFingerprint(ctx context.Context, merchantID string, fingerprint []byte, expiryMonth, expiryYear int) (*model.VaultedCard, error)
+}
+
+type cardRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewCardRepository(db *pgxpool.Pool) CardRepository {
+	return &cardRepository{db: db}
+}
+
+func (r *cardRepository) SaveCard(ctx context.Context, card *model.VaultedCard) error {
+	query := `
+		INSERT INTO card_tokens (
+			token, merchant_id, pan_ciphertext, pan_key_id, pan_fingerprint, bin, last4,
+			expiry_month, expiry_year, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err := r.db.Exec(ctx, query,
+		card.Token,
+		card.MerchantID,
+		card.PANCiphertext,
+		card.PANKeyID,
+		card.PANFingerprint,
+		card.BIN,
+		card.Last4,
+		card.ExpiryMonth,
+		card.ExpiryYear,
+		card.CreatedAt,
+	)
+
+	return err
+}
+
+func (r *cardRepository) GetCardByToken(ctx context.Context, token string) (*model.VaultedCard, error) {
+	query := `
+		SELECT token, COALESCE(merchant_id, ''), pan_ciphertext, COALESCE(pan_key_id, ''), pan_fingerprint, bin, last4,
+			   expiry_month, expiry_year, created_at
+		FROM card_tokens
+		WHERE token = $1
+	`
+
+	return r.scanCard(r.db.QueryRow(ctx, query, token))
+}
+
+func (r *cardRepository) GetCardByFingerprint(ctx context.Context, merchantID string, fingerprint []byte, expiryMonth, expiryYear int) (*model.VaultedCard, error) {
+	query := `
+		SELECT token, COALESCE(merchant_id, ''), pan_ciphertext, COALESCE(pan_key_id, ''), pan_fingerprint, bin, last4,
+			   expiry_month, expiry_year, created_at
+		FROM card_tokens
+		WHERE merchant_id = $1 AND pan_fingerprint = $2 AND expiry_month = $3 AND expiry_year = $4
+		ORDER BY created_at
+		LIMIT 1
+	`
+
+	return r.scanCard(r.db.QueryRow(ctx, query, merchantID, fingerprint, expiryMonth, expiryYear))
+}
+
+func (r *cardRepository) scanCard(row pgx.Row) (*model.VaultedCard, error) {
+	card := &model.VaultedCard{}
+	err := row.Scan(
+		&card.Token,
+		&card.MerchantID,
+		&card.PANCiphertext,
+		&card.PANKeyID,
+		&card.PANFingerprint,
+		&card.BIN,
+		&card.Last4,
+		&card.ExpiryMonth,
+		&card.ExpiryYear,
+		&card.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrCardNotFound
+		}
+		return nil, err
+	}
+
+	return card, nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+
+	"golang-payment-microservice/internal/kms"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type dataKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewDataKeyRepository(db *pgxpool.Pool) kms.DataKeyStore {
+	return &dataKeyRepository{db: db}
+}
+
+func (r *dataKeyRepository) ListDataKeys(ctx context.Context) ([]*kms.DataKey, error) {
+	query := `
+		SELECT id, version, wrapped_key, master_key_id, active, created_at
+		FROM data_keys
+		ORDER BY version
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*kms.DataKey
+	for rows.Next() {
+		key := &kms.DataKey{}
+		err := rows.Scan(
+			&key.ID,
+			&key.Version,
+			&key.WrappedKey,
+			&key.MasterKeyID,
+			&key.Active,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *dataKeyRepository) CreateDataKey(ctx context.Context, key *kms.DataKey) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if key.Active {
+		if _, err := tx.Exec(ctx, `UPDATE data_keys SET active = false WHERE active`); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO data_keys (id, version, wrapped_key, master_key_id, active, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err = tx.Exec(ctx, query,
+		key.ID,
+		key.Version,
+		key.WrappedKey,
+		key.MasterKeyID,
+		key.Active,
+		key.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *dataKeyRepository) UpdateWrappedKey(ctx context.Context, id string, wrappedKey []byte, masterKeyID string) error {
+	query := `
+		UPDATE data_keys
+		SET wrapped_key = $2, master_key_id = $3
+		WHERE id = $1
+	`
+
+	_, err := r.db.Exec(ctx, query, id, wrappedKey, masterKeyID)
+	return err
+}
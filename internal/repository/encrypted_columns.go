@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"golang-payment-microservice/internal/kms"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// encryptString cifra o valor de uma coluna sensível; aad amarra o
+// ciphertext à linha (normalmente o ID), impedindo a troca entre linhas
+func encryptString(ctx context.Context, keys kms.KeyManager, value, aad string) (kms.Ciphertext, error) {
+	ciphertext, err := keys.Encrypt(ctx, []byte(value), []byte(aad))
+	if err != nil {
+		return kms.Ciphertext{}, fmt.Errorf("failed to encrypt column: %w", err)
+	}
+	return ciphertext, nil
+}
+
+// decryptString decifra uma coluna sensível. Linhas ainda não migradas têm
+// apenas o valor legado em texto claro, que é retornado como está
+func decryptString(ctx context.Context, keys kms.KeyManager, data []byte, keyID, legacy *string, aad string) (string, error) {
+	if data == nil {
+		if legacy != nil {
+			return *legacy, nil
+		}
+		return "", nil
+	}
+
+	ciphertext := kms.Ciphertext{Data: data}
+	if keyID != nil {
+		ciphertext.KeyID = *keyID
+	}
+
+	plaintext, err := keys.Decrypt(ctx, ciphertext, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt column: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// encryptedColumn descreve uma coluna cifrada seguindo a convenção
+// <column>_ciphertext / <column>_key_id, com coluna legada em texto claro e
+// coluna de blind index opcionais
+type encryptedColumn struct {
+	db          *pgxpool.Pool
+	name        string
+	table       string
+	idColumn    string
+	idType      string
+	plainColumn string
+	column      string
+	hashColumn  string
+}
+
+// NewRotationTargets retorna todas as colunas cifradas do banco para o job
+// de recifragem
+func NewRotationTargets(db *pgxpool.Pool) []kms.RotationTarget {
+	return []kms.RotationTarget{
+		&encryptedColumn{
+			db:          db,
+			name:        "payments.card_holder",
+			table:       "payments",
+			idColumn:    "id",
+			idType:      "uuid",
+			plainColumn: "card_holder",
+			column:      "card_holder",
+		},
+		&encryptedColumn{
+			db:          db,
+			name:        "accounts.card_number",
+			table:       "accounts",
+			idColumn:    "id",
+			idType:      "uuid",
+			plainColumn: "card_number",
+			column:      "card_number",
+			hashColumn:  "card_number_hash",
+		},
+		&encryptedColumn{
+			db:         db,
+			name:       "card_tokens.pan",
+			table:      "card_tokens",
+			idColumn:   "token",
+			idType:     "varchar",
+			column:     "pan",
+			hashColumn: "pan_fingerprint",
+		},
+	}
+}
+
+func (c *encryptedColumn) Name() string {
+	return c.name
+}
+
+// ReencryptBatch recifra com a chave ativa as linhas em texto claro ou
+// cifradas com outra chave. FOR UPDATE SKIP LOCKED permite que várias
+// réplicas executem o job ao mesmo tempo sem disputar as mesmas linhas
+func (c *encryptedColumn) ReencryptBatch(ctx context.Context, keys kms.KeyManager, limit int) (int, error) {
+	plainExpr := "NULL::text"
+	if c.plainColumn != "" {
+		plainExpr = c.plainColumn
+	}
+
+	selectQuery := fmt.Sprintf(`
+		SELECT %[2]s::text, %[3]s, %[4]s_ciphertext, %[4]s_key_id
+		FROM %[1]s
+		WHERE %[3]s IS NOT NULL
+		   OR (%[4]s_ciphertext IS NOT NULL AND %[4]s_key_id IS DISTINCT FROM $1)
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, c.table, c.idColumn, plainExpr, c.column)
+
+	tx, err := c.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	type pendingRow struct {
+		id    string
+		plain *string
+		data  []byte
+		keyID *string
+	}
+
+	rows, err := tx.Query(ctx, selectQuery, keys.ActiveKeyID(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var pending []pendingRow
+	for rows.Next() {
+		var row pendingRow
+		if err := rows.Scan(&row.id, &row.plain, &row.data, &row.keyID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	set := fmt.Sprintf("%[1]s_ciphertext = $2, %[1]s_key_id = $3", c.column)
+	if c.plainColumn != "" {
+		set += fmt.Sprintf(", %s = NULL", c.plainColumn)
+	}
+	if c.hashColumn != "" {
+		set += fmt.Sprintf(", %s = $4", c.hashColumn)
+	}
+	updateQuery := fmt.Sprintf(`UPDATE %s SET %s WHERE %s = CAST($1 AS %s)`, c.table, set, c.idColumn, c.idType)
+
+	for _, row := range pending {
+		value, err := decryptString(ctx, keys, row.data, row.keyID, row.plain, row.id)
+		if err != nil {
+			return 0, fmt.Errorf("%s %s: %w", c.name, row.id, err)
+		}
+
+		ciphertext, err := encryptString(ctx, keys, value, row.id)
+		if err != nil {
+			return 0, err
+		}
+
+		args := []any{row.id, ciphertext.Data, ciphertext.KeyID}
+		if c.hashColumn != "" {
+			args = append(args, keys.BlindIndex(value))
+		}
+
+		if _, err := tx.Exec(ctx, updateQuery, args...); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return len(pending), nil
+}
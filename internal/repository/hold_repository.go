@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrHoldNotFound = errors.New("active hold not found")
+
+// HoldRepository gerencia as reservas de saldo. Cada operação altera a reserva
+// e o saldo da conta na mesma transação (a do chamador, se houver)
+type HoldRepository interface {
+	// PlaceHold reserva o valor no saldo disponível da conta. Retorna
+	// ErrInsufficientFunds se o saldo disponível não cobrir o valor
+	PlaceHold(ctx context.Context, hold *model.Hold) error
+	// CaptureHold converte a reserva ativa do pagamento em débito no saldo contábil
+	CaptureHold(ctx context.Context, paymentID uuid.UUID) (*model.Hold, error)
+	// CaptureHoldAmount é a captura parcial da reserva: debita o valor do
+	// saldo contábil e devolve o restante da reserva ao disponível
+	CaptureHoldAmount(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error)
+	// DrawHold debita do saldo contábil parte da reserva ativa, que continua
+	// ativa com o restante para as próximas capturas
+	DrawHold(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error)
+	// IncreaseHold soma o valor à reserva ativa do pagamento, reservando-o no
+	// saldo disponível. Retorna ErrInsufficientFunds se o disponível não cobrir
+	IncreaseHold(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error)
+	// ReleaseHold devolve ao saldo disponível a reserva ativa do pagamento,
+	// marcando-a como released ou expired
+	ReleaseHold(ctx context.Context, paymentID uuid.UUID, status model.HoldStatus) (*model.Hold, error)
+	// GetExpiredHolds lista reservas ativas vencidas de pagamentos ainda pendentes
+	GetExpiredHolds(ctx context.Context, now time.Time, limit int) ([]*model.Hold, error)
+	// GetHoldsByAccount lista as reservas da conta, opcionalmente filtradas por status
+	GetHoldsByAccount(ctx context.Context, accountID uuid.UUID, status model.HoldStatus, limit, offset int) ([]*model.Hold, error)
+}
+
+const holdColumns = `
+	id, account_id, payment_id, amount, currency, status, expires_at,
+	created_at, updated_at, released_at
+`
+
+func (r *paymentRepository) PlaceHold(ctx context.Context, hold *model.Hold) error {
+	return r.inTx(ctx, func(tx *paymentRepository) error {
+		query := `
+			UPDATE accounts
+			SET available_balance = available_balance - $2, updated_at = $4
+			WHERE id = $1 AND currency = $3 AND is_active AND available_balance >= $2
+		`
+
+		tag, err := tx.db.Exec(ctx, query, hold.AccountID, hold.Amount.Value, hold.Amount.Currency, time.Now())
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return tx.debitError(ctx, hold.AccountID, hold.Amount)
+		}
+
+		query = `
+			INSERT INTO holds (
+				id, account_id, payment_id, amount, currency, status, expires_at,
+				created_at, updated_at
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`
+
+		_, err = tx.db.Exec(ctx, query,
+			hold.ID,
+			hold.AccountID,
+			hold.PaymentID,
+			hold.Amount.Value,
+			hold.Amount.Currency,
+			hold.Status,
+			hold.ExpiresAt,
+			hold.CreatedAt,
+			hold.UpdatedAt,
+		)
+		return err
+	})
+}
+
+func (r *paymentRepository) CaptureHold(ctx context.Context, paymentID uuid.UUID) (*model.Hold, error) {
+	return r.captureHold(ctx, paymentID, nil)
+}
+
+func (r *paymentRepository) CaptureHoldAmount(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error) {
+	return r.captureHold(ctx, paymentID, &amount)
+}
+
+// captureHold encerra a reserva como capturada. Sem valor, a reserva inteira
+// é capturada
+func (r *paymentRepository) captureHold(ctx context.Context, paymentID uuid.UUID, amount *model.Money) (*model.Hold, error) {
+	var hold *model.Hold
+
+	err := r.inTx(ctx, func(tx *paymentRepository) error {
+		var err error
+		hold, err = tx.closeHold(ctx, paymentID, model.HoldStatusCaptured)
+		if err != nil {
+			return err
+		}
+
+		captured := hold.Amount
+		if amount != nil {
+			cmp, err := amount.Cmp(hold.Amount)
+			if err != nil {
+				return err
+			}
+			if cmp > 0 || !amount.IsPositive() {
+				return fmt.Errorf("invalid capture of %s for hold of %s", amount, hold.Amount)
+			}
+			captured = *amount
+		}
+
+		// O saldo disponível já foi reduzido na reserva; o contábil perde o
+		// valor capturado e o disponível recebe de volta o que não foi
+		query := `
+			UPDATE accounts
+			SET balance = balance - $2, available_balance = available_balance + $3, updated_at = $4
+			WHERE id = $1
+		`
+
+		_, err = tx.db.Exec(ctx, query, hold.AccountID, captured.Value, hold.Amount.Value-captured.Value, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func (r *paymentRepository) DrawHold(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error) {
+	var hold *model.Hold
+
+	err := r.inTx(ctx, func(tx *paymentRepository) error {
+		// A reserva precisa continuar positiva; a última captura é a final
+		query := `
+			UPDATE holds
+			SET amount = amount - $2, updated_at = $4
+			WHERE payment_id = $1 AND status = 'active' AND currency = $3 AND amount > $2
+			RETURNING ` + holdColumns
+
+		var err error
+		hold, err = scanHold(tx.db.QueryRow(ctx, query, paymentID, amount.Value, amount.Currency, time.Now()))
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return fmt.Errorf("%w: no active hold above %s", ErrHoldNotFound, amount)
+			}
+			return err
+		}
+
+		// O disponível já foi reduzido na reserva; só o contábil muda
+		query = `
+			UPDATE accounts
+			SET balance = balance - $2, updated_at = $3
+			WHERE id = $1
+		`
+
+		_, err = tx.db.Exec(ctx, query, hold.AccountID, amount.Value, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func (r *paymentRepository) IncreaseHold(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error) {
+	var hold *model.Hold
+
+	err := r.inTx(ctx, func(tx *paymentRepository) error {
+		// A reserva é travada antes da conta, na ordem do débito do consumidor
+		query := `
+			UPDATE holds
+			SET amount = amount + $2, updated_at = $4
+			WHERE payment_id = $1 AND status = 'active' AND currency = $3
+			RETURNING ` + holdColumns
+
+		var err error
+		hold, err = scanHold(tx.db.QueryRow(ctx, query, paymentID, amount.Value, amount.Currency, time.Now()))
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrHoldNotFound
+			}
+			return err
+		}
+
+		query = `
+			UPDATE accounts
+			SET available_balance = available_balance - $2, updated_at = $4
+			WHERE id = $1 AND currency = $3 AND is_active AND available_balance >= $2
+		`
+
+		tag, err := tx.db.Exec(ctx, query, hold.AccountID, amount.Value, amount.Currency, time.Now())
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return tx.debitError(ctx, hold.AccountID, amount)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func (r *paymentRepository) ReleaseHold(ctx context.Context, paymentID uuid.UUID, status model.HoldStatus) (*model.Hold, error) {
+	if status != model.HoldStatusReleased && status != model.HoldStatusExpired {
+		return nil, fmt.Errorf("invalid hold release status: %s", status)
+	}
+
+	var hold *model.Hold
+
+	err := r.inTx(ctx, func(tx *paymentRepository) error {
+		var err error
+		hold, err = tx.closeHold(ctx, paymentID, status)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE accounts
+			SET available_balance = available_balance + $2, updated_at = $3
+			WHERE id = $1
+		`
+
+		_, err = tx.db.Exec(ctx, query, hold.AccountID, hold.Amount.Value, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func (r *paymentRepository) GetExpiredHolds(ctx context.Context, now time.Time, limit int) ([]*model.Hold, error) {
+	query := `
+		SELECT ` + holdColumns + `
+		FROM holds
+		WHERE status = 'active' AND expires_at <= $1
+		  AND payment_id IN (SELECT id FROM payments WHERE status = 'pending')
+		ORDER BY expires_at
+		LIMIT $2
+	`
+
+	return r.queryHolds(ctx, query, now, limit)
+}
+
+func (r *paymentRepository) GetHoldsByAccount(ctx context.Context, accountID uuid.UUID, status model.HoldStatus, limit, offset int) ([]*model.Hold, error) {
+	query := `
+		SELECT ` + holdColumns + `
+		FROM holds
+		WHERE account_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	return r.queryHolds(ctx, query, accountID, string(status), limit, offset)
+}
+
+// closeHold encerra a reserva ativa do pagamento com o status informado
+func (r *paymentRepository) closeHold(ctx context.Context, paymentID uuid.UUID, status model.HoldStatus) (*model.Hold, error) {
+	query := `
+		UPDATE holds
+		SET status = $2, updated_at = $3, released_at = $3
+		WHERE payment_id = $1 AND status = 'active'
+		RETURNING ` + holdColumns
+
+	hold, err := scanHold(r.db.QueryRow(ctx, query, paymentID, status, time.Now()))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrHoldNotFound
+		}
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func (r *paymentRepository) queryHolds(ctx context.Context, query string, args ...any) ([]*model.Hold, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []*model.Hold
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+
+	return holds, rows.Err()
+}
+
+func scanHold(row pgx.Row) (*model.Hold, error) {
+	hold := &model.Hold{}
+
+	err := row.Scan(
+		&hold.ID,
+		&hold.AccountID,
+		&hold.PaymentID,
+		&hold.Amount.Value,
+		&hold.Amount.Currency,
+		&hold.Status,
+		&hold.ExpiresAt,
+		&hold.CreatedAt,
+		&hold.UpdatedAt,
+		&hold.ReleasedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
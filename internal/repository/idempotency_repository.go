@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+// IdempotencyRepository guarda as Idempotency-Keys por merchant
+type IdempotencyRepository interface {
+	// Reserve grava a chave como em andamento até key.LockedUntil e retorna
+	// o registro gravado com reserved=true. Se ela já existe e não expirou,
+	// retorna o registro existente e reserved=false. Uma chave expirada é
+	// reaproveitada, e uma em andamento com o lease vencido é reservada de
+	// novo pela mesma requisição (mesmo hash)
+	Reserve(ctx context.Context, key *model.IdempotencyKey) (record *model.IdempotencyKey, reserved bool, err error)
+	// Complete grava a resposta da requisição que reservou a chave. Se o
+	// lease foi tomado por outra requisição, retorna ErrIdempotencyKeyNotFound
+	Complete(ctx context.Context, key *model.IdempotencyKey, status int, body []byte) error
+	// Release apaga uma chave em andamento, se o lease ainda é de key, para
+	// que o cliente possa repetir a requisição
+	Release(ctx context.Context, key *model.IdempotencyKey) error
+	// DeleteExpired apaga até limit chaves expiradas e retorna quantas apagou
+	DeleteExpired(ctx context.Context, now time.Time, limit int) (int64, error)
+}
+
+type idempotencyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdempotencyRepository(db *pgxpool.Pool) IdempotencyRepository {
+	return &idempotencyRepository{db: db}
+}
+
+const idempotencyColumns = `merchant_id, key, request_hash, COALESCE(response_status, 0),
+	response_body, created_at, expires_at, completed_at, locked_until`
+
+func (r *idempotencyRepository) Reserve(ctx context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, bool, error) {
+	// O DO UPDATE só reaproveita chaves expiradas ou em andamento com o
+	// lease vencido; para as demais o INSERT não retorna linha e o registro
+	// existente é lido em seguida
+	insert := `
+		INSERT INTO idempotency_keys (merchant_id, key, request_hash, created_at, expires_at, locked_until)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (merchant_id, key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			response_status = NULL,
+			response_body = NULL,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at,
+			completed_at = NULL,
+			locked_until = EXCLUDED.locked_until
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+		   OR (idempotency_keys.completed_at IS NULL
+		       AND idempotency_keys.request_hash = EXCLUDED.request_hash
+		       AND COALESCE(idempotency_keys.locked_until, idempotency_keys.created_at) <= EXCLUDED.created_at)
+		RETURNING ` + idempotencyColumns
+
+	selectExisting := `
+		SELECT ` + idempotencyColumns + `
+		FROM idempotency_keys
+		WHERE merchant_id = $1 AND key = $2
+	`
+
+	// A chave existente pode ser apagada pelo expurgo entre as duas
+	// consultas; nesse caso a reserva é tentada de novo
+	for attempt := 0; attempt < 2; attempt++ {
+		reserved, err := scanIdempotencyKey(r.db.QueryRow(ctx, insert,
+			key.MerchantID, key.Key, key.RequestHash, key.CreatedAt, key.ExpiresAt, key.LockedUntil))
+		if err == nil {
+			return reserved, true, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, err
+		}
+
+		existing, err := scanIdempotencyKey(r.db.QueryRow(ctx, selectExisting, key.MerchantID, key.Key))
+		if err == nil {
+			return existing, false, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, err
+		}
+	}
+
+	return nil, false, ErrIdempotencyKeyNotFound
+}
+
+func (r *idempotencyRepository) Complete(ctx context.Context, key *model.IdempotencyKey, status int, body []byte) error {
+	// O locked_until gravado na reserva identifica o dono do lease
+	query := `
+		UPDATE idempotency_keys
+		SET response_status = $4, response_body = $5, completed_at = $6, locked_until = NULL
+		WHERE merchant_id = $1 AND key = $2 AND completed_at IS NULL AND locked_until = $3
+	`
+
+	tag, err := r.db.Exec(ctx, query, key.MerchantID, key.Key, key.LockedUntil, status, body, time.Now())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *idempotencyRepository) Release(ctx context.Context, key *model.IdempotencyKey) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE merchant_id = $1 AND key = $2 AND completed_at IS NULL AND locked_until = $3
+	`
+
+	_, err := r.db.Exec(ctx, query, key.MerchantID, key.Key, key.LockedUntil)
+	return err
+}
+
+func (r *idempotencyRepository) DeleteExpired(ctx context.Context, now time.Time, limit int) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE (merchant_id, key) IN (
+			SELECT merchant_id, key FROM idempotency_keys
+			WHERE expires_at <= $1
+			LIMIT $2
+		)
+	`
+
+	tag, err := r.db.Exec(ctx, query, now, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func scanIdempotencyKey(row pgx.Row) (*model.IdempotencyKey, error) {
+	key := &model.IdempotencyKey{}
+	err := row.Scan(
+		&key.MerchantID,
+		&key.Key,
+		&key.RequestHash,
+		&key.ResponseStatus,
+		&key.ResponseBody,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.CompletedAt,
+		&key.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
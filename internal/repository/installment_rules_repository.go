@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrInstallmentRulesNotFound = errors.New("installment rules not found")
+
+// InstallmentRulesRepository guarda as regras de parcelamento de cada merchant
+type InstallmentRulesRepository interface {
+	// GetInstallmentRules retorna as regras do merchant ou
+	// ErrInstallmentRulesNotFound se ele usa as da configuração
+	GetInstallmentRules(ctx context.Context, merchantID string) (*model.InstallmentRules, error)
+	// SaveInstallmentRules cria ou substitui as regras do merchant e preenche UpdatedAt
+	SaveInstallmentRules(ctx context.Context, rules *model.InstallmentRules) error
+}
+
+func (r *paymentRepository) GetInstallmentRules(ctx context.Context, merchantID string) (*model.InstallmentRules, error) {
+	query := `
+		SELECT merchant_id, max_installments, min_installment_amount, currency,
+			interest_free_installments, interest, monthly_rate_bps, updated_at
+		FROM merchant_installment_rules
+		WHERE merchant_id = $1
+	`
+
+	rules := &model.InstallmentRules{}
+	err := r.db.QueryRow(ctx, query, merchantID).Scan(
+		&rules.MerchantID,
+		&rules.MaxInstallments,
+		&rules.MinInstallmentAmount.Value,
+		&rules.MinInstallmentAmount.Currency,
+		&rules.InterestFreeInstallments,
+		&rules.Interest,
+		&rules.MonthlyRateBPS,
+		&rules.UpdatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInstallmentRulesNotFound
+		}
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (r *paymentRepository) SaveInstallmentRules(ctx context.Context, rules *model.InstallmentRules) error {
+	query := `
+		INSERT INTO merchant_installment_rules (
+			merchant_id, max_installments, min_installment_amount, currency,
+			interest_free_installments, interest, monthly_rate_bps, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (merchant_id) DO UPDATE SET
+			max_installments = EXCLUDED.max_installments,
+			min_installment_amount = EXCLUDED.min_installment_amount,
+			currency = EXCLUDED.currency,
+			interest_free_installments = EXCLUDED.interest_free_installments,
+			interest = EXCLUDED.interest,
+			monthly_rate_bps = EXCLUDED.monthly_rate_bps,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	now := time.Now()
+	_, err := r.db.Exec(ctx, query,
+		rules.MerchantID,
+		rules.MaxInstallments,
+		rules.MinInstallmentAmount.Value,
+		rules.MinInstallmentAmount.Currency,
+		rules.InterestFreeInstallments,
+		rules.Interest,
+		rules.MonthlyRateBPS,
+		now,
+	)
+	if err != nil {
+		return err
+	}
+
+	rules.UpdatedAt = &now
+	return nil
+}
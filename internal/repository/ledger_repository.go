@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// LedgerRepository grava o razão de partidas dobradas. Os lançamentos são
+// imutáveis; o saldo de cada conta do razão é mantido junto com as pernas e
+// conferido contra elas pelo verificador de invariantes
+type LedgerRepository interface {
+	// EnsureLedgerAccount retorna a conta do razão, criando-a se preciso. A
+	// linha não é travada; o saldo lido serve só de referência
+	EnsureLedgerAccount(ctx context.Context, accountType model.LedgerAccountType, ownerID string, currency model.Currency) (*model.LedgerAccount, error)
+	// LockLedgerAccount relê a conta do razão travando a linha até o fim da
+	// transação do chamador, para quem decide pelo saldo dela
+	LockLedgerAccount(ctx context.Context, id uuid.UUID) (*model.LedgerAccount, error)
+	// PostEntry grava o lançamento e atualiza o saldo das contas envolvidas
+	PostEntry(ctx context.Context, entry *model.JournalEntry) error
+	// CheckLedgerInvariants lista as divergências entre lançamentos, saldos
+	// do razão e saldos das contas dos portadores
+	CheckLedgerInvariants(ctx context.Context) ([]model.LedgerDrift, error)
+}
+
+const (
+	driftEntryBalanced     = "entry_balanced"
+	driftAccountBalance    = "ledger_account_matches_postings"
+	driftCardholderBalance = "cardholder_matches_account"
+	driftLedgerSumsToZero  = "ledger_sums_to_zero"
+	driftPaymentCaptured   = "payment_captured_matches_captures"
+	driftPaymentRefunded   = "payment_refunded_matches_refunds"
+)
+
+const ledgerAccountColumns = `id, type, owner_id, balance, currency, created_at, updated_at`
+
+func (r *paymentRepository) EnsureLedgerAccount(ctx context.Context, accountType model.LedgerAccountType, ownerID string, currency model.Currency) (*model.LedgerAccount, error) {
+	// A conta quase sempre já existe. A leitura simples não trava a linha,
+	// disputada por todas as conclusões do mesmo merchant e pela de taxas;
+	// o saldo só é travado no UPDATE de PostEntry
+	query := `
+		SELECT ` + ledgerAccountColumns + `
+		FROM ledger_accounts
+		WHERE type = $1 AND owner_id = $2 AND currency = $3
+	`
+
+	account, err := scanLedgerAccount(r.db.QueryRow(ctx, query, accountType, ownerID, currency))
+	if err != pgx.ErrNoRows {
+		return account, err
+	}
+
+	// Numa criação concorrente o INSERT espera a outra transação e não faz
+	// nada; a releitura encontra a linha criada por ela
+	_, err = r.db.Exec(ctx, `
+		INSERT INTO ledger_accounts (id, type, owner_id, currency, balance, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, 0, $5, $5)
+		ON CONFLICT (type, owner_id, currency) DO NOTHING
+	`, uuid.New(), accountType, ownerID, currency, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return scanLedgerAccount(r.db.QueryRow(ctx, query, accountType, ownerID, currency))
+}
+
+func (r *paymentRepository) LockLedgerAccount(ctx context.Context, id uuid.UUID) (*model.LedgerAccount, error) {
+	query := `SELECT ` + ledgerAccountColumns + ` FROM ledger_accounts WHERE id = $1 FOR UPDATE`
+
+	account, err := scanLedgerAccount(r.db.QueryRow(ctx, query, id))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("ledger account %s not found", id)
+	}
+	return account, err
+}
+
+func (r *paymentRepository) PostEntry(ctx context.Context, entry *model.JournalEntry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	return r.inTx(ctx, func(tx *paymentRepository) error {
+		query := `
+			INSERT INTO journal_entries (id, kind, payment_id, description, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`
+
+		if _, err := tx.db.Exec(ctx, query, entry.ID, entry.Kind, entry.PaymentID, entry.Description, entry.CreatedAt); err != nil {
+			return err
+		}
+
+		for _, p := range entry.Postings {
+			query = `
+				INSERT INTO postings (id, entry_id, ledger_account_id, direction, amount, currency, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+			`
+
+			_, err := tx.db.Exec(ctx, query, p.ID, entry.ID, p.LedgerAccountID, p.Direction, p.Amount.Value, p.Amount.Currency, entry.CreatedAt)
+			if err != nil {
+				return err
+			}
+
+			query = `
+				UPDATE ledger_accounts
+				SET balance = balance + $2, updated_at = $4
+				WHERE id = $1 AND currency = $3
+			`
+
+			tag, err := tx.db.Exec(ctx, query, p.LedgerAccountID, p.SignedAmount(), p.Amount.Currency, entry.CreatedAt)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				return fmt.Errorf("ledger account %s not found for currency %s", p.LedgerAccountID, p.Amount.Currency)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *paymentRepository) CheckLedgerInvariants(ctx context.Context) ([]model.LedgerDrift, error) {
+	checks := []struct {
+		name  string
+		query string
+	}{
+		{
+			// Cada lançamento soma zero em cada moeda
+			name: driftEntryBalanced,
+			query: `
+				SELECT entry_id::text, currency, 0,
+					SUM(CASE direction WHEN 'credit' THEN amount ELSE -amount END)::bigint
+				FROM postings
+				GROUP BY entry_id, currency
+				HAVING SUM(CASE direction WHEN 'credit' THEN amount ELSE -amount END) <> 0
+			`,
+		},
+		{
+			// O saldo mantido em cada conta do razão é a soma das suas pernas
+			name: driftAccountBalance,
+			query: `
+				SELECT la.id::text, la.currency,
+					COALESCE(SUM(CASE p.direction WHEN 'credit' THEN p.amount ELSE -p.amount END), 0)::bigint,
+					la.balance
+				FROM ledger_accounts la
+				LEFT JOIN postings p ON p.ledger_account_id = la.id
+				GROUP BY la.id, la.currency, la.balance
+				HAVING la.balance <> COALESCE(SUM(CASE p.direction WHEN 'credit' THEN p.amount ELSE -p.amount END), 0)
+			`,
+		},
+		{
+			// O saldo contábil de cada conta de portador bate com o razão
+			name: driftCardholderBalance,
+			query: `
+				SELECT a.id::text, a.currency, COALESCE(la.balance, 0), a.balance
+				FROM accounts a
+				LEFT JOIN ledger_accounts la
+					ON la.type = 'cardholder' AND la.owner_id = a.id::text AND la.currency = a.currency
+				WHERE a.balance <> COALESCE(la.balance, 0)
+			`,
+		},
+		{
+			// Partidas dobradas: todas as contas do razão somam zero
+			name: driftLedgerSumsToZero,
+			query: `
+				SELECT currency, currency, 0, SUM(balance)::bigint
+				FROM ledger_accounts
+				GROUP BY currency
+				HAVING SUM(balance) <> 0
+			`,
+		},
+		{
+			// O total capturado de cada pagamento é a soma das suas capturas
+			// concluídas
+			name: driftPaymentCaptured,
+			query: `
+				SELECT p.id::text, p.currency, COALESCE(SUM(c.amount), 0)::bigint, COALESCE(p.captured_amount, 0)
+				FROM payments p
+				LEFT JOIN payment_captures c ON c.payment_id = p.id AND c.status = 'succeeded'
+				GROUP BY p.id, p.currency, p.captured_amount
+				HAVING COALESCE(p.captured_amount, 0) <> COALESCE(SUM(c.amount), 0)
+			`,
+		},
+		{
+			// O total estornado de cada pagamento é a soma dos estornos concluídos
+			name: driftPaymentRefunded,
+			query: `
+				SELECT p.id::text, p.currency, COALESCE(SUM(r.amount), 0)::bigint, p.refunded_amount
+				FROM payments p
+				LEFT JOIN refunds r ON r.payment_id = p.id AND r.status = 'succeeded'
+				GROUP BY p.id, p.currency, p.refunded_amount
+				HAVING p.refunded_amount <> COALESCE(SUM(r.amount), 0)
+			`,
+		},
+	}
+
+	drifts := []model.LedgerDrift{}
+	for _, check := range checks {
+		rows, err := r.db.Query(ctx, check.query)
+		if err != nil {
+			return nil, fmt.Errorf("ledger check %s: %w", check.name, err)
+		}
+
+		found, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.LedgerDrift, error) {
+			drift := model.LedgerDrift{Check: check.name}
+			err := row.Scan(&drift.Subject, &drift.Currency, &drift.Expected, &drift.Actual)
+			return drift, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ledger check %s: %w", check.name, err)
+		}
+
+		drifts = append(drifts, found...)
+	}
+
+	return drifts, nil
+}
+
+func scanLedgerAccount(row pgx.Row) (*model.LedgerAccount, error) {
+	account := &model.LedgerAccount{}
+
+	err := row.Scan(
+		&account.ID,
+		&account.Type,
+		&account.OwnerID,
+		&account.Balance.Value,
+		&account.Balance.Currency,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// OutboxRepository gerencia as mensagens do transactional outbox. Enqueue
+// participa da transação do chamador; as demais operações são do relay
+type OutboxRepository interface {
+	// EnqueueOutbox grava a mensagem como pendente e preenche o ID
+	EnqueueOutbox(ctx context.Context, message *model.OutboxMessage) error
+	// ClaimOutbox reserva até limit mensagens pendentes prontas para envio
+	// até now+lease, em ordem de ID. Só a mensagem pendente mais antiga de
+	// cada chave é retornada, preservando a ordem por chave
+	ClaimOutbox(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.OutboxMessage, error)
+	// MarkOutboxSent marca a mensagem como publicada
+	MarkOutboxSent(ctx context.Context, id int64, sentAt time.Time) error
+	// MarkOutboxFailed registra a falha de publicação e agenda nova tentativa
+	MarkOutboxFailed(ctx context.Context, id int64, errorMsg string, nextAttemptAt time.Time) error
+	// MarkOutboxDead registra a última falha e tira a mensagem da fila; as
+	// seguintes da mesma chave deixam de esperar por ela
+	MarkOutboxDead(ctx context.Context, id int64, errorMsg string, deadAt time.Time) error
+	// GetOutboxBacklog conta as mensagens pendentes, a data da mais antiga e
+	// as mensagens mortas
+	GetOutboxBacklog(ctx context.Context) (*model.OutboxBacklog, error)
+	// DeleteSentOutbox apaga até limit mensagens publicadas antes de before
+	DeleteSentOutbox(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// NewOutboxRepository cria um repositório só com as operações do outbox, para
+// quem precisa enfileirar mensagens sem o restante do repositório de pagamentos
+func NewOutboxRepository(db *pgxpool.Pool) OutboxRepository {
+	return &paymentRepository{db: db, pool: db}
+}
+
+const outboxColumns = `
+	id, topic, message_key, payload, status, attempts, last_error,
+	next_attempt_at, created_at, sent_at
+`
+
+func (r *paymentRepository) EnqueueOutbox(ctx context.Context, message *model.OutboxMessage) error {
+	if message.Status == "" {
+		message.Status = model.OutboxStatusPending
+	}
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	if message.NextAttemptAt.IsZero() {
+		message.NextAttemptAt = message.CreatedAt
+	}
+
+	query := `
+		INSERT INTO outbox (topic, message_key, payload, status, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+
+	return r.db.QueryRow(ctx, query,
+		message.Topic,
+		message.Key,
+		message.Payload,
+		message.Status,
+		message.NextAttemptAt,
+		message.CreatedAt,
+	).Scan(&message.ID)
+}
+
+func (r *paymentRepository) ClaimOutbox(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.OutboxMessage, error) {
+	// A reserva por locked_until permite várias réplicas do relay: cada
+	// mensagem fica com uma só até o lease vencer. Uma mensagem com outra
+	// mais antiga da mesma chave ainda pendente espera a vez dela
+	query := `
+		UPDATE outbox
+		SET locked_until = $2
+		WHERE id IN (
+			SELECT o.id FROM outbox o
+			WHERE o.status = 'pending'
+			  AND o.next_attempt_at <= $1
+			  AND (o.locked_until IS NULL OR o.locked_until <= $1)
+			  AND NOT EXISTS (
+				SELECT 1 FROM outbox p
+				WHERE p.message_key = o.message_key AND p.status = 'pending' AND p.id < o.id
+			  )
+			ORDER BY o.id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns
+
+	rows, err := r.db.Query(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*model.OutboxMessage
+	for rows.Next() {
+		message, err := scanOutboxMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING não garante ordem
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+
+	return messages, nil
+}
+
+func (r *paymentRepository) MarkOutboxSent(ctx context.Context, id int64, sentAt time.Time) error {
+	query := `
+		UPDATE outbox
+		SET status = 'sent', sent_at = $2, attempts = attempts + 1, last_error = NULL, locked_until = NULL
+		WHERE id = $1
+	`
+
+	_, err := r.db.Exec(ctx, query, id, sentAt)
+	return err
+}
+
+func (r *paymentRepository) MarkOutboxFailed(ctx context.Context, id int64, errorMsg string, nextAttemptAt time.Time) error {
+	query := `
+		UPDATE outbox
+		SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3, locked_until = NULL
+		WHERE id = $1 AND status = 'pending'
+	`
+
+	_, err := r.db.Exec(ctx, query, id, errorMsg, nextAttemptAt)
+	return err
+}
+
+func (r *paymentRepository) MarkOutboxDead(ctx context.Context, id int64, errorMsg string, deadAt time.Time) error {
+	query := `
+		UPDATE outbox
+		SET status = 'dead', attempts = attempts + 1, last_error = $2, dead_at = $3, locked_until = NULL
+		WHERE id = $1 AND status = 'pending'
+	`
+
+	_, err := r.db.Exec(ctx, query, id, errorMsg, deadAt)
+	return err
+}
+
+func (r *paymentRepository) GetOutboxBacklog(ctx context.Context) (*model.OutboxBacklog, error) {
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			MIN(created_at) FILTER (WHERE status = 'pending'),
+			COUNT(*) FILTER (WHERE status = 'dead')
+		FROM outbox
+		WHERE status IN ('pending', 'dead')
+	`
+
+	backlog := &model.OutboxBacklog{}
+	if err := r.db.QueryRow(ctx, query).Scan(&backlog.Pending, &backlog.OldestCreatedAt, &backlog.Dead); err != nil {
+		return nil, err
+	}
+
+	return backlog, nil
+}
+
+func (r *paymentRepository) DeleteSentOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	query := `
+		DELETE FROM outbox
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE status = 'sent' AND sent_at < $1
+			LIMIT $2
+		)
+	`
+
+	tag, err := r.db.Exec(ctx, query, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func scanOutboxMessage(row pgx.Row) (*model.OutboxMessage, error) {
+	message := &model.OutboxMessage{}
+
+	err := row.Scan(
+		&message.ID,
+		&message.Topic,
+		&message.Key,
+		&message.Payload,
+		&message.Status,
+		&message.Attempts,
+		&message.LastError,
+		&message.NextAttemptAt,
+		&message.CreatedAt,
+		&message.SentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// PaymentEventRepository guarda o histórico de ações sobre os pagamentos
+type PaymentEventRepository interface {
+	// RecordPaymentEvent grava o evento e preenche ID e CreatedAt
+	RecordPaymentEvent(ctx context.Context, event *model.PaymentEvent) error
+	// GetPaymentEvents retorna o histórico do pagamento, do mais antigo ao mais recente
+	GetPaymentEvents(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentEvent, error)
+}
+
+func (r *paymentRepository) RecordPaymentEvent(ctx context.Context, event *model.PaymentEvent) error {
+	query := `
+		INSERT INTO payment_events (payment_id, event_type, from_status, to_status, actor, reason)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		RETURNING id, created_at
+	`
+
+	return r.db.QueryRow(ctx, query,
+		event.PaymentID,
+		event.Type,
+		event.FromStatus,
+		event.ToStatus,
+		event.Actor,
+		event.Reason,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (r *paymentRepository) GetPaymentEvents(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentEvent, error) {
+	query := `
+		SELECT id, payment_id, event_type, from_status, to_status, actor, COALESCE(reason, ''), created_at
+		FROM payment_events
+		WHERE payment_id = $1
+		ORDER BY id
+	`
+
+	rows, err := r.db.Query(ctx, query, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*model.PaymentEvent{}
+	for rows.Next() {
+		event := &model.PaymentEvent{}
+		if err := rows.Scan(
+			&event.ID,
+			&event.PaymentID,
+			&event.Type,
+			&event.FromStatus,
+			&event.ToStatus,
+			&event.Actor,
+			&event.Reason,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
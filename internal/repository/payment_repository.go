@@ -2,9 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"golang-payment-microservice/internal/kms"
 	"golang-payment-microservice/internal/model"
 
 	"github.com/google/uuid"
@@ -15,177 +17,446 @@ import (
 type PaymentRepository interface {
 	Create(ctx context.Context, payment *model.Payment) error
 	GetByID(ctx context.Context, id uuid.UUID) (*model.Payment, error)
-	UpdateStatus(ctx context.Context, id uuid.UUID, status model.PaymentStatus, errorMsg *string) error
+	// TransitionStatus muda o status de from para to somente se o pagamento
+	// ainda estiver em from; caso contrário retorna um *model.TransitionError
+	TransitionStatus(ctx context.Context, id uuid.UUID, from, to model.PaymentStatus, errorMsg *string) error
+	// RecordProcessingAttempt soma uma tentativa ao pagamento em processing e
+	// retorna o total de tentativas
+	RecordProcessingAttempt(ctx context.Context, id uuid.UUID) (int, error)
+	// RecordAcquirerResponse grava no pagamento a resposta do adquirente
+	RecordAcquirerResponse(ctx context.Context, id uuid.UUID, acquirer string, response *model.AcquirerResponse) error
 	GetByMerchantID(ctx context.Context, merchantID string, limit, offset int) ([]*model.Payment, error)
 	GetAccountByCardNumber(ctx context.Context, cardNumber string) (*model.Account, error)
-	UpdateAccountBalance(ctx context.Context, cardNumber string, newBalance float64) error
+	GetAccountByID(ctx context.Context, accountID uuid.UUID) (*model.Account, error)
+	// DebitAccount subtrai o valor do saldo de forma atômica, somente se a
+	// conta estiver ativa, na mesma moeda e com saldo suficiente. Retorna o
+	// novo saldo
+	DebitAccount(ctx context.Context, accountID uuid.UUID, amount model.Money) (model.Money, error)
+	// CreditAccount soma o valor ao saldo contábil e ao disponível, na mesma
+	// moeda da conta, mesmo que ela esteja inativa. Retorna o novo saldo
+	CreditAccount(ctx context.Context, accountID uuid.UUID, amount model.Money) (model.Money, error)
+	// WithTx executa fn dentro de uma única transação. O repositório recebido
+	// por fn opera sobre a transação, que é confirmada se fn retornar nil e
+	// desfeita caso contrário
+	WithTx(ctx context.Context, fn func(tx PaymentRepository) error) error
+
+	HoldRepository
+	LedgerRepository
+	OutboxRepository
+	ProcessedMessageRepository
+	PaymentEventRepository
+	ReaperRepository
+	RefundRepository
+	AuthorizationRepository
+	CaptureRepository
+	InstallmentRulesRepository
 }
 
+var (
+	ErrAccountNotFound   = errors.New("account not found")
+	ErrAccountInactive   = errors.New("account is inactive")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrPaymentNotFound   = errors.New("payment not found")
+)
+
 type paymentRepository struct {
-	db *pgxpool.Pool
+	db   querier
+	pool *pgxpool.Pool
+	keys kms.KeyManager
+}
+
+func NewPaymentRepository(db *pgxpool.Pool, keys kms.KeyManager) PaymentRepository {
+	return &paymentRepository{db: db, pool: db, keys: keys}
 }
 
-func NewPaymentRepository(db *pgxpool.Pool) PaymentRepository {
-	return &paymentRepository{db: db}
+func (r *paymentRepository) WithTx(ctx context.Context, fn func(tx PaymentRepository) error) error {
+	return r.inTx(ctx, func(tx *paymentRepository) error {
+		return fn(tx)
+	})
 }
 
+// inTx é o WithTx interno, que dá acesso ao querier da transação
+func (r *paymentRepository) inTx(ctx context.Context, fn func(tx *paymentRepository) error) error {
+	// Já dentro de uma transação: reutilizá-la
+	if r.pool == nil {
+		return fn(r)
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(&paymentRepository{db: tx, keys: r.keys}); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// paymentColumns lista as colunas lidas por scanPayment, na mesma ordem
+const paymentColumns = `
+	id, COALESCE(card_token, ''), card_fingerprint, card_bin, card_last4, COALESCE(card_brand, ''), card_holder,
+	card_holder_ciphertext, card_holder_key_id, expiry_month, expiry_year,
+	amount, currency, merchant_id, status, created_at, updated_at,
+	processed_at, processing_started_at, completed_at, failed_at, cancelled_at, error_msg, attempts, requeues,
+	heartbeat_at,
+	acquirer, response_code, approval_code, network_reference,
+	capture_method, captured_amount, authorized_at, authorization_expires_at, voided_at,
+	authorized_amount, refunded_amount, installments, installment_plan
+`
+
 func (r *paymentRepository) Create(ctx context.Context, payment *model.Payment) error {
+	cardHolder, err := encryptString(ctx, r.keys, payment.CardHolder, payment.ID.String())
+	if err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO payments (
-			id, card_number, card_holder, expiry_month, expiry_year, 
-			cvv, amount, currency, merchant_id, status, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			id, card_token, card_bin, card_last4, card_brand,
+			card_holder_ciphertext, card_holder_key_id, expiry_month, expiry_year,
+			amount, currency, merchant_id, status, created_at, updated_at, capture_method,
+			authorized_amount, installments, installment_plan, heartbeat_at, card_fingerprint
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $10, $17, $18, $14, $19)
 	`
-	
-	_, err := r.db.Exec(ctx, query,
+
+	_, err = r.db.Exec(ctx, query,
 		payment.ID,
-		payment.CardNumber,
-		payment.CardHolder,
+		payment.CardToken,
+		payment.CardBIN,
+		payment.CardLast4,
+		payment.CardBrand,
+		cardHolder.Data,
+		cardHolder.KeyID,
 		payment.ExpiryMonth,
 		payment.ExpiryYear,
-		payment.CVV,
-		payment.Amount,
-		payment.Currency,
+		payment.Amount.Value,
+		payment.Amount.Currency,
 		payment.MerchantID,
 		payment.Status,
 		payment.CreatedAt,
 		payment.UpdatedAt,
+		payment.CaptureMethod,
+		payment.Installments,
+		payment.InstallmentPlan,
+		payment.CardFingerprint,
 	)
-	
+
 	return err
 }
 
 func (r *paymentRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.Payment, error) {
-	query := `
-		SELECT id, card_number, card_holder, expiry_month, expiry_year,
-			   cvv, amount, currency, merchant_id, status, created_at, 
-			   updated_at, processed_at, error_msg
-		FROM payments 
-		WHERE id = $1
-	`
-	
-	payment := &model.Payment{}
-	row := r.db.QueryRow(ctx, query, id)
-	
-	err := row.Scan(
-		&payment.ID,
-		&payment.CardNumber,
-		&payment.CardHolder,
-		&payment.ExpiryMonth,
-		&payment.ExpiryYear,
-		&payment.CVV,
-		&payment.Amount,
-		&payment.Currency,
-		&payment.MerchantID,
-		&payment.Status,
-		&payment.CreatedAt,
-		&payment.UpdatedAt,
-		&payment.ProcessedAt,
-		&payment.ErrorMsg,
-	)
-	
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE id = $1`
+
+	payment, err := r.scanPayment(ctx, r.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("payment not found")
+			return nil, ErrPaymentNotFound
 		}
 		return nil, err
 	}
-	
+
 	return payment, nil
 }
 
-func (r *paymentRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status model.PaymentStatus, errorMsg *string) error {
+func (r *paymentRepository) TransitionStatus(ctx context.Context, id uuid.UUID, from, to model.PaymentStatus, errorMsg *string) error {
+	if err := model.ValidateTransition(from, to); err != nil {
+		return err
+	}
+
+	// Cada fase grava o próprio timestamp; processed_at só é preenchido
+	// quando o pagamento chega a um estado final
 	query := `
-		UPDATE payments 
-		SET status = $2, updated_at = $3, processed_at = $4, error_msg = $5
+		UPDATE payments
+		SET status = $3,
+			updated_at = $4,
+			error_msg = COALESCE($5, error_msg),
+			processing_started_at = CASE WHEN $3 = 'processing' THEN $4 ELSE processing_started_at END,
+			heartbeat_at = CASE WHEN $3 = 'processing' THEN $4 ELSE heartbeat_at END,
+			authorized_at = CASE WHEN $3 = 'authorized' THEN $4 ELSE authorized_at END,
+			completed_at = CASE WHEN $3 = 'completed' THEN $4 ELSE completed_at END,
+			failed_at = CASE WHEN $3 = 'failed' THEN $4 ELSE failed_at END,
+			cancelled_at = CASE WHEN $3 = 'cancelled' THEN $4 ELSE cancelled_at END,
+			voided_at = CASE WHEN $3 = 'voided' THEN $4 ELSE voided_at END,
+			processed_at = CASE WHEN $6 THEN $4 ELSE processed_at END
+		WHERE id = $1 AND status = $2
+	`
+
+	tag, err := r.db.Exec(ctx, query, id, from, to, time.Now(), errorMsg, to.IsTerminal())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 1 {
+		return nil
+	}
+
+	// Nenhuma linha alterada: o pagamento não existe ou já mudou de status
+	var current model.PaymentStatus
+	err = r.db.QueryRow(ctx, `SELECT status FROM payments WHERE id = $1`, id).Scan(&current)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrPaymentNotFound
+		}
+		return err
+	}
+
+	return &model.TransitionError{PaymentID: id.String(), From: from, To: to, Current: current}
+}
+
+func (r *paymentRepository) RecordProcessingAttempt(ctx context.Context, id uuid.UUID) (int, error) {
+	query := `
+		UPDATE payments
+		SET attempts = attempts + 1, updated_at = $2, heartbeat_at = $2
+		WHERE id = $1 AND status = 'processing'
+		RETURNING attempts
+	`
+
+	var attempts int
+	err := r.db.QueryRow(ctx, query, id, time.Now()).Scan(&attempts)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("payment %s is not processing", id)
+		}
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+func (r *paymentRepository) RecordAcquirerResponse(ctx context.Context, id uuid.UUID, acquirer string, response *model.AcquirerResponse) error {
+	query := `
+		UPDATE payments
+		SET acquirer = $2, response_code = $3, approval_code = NULLIF($4, ''),
+		    network_reference = NULLIF($5, ''), updated_at = $6
 		WHERE id = $1
 	`
-	
-	now := time.Now()
-	_, err := r.db.Exec(ctx, query, id, status, now, now, errorMsg)
-	return err
+
+	tag, err := r.db.Exec(ctx, query, id, acquirer, response.ResponseCode,
+		response.ApprovalCode, response.NetworkReference, time.Now())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPaymentNotFound
+	}
+	return nil
 }
 
 func (r *paymentRepository) GetByMerchantID(ctx context.Context, merchantID string, limit, offset int) ([]*model.Payment, error) {
 	query := `
-		SELECT id, card_number, card_holder, expiry_month, expiry_year,
-			   cvv, amount, currency, merchant_id, status, created_at, 
-			   updated_at, processed_at, error_msg
-		FROM payments 
+		SELECT ` + paymentColumns + `
+		FROM payments
 		WHERE merchant_id = $1
 		ORDER BY created_at DESC
 		LIMIT $2 OFFSET $3
 	`
-	
+
 	rows, err := r.db.Query(ctx, query, merchantID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
+
 	var payments []*model.Payment
 	for rows.Next() {
-		payment := &model.Payment{}
-		err := rows.Scan(
-			&payment.ID,
-			&payment.CardNumber,
-			&payment.CardHolder,
-			&payment.ExpiryMonth,
-			&payment.ExpiryYear,
-			&payment.CVV,
-			&payment.Amount,
-			&payment.Currency,
-			&payment.MerchantID,
-			&payment.Status,
-			&payment.CreatedAt,
-			&payment.UpdatedAt,
-			&payment.ProcessedAt,
-			&payment.ErrorMsg,
-		)
+		payment, err := r.scanPayment(ctx, rows)
 		if err != nil {
 			return nil, err
 		}
 		payments = append(payments, payment)
 	}
-	
+
 	return payments, rows.Err()
 }
 
+// accountColumns lista as colunas lidas por scanAccount, na mesma ordem
+const accountColumns = `
+	id, card_number, card_number_ciphertext, card_number_key_id, balance,
+	available_balance, currency, is_active, created_at, updated_at
+`
+
+// GetAccountByCardNumber busca a conta pelo blind index do PAN. Contas ainda
+// não migradas pelo job de recifragem são encontradas pela coluna legada
 func (r *paymentRepository) GetAccountByCardNumber(ctx context.Context, cardNumber string) (*model.Account, error) {
 	query := `
-		SELECT card_number, balance, is_active, created_at, updated_at
-		FROM accounts 
-		WHERE card_number = $1
+		SELECT ` + accountColumns + `
+		FROM accounts
+		WHERE card_number_hash = $1
+		   OR (card_number_hash IS NULL AND card_number = $2)
 	`
-	
+
+	return r.scanAccount(ctx, r.db.QueryRow(ctx, query, r.keys.BlindIndex(cardNumber), cardNumber))
+}
+
+func (r *paymentRepository) GetAccountByID(ctx context.Context, accountID uuid.UUID) (*model.Account, error) {
+	query := `SELECT ` + accountColumns + ` FROM accounts WHERE id = $1`
+
+	return r.scanAccount(ctx, r.db.QueryRow(ctx, query, accountID))
+}
+
+func (r *paymentRepository) DebitAccount(ctx context.Context, accountID uuid.UUID, amount model.Money) (model.Money, error) {
+	// Débito direto, sem reserva: reduz o saldo contábil e o disponível. O
+	// UPDATE condicional trava a linha e reavalia o saldo, então débitos
+	// concorrentes no mesmo cartão não perdem atualizações
+	query := `
+		UPDATE accounts
+		SET balance = balance - $2, available_balance = available_balance - $2, updated_at = $4
+		WHERE id = $1 AND currency = $3 AND is_active AND available_balance >= $2
+		RETURNING balance
+	`
+
+	balance := model.Money{Currency: amount.Currency}
+	err := r.db.QueryRow(ctx, query, accountID, amount.Value, amount.Currency, time.Now()).Scan(&balance.Value)
+	if err == nil {
+		return balance, nil
+	}
+	if err != pgx.ErrNoRows {
+		return model.Money{}, err
+	}
+
+	return model.Money{}, r.debitError(ctx, accountID, amount)
+}
+
+func (r *paymentRepository) CreditAccount(ctx context.Context, accountID uuid.UUID, amount model.Money) (model.Money, error) {
+	// Crédito de um estorno: o valor volta ao saldo contábil e ao disponível.
+	// Uma conta inativa também recebe, já que o dinheiro é do portador
+	query := `
+		UPDATE accounts
+		SET balance = balance + $2, available_balance = available_balance + $2, updated_at = $4
+		WHERE id = $1 AND currency = $3
+		RETURNING balance
+	`
+
+	balance := model.Money{Currency: amount.Currency}
+	err := r.db.QueryRow(ctx, query, accountID, amount.Value, amount.Currency, time.Now()).Scan(&balance.Value)
+	if err == nil {
+		return balance, nil
+	}
+	if err != pgx.ErrNoRows {
+		return model.Money{}, err
+	}
+
+	return model.Money{}, r.debitError(ctx, accountID, amount)
+}
+
+// debitError descobre qual condição impediu um débito, reserva ou crédito na conta
+func (r *paymentRepository) debitError(ctx context.Context, accountID uuid.UUID, amount model.Money) error {
+	var currency model.Currency
+	var active bool
+	err := r.db.QueryRow(ctx, `SELECT currency, is_active FROM accounts WHERE id = $1`, accountID).Scan(&currency, &active)
+	switch {
+	case err == pgx.ErrNoRows:
+		return ErrAccountNotFound
+	case err != nil:
+		return err
+	case currency != amount.Currency:
+		return fmt.Errorf("%w: %s and %s", model.ErrCurrencyMismatch, currency, amount.Currency)
+	case !active:
+		return ErrAccountInactive
+	default:
+		return ErrInsufficientFunds
+	}
+}
+
+func (r *paymentRepository) scanAccount(ctx context.Context, row pgx.Row) (*model.Account, error) {
 	account := &model.Account{}
-	row := r.db.QueryRow(ctx, query, cardNumber)
-	
+	var legacyNumber, keyID *string
+	var ciphertext []byte
+	var currency model.Currency
+
 	err := row.Scan(
-		&account.CardNumber,
-		&account.Balance,
+		&account.ID,
+		&legacyNumber,
+		&ciphertext,
+		&keyID,
+		&account.LedgerBalance.Value,
+		&account.AvailableBalance.Value,
+		&currency,
 		&account.IsActive,
 		&account.CreatedAt,
 		&account.UpdatedAt,
 	)
-	
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("account not found")
+			return nil, ErrAccountNotFound
 		}
 		return nil, err
 	}
-	
+
+	account.LedgerBalance.Currency = currency
+	account.AvailableBalance.Currency = currency
+
+	account.CardNumber, err = decryptString(ctx, r.keys, ciphertext, keyID, legacyNumber, account.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
 	return account, nil
 }
 
-func (r *paymentRepository) UpdateAccountBalance(ctx context.Context, cardNumber string, newBalance float64) error {
-	query := `
-		UPDATE accounts 
-		SET balance = $2, updated_at = $3
-		WHERE card_number = $1
-	`
-	
-	_, err := r.db.Exec(ctx, query, cardNumber, newBalance, time.Now())
-	return err
-} 
\ No newline at end of file
+func (r *paymentRepository) scanPayment(ctx context.Context, row pgx.Row) (*model.Payment, error) {
+	payment := &model.Payment{}
+	var legacyHolder, holderKeyID *string
+	var holderCiphertext []byte
+	var capturedAmount *int64
+
+	err := row.Scan(
+		&payment.ID,
+		&payment.CardToken,
+		&payment.CardFingerprint,
+		&payment.CardBIN,
+		&payment.CardLast4,
+		&payment.CardBrand,
+		&legacyHolder,
+		&holderCiphertext,
+		&holderKeyID,
+		&payment.ExpiryMonth,
+		&payment.ExpiryYear,
+		&payment.Amount.Value,
+		&payment.Amount.Currency,
+		&payment.MerchantID,
+		&payment.Status,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+		&payment.ProcessedAt,
+		&payment.ProcessingStartedAt,
+		&payment.CompletedAt,
+		&payment.FailedAt,
+		&payment.CancelledAt,
+		&payment.ErrorMsg,
+		&payment.Attempts,
+		&payment.Requeues,
+		&payment.HeartbeatAt,
+		&payment.Acquirer,
+		&payment.ResponseCode,
+		&payment.ApprovalCode,
+		&payment.NetworkReference,
+		&payment.CaptureMethod,
+		&capturedAmount,
+		&payment.AuthorizedAt,
+		&payment.AuthorizationExpiresAt,
+		&payment.VoidedAt,
+		&payment.AuthorizedAmount.Value,
+		&payment.RefundedAmount.Value,
+		&payment.Installments,
+		&payment.InstallmentPlan,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	payment.AuthorizedAmount.Currency = payment.Amount.Currency
+	payment.RefundedAmount.Currency = payment.Amount.Currency
+	if capturedAmount != nil {
+		payment.CapturedAmount = &model.Money{Value: *capturedAmount, Currency: payment.Amount.Currency}
+	}
+
+	payment.CardHolder, err = decryptString(ctx, r.keys, holderCiphertext, holderKeyID, legacyHolder, payment.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
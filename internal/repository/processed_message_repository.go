@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrMessageAlreadyProcessed = errors.New("message already processed")
+
+// ProcessedMessageRepository guarda as mensagens do Kafka já aplicadas
+type ProcessedMessageRepository interface {
+	// RecordProcessedMessage grava a mensagem como aplicada. Retorna
+	// ErrMessageAlreadyProcessed se ela já estava gravada
+	RecordProcessedMessage(ctx context.Context, message *model.ProcessedMessage) error
+	// IsMessageProcessed indica se a mensagem já foi aplicada ao pagamento
+	IsMessageProcessed(ctx context.Context, paymentID uuid.UUID, delivery model.MessageDelivery) (bool, error)
+}
+
+func (r *paymentRepository) RecordProcessedMessage(ctx context.Context, message *model.ProcessedMessage) error {
+	query := `
+		INSERT INTO processed_messages (payment_id, topic, kafka_partition, kafka_offset, outcome, processed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING
+		RETURNING payment_id
+	`
+
+	var paymentID uuid.UUID
+	err := r.db.QueryRow(ctx, query,
+		message.PaymentID,
+		message.Delivery.Topic,
+		message.Delivery.Partition,
+		message.Delivery.Offset,
+		message.Outcome,
+		message.ProcessedAt,
+	).Scan(&paymentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrMessageAlreadyProcessed
+	}
+
+	return err
+}
+
+func (r *paymentRepository) IsMessageProcessed(ctx context.Context, paymentID uuid.UUID, delivery model.MessageDelivery) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM processed_messages
+			WHERE payment_id = $1 AND topic = $2 AND kafka_partition = $3 AND kafka_offset = $4
+		)
+	`
+
+	var processed bool
+	err := r.db.QueryRow(ctx, query, paymentID, delivery.Topic, delivery.Partition, delivery.Offset).Scan(&processed)
+	return processed, err
+}
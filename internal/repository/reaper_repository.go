@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ReaperRepository encontra e marca os pagamentos parados em pending ou processing
+type ReaperRepository interface {
+	// ClaimStuckPayments trava até limit pagamentos em status sem heartbeat
+	// desde before, os mais antigos primeiro. Linhas já travadas por outra
+	// réplica são puladas; deve ser chamado dentro de WithTx, que mantém a
+	// trava até o fim da transação
+	ClaimStuckPayments(ctx context.Context, status model.PaymentStatus, before time.Time, limit int) ([]*model.Payment, error)
+	// RecordRequeue soma um reenvio ao pagamento e renova o heartbeat, para
+	// que ele só volte a ser considerado parado depois de um novo intervalo.
+	// Retorna o total de reenvios
+	RecordRequeue(ctx context.Context, id uuid.UUID) (int, error)
+}
+
+func (r *paymentRepository) ClaimStuckPayments(ctx context.Context, status model.PaymentStatus, before time.Time, limit int) ([]*model.Payment, error) {
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE status = $1 AND heartbeat_at < $2
+		ORDER BY heartbeat_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := r.db.Query(ctx, query, status, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []*model.Payment
+	for rows.Next() {
+		payment, err := r.scanPayment(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
+func (r *paymentRepository) RecordRequeue(ctx context.Context, id uuid.UUID) (int, error) {
+	query := `
+		UPDATE payments
+		SET requeues = requeues + 1, updated_at = $2, heartbeat_at = $2
+		WHERE id = $1
+		RETURNING requeues
+	`
+
+	var requeues int
+	err := r.db.QueryRow(ctx, query, id, time.Now()).Scan(&requeues)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrPaymentNotFound
+		}
+		return 0, err
+	}
+
+	return requeues, nil
+}
@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrRefundNotFound       = errors.New("refund not found")
+	ErrRefundNotPending     = errors.New("refund is not pending")
+	ErrPaymentNotRefundable = errors.New("payment is not refundable")
+	ErrRefundExceedsPayment = errors.New("refund exceeds refundable amount")
+)
+
+// RefundRepository gerencia os estornos dos pagamentos
+type RefundRepository interface {
+	// CreateRefund grava o estorno pendente com o pagamento travado, para que
+	// estornos concorrentes não passem juntos do valor capturado. Um estorno
+	// sem valor recebe o saldo ainda não estornado. Retorna
+	// ErrPaymentNotRefundable se nada foi capturado e ErrRefundExceedsPayment
+	// se o valor passar do estornável
+	CreateRefund(ctx context.Context, refund *model.Refund) error
+	GetRefund(ctx context.Context, id uuid.UUID) (*model.Refund, error)
+	// GetRefundsByPayment retorna os estornos do pagamento, do mais antigo ao
+	// mais recente
+	GetRefundsByPayment(ctx context.Context, paymentID uuid.UUID) ([]*model.Refund, error)
+	// RecordRefundAttempt soma uma tentativa ao estorno pendente e retorna o
+	// total de tentativas
+	RecordRefundAttempt(ctx context.Context, id uuid.UUID) (int, error)
+	// RecordRefundAcquirerResponse grava no estorno a resposta do adquirente
+	RecordRefundAcquirerResponse(ctx context.Context, id uuid.UUID, acquirer string, response *model.AcquirerResponse) error
+	// TransitionRefundStatus leva o estorno de pending a um estado final
+	// somente se ele ainda estiver pendente; caso contrário retorna
+	// ErrRefundNotPending. Um estorno concluído é somado ao refunded_amount
+	// do pagamento
+	TransitionRefundStatus(ctx context.Context, id uuid.UUID, to model.RefundStatus, errorMsg *string) error
+}
+
+// refundColumns lista as colunas lidas por scanRefund, na mesma ordem
+const refundColumns = `
+	id, payment_id, amount, currency, status, COALESCE(reason, ''), error_msg, attempts,
+	acquirer, response_code, network_reference, created_at, updated_at, processed_at
+`
+
+func (r *paymentRepository) CreateRefund(ctx context.Context, refund *model.Refund) error {
+	return r.inTx(ctx, func(tx *paymentRepository) error {
+		// O limite é o capturado, não o status: um pagamento ainda em
+		// authorized já pode estornar as capturas parciais
+		var status model.PaymentStatus
+		captured := model.Money{}
+		err := tx.db.QueryRow(ctx,
+			`SELECT status, COALESCE(captured_amount, 0), currency FROM payments WHERE id = $1 FOR UPDATE`,
+			refund.PaymentID,
+		).Scan(&status, &captured.Value, &captured.Currency)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrPaymentNotFound
+			}
+			return err
+		}
+		if !captured.IsPositive() {
+			return fmt.Errorf("%w: payment is %s with nothing captured", ErrPaymentNotRefundable, status)
+		}
+
+		// Estornos pendentes já comprometem o valor, mesmo sem processados, e
+		// os aprovados no adquirente sem crédito local também
+		refunded := model.Money{Currency: captured.Currency}
+		err = tx.db.QueryRow(ctx, `
+			SELECT COALESCE(SUM(amount), 0)
+			FROM refunds
+			WHERE payment_id = $1 AND status IN ('pending', 'succeeded', 'needs_reconciliation')
+		`, refund.PaymentID).Scan(&refunded.Value)
+		if err != nil {
+			return err
+		}
+
+		refundable, err := captured.Sub(refunded)
+		if err != nil {
+			return err
+		}
+
+		if refund.Amount.IsZero() {
+			refund.Amount = refundable
+		}
+		cmp, err := refund.Amount.Cmp(refundable)
+		if err != nil {
+			return err
+		}
+		if cmp > 0 || !refund.Amount.IsPositive() {
+			return fmt.Errorf("%w: %s refundable", ErrRefundExceedsPayment, refundable)
+		}
+
+		query := `
+			INSERT INTO refunds (id, payment_id, amount, currency, status, reason, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+		`
+
+		_, err = tx.db.Exec(ctx, query,
+			refund.ID,
+			refund.PaymentID,
+			refund.Amount.Value,
+			refund.Amount.Currency,
+			refund.Status,
+			refund.Reason,
+			refund.CreatedAt,
+			refund.UpdatedAt,
+		)
+		return err
+	})
+}
+
+func (r *paymentRepository) GetRefund(ctx context.Context, id uuid.UUID) (*model.Refund, error) {
+	query := `SELECT ` + refundColumns + ` FROM refunds WHERE id = $1`
+
+	refund, err := scanRefund(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrRefundNotFound
+		}
+		return nil, err
+	}
+
+	return refund, nil
+}
+
+func (r *paymentRepository) GetRefundsByPayment(ctx context.Context, paymentID uuid.UUID) ([]*model.Refund, error) {
+	query := `
+		SELECT ` + refundColumns + `
+		FROM refunds
+		WHERE payment_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.Query(ctx, query, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []*model.Refund{}
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+func (r *paymentRepository) RecordRefundAttempt(ctx context.Context, id uuid.UUID) (int, error) {
+	query := `
+		UPDATE refunds
+		SET attempts = attempts + 1, updated_at = $2
+		WHERE id = $1 AND status = 'pending'
+		RETURNING attempts
+	`
+
+	var attempts int
+	err := r.db.QueryRow(ctx, query, id, time.Now()).Scan(&attempts)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("refund %s is not pending", id)
+		}
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+func (r *paymentRepository) RecordRefundAcquirerResponse(ctx context.Context, id uuid.UUID, acquirer string, response *model.AcquirerResponse) error {
+	query := `
+		UPDATE refunds
+		SET acquirer = $2, response_code = $3, network_reference = NULLIF($4, ''), updated_at = $5
+		WHERE id = $1
+	`
+
+	tag, err := r.db.Exec(ctx, query, id, acquirer, response.ResponseCode, response.NetworkReference, time.Now())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRefundNotFound
+	}
+	return nil
+}
+
+func (r *paymentRepository) TransitionRefundStatus(ctx context.Context, id uuid.UUID, to model.RefundStatus, errorMsg *string) error {
+	if !to.IsTerminal() {
+		return fmt.Errorf("invalid refund transition to %s", to)
+	}
+
+	return r.inTx(ctx, func(tx *paymentRepository) error {
+		query := `
+			UPDATE refunds
+			SET status = $2, error_msg = COALESCE($3, error_msg), updated_at = $4, processed_at = $4
+			WHERE id = $1 AND status = 'pending'
+			RETURNING payment_id, amount
+		`
+
+		var paymentID uuid.UUID
+		var amount int64
+		err := tx.db.QueryRow(ctx, query, id, to, errorMsg, time.Now()).Scan(&paymentID, &amount)
+		if err == pgx.ErrNoRows {
+			return tx.refundNotPendingError(ctx, id)
+		}
+		if err != nil || to != model.RefundStatusSucceeded {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, `
+			UPDATE payments
+			SET refunded_amount = refunded_amount + $2, updated_at = $3
+			WHERE id = $1
+		`, paymentID, amount, time.Now())
+		return err
+	})
+}
+
+// refundNotPendingError diferencia o estorno inexistente do já encerrado
+func (r *paymentRepository) refundNotPendingError(ctx context.Context, id uuid.UUID) error {
+	var exists bool
+	if err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM refunds WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRefundNotFound
+	}
+	return ErrRefundNotPending
+}
+
+func scanRefund(row pgx.Row) (*model.Refund, error) {
+	refund := &model.Refund{}
+	err := row.Scan(
+		&refund.ID,
+		&refund.PaymentID,
+		&refund.Amount.Value,
+		&refund.Amount.Currency,
+		&refund.Status,
+		&refund.Reason,
+		&refund.ErrorMsg,
+		&refund.Attempts,
+		&refund.Acquirer,
+		&refund.ResponseCode,
+		&refund.NetworkReference,
+		&refund.CreatedAt,
+		&refund.UpdatedAt,
+		&refund.ProcessedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
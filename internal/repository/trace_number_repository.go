@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+
+	"golang-payment-microservice/internal/gateway"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type traceNumberRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewTraceNumberRepository retorna os STANs do gateway ISO 8583 a partir da
+// sequência do banco, compartilhada entre as réplicas
+func NewTraceNumberRepository(db *pgxpool.Pool) gateway.TraceNumberSource {
+	return &traceNumberRepository{db: db}
+}
+
+func (r *traceNumberRepository) NextTraceNumber(ctx context.Context) (int64, error) {
+	var stan int64
+	err := r.db.QueryRow(ctx, `SELECT nextval('iso8583_trace_numbers')`).Scan(&stan)
+	return stan, err
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuthorizationExpirer é o job em background que cancela as autorizações de
+// captura manual não capturadas dentro do prazo, liberando a reserva de saldo.
+// Um pagamento já capturado em parte é encerrado com o valor capturado
+type AuthorizationExpirer struct {
+	repo      repository.PaymentRepository
+	acquirer  gateway.AcquirerGateway
+	interval  time.Duration
+	batchSize int
+	logger    *logrus.Logger
+	onExpired func(payment *model.Payment)
+}
+
+func NewAuthorizationExpirer(repo repository.PaymentRepository, acquirer gateway.AcquirerGateway, interval time.Duration, batchSize int, logger *logrus.Logger) *AuthorizationExpirer {
+	return &AuthorizationExpirer{
+		repo:      repo,
+		acquirer:  acquirer,
+		interval:  interval,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+// OnExpired registra um callback chamado para cada autorização expirada (métricas)
+func (e *AuthorizationExpirer) OnExpired(fn func(payment *model.Payment)) {
+	e.onExpired = fn
+}
+
+// Start executa o job até o contexto ser cancelado
+func (e *AuthorizationExpirer) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			e.logger.WithError(err).Error("Authorization expiry run failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce cancela um lote de autorizações vencidas e retorna quantas cancelou
+func (e *AuthorizationExpirer) RunOnce(ctx context.Context) (int, error) {
+	payments, err := e.repo.GetExpiredAuthorizations(ctx, time.Now(), e.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, payment := range payments {
+		if ctx.Err() != nil {
+			return expired, ctx.Err()
+		}
+
+		remaining, err := releaseAuthorization(ctx, e.repo, payment, model.HoldStatusExpired, model.ActorAuthorizationExpirer, "Authorization expired before capture")
+		if err != nil {
+			// O pagamento foi capturado ou cancelado entre a consulta e a
+			// expiração, ou tem uma captura em andamento e fica para a próxima
+			var transitionErr *model.TransitionError
+			if errors.As(err, &transitionErr) || errors.Is(err, repository.ErrCaptureInProgress) {
+				continue
+			}
+			return expired, err
+		}
+
+		if remaining.IsPositive() {
+			voidAuthorization(ctx, e.acquirer, e.logger, payment, remaining, networkReference(payment))
+		}
+
+		expired++
+		if e.onExpired != nil {
+			e.onExpired(payment)
+		}
+	}
+
+	if expired > 0 {
+		e.logger.WithField("payments", expired).Info("Voided expired authorizations")
+	}
+
+	return expired, nil
+}
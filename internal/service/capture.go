@@ -0,0 +1,452 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidCaptureAmount   = errors.New("capture amount exceeds authorized amount")
+	ErrAuthorizationExpired   = errors.New("authorization expired")
+	ErrCaptureDeclined        = errors.New("capture declined")
+	ErrInvalidIncrementAmount = errors.New("invalid increment amount")
+	ErrIncrementDeclined      = errors.New("authorization increment declined")
+)
+
+func (s *paymentService) CapturePayment(ctx context.Context, id uuid.UUID, req *model.CaptureRequest) (*model.Payment, error) {
+	payment, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAuthorized(payment, model.PaymentStatusCompleted); err != nil {
+		return nil, err
+	}
+
+	// A captura é reservada com o pagamento travado antes de ir ao
+	// adquirente: capturas concorrentes, o void e a expiração esbarram nela
+	// aqui, e não depois do valor já capturado no emissor. Sem valor ela leva
+	// o saldo não capturado, e o que a encerra é decidido sob a trava
+	capture := &model.PaymentCapture{
+		ID:        uuid.New(),
+		PaymentID: id,
+		Final:     req.IsFinal(),
+		Status:    model.CaptureStatusPending,
+		CreatedAt: time.Now(),
+	}
+	if req.Amount != nil {
+		if err := req.Amount.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid amount: %w", err)
+		}
+		if !req.Amount.IsPositive() {
+			return nil, fmt.Errorf("%w: must be positive", ErrInvalidCaptureAmount)
+		}
+		capture.Amount = *req.Amount
+	}
+	if err := s.repo.ReserveCapture(ctx, capture); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrPaymentNotAuthorized):
+			if current, getErr := s.repo.GetByID(ctx, id); getErr == nil {
+				err = &model.TransitionError{PaymentID: id.String(), From: model.PaymentStatusAuthorized, To: model.PaymentStatusCompleted, Current: current.Status}
+			}
+		case errors.Is(err, repository.ErrCaptureExceedsAuthorization):
+			err = fmt.Errorf("%w: %v", ErrInvalidCaptureAmount, err)
+		}
+		s.logger.WithError(err).WithField("payment_id", id).Warn("Failed to reserve capture")
+		return nil, err
+	}
+
+	captured := capture.Amount
+	final := capture.Final
+	to := model.PaymentStatusAuthorized
+	if final {
+		to = model.PaymentStatusCompleted
+	}
+
+	reference := networkReference(payment)
+
+	response, err := s.acquirer.Capture(ctx, &model.AcquirerRequest{
+		PaymentID:        id,
+		Amount:           captured,
+		NetworkReference: reference,
+	})
+	if err != nil {
+		s.failCapture(ctx, capture)
+		return nil, fmt.Errorf("failed to capture payment: %w", err)
+	}
+	if !response.Approved {
+		s.failCapture(ctx, capture)
+		s.logger.WithFields(logrus.Fields{
+			"payment_id":    id,
+			"response_code": response.ResponseCode,
+		}).Warn("Capture declined by acquirer")
+		if response.DeclineReason != "" {
+			return nil, fmt.Errorf("%w: %s", ErrCaptureDeclined, response.DeclineReason)
+		}
+		return nil, ErrCaptureDeclined
+	}
+
+	capture.Acquirer = s.acquirer.Name()
+	capture.ResponseCode = response.ResponseCode
+	capture.NetworkReference = response.NetworkReference
+	if capture.NetworkReference == "" {
+		// Adquirentes que não emitem referência por captura usam a da autorização
+		capture.NetworkReference = reference
+	}
+
+	event := &model.PaymentEvent{
+		PaymentID:  id,
+		Type:       model.PaymentEventCaptured,
+		FromStatus: model.PaymentStatusAuthorized,
+		ToStatus:   to,
+		Actor:      model.ActorAPI,
+		Reason:     "Captured " + captured.String(),
+	}
+
+	// Na captura final a reserva vira débito pelo valor capturado e o
+	// restante volta ao saldo disponível; numa parcial só o valor capturado
+	// sai da reserva. A ordem de travas (reserva, depois pagamento) é a mesma
+	// do cancelamento
+	err = s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		var hold *model.Hold
+		var err error
+		if final {
+			hold, err = tx.CaptureHoldAmount(ctx, id, captured)
+		} else {
+			hold, err = tx.DrawHold(ctx, id, captured)
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.RecordCapture(ctx, capture); err != nil {
+			return err
+		}
+		if err := s.postPaymentCompleted(ctx, tx, payment, captured, hold.AccountID); err != nil {
+			return err
+		}
+		if final {
+			if err := tx.TransitionStatus(ctx, id, model.PaymentStatusAuthorized, model.PaymentStatusCompleted, nil); err != nil {
+				return err
+			}
+		}
+		return tx.RecordPaymentEvent(ctx, event)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrHoldNotFound) || errors.Is(err, repository.ErrPaymentNotAuthorized) {
+			if current, getErr := s.repo.GetByID(ctx, id); getErr == nil {
+				err = &model.TransitionError{PaymentID: id.String(), From: model.PaymentStatusAuthorized, To: to, Current: current.Status}
+			}
+		}
+
+		// O valor já foi capturado no emissor: um void não o desfaz mais, só
+		// o estorno da captura
+		if s.refundCapture(ctx, capture) {
+			s.failCapture(ctx, capture)
+		}
+
+		s.logger.WithError(err).WithField("payment_id", id).Warn("Failed to capture payment")
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"payment_id": id,
+		"amount":     captured.String(),
+		"final":      final,
+	}).Info("Payment captured")
+
+	return s.repo.GetByID(ctx, id)
+}
+
+func (s *paymentService) IncrementAuthorization(ctx context.Context, id uuid.UUID, req *model.IncrementAuthorizationRequest) (*model.Payment, error) {
+	payment, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAuthorized(payment, model.PaymentStatusAuthorized); err != nil {
+		return nil, err
+	}
+
+	if err := req.Amount.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIncrementAmount, err)
+	}
+	if !req.Amount.IsPositive() {
+		return nil, fmt.Errorf("%w: must be positive", ErrInvalidIncrementAmount)
+	}
+	if req.Amount.Currency != payment.Amount.Currency {
+		return nil, fmt.Errorf("%w: %s and %s", model.ErrCurrencyMismatch, payment.Amount.Currency, req.Amount.Currency)
+	}
+
+	// Uma captura em andamento decide sob a trava se encerra a autorização;
+	// um incremento no meio dela seria liberado sem ser capturado
+	pending, err := s.repo.HasPendingCapture(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, repository.ErrCaptureInProgress
+	}
+
+	reference := networkReference(payment)
+
+	response, err := s.acquirer.IncrementAuthorization(ctx, &model.AcquirerRequest{
+		PaymentID:        id,
+		Amount:           req.Amount,
+		NetworkReference: reference,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to increment authorization: %w", err)
+	}
+	if !response.Approved {
+		s.logger.WithFields(logrus.Fields{
+			"payment_id":    id,
+			"response_code": response.ResponseCode,
+		}).Warn("Authorization increment declined by acquirer")
+		if response.DeclineReason != "" {
+			return nil, fmt.Errorf("%w: %s", ErrIncrementDeclined, response.DeclineReason)
+		}
+		return nil, ErrIncrementDeclined
+	}
+
+	reason := "Authorization increased by " + req.Amount.String()
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+	event := &model.PaymentEvent{
+		PaymentID:  id,
+		Type:       model.PaymentEventAuthorizationIncreased,
+		FromStatus: model.PaymentStatusAuthorized,
+		ToStatus:   model.PaymentStatusAuthorized,
+		Actor:      model.ActorAPI,
+		Reason:     reason,
+	}
+
+	var authorized model.Money
+	err = s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		if _, err := tx.IncreaseHold(ctx, id, req.Amount); err != nil {
+			return err
+		}
+		// Conferido de novo com o pagamento travado, depois da reserva
+		pending, err := tx.HasPendingCapture(ctx, id)
+		if err != nil {
+			return err
+		}
+		if pending {
+			return repository.ErrCaptureInProgress
+		}
+		authorized, err = tx.IncrementAuthorization(ctx, id, req.Amount)
+		if err != nil {
+			return err
+		}
+		return tx.RecordPaymentEvent(ctx, event)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrHoldNotFound) || errors.Is(err, repository.ErrPaymentNotAuthorized) {
+			if current, getErr := s.repo.GetByID(ctx, id); getErr == nil {
+				err = &model.TransitionError{PaymentID: id.String(), From: model.PaymentStatusAuthorized, To: model.PaymentStatusAuthorized, Current: current.Status}
+			}
+		}
+
+		// O incremento aprovado no adquirente não pode ficar sem a reserva
+		voidAuthorization(ctx, s.acquirer, s.logger, payment, req.Amount, reference)
+
+		s.logger.WithError(err).WithField("payment_id", id).Warn("Failed to increment authorization")
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"payment_id": id,
+		"increment":  req.Amount.String(),
+		"authorized": authorized.String(),
+	}).Info("Payment authorization increased")
+
+	return s.repo.GetByID(ctx, id)
+}
+
+func (s *paymentService) GetCaptures(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentCapture, error) {
+	if _, err := s.repo.GetByID(ctx, paymentID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetCaptures(ctx, paymentID)
+}
+
+func (s *paymentService) VoidPayment(ctx context.Context, id uuid.UUID, reason, actor string) (*model.Payment, error) {
+	payment, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if payment.Status != model.PaymentStatusAuthorized {
+		return nil, &model.TransitionError{PaymentID: id.String(), From: payment.Status, To: model.PaymentStatusVoided, Current: payment.Status}
+	}
+
+	if actor == "" {
+		actor = model.ActorAPI
+	}
+	remaining, err := releaseAuthorization(ctx, s.repo, payment, model.HoldStatusReleased, actor, reason)
+	if err != nil {
+		s.logger.WithError(err).WithField("payment_id", id).Warn("Failed to void payment")
+		return nil, err
+	}
+
+	if remaining.IsPositive() {
+		voidAuthorization(ctx, s.acquirer, s.logger, payment, remaining, networkReference(payment))
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"payment_id": id,
+		"actor":      actor,
+		"voided":     remaining.String(),
+	}).Info("Payment authorization voided")
+
+	return s.repo.GetByID(ctx, id)
+}
+
+// failCapture marca a captura reservada como failed, apenas registrando
+// falhas. Uma captura que fica pending bloqueia novas capturas e o void até a
+// conciliação
+func (s *paymentService) failCapture(ctx context.Context, capture *model.PaymentCapture) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), voidTimeout)
+	defer cancel()
+
+	if err := s.repo.FailCapture(ctx, capture.ID); err != nil {
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"payment_id": capture.PaymentID,
+			"capture_id": capture.ID,
+		}).Error("Failed to mark capture as failed")
+	}
+}
+
+// refundCapture estorna no adquirente uma captura aprovada que não pôde ser
+// gravada, pela referência da própria captura, e indica se conseguiu. Como o
+// void, é feito mesmo com o contexto cancelado
+func (s *paymentService) refundCapture(ctx context.Context, capture *model.PaymentCapture) bool {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), voidTimeout)
+	defer cancel()
+
+	fields := logrus.Fields{
+		"payment_id":        capture.PaymentID,
+		"capture_id":        capture.ID,
+		"amount":            capture.Amount.String(),
+		"network_reference": capture.NetworkReference,
+	}
+
+	response, err := s.acquirer.Refund(ctx, &model.AcquirerRequest{
+		PaymentID:        capture.PaymentID,
+		Amount:           capture.Amount,
+		NetworkReference: capture.NetworkReference,
+	})
+	if err != nil {
+		s.logger.WithError(err).WithFields(fields).Error("Failed to refund unrecorded capture, left pending for reconciliation")
+		return false
+	}
+	if !response.Approved {
+		s.logger.WithFields(fields).WithField("response_code", response.ResponseCode).
+			Error("Refund of unrecorded capture declined, left pending for reconciliation")
+		return false
+	}
+
+	s.logger.WithFields(fields).Warn("Refunded capture that could not be recorded")
+	return true
+}
+
+// newCapture monta o registro de uma captura aprovada pelo adquirente
+func (s *paymentService) newCapture(paymentID uuid.UUID, amount model.Money, final bool, response *model.AcquirerResponse) *model.PaymentCapture {
+	return &model.PaymentCapture{
+		ID:               uuid.New(),
+		PaymentID:        paymentID,
+		Amount:           amount,
+		Final:            final,
+		Status:           model.CaptureStatusSucceeded,
+		Acquirer:         s.acquirer.Name(),
+		ResponseCode:     response.ResponseCode,
+		NetworkReference: response.NetworkReference,
+		CreatedAt:        time.Now(),
+	}
+}
+
+// releaseAuthorization libera a reserva e encerra a autorização, registrando
+// o evento na mesma transação. O pagamento sem capturas vai a voided; o já
+// capturado em parte vai a completed com o valor capturado. Retorna o valor
+// não capturado, que o chamador cancela no adquirente depois do commit
+func releaseAuthorization(ctx context.Context, repo repository.PaymentRepository, payment *model.Payment, holdStatus model.HoldStatus, actor, reason string) (model.Money, error) {
+	var remaining model.Money
+	err := repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		if _, err := tx.ReleaseHold(ctx, payment.ID, holdStatus); err != nil && !errors.Is(err, repository.ErrHoldNotFound) {
+			return err
+		}
+
+		// Uma captura em andamento no adquirente não pode perder a autorização
+		pending, err := tx.HasPendingCapture(ctx, payment.ID)
+		if err != nil {
+			return err
+		}
+		if pending {
+			return repository.ErrCaptureInProgress
+		}
+
+		// Relido depois de travar a reserva, para contar as capturas parciais
+		// concluídas até aqui
+		current, err := tx.GetByID(ctx, payment.ID)
+		if err != nil {
+			return err
+		}
+		remaining, err = uncapturedAmount(current)
+		if err != nil {
+			return err
+		}
+
+		to := model.PaymentStatusVoided
+		if current.CapturedAmount != nil && current.CapturedAmount.IsPositive() {
+			to = model.PaymentStatusCompleted
+		}
+		if err := tx.TransitionStatus(ctx, payment.ID, model.PaymentStatusAuthorized, to, &reason); err != nil {
+			return err
+		}
+		return tx.RecordPaymentEvent(ctx, &model.PaymentEvent{
+			PaymentID:  payment.ID,
+			Type:       model.PaymentEventVoided,
+			FromStatus: model.PaymentStatusAuthorized,
+			ToStatus:   to,
+			Actor:      actor,
+			Reason:     reason,
+		})
+	})
+	return remaining, err
+}
+
+// checkAuthorized confere que o pagamento está em authorized e dentro do
+// prazo da autorização; to é o status pretendido no *model.TransitionError
+func checkAuthorized(payment *model.Payment, to model.PaymentStatus) error {
+	if payment.Status != model.PaymentStatusAuthorized {
+		return &model.TransitionError{PaymentID: payment.ID.String(), From: payment.Status, To: to, Current: payment.Status}
+	}
+	if payment.AuthorizationExpiresAt != nil && time.Now().After(*payment.AuthorizationExpiresAt) {
+		return ErrAuthorizationExpired
+	}
+	return nil
+}
+
+// uncapturedAmount é o saldo da autorização ainda não capturado
+func uncapturedAmount(payment *model.Payment) (model.Money, error) {
+	if payment.CapturedAmount == nil {
+		return payment.AuthorizedAmount, nil
+	}
+	return payment.AuthorizedAmount.Sub(*payment.CapturedAmount)
+}
+
+// networkReference é a referência da autorização gravada no pagamento
+func networkReference(payment *model.Payment) string {
+	if payment.NetworkReference == nil {
+		return ""
+	}
+	return *payment.NetworkReference
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDeadLetterPageSize é o número de mensagens listadas quando o limite
+// não é informado
+const defaultDeadLetterPageSize = 50
+
+// DeadLetterService dá acesso às mensagens do tópico de dead-letter
+type DeadLetterService interface {
+	Partitions(ctx context.Context) ([]*model.DeadLetterPartition, error)
+	List(ctx context.Context, partition int, offset int64, limit int) ([]*model.DeadLetter, error)
+	Get(ctx context.Context, partition int, offset int64) (*model.DeadLetter, error)
+	// Redrive reenvia a mensagem original ao tópico de origem pelo outbox,
+	// com a mesma chave e payload. A mensagem continua no dead-letter; o
+	// registro de mensagens processadas evita efeito repetido
+	Redrive(ctx context.Context, partition int, offset int64) (*model.DeadLetterRedrive, error)
+}
+
+type deadLetterService struct {
+	dlq          queue.DeadLetterQueue
+	outbox       repository.OutboxRepository
+	paymentTopic string
+	logger       *logrus.Logger
+}
+
+// NewDeadLetterService cria o serviço. paymentTopic é o destino do reenvio
+// quando a mensagem não informa o tópico de origem
+func NewDeadLetterService(dlq queue.DeadLetterQueue, outbox repository.OutboxRepository, paymentTopic string, logger *logrus.Logger) DeadLetterService {
+	if paymentTopic == "" {
+		paymentTopic = defaultPaymentTopic
+	}
+
+	return &deadLetterService{
+		dlq:          dlq,
+		outbox:       outbox,
+		paymentTopic: paymentTopic,
+		logger:       logger,
+	}
+}
+
+func (s *deadLetterService) Partitions(ctx context.Context) ([]*model.DeadLetterPartition, error) {
+	return s.dlq.Partitions(ctx)
+}
+
+func (s *deadLetterService) List(ctx context.Context, partition int, offset int64, limit int) ([]*model.DeadLetter, error) {
+	if limit <= 0 {
+		limit = defaultDeadLetterPageSize
+	}
+	return s.dlq.List(ctx, partition, offset, limit)
+}
+
+func (s *deadLetterService) Get(ctx context.Context, partition int, offset int64) (*model.DeadLetter, error) {
+	return s.dlq.Get(ctx, partition, offset)
+}
+
+func (s *deadLetterService) Redrive(ctx context.Context, partition int, offset int64) (*model.DeadLetterRedrive, error) {
+	letter, err := s.dlq.Get(ctx, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	topic := letter.OriginalTopic
+	if topic == "" {
+		topic = s.paymentTopic
+	}
+
+	message := &model.OutboxMessage{
+		Topic:   topic,
+		Key:     letter.Key,
+		Payload: letter.Value,
+	}
+	if err := s.outbox.EnqueueOutbox(ctx, message); err != nil {
+		return nil, fmt.Errorf("failed to enqueue redrive: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"partition":   partition,
+		"offset":      offset,
+		"topic":       topic,
+		"error_class": letter.ErrorClass,
+		"outbox_id":   message.ID,
+	}).Info("Dead letter redriven")
+
+	return &model.DeadLetterRedrive{
+		Partition:       partition,
+		Offset:          offset,
+		Topic:           topic,
+		OutboxMessageID: message.ID,
+	}, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HoldExpirer é o job em background que libera reservas vencidas de
+// pagamentos que nunca chegaram a ser processados, marcando-os como falhos
+type HoldExpirer struct {
+	repo      repository.PaymentRepository
+	interval  time.Duration
+	batchSize int
+	logger    *logrus.Logger
+	onExpired func(hold *model.Hold)
+}
+
+func NewHoldExpirer(repo repository.PaymentRepository, interval time.Duration, batchSize int, logger *logrus.Logger) *HoldExpirer {
+	return &HoldExpirer{
+		repo:      repo,
+		interval:  interval,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+// OnExpired registra um callback chamado para cada reserva expirada (métricas)
+func (e *HoldExpirer) OnExpired(fn func(hold *model.Hold)) {
+	e.onExpired = fn
+}
+
+// Start executa o job até o contexto ser cancelado
+func (e *HoldExpirer) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			e.logger.WithError(err).Error("Hold expiry run failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce expira um lote de reservas vencidas e retorna quantas expirou
+func (e *HoldExpirer) RunOnce(ctx context.Context) (int, error) {
+	holds, err := e.repo.GetExpiredHolds(ctx, time.Now(), e.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, hold := range holds {
+		if ctx.Err() != nil {
+			return expired, ctx.Err()
+		}
+
+		if err := e.expire(ctx, hold); err != nil {
+			// Outro processo encerrou a reserva ou começou a processar o
+			// pagamento entre a consulta e a expiração
+			var transitionErr *model.TransitionError
+			if errors.Is(err, repository.ErrHoldNotFound) || errors.As(err, &transitionErr) {
+				continue
+			}
+			return expired, err
+		}
+
+		expired++
+		if e.onExpired != nil {
+			e.onExpired(hold)
+		}
+	}
+
+	if expired > 0 {
+		e.logger.WithField("holds", expired).Info("Expired balance holds")
+	}
+
+	return expired, nil
+}
+
+// expire libera a reserva e falha o pagamento pendente na mesma transação
+func (e *HoldExpirer) expire(ctx context.Context, hold *model.Hold) error {
+	return e.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		if _, err := tx.ReleaseHold(ctx, hold.PaymentID, model.HoldStatusExpired); err != nil {
+			return err
+		}
+
+		errorMsg := "Balance hold expired before processing"
+		return tx.TransitionStatus(ctx, hold.PaymentID, model.PaymentStatusPending, model.PaymentStatusFailed, &errorMsg)
+	})
+}
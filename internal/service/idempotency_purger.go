@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+// IdempotencyPurger é o job em background que apaga Idempotency-Keys
+// expiradas. Chaves vencidas já são reaproveitadas na reserva; o expurgo só
+// impede que a tabela cresça indefinidamente
+type IdempotencyPurger struct {
+	repo      repository.IdempotencyRepository
+	interval  time.Duration
+	batchSize int
+	logger    *logrus.Logger
+}
+
+func NewIdempotencyPurger(repo repository.IdempotencyRepository, interval time.Duration, batchSize int, logger *logrus.Logger) *IdempotencyPurger {
+	return &IdempotencyPurger{
+		repo:      repo,
+		interval:  interval,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+// Start executa o job até o contexto ser cancelado
+func (p *IdempotencyPurger) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			p.logger.WithError(err).Error("Idempotency key purge failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce apaga lotes de chaves expiradas até não restar nenhuma e retorna
+// quantas apagou
+func (p *IdempotencyPurger) RunOnce(ctx context.Context) (int64, error) {
+	var purged int64
+	for {
+		n, err := p.repo.DeleteExpired(ctx, time.Now(), p.batchSize)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+		if n < int64(p.batchSize) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if purged > 0 {
+		p.logger.WithField("keys", purged).Info("Purged expired idempotency keys")
+	}
+
+	return purged, ctx.Err()
+}
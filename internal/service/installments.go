@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang-payment-microservice/internal/installment"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (s *paymentService) GetInstallmentRules(ctx context.Context, merchantID string) (*model.InstallmentRules, error) {
+	rules, err := s.repo.GetInstallmentRules(ctx, merchantID)
+	if errors.Is(err, repository.ErrInstallmentRulesNotFound) {
+		defaults := s.installments
+		defaults.MerchantID = merchantID
+		return &defaults, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installment rules: %w", err)
+	}
+	return rules, nil
+}
+
+func (s *paymentService) SetInstallmentRules(ctx context.Context, rules *model.InstallmentRules) (*model.InstallmentRules, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	if rules.MinInstallmentAmount.Currency != installment.Currency {
+		return nil, fmt.Errorf("%w: %s", installment.ErrCurrencyNotSupported, rules.MinInstallmentAmount.Currency)
+	}
+
+	if err := s.repo.SaveInstallmentRules(ctx, rules); err != nil {
+		return nil, fmt.Errorf("failed to save installment rules: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"merchant_id":      rules.MerchantID,
+		"max_installments": rules.MaxInstallments,
+		"interest_free":    rules.InterestFreeInstallments,
+		"interest":         rules.Interest,
+	}).Info("Installment rules updated")
+
+	return rules, nil
+}
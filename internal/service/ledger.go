@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/ledger"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrNothingToSettle = errors.New("nothing to settle")
+
+// postPaymentCompleted lança no razão a conclusão do pagamento pelo valor
+// capturado, na mesma transação do débito da conta do portador
+func (s *paymentService) postPaymentCompleted(ctx context.Context, tx repository.PaymentRepository, payment *model.Payment, captured model.Money, accountID uuid.UUID) error {
+	currency := captured.Currency
+
+	cardholder, err := tx.EnsureLedgerAccount(ctx, model.LedgerAccountCardholder, accountID.String(), currency)
+	if err != nil {
+		return err
+	}
+	merchant, err := tx.EnsureLedgerAccount(ctx, model.LedgerAccountMerchant, payment.MerchantID, currency)
+	if err != nil {
+		return err
+	}
+
+	fee, err := ledger.Fee(captured, s.feeBPS)
+	if err != nil {
+		return err
+	}
+
+	// Sem taxa a conta de taxas, comum a todos os pagamentos, não é tocada
+	var feesAccountID uuid.UUID
+	if fee.IsPositive() {
+		fees, err := tx.EnsureLedgerAccount(ctx, model.LedgerAccountFees, model.SystemLedgerOwner, currency)
+		if err != nil {
+			return err
+		}
+		feesAccountID = fees.ID
+	}
+
+	entry, err := ledger.PaymentCompleted(payment.ID, captured, fee, cardholder.ID, merchant.ID, feesAccountID)
+	if err != nil {
+		return err
+	}
+
+	return tx.PostEntry(ctx, entry)
+}
+
+// postRefund lança no razão a devolução do estorno, na mesma transação do
+// crédito na conta do portador
+func (s *paymentService) postRefund(ctx context.Context, tx repository.PaymentRepository, payment *model.Payment, refund *model.Refund, accountID uuid.UUID) error {
+	currency := refund.Amount.Currency
+
+	cardholder, err := tx.EnsureLedgerAccount(ctx, model.LedgerAccountCardholder, accountID.String(), currency)
+	if err != nil {
+		return err
+	}
+	merchant, err := tx.EnsureLedgerAccount(ctx, model.LedgerAccountMerchant, payment.MerchantID, currency)
+	if err != nil {
+		return err
+	}
+
+	entry, err := ledger.Refund(payment.ID, refund.Amount, cardholder.ID, merchant.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.PostEntry(ctx, entry)
+}
+
+// SettleMerchant liquida todo o saldo do merchant na moeda informada
+func (s *paymentService) SettleMerchant(ctx context.Context, merchantID string, currency model.Currency) (*model.JournalEntry, error) {
+	if !currency.IsValid() {
+		return nil, fmt.Errorf("%w: %q", model.ErrUnknownCurrency, string(currency))
+	}
+
+	var entry *model.JournalEntry
+
+	err := s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		// A conta do merchant fica travada até o fim da transação, então
+		// liquidações concorrentes não repassam o mesmo saldo
+		merchant, err := tx.EnsureLedgerAccount(ctx, model.LedgerAccountMerchant, merchantID, currency)
+		if err != nil {
+			return err
+		}
+		merchant, err = tx.LockLedgerAccount(ctx, merchant.ID)
+		if err != nil {
+			return err
+		}
+		if !merchant.Balance.IsPositive() {
+			return ErrNothingToSettle
+		}
+
+		suspense, err := tx.EnsureLedgerAccount(ctx, model.LedgerAccountSuspense, model.SystemLedgerOwner, currency)
+		if err != nil {
+			return err
+		}
+
+		entry, err = ledger.Settlement(merchantID, merchant.Balance, merchant.ID, suspense.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.PostEntry(ctx, entry)
+	})
+	if err != nil {
+		if !errors.Is(err, ErrNothingToSettle) {
+			s.logger.WithError(err).WithField("merchant_id", merchantID).Error("Failed to settle merchant")
+		}
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"merchant_id": merchantID,
+		"amount":      entry.Postings[0].Amount.String(),
+	}).Info("Merchant settled")
+
+	return entry, nil
+}
+
+// CheckLedger executa o verificador de invariantes do razão
+func (s *paymentService) CheckLedger(ctx context.Context) (*model.LedgerReport, error) {
+	drifts, err := s.repo.CheckLedgerInvariants(ctx)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to check ledger invariants")
+		return nil, err
+	}
+
+	report := &model.LedgerReport{
+		CheckedAt: time.Now(),
+		Balanced:  len(drifts) == 0,
+		Drifts:    drifts,
+	}
+
+	if !report.Balanced {
+		s.logger.WithField("drifts", len(drifts)).Warn("Ledger invariant check found drift")
+	}
+
+	return report, nil
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// outboxLease é por quanto tempo uma mensagem reservada fica com uma
+	// réplica do relay; se ela morrer, outra reenvia depois disso
+	outboxLease = time.Minute
+	// outboxBaseBackoff é a espera antes da primeira nova tentativa
+	outboxBaseBackoff = time.Second
+)
+
+// OutboxRelay é o job em background que publica no Kafka as mensagens
+// pendentes do outbox. A publicação é at-least-once: uma mensagem pode ser
+// reenviada se o relay cair entre o envio e a marcação como enviada
+type OutboxRelay struct {
+	repo       repository.OutboxRepository
+	producer   queue.KafkaProducer
+	interval   time.Duration
+	batchSize  int
+	maxBackoff time.Duration
+	// maxAttempts é o limite de tentativas de publicação; zero não limita
+	maxAttempts int
+	retention   time.Duration
+	logger      *logrus.Logger
+	onBacklog   func(backlog *model.OutboxBacklog)
+}
+
+func NewOutboxRelay(repo repository.OutboxRepository, producer queue.KafkaProducer, interval time.Duration, batchSize int, maxBackoff time.Duration, maxAttempts int, retention time.Duration, logger *logrus.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		repo:        repo,
+		producer:    producer,
+		interval:    interval,
+		batchSize:   batchSize,
+		maxBackoff:  maxBackoff,
+		maxAttempts: maxAttempts,
+		retention:   retention,
+		logger:      logger,
+	}
+}
+
+// OnBacklog registra um callback chamado com o backlog após cada execução (métricas)
+func (r *OutboxRelay) OnBacklog(fn func(backlog *model.OutboxBacklog)) {
+	r.onBacklog = fn
+}
+
+// Start executa o job até o contexto ser cancelado
+func (r *OutboxRelay) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).Error("Outbox relay run failed")
+		}
+
+		if err := r.reportBacklog(ctx); err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).Error("Failed to read outbox backlog")
+		}
+
+		if r.retention > 0 {
+			if _, err := r.repo.DeleteSentOutbox(ctx, time.Now().Add(-r.retention), r.batchSize); err != nil && ctx.Err() == nil {
+				r.logger.WithError(err).Error("Failed to delete sent outbox messages")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce publica lotes de mensagens prontas até não restar nenhuma e
+// retorna quantas publicou. Falhas de publicação não interrompem o lote: a
+// mensagem é reagendada com backoff exponencial e as seguintes da mesma
+// chave esperam por ela
+func (r *OutboxRelay) RunOnce(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		messages, err := r.repo.ClaimOutbox(ctx, time.Now(), outboxLease, r.batchSize)
+		if err != nil {
+			return sent, err
+		}
+
+		failed := 0
+		for _, message := range messages {
+			if ctx.Err() != nil {
+				return sent, ctx.Err()
+			}
+
+			ok, err := r.publish(ctx, message)
+			if err != nil {
+				return sent, err
+			}
+			if ok {
+				sent++
+			} else {
+				failed++
+			}
+		}
+
+		// Com falhas, as mensagens seguintes das mesmas chaves ficam para a
+		// próxima execução, depois do backoff
+		if len(messages) < r.batchSize || failed > 0 {
+			break
+		}
+	}
+
+	if sent > 0 {
+		r.logger.WithField("messages", sent).Info("Published outbox messages")
+	}
+
+	return sent, nil
+}
+
+// publish envia uma mensagem e registra o resultado. Retorna false se o envio
+// falhou e foi reagendado ou, sem tentativas restantes, movido para dead
+func (r *OutboxRelay) publish(ctx context.Context, message *model.OutboxMessage) (bool, error) {
+	logger := r.logger.WithFields(logrus.Fields{
+		"outbox_id": message.ID,
+		"topic":     message.Topic,
+		"key":       message.Key,
+	})
+
+	if err := r.producer.Publish(ctx, message.Topic, []byte(message.Key), message.Payload); err != nil {
+		attempts := message.Attempts + 1
+		if r.maxAttempts > 0 && attempts >= r.maxAttempts {
+			// Uma mensagem que nunca sai não pode segurar a chave para sempre
+			logger.WithError(err).WithField("attempts", attempts).Error("Outbox message exhausted its attempts, moving it to dead")
+			if err := r.repo.MarkOutboxDead(ctx, message.ID, err.Error(), time.Now()); err != nil {
+				return false, err
+			}
+			return false, nil
+		}
+
+		next := time.Now().Add(r.backoff(message.Attempts))
+		logger.WithError(err).WithField("attempts", attempts).Warn("Failed to publish outbox message, will retry")
+
+		if err := r.repo.MarkOutboxFailed(ctx, message.ID, err.Error(), next); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	if err := r.repo.MarkOutboxSent(ctx, message.ID, time.Now()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// backoff dobra a espera a cada tentativa, até maxBackoff
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 0; i < attempts && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
+
+func (r *OutboxRelay) reportBacklog(ctx context.Context) error {
+	if r.onBacklog == nil {
+		return nil
+	}
+
+	backlog, err := r.repo.GetOutboxBacklog(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.onBacklog(backlog)
+	return nil
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PaymentReaper é o job em background que encontra pagamentos parados em
+// pending ou processing, como os de uma mensagem perdida ou de um consumidor
+// que caiu no meio do processamento. Parado é o pagamento sem heartbeat há
+// mais que o limite do status. O pagamento é reenviado pelo outbox até
+// maxRequeues vezes; depois disso o pendente é cancelado e o em processamento
+// falha, a não ser que o adquirente já o tenha aprovado. Cada ação fica no
+// histórico do pagamento
+type PaymentReaper struct {
+	repo            repository.PaymentRepository
+	topic           string
+	interval        time.Duration
+	batchSize       int
+	pendingAfter    time.Duration
+	processingAfter time.Duration
+	maxRequeues     int
+	logger          *logrus.Logger
+	onReaped        func(event *model.PaymentEvent)
+}
+
+func NewPaymentReaper(repo repository.PaymentRepository, topic string, interval time.Duration, batchSize int, pendingAfter, processingAfter time.Duration, maxRequeues int, logger *logrus.Logger) *PaymentReaper {
+	return &PaymentReaper{
+		repo:            repo,
+		topic:           topic,
+		interval:        interval,
+		batchSize:       batchSize,
+		pendingAfter:    pendingAfter,
+		processingAfter: processingAfter,
+		maxRequeues:     maxRequeues,
+		logger:          logger,
+	}
+}
+
+// OnReaped registra um callback chamado para cada ação do reaper (métricas)
+func (r *PaymentReaper) OnReaped(fn func(event *model.PaymentEvent)) {
+	r.onReaped = fn
+}
+
+// Start executa o job até o contexto ser cancelado
+func (r *PaymentReaper) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).Error("Payment reaper run failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce trata um lote de pagamentos parados em cada status e retorna
+// quantos tratou
+func (r *PaymentReaper) RunOnce(ctx context.Context) (int, error) {
+	now := time.Now()
+	reaped := 0
+
+	behind, err := r.outboxBehind(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	if behind {
+		// A mensagem dos pendentes ainda está no outbox: eles estão atrasados,
+		// não perdidos, e reenviá-los só gastaria o limite de reenvios
+		r.logger.Warn("Outbox relay is behind, skipping pending payments")
+	} else {
+		n, err := r.reap(ctx, model.PaymentStatusPending, now.Add(-r.pendingAfter))
+		reaped += n
+		if err != nil {
+			return reaped, err
+		}
+	}
+
+	n, err := r.reap(ctx, model.PaymentStatusProcessing, now.Add(-r.processingAfter))
+	reaped += n
+	if err != nil {
+		return reaped, err
+	}
+
+	if reaped > 0 {
+		r.logger.WithField("payments", reaped).Info("Reaped stuck payments")
+	}
+
+	return reaped, nil
+}
+
+// outboxBehind indica se há mensagens do outbox esperando há mais tempo que
+// o limite dos pagamentos pendentes
+func (r *PaymentReaper) outboxBehind(ctx context.Context, now time.Time) (bool, error) {
+	backlog, err := r.repo.GetOutboxBacklog(ctx)
+	if err != nil {
+		return false, err
+	}
+	return backlog.OldestCreatedAt != nil && backlog.OldestCreatedAt.Before(now.Add(-r.pendingAfter)), nil
+}
+
+// reap trava os pagamentos parados de um status e age sobre eles na mesma
+// transação. Réplicas concorrentes pulam as linhas travadas, então cada
+// pagamento é tratado por uma só
+func (r *PaymentReaper) reap(ctx context.Context, status model.PaymentStatus, before time.Time) (int, error) {
+	var events []*model.PaymentEvent
+
+	err := r.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		events = nil
+
+		payments, err := tx.ClaimStuckPayments(ctx, status, before, r.batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, payment := range payments {
+			event, err := r.reapPayment(ctx, tx, payment)
+			if err != nil {
+				return fmt.Errorf("failed to reap payment %s: %w", payment.ID, err)
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		r.logger.WithFields(logrus.Fields{
+			"payment_id": event.PaymentID,
+			"action":     event.Type,
+			"status":     event.FromStatus,
+		}).Warn(event.Reason)
+
+		if r.onReaped != nil {
+			r.onReaped(event)
+		}
+	}
+
+	return len(events), nil
+}
+
+// reapPayment reenvia o pagamento ou, com os reenvios esgotados, o encerra
+func (r *PaymentReaper) reapPayment(ctx context.Context, tx repository.PaymentRepository, payment *model.Payment) (*model.PaymentEvent, error) {
+	stuckFor := time.Since(payment.HeartbeatAt).Round(time.Second)
+
+	event := &model.PaymentEvent{
+		PaymentID:  payment.ID,
+		FromStatus: payment.Status,
+		Actor:      model.ActorReaper,
+	}
+
+	switch {
+	case payment.Requeues < r.maxRequeues:
+		requeues, err := r.requeue(ctx, tx, payment)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Type = model.PaymentEventRequeued
+		event.ToStatus = payment.Status
+		event.Reason = fmt.Sprintf("Payment stuck in %s for %s, requeued (%d/%d)", payment.Status, stuckFor, requeues, r.maxRequeues)
+	case payment.Status == model.PaymentStatusProcessing && payment.IsAcquirerApproved():
+		// O adquirente já aprovou: marcar como falho deixaria o valor
+		// autorizado no emissor sem o pagamento. O reenvio retoma o pagamento
+		// a partir da resposta gravada, sem autorizar de novo
+		requeues, err := r.requeue(ctx, tx, payment)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Type = model.PaymentEventRequeued
+		event.ToStatus = payment.Status
+		event.Reason = fmt.Sprintf("Payment stuck in %s for %s with an approved acquirer response, requeued (%d/%d)", payment.Status, stuckFor, requeues, r.maxRequeues)
+	default:
+		// Um pendente nunca chegou a ser processado e é cancelado; um em
+		// processamento sem aprovação do adquirente pode ter parado no meio e
+		// é marcado como falho
+		event.Type = model.PaymentEventCancelled
+		event.ToStatus = model.PaymentStatusCancelled
+		if payment.Status == model.PaymentStatusProcessing {
+			event.Type = model.PaymentEventFailed
+			event.ToStatus = model.PaymentStatusFailed
+		}
+		event.Reason = fmt.Sprintf("Payment stuck in %s for %s after %d requeues", payment.Status, stuckFor, payment.Requeues)
+
+		if err := tx.TransitionStatus(ctx, payment.ID, payment.Status, event.ToStatus, &event.Reason); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ReleaseHold(ctx, payment.ID, model.HoldStatusReleased); err != nil && !errors.Is(err, repository.ErrHoldNotFound) {
+			return nil, err
+		}
+	}
+
+	if err := tx.RecordPaymentEvent(ctx, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// requeue conta o reenvio e publica o pagamento de novo pelo outbox.
+// Retorna o total de reenvios
+func (r *PaymentReaper) requeue(ctx context.Context, tx repository.PaymentRepository, payment *model.Payment) (int, error) {
+	requeues, err := tx.RecordRequeue(ctx, payment.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	message, err := queue.NewPaymentOutboxMessage(r.topic, payment)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.EnqueueOutbox(ctx, message); err != nil {
+		return 0, err
+	}
+	return requeues, nil
+}
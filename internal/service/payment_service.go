@@ -2,104 +2,434 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math/rand"
 	"time"
 
+	"golang-payment-microservice/internal/cardvalidation"
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/installment"
 	"golang-payment-microservice/internal/model"
 	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/redact"
 	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/vault"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 type PaymentService interface {
+	// CreatePayment cria o pagamento e o enfileira para processamento. Recusas
+	// causadas pelo próprio pedido retornam um *RequestError; qualquer outro
+	// erro é uma falha interna, que uma nova tentativa pode resolver
 	CreatePayment(ctx context.Context, req *model.PaymentRequest) (*model.PaymentResponse, error)
 	GetPayment(ctx context.Context, id uuid.UUID) (*model.Payment, error)
 	GetPaymentsByMerchant(ctx context.Context, merchantID string, limit, offset int) ([]*model.Payment, error)
-	ProcessPaymentAsync(ctx context.Context, paymentID string) error
+	// GetPaymentHistory retorna o pagamento e o histórico de ações sobre ele
+	GetPaymentHistory(ctx context.Context, id uuid.UUID) (*model.Payment, []*model.PaymentEvent, error)
+	// CancelPayment cancela um pagamento pending ou processing, liberando a
+	// reserva de saldo e registrando o motivo e o autor no histórico. Um
+	// pagamento em outro status retorna um *model.TransitionError
+	CancelPayment(ctx context.Context, id uuid.UUID, reason, actor string) (*model.Payment, error)
+	// ProcessPaymentAsync processa o pagamento entregue pela mensagem do
+	// Kafka. O efeito é aplicado uma única vez por pagamento, mesmo com
+	// reentregas; delivery é nil quando o processamento não vem do Kafka.
+	// Falhas transitórias retornam um *model.RetryableError e deixam o
+	// pagamento em processing para uma nova tentativa
+	ProcessPaymentAsync(ctx context.Context, paymentID string, delivery *model.MessageDelivery) error
+	// CapturePayment captura um pagamento authorized, no saldo ainda não
+	// capturado ou num valor menor. Uma captura parcial que não é a final
+	// mantém o pagamento em authorized para as próximas. Um pagamento em
+	// outro status retorna um *model.TransitionError
+	CapturePayment(ctx context.Context, id uuid.UUID, req *model.CaptureRequest) (*model.Payment, error)
+	// IncrementAuthorization aumenta a autorização de um pagamento
+	// authorized no adquirente e a reserva de saldo pelo mesmo valor
+	IncrementAuthorization(ctx context.Context, id uuid.UUID, req *model.IncrementAuthorizationRequest) (*model.Payment, error)
+	// GetCaptures retorna as capturas do pagamento, as mais antigas primeiro
+	GetCaptures(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentCapture, error)
+	// VoidPayment cancela a autorização de um pagamento authorized, liberando
+	// a reserva de saldo. Um pagamento já capturado em parte é encerrado em
+	// completed com o valor capturado. Um pagamento em outro status retorna
+	// um *model.TransitionError
+	VoidPayment(ctx context.Context, id uuid.UUID, reason, actor string) (*model.Payment, error)
+	// CreateRefund cria um estorno pendente de um pagamento concluído e o
+	// envia ao Kafka pelo outbox. Sem valor, estorna o saldo ainda não
+	// estornado
+	CreateRefund(ctx context.Context, paymentID uuid.UUID, req *model.RefundRequest) (*model.Refund, error)
+	GetRefunds(ctx context.Context, paymentID uuid.UUID) ([]*model.Refund, error)
+	// ProcessRefundAsync processa o estorno entregue pela mensagem do Kafka:
+	// estorna no adquirente e credita a conta do portador. Como no
+	// pagamento, o crédito é aplicado uma única vez e falhas transitórias
+	// retornam um *model.RetryableError
+	ProcessRefundAsync(ctx context.Context, refundID string, delivery *model.MessageDelivery) error
+	// GetInstallmentRules retorna as regras de parcelamento do merchant, as
+	// da configuração se ele não tiver regras próprias
+	GetInstallmentRules(ctx context.Context, merchantID string) (*model.InstallmentRules, error)
+	// SetInstallmentRules valida e grava as regras de parcelamento do merchant
+	SetInstallmentRules(ctx context.Context, rules *model.InstallmentRules) (*model.InstallmentRules, error)
+	GetAccountHolds(ctx context.Context, accountID uuid.UUID, status model.HoldStatus, limit, offset int) (*model.Account, []*model.Hold, error)
+	SettleMerchant(ctx context.Context, merchantID string, currency model.Currency) (*model.JournalEntry, error)
+	CheckLedger(ctx context.Context) (*model.LedgerReport, error)
+}
+
+const (
+	// defaultHoldTTL é a validade padrão da reserva de saldo de um pagamento
+	defaultHoldTTL = 30 * time.Minute
+	// defaultPaymentTopic é o tópico padrão das mensagens de processamento
+	defaultPaymentTopic = "payment-processing"
+	// defaultMaxAttempts é o número padrão de tentativas de processamento
+	defaultMaxAttempts = 5
+	// defaultAuthorizationTTL é o prazo padrão para capturar uma autorização
+	// manual, próximo do que os emissores mantêm a autorização
+	defaultAuthorizationTTL = 7 * 24 * time.Hour
+	// voidTimeout limita o cancelamento de uma autorização que não pôde ser
+	// concluída localmente, feito mesmo depois do contexto cancelado
+	voidTimeout = 10 * time.Second
+	// defaultCVVTTL é a validade padrão do CVV retido para a autorização
+	defaultCVVTTL = 15 * time.Minute
+)
+
+// RequestError é uma recusa de CreatePayment causada pelo próprio pedido,
+// como dados de cartão inválidos ou saldo insuficiente
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// invalidRequest marca err como uma recusa causada pelo pedido
+func invalidRequest(err error) error {
+	return &RequestError{Err: err}
+}
+
+// defaultInstallmentRules são as regras de parcelamento sem configuração:
+// até 12x sem juros, com parcelas de no mínimo R$ 5,00
+var defaultInstallmentRules = model.InstallmentRules{
+	MaxInstallments:          12,
+	MinInstallmentAmount:     model.NewMoney(500, installment.Currency),
+	InterestFreeInstallments: 12,
+	Interest:                 model.InstallmentInterestIssuer,
+	MonthlyRateBPS:           199,
 }
 
 type paymentService struct {
-	repo     repository.PaymentRepository
-	producer queue.KafkaProducer
-	logger   *logrus.Logger
+	repo         repository.PaymentRepository
+	cardVault    vault.CardVault
+	logger       *logrus.Logger
+	holdTTL      time.Duration
+	feeBPS       int64
+	paymentTopic string
+	maxAttempts  int
+	acquirer     gateway.AcquirerGateway
+	authTTL      time.Duration
+	installments model.InstallmentRules
+	cvvs         *vault.CVVCache
+}
+
+// Option configura parâmetros opcionais do serviço
+type Option func(*paymentService)
+
+// WithHoldTTL define por quanto tempo o saldo fica reservado aguardando o
+// processamento do pagamento
+func WithHoldTTL(ttl time.Duration) Option {
+	return func(s *paymentService) {
+		if ttl > 0 {
+			s.holdTTL = ttl
+		}
+	}
+}
+
+// WithMerchantFee define a taxa cobrada do merchant em pontos-base (1/100 de 1%)
+func WithMerchantFee(basisPoints int64) Option {
+	return func(s *paymentService) {
+		s.feeBPS = basisPoints
+	}
+}
+
+// WithPaymentTopic define o tópico Kafka das mensagens de processamento
+// gravadas no outbox
+func WithPaymentTopic(topic string) Option {
+	return func(s *paymentService) {
+		if topic != "" {
+			s.paymentTopic = topic
+		}
+	}
+}
+
+// WithMaxAttempts define quantas tentativas de processamento um pagamento
+// tem antes de uma falha transitória levá-lo a failed
+func WithMaxAttempts(attempts int) Option {
+	return func(s *paymentService) {
+		if attempts > 0 {
+			s.maxAttempts = attempts
+		}
+	}
+}
+
+// WithAcquirer define o gateway do adquirente que autoriza os pagamentos.
+// Sem ele o serviço usa o simulador com a configuração padrão
+func WithAcquirer(acquirer gateway.AcquirerGateway) Option {
+	return func(s *paymentService) {
+		if acquirer != nil {
+			s.acquirer = acquirer
+		}
+	}
+}
+
+// WithAuthorizationTTL define o prazo para capturar um pagamento de captura
+// manual; depois dele a autorização é cancelada pelo AuthorizationExpirer
+func WithAuthorizationTTL(ttl time.Duration) Option {
+	return func(s *paymentService) {
+		if ttl > 0 {
+			s.authTTL = ttl
+		}
+	}
 }
 
-func NewPaymentService(repo repository.PaymentRepository, producer queue.KafkaProducer, logger *logrus.Logger) PaymentService {
-	return &paymentService{
-		repo:     repo,
-		producer: producer,
-		logger:   logger,
+// WithInstallmentRules define as regras de parcelamento dos merchants sem
+// regras próprias
+func WithInstallmentRules(rules model.InstallmentRules) Option {
+	return func(s *paymentService) {
+		s.installments = rules
 	}
 }
 
+// WithCVVCache define onde o CVV de cada pagamento fica retido aguardando a
+// autorização, compartilhado com os jobs que encerram pagamentos
+func WithCVVCache(cvvs *vault.CVVCache) Option {
+	return func(s *paymentService) {
+		s.cvvs = cvvs
+	}
+}
+
+func NewPaymentService(repo repository.PaymentRepository, cardVault vault.CardVault, logger *logrus.Logger, opts ...Option) PaymentService {
+	s := &paymentService{
+		repo:         repo,
+		cardVault:    cardVault,
+		logger:       logger,
+		holdTTL:      defaultHoldTTL,
+		paymentTopic: defaultPaymentTopic,
+		maxAttempts:  defaultMaxAttempts,
+		acquirer:     gateway.NewSimulator(),
+		authTTL:      defaultAuthorizationTTL,
+		installments: defaultInstallmentRules,
+		cvvs:         vault.NewCVVCache(defaultCVVTTL),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
 func (s *paymentService) CreatePayment(ctx context.Context, req *model.PaymentRequest) (*model.PaymentResponse, error) {
-	// Validar dados do cartão
-	card := &model.Card{
-		Number:      req.CardNumber,
-		Holder:      req.CardHolder,
-		ExpiryMonth: req.ExpiryMonth,
-		ExpiryYear:  req.ExpiryYear,
-		CVV:         req.CVV,
+	// Resolver o cartão: dados brutos são validados e tokenizados,
+	// tokens existentes são consultados no cofre
+	card, cardToken, err := s.resolveCard(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Amount.Validate(); err != nil {
+		return nil, invalidRequest(fmt.Errorf("invalid amount: %w", err))
 	}
 
-	if !card.IsValid() {
-		return nil, fmt.Errorf("invalid card data")
+	captureMethod := req.CaptureMethod
+	if captureMethod == "" {
+		captureMethod = model.CaptureAutomatic
+	}
+	if !captureMethod.IsValid() {
+		return nil, invalidRequest(fmt.Errorf("invalid capture method %q", string(req.CaptureMethod)))
+	}
+	// A tag validate do PaymentRequest não é aplicada no bind; sem parcelas o
+	// JSON chega com 0, que é à vista, mas uma contagem negativa é recusada
+	if req.Installments < 0 {
+		return nil, invalidRequest(fmt.Errorf("invalid installments: %w: %d", installment.ErrInvalidInstallments, req.Installments))
+	}
+	// Capturas parciais não se repartem pelas parcelas do plano
+	if req.Installments > 1 && captureMethod == model.CaptureManual {
+		return nil, invalidRequest(fmt.Errorf("invalid installments: %w: manual capture is not supported for installment payments",
+			installment.ErrInvalidInstallments))
+	}
+
+	// O parcelamento segue as regras do merchant; com juros do lojista o
+	// valor cobrado do cartão é o total das parcelas
+	amount := req.Amount
+	installments := 1
+	var plan *model.InstallmentPlan
+	if req.Installments > 1 {
+		rules, err := s.GetInstallmentRules(ctx, req.MerchantID)
+		if err != nil {
+			return nil, err
+		}
+		plan, err = installment.Plan(req.Amount, req.Installments, rules, time.Now())
+		if err != nil {
+			return nil, invalidRequest(fmt.Errorf("invalid installments: %w", err))
+		}
+		amount = plan.Charged()
+		installments = plan.Count
 	}
 
 	// Verificar saldo da conta
-	account, err := s.repo.GetAccountByCardNumber(ctx, req.CardNumber)
+	account, err := s.repo.GetAccountByCardNumber(ctx, card.Number)
 	if err != nil {
-		s.logger.WithError(err).WithField("card_number", req.CardNumber).Error("Failed to get account")
-		return nil, fmt.Errorf("account not found or invalid")
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"card_token": cardToken,
+			"card":       redact.MaskPAN(card.Number),
+		}).Error("Failed to get account")
+		if errors.Is(err, repository.ErrAccountNotFound) {
+			return nil, invalidRequest(errors.New("account not found or invalid"))
+		}
+		return nil, fmt.Errorf("failed to get account: %w", err)
 	}
 
-	if !account.HasSufficientBalance(req.Amount) {
-		return nil, fmt.Errorf("insufficient balance")
+	if !account.HasSufficientBalance(amount) {
+		return nil, invalidRequest(errors.New("insufficient balance"))
 	}
 
 	// Criar pagamento
 	payment := &model.Payment{
 		ID:          uuid.New(),
-		CardNumber:  req.CardNumber,
+		CardToken:   cardToken,
+		CardBIN:     card.BIN(),
+		CardLast4:   card.Last4(),
+		CardBrand:   string(card.Brand()),
 		CardHolder:  req.CardHolder,
-		ExpiryMonth: req.ExpiryMonth,
-		ExpiryYear:  req.ExpiryYear,
-		CVV:         req.CVV,
-		Amount:      req.Amount,
-		Currency:    req.Currency,
+		ExpiryMonth: card.ExpiryMonth,
+		ExpiryYear:  card.ExpiryYear,
+		Amount:      amount,
 		MerchantID:  req.MerchantID,
 		Status:      model.PaymentStatusPending,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+
+		CaptureMethod:   captureMethod,
+		Installments:    installments,
+		InstallmentPlan: plan,
+		// Ordena o processamento pelo cartão, e não pelo token do merchant
+		CardFingerprint: card.Fingerprint,
+	}
+
+	// Reservar o valor no saldo disponível na mesma transação que cria o
+	// pagamento, para que pagamentos pendentes não gastem o mesmo saldo
+	hold := &model.Hold{
+		ID:        uuid.New(),
+		AccountID: account.ID,
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+		Status:    model.HoldStatusActive,
+		ExpiresAt: payment.CreatedAt.Add(s.holdTTL),
+		CreatedAt: payment.CreatedAt,
+		UpdatedAt: payment.CreatedAt,
+	}
+
+	// A mensagem de processamento vai para o outbox na mesma transação; o
+	// relay a publica no Kafka mesmo que o broker esteja fora agora
+	message, err := queue.NewPaymentOutboxMessage(s.paymentTopic, payment)
+	if err != nil {
+		return nil, err
 	}
 
+	// O CVV fica só em memória até a autorização. É retido antes do commit
+	// porque o relay pode publicar a mensagem logo em seguida
+	s.cvvs.Hold(payment.ID, card.CVV)
+
 	// Salvar no banco
-	if err := s.repo.Create(ctx, payment); err != nil {
+	err = s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		if err := tx.Create(ctx, payment); err != nil {
+			return err
+		}
+		if err := tx.PlaceHold(ctx, hold); err != nil {
+			return err
+		}
+		return tx.EnqueueOutbox(ctx, message)
+	})
+	if err != nil {
+		s.cvvs.Release(payment.ID)
+		if errors.Is(err, repository.ErrInsufficientFunds) || errors.Is(err, repository.ErrAccountInactive) {
+			return nil, invalidRequest(errors.New("insufficient balance"))
+		}
 		s.logger.WithError(err).Error("Failed to create payment")
 		return nil, fmt.Errorf("failed to create payment: %w", err)
 	}
 
-	// Enviar para fila de processamento
-	if err := s.producer.SendPaymentMessage(ctx, payment); err != nil {
-		s.logger.WithError(err).WithField("payment_id", payment.ID).Error("Failed to send payment to queue")
-		// Não retornar erro aqui, pois o pagamento foi criado
-	}
-
 	s.logger.WithField("payment_id", payment.ID).Info("Payment created successfully")
 
 	return &model.PaymentResponse{
-		ID:        payment.ID,
-		Status:    payment.Status,
-		Amount:    payment.Amount,
-		Currency:  payment.Currency,
-		CreatedAt: payment.CreatedAt,
-		Message:   "Payment created and queued for processing",
+		ID:            payment.ID,
+		Status:        payment.Status,
+		Amount:        payment.Amount,
+		CaptureMethod: payment.CaptureMethod,
+		CardToken:     payment.CardToken,
+		CardBrand:     payment.CardBrand,
+		CreatedAt:     payment.CreatedAt,
+		Message:       "Payment created and queued for processing",
+
+		InstallmentPlan: payment.InstallmentPlan,
 	}, nil
 }
 
+// resolveCard retorna o cartão completo e o token do cofre correspondente
+func (s *paymentService) resolveCard(ctx context.Context, req *model.PaymentRequest) (*model.Card, string, error) {
+	if req.CardToken != "" {
+		card, err := s.cardVault.Detokenize(ctx, req.MerchantID, req.CardToken)
+		if err != nil {
+			if errors.Is(err, vault.ErrTokenNotFound) {
+				return nil, "", invalidRequest(errors.New("invalid card token"))
+			}
+			s.logger.WithError(err).WithField("card_token", req.CardToken).Error("Failed to detokenize card")
+			return nil, "", fmt.Errorf("failed to resolve card token")
+		}
+
+		// O PAN já foi validado na tokenização; aqui só a validade e o CVV
+		// recoletado, cujo tamanho depende da bandeira
+		failures := cardvalidation.ValidateExpiry(card.ExpiryMonth, card.ExpiryYear, time.Now())
+		if req.CVV != "" {
+			failures = append(failures, cardvalidation.ValidateCVV(card.Brand(), req.CVV)...)
+		}
+		if len(failures) > 0 {
+			verr := &cardvalidation.ValidationError{Brand: card.Brand(), Failures: failures}
+			return nil, "", invalidRequest(fmt.Errorf("invalid card data: %w", verr))
+		}
+
+		// CVV recoletado para um cartão salvo; é retido com o pagamento
+		card.CVV = req.CVV
+		card.Holder = req.CardHolder
+
+		return card, req.CardToken, nil
+	}
+
+	card := &model.Card{
+		Number:      req.CardNumber,
+		Holder:      req.CardHolder,
+		ExpiryMonth: req.ExpiryMonth,
+		ExpiryYear:  req.ExpiryYear,
+		CVV:         req.CVV,
+	}
+
+	if err := card.Validate(); err != nil {
+		return nil, "", invalidRequest(fmt.Errorf("invalid card data: %w", err))
+	}
+
+	vaulted, err := s.cardVault.Tokenize(ctx, req.MerchantID, card)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to tokenize card")
+		return nil, "", fmt.Errorf("failed to store card")
+	}
+
+	card.Fingerprint = vaulted.PANFingerprint
+
+	return card, vaulted.Token, nil
+}
+
 func (s *paymentService) GetPayment(ctx context.Context, id uuid.UUID) (*model.Payment, error) {
 	payment, err := s.repo.GetByID(ctx, id)
 	if err != nil {
@@ -120,62 +450,550 @@ func (s *paymentService) GetPaymentsByMerchant(ctx context.Context, merchantID s
 	return payments, nil
 }
 
-func (s *paymentService) ProcessPaymentAsync(ctx context.Context, paymentID string) error {
+func (s *paymentService) GetPaymentHistory(ctx context.Context, id uuid.UUID) (*model.Payment, []*model.PaymentEvent, error) {
+	payment, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events, err := s.repo.GetPaymentEvents(ctx, id)
+	if err != nil {
+		s.logger.WithError(err).WithField("payment_id", id).Error("Failed to get payment history")
+		return nil, nil, err
+	}
+
+	return payment, events, nil
+}
+
+func (s *paymentService) CancelPayment(ctx context.Context, id uuid.UUID, reason, actor string) (*model.Payment, error) {
+	payment, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if actor == "" {
+		actor = model.ActorAPI
+	}
+	event := &model.PaymentEvent{
+		PaymentID: id,
+		Type:      model.PaymentEventCancelled,
+		ToStatus:  model.PaymentStatusCancelled,
+		Actor:     actor,
+		Reason:    reason,
+	}
+
+	// O compare-and-set parte do status lido. Se o consumidor tirou o
+	// pagamento de pending nesse meio-tempo, o cancelamento é refeito a
+	// partir de processing; o processamento em andamento é abortado quando a
+	// sua transição final encontrar o pagamento cancelado
+	from := payment.Status
+	for {
+		if !from.IsCancellable() {
+			return nil, &model.TransitionError{PaymentID: id.String(), From: from, To: model.PaymentStatusCancelled, Current: from}
+		}
+
+		event.FromStatus = from
+		err = s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+			// A reserva é liberada antes da mudança de status, na mesma ordem
+			// de travas do débito do consumidor (reserva, depois pagamento), para
+			// que as duas transações não se bloqueiem mutuamente
+			if _, err := tx.ReleaseHold(ctx, id, model.HoldStatusReleased); err != nil && !errors.Is(err, repository.ErrHoldNotFound) {
+				return err
+			}
+			if err := tx.TransitionStatus(ctx, id, from, model.PaymentStatusCancelled, &reason); err != nil {
+				return err
+			}
+			return tx.RecordPaymentEvent(ctx, event)
+		})
+
+		var transitionErr *model.TransitionError
+		if errors.As(err, &transitionErr) && transitionErr.Current != from {
+			from = transitionErr.Current
+			continue
+		}
+		break
+	}
+	if err != nil {
+		s.logger.WithError(err).WithField("payment_id", id).Warn("Failed to cancel payment")
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"payment_id": id,
+		"status":     event.FromStatus,
+		"actor":      actor,
+	}).Info("Payment cancelled")
+
+	s.cvvs.Release(id)
+
+	cancelled, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Um pagamento cancelado entre duas tentativas de processamento pode ter
+	// a autorização aprovada gravada, que nenhuma entrega vai mais concluir
+	if authorization := recordedAuthorization(cancelled); authorization != nil && event.FromStatus == model.PaymentStatusProcessing {
+		s.void(ctx, cancelled, authorization)
+	}
+
+	return cancelled, nil
+}
+
+func (s *paymentService) ProcessPaymentAsync(ctx context.Context, paymentID string, delivery *model.MessageDelivery) (err error) {
 	id, err := uuid.Parse(paymentID)
 	if err != nil {
 		return fmt.Errorf("invalid payment ID: %w", err)
 	}
 
-	// Atualizar status para processando
-	if err := s.repo.UpdateStatus(ctx, id, model.PaymentStatusProcessing, nil); err != nil {
-		s.logger.WithError(err).WithField("payment_id", id).Error("Failed to update payment status to processing")
-		return err
+	// O CVV serve só a esta autorização: é descartado em qualquer desfecho,
+	// salvo quando a mesma mensagem ainda vai ser tentada de novo
+	defer func() {
+		if !model.IsRetryable(err) && ctx.Err() == nil {
+			s.cvvs.Release(id)
+		}
+	}()
+
+	// Uma mensagem reentregue depois de aplicada é descartada sem reprocessar
+	if delivery != nil {
+		processed, err := s.repo.IsMessageProcessed(ctx, id, *delivery)
+		if err != nil {
+			return model.Retryable(fmt.Errorf("failed to check processed message: %w", err))
+		}
+		if processed {
+			s.logger.WithField("payment_id", id).WithField("offset", delivery.Offset).Info("Skipping already processed message")
+			return nil
+		}
+	}
+
+	// Atualizar status para processando. Um pagamento que já chegou a um
+	// estado final é descartado; um que ficou em processing porque a entrega
+	// anterior foi interrompida é retomado. A transição final é um
+	// compare-and-set na mesma transação do débito, então só uma entrega o
+	// conclui
+	if err := s.repo.TransitionStatus(ctx, id, model.PaymentStatusPending, model.PaymentStatusProcessing, nil); err != nil {
+		var transitionErr *model.TransitionError
+		if !errors.As(err, &transitionErr) {
+			s.logger.WithError(err).WithField("payment_id", id).Error("Failed to update payment status to processing")
+			return model.Retryable(err)
+		}
+		if transitionErr.Current != model.PaymentStatusProcessing {
+			s.logger.WithError(err).WithField("payment_id", id).Warn("Skipping payment that is no longer pending")
+			return nil
+		}
+		s.logger.WithField("payment_id", id).Warn("Resuming payment left in processing by an interrupted delivery")
+	}
+
+	attempts, err := s.repo.RecordProcessingAttempt(ctx, id)
+	if err != nil {
+		return model.Retryable(fmt.Errorf("failed to record processing attempt: %w", err))
+	}
+
+	payment, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return s.retryOrFail(ctx, id, delivery, attempts, "Failed to load payment", err)
+	}
+	if payment.Status == model.PaymentStatusCancelled {
+		s.logger.WithField("payment_id", id).Warn("Skipping payment cancelled during processing")
+		return nil
+	}
+
+	// Uma entrega anterior que já teve a aprovação do adquirente gravada é
+	// retomada a partir dela: autorizar de novo cobraria o portador duas vezes
+	recorded := recordedAuthorization(payment)
+
+	// Recuperar o cartão do cofre
+	card, err := s.cardVault.Detokenize(ctx, payment.MerchantID, payment.CardToken)
+	if err != nil {
+		if errors.Is(err, vault.ErrTokenNotFound) {
+			if recorded != nil {
+				s.failAuthorized(ctx, payment, recorded, delivery, "Failed to resolve card token")
+			} else {
+				s.failProcessing(ctx, id, delivery, "Failed to resolve card token")
+			}
+			s.logger.WithError(err).WithField("payment_id", id).Warn("Payment failed")
+			return nil
+		}
+		return s.retryOrVoid(ctx, payment, recorded, delivery, attempts, "Failed to resolve card token",
+			fmt.Errorf("failed to detokenize card: %w", err))
+	}
+
+	response := recorded
+	if response != nil {
+		s.logger.WithFields(logrus.Fields{
+			"payment_id":        id,
+			"network_reference": response.NetworkReference,
+		}).Info("Resuming payment from the recorded acquirer authorization")
+	} else {
+		// Uma referência gravada sem aprovação não pode ficar pendurada no
+		// emissor enquanto uma nova autorização é pedida
+		if payment.NetworkReference != nil {
+			voidAuthorization(ctx, s.acquirer, s.logger, payment, payment.Amount, *payment.NetworkReference)
+		}
+
+		// O CVV retido com o pagamento; sem ele, porque expirou, não foi
+		// informado ou ficou em outra réplica, a autorização segue sem CVV
+		card.CVV = s.cvvs.Get(id)
+
+		// Autorizar e capturar no adquirente. Se o contexto for cancelado, por
+		// exemplo no shutdown, o pagamento fica em processing para ser
+		// retomado na reentrega da mensagem
+		response, err = s.authorize(ctx, payment, card)
+		if err != nil {
+			if ctx.Err() != nil {
+				s.logger.WithField("payment_id", id).Warn("Payment processing interrupted, left in processing for recovery")
+				return ctx.Err()
+			}
+			return s.retryOrFail(ctx, id, delivery, attempts, "Payment processing failed due to external service error", err)
+		}
+
+		// Com a resposta do adquirente o CVV não é mais necessário
+		s.cvvs.Release(id)
 	}
 
-	// Simular processamento (tempo aleatório entre 1-5 segundos)
-	processingTime := time.Duration(rand.Intn(4)+1) * time.Second
-	time.Sleep(processingTime)
+	if !response.Approved {
+		reason := "Payment declined"
+		if response.DeclineReason != "" {
+			reason += ": " + response.DeclineReason
+		}
+		s.failProcessing(ctx, id, delivery, reason)
+		s.logger.WithFields(logrus.Fields{
+			"payment_id":    id,
+			"response_code": response.ResponseCode,
+		}).Warn("Payment declined by acquirer")
+		return nil
+	}
 
-	// Simular sucesso/falha (90% de sucesso)
-	success := rand.Float32() < 0.9
+	// Na captura manual a reserva é mantida e o pagamento para em authorized
+	if payment.CaptureMethod == model.CaptureManual {
+		return s.holdAuthorization(ctx, payment, response, delivery, attempts)
+	}
 
-	if success {
-		// Processar pagamento com sucesso
-		payment, err := s.repo.GetByID(ctx, id)
+	// Debitar da conta e concluir o pagamento na mesma transação
+	err = s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		accountID, err := s.debit(ctx, tx, payment, card)
 		if err != nil {
 			return err
 		}
+		if err := tx.RecordCapture(ctx, s.newCapture(id, payment.Amount, true, response)); err != nil {
+			return err
+		}
+		if err := s.postPaymentCompleted(ctx, tx, payment, payment.Amount, accountID); err != nil {
+			return err
+		}
+		if err := tx.TransitionStatus(ctx, id, model.PaymentStatusProcessing, model.PaymentStatusCompleted, nil); err != nil {
+			return err
+		}
+		return recordDelivery(ctx, tx, id, delivery, model.PaymentStatusCompleted)
+	})
+	if err != nil {
+		// A captura no adquirente não pode ficar sem o pagamento concluído
+		if isCancelled(err) {
+			s.void(ctx, payment, response)
+			s.logger.WithField("payment_id", id).Warn("Payment cancelled during processing, authorization voided")
+			return nil
+		}
+
+		// Outra entrega concluiu o pagamento com esta mesma autorização
+		var transitionErr *model.TransitionError
+		if errors.As(err, &transitionErr) {
+			return err
+		}
+
+		reason := debitFailureReason(err)
+
+		// Recusas e dados inconsistentes não mudam numa nova tentativa
+		if isTerminalDebitError(err) {
+			s.failAuthorized(ctx, payment, response, delivery, reason)
+			s.logger.WithField("payment_id", id).WithField("reason", reason).Warn("Payment declined")
+			return nil
+		}
+		return s.retryOrVoid(ctx, payment, response, delivery, attempts, reason, fmt.Errorf("failed to debit account: %w", err))
+	}
+
+	s.logger.WithField("payment_id", id).Info("Payment processed successfully")
+
+	return nil
+}
+
+// holdAuthorization leva o pagamento de captura manual a authorized, com o
+// prazo da autorização, na mesma transação que registra a entrega
+func (s *paymentService) holdAuthorization(ctx context.Context, payment *model.Payment, response *model.AcquirerResponse, delivery *model.MessageDelivery, attempts int) error {
+	id := payment.ID
+	expiresAt := time.Now().Add(s.authTTL)
+
+	err := s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		if err := tx.RecordAuthorization(ctx, id, expiresAt); err != nil {
+			return err
+		}
+		if err := tx.TransitionStatus(ctx, id, model.PaymentStatusProcessing, model.PaymentStatusAuthorized, nil); err != nil {
+			return err
+		}
+		return recordDelivery(ctx, tx, id, delivery, model.PaymentStatusAuthorized)
+	})
+	if err != nil {
+		// Uma autorização sem o pagamento authorized nunca seria capturada
+		if isCancelled(err) {
+			s.void(ctx, payment, response)
+			s.logger.WithField("payment_id", id).Warn("Payment cancelled during processing, authorization voided")
+			return nil
+		}
+
+		var transitionErr *model.TransitionError
+		if errors.As(err, &transitionErr) {
+			return err
+		}
+		return s.retryOrVoid(ctx, payment, response, delivery, attempts, "Failed to record authorization", fmt.Errorf("failed to record authorization: %w", err))
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"payment_id": id,
+		"expires_at": expiresAt,
+	}).Info("Payment authorized, awaiting capture")
 
-		// Debitar da conta
-		account, err := s.repo.GetAccountByCardNumber(ctx, payment.CardNumber)
+	return nil
+}
+
+// authorize autoriza e captura o pagamento no adquirente e grava a resposta
+// no pagamento. Na captura manual só autoriza. Uma captura recusada ou que
+// falha cancela a autorização
+func (s *paymentService) authorize(ctx context.Context, payment *model.Payment, card *model.Card) (*model.AcquirerResponse, error) {
+	response, err := s.acquirer.Authorize(ctx, &model.AuthorizationRequest{
+		PaymentID:  payment.ID,
+		MerchantID: payment.MerchantID,
+		Amount:     payment.Amount,
+		Card:       card,
+
+		Installments: payment.InstallmentPlan,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize payment: %w", err)
+	}
+
+	if response.Approved && payment.CaptureMethod != model.CaptureManual {
+		capture, err := s.acquirer.Capture(ctx, &model.AcquirerRequest{
+			PaymentID:        payment.ID,
+			Amount:           payment.Amount,
+			NetworkReference: response.NetworkReference,
+		})
 		if err != nil {
-			errorMsg := "Failed to get account for debit"
-			s.repo.UpdateStatus(ctx, id, model.PaymentStatusFailed, &errorMsg)
-			return fmt.Errorf("failed to get account: %w", err)
+			s.void(ctx, payment, response)
+			return nil, fmt.Errorf("failed to capture payment: %w", err)
+		}
+		if !capture.Approved {
+			s.void(ctx, payment, response)
+			response = capture
+		}
+	}
+
+	if err := s.repo.RecordAcquirerResponse(ctx, payment.ID, s.acquirer.Name(), response); err != nil {
+		if response.Approved {
+			s.void(ctx, payment, response)
 		}
+		return nil, fmt.Errorf("failed to record acquirer response: %w", err)
+	}
+
+	return response, nil
+}
+
+// recordedAuthorization reconstrói a autorização aprovada que uma entrega
+// anterior gravou no pagamento, ou retorna nil se não houver uma
+func recordedAuthorization(payment *model.Payment) *model.AcquirerResponse {
+	if !payment.IsAcquirerApproved() || payment.NetworkReference == nil {
+		return nil
+	}
+
+	response := &model.AcquirerResponse{
+		Approved:         true,
+		ResponseCode:     model.ResponseApproved,
+		NetworkReference: *payment.NetworkReference,
+	}
+	if payment.ApprovalCode != nil {
+		response.ApprovalCode = *payment.ApprovalCode
+	}
+	return response
+}
+
+// void cancela no adquirente uma autorização que não vai ser concluída. É
+// feito mesmo com o contexto cancelado; uma falha só é registrada, e a
+// autorização expira no emissor
+func (s *paymentService) void(ctx context.Context, payment *model.Payment, authorization *model.AcquirerResponse) {
+	voidAuthorization(ctx, s.acquirer, s.logger, payment, payment.Amount, authorization.NetworkReference)
+}
+
+// voidAuthorization é o void compartilhado com o AuthorizationExpirer, que só
+// conhece a referência gravada no pagamento. amount é a parte da autorização
+// a desfazer
+func voidAuthorization(ctx context.Context, acquirer gateway.AcquirerGateway, logger *logrus.Logger, payment *model.Payment, amount model.Money, networkReference string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), voidTimeout)
+	defer cancel()
+
+	response, err := acquirer.Void(ctx, &model.AcquirerRequest{
+		PaymentID:        payment.ID,
+		Amount:           amount,
+		NetworkReference: networkReference,
+	})
+	if err == nil && !response.Approved {
+		err = fmt.Errorf("void declined with response code %s", response.ResponseCode)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("payment_id", payment.ID).Error("Failed to void acquirer authorization")
+	}
+}
+
+// retryOrFail trata uma falha transitória. Com tentativas restantes o
+// pagamento fica em processing e o erro é marcado como retentável; na última
+// o pagamento vai para failed e o erro envolve model.ErrRetriesExhausted
+func (s *paymentService) retryOrFail(ctx context.Context, id uuid.UUID, delivery *model.MessageDelivery, attempts int, reason string, cause error) error {
+	fields := logrus.Fields{
+		"payment_id":   id,
+		"attempt":      attempts,
+		"max_attempts": s.maxAttempts,
+	}
+
+	if attempts < s.maxAttempts {
+		s.logger.WithError(cause).WithFields(fields).Warn("Payment processing failed, will retry")
+		return model.Retryable(cause)
+	}
 
-		newBalance := account.Balance - payment.Amount
-		if err := s.repo.UpdateAccountBalance(ctx, payment.CardNumber, newBalance); err != nil {
-			errorMsg := "Failed to update account balance"
-			s.repo.UpdateStatus(ctx, id, model.PaymentStatusFailed, &errorMsg)
-			return fmt.Errorf("failed to update balance: %w", err)
+	if err := s.markFailed(ctx, id, delivery, reason); err != nil {
+		if isCancelled(err) {
+			return nil
 		}
+		return model.Retryable(fmt.Errorf("failed to mark payment as failed: %w", err))
+	}
+
+	s.logger.WithError(cause).WithFields(fields).Warn("Payment failed after exhausting retries")
+	return fmt.Errorf("%w after %d attempts: %v", model.ErrRetriesExhausted, attempts, cause)
+}
+
+// retryOrVoid é o retryOrFail de um pagamento com a autorização aprovada
+// gravada. Com tentativas restantes a autorização é mantida, e a próxima
+// entrega retoma o pagamento a partir dela; só depois de o pagamento ir para
+// failed ela é cancelada. authorization nil é um pagamento ainda sem ela
+func (s *paymentService) retryOrVoid(ctx context.Context, payment *model.Payment, authorization *model.AcquirerResponse, delivery *model.MessageDelivery, attempts int, reason string, cause error) error {
+	err := s.retryOrFail(ctx, payment.ID, delivery, attempts, reason, cause)
+	if authorization != nil && errors.Is(err, model.ErrRetriesExhausted) {
+		s.void(ctx, payment, authorization)
+	}
+	return err
+}
+
+// isTerminalDebitError informa se o débito falhou por um motivo que uma nova
+// tentativa não resolveria
+func isTerminalDebitError(err error) bool {
+	return errors.Is(err, repository.ErrInsufficientFunds) ||
+		errors.Is(err, repository.ErrAccountInactive) ||
+		errors.Is(err, repository.ErrAccountNotFound) ||
+		errors.Is(err, model.ErrCurrencyMismatch)
+}
+
+// recordDelivery grava, na transação do estado final, a mensagem que levou o
+// pagamento até ele
+func recordDelivery(ctx context.Context, tx repository.PaymentRepository, id uuid.UUID, delivery *model.MessageDelivery, outcome model.PaymentStatus) error {
+	if delivery == nil {
+		return nil
+	}
+
+	return tx.RecordProcessedMessage(ctx, &model.ProcessedMessage{
+		PaymentID:   id,
+		Delivery:    *delivery,
+		Outcome:     outcome,
+		ProcessedAt: time.Now(),
+	})
+}
+
+// debitFailureReason traduz o erro do débito na mensagem gravada no pagamento
+func debitFailureReason(err error) string {
+	switch {
+	case errors.Is(err, repository.ErrInsufficientFunds):
+		return "Insufficient funds"
+	case errors.Is(err, repository.ErrAccountInactive):
+		return "Account is inactive"
+	case errors.Is(err, repository.ErrAccountNotFound):
+		return "Failed to get account for debit"
+	case errors.Is(err, model.ErrCurrencyMismatch):
+		return "Account currency does not match payment currency"
+	default:
+		return "Failed to update account balance"
+	}
+}
+
+// debit converte a reserva do pagamento em débito e retorna a conta
+// debitada. Pagamentos criados antes das reservas não têm uma e são
+// debitados diretamente da conta
+func (s *paymentService) debit(ctx context.Context, tx repository.PaymentRepository, payment *model.Payment, card *model.Card) (uuid.UUID, error) {
+	hold, err := tx.CaptureHold(ctx, payment.ID)
+	if err == nil {
+		return hold.AccountID, nil
+	}
+	if !errors.Is(err, repository.ErrHoldNotFound) {
+		return uuid.Nil, err
+	}
+
+	account, err := tx.GetAccountByCardNumber(ctx, card.Number)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if _, err := tx.DebitAccount(ctx, account.ID, payment.Amount); err != nil {
+		return uuid.Nil, err
+	}
+	return account.ID, nil
+}
 
-		// Atualizar status para completado
-		if err := s.repo.UpdateStatus(ctx, id, model.PaymentStatusCompleted, nil); err != nil {
+// markFailed marca como falho um pagamento em processamento e libera a
+// reserva de saldo na mesma transação
+func (s *paymentService) markFailed(ctx context.Context, id uuid.UUID, delivery *model.MessageDelivery, errorMsg string) error {
+	return s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		if err := tx.TransitionStatus(ctx, id, model.PaymentStatusProcessing, model.PaymentStatusFailed, &errorMsg); err != nil {
 			return err
 		}
 
-		s.logger.WithField("payment_id", id).Info("Payment processed successfully")
-	} else {
-		// Simular falha no processamento
-		errorMsg := "Payment processing failed due to external service error"
-		if err := s.repo.UpdateStatus(ctx, id, model.PaymentStatusFailed, &errorMsg); err != nil {
+		if _, err := tx.ReleaseHold(ctx, id, model.HoldStatusReleased); err != nil && !errors.Is(err, repository.ErrHoldNotFound) {
 			return err
 		}
+		return recordDelivery(ctx, tx, id, delivery, model.PaymentStatusFailed)
+	})
+}
 
-		s.logger.WithField("payment_id", id).Warn("Payment processing failed")
+// failProcessing é o markFailed dos caminhos de erro, que apenas registra falhas
+func (s *paymentService) failProcessing(ctx context.Context, id uuid.UUID, delivery *model.MessageDelivery, errorMsg string) {
+	if err := s.markFailed(ctx, id, delivery, errorMsg); err != nil && !isCancelled(err) {
+		s.logger.WithError(err).WithField("payment_id", id).Error("Failed to mark payment as failed")
 	}
+}
 
-	return nil
-} 
\ No newline at end of file
+// failAuthorized marca como falho um pagamento com a autorização aprovada
+// gravada e só então cancela a autorização. Se a marcação falhar a
+// autorização é mantida, porque uma nova entrega retoma o pagamento a partir
+// dela
+func (s *paymentService) failAuthorized(ctx context.Context, payment *model.Payment, authorization *model.AcquirerResponse, delivery *model.MessageDelivery, errorMsg string) {
+	if err := s.markFailed(ctx, payment.ID, delivery, errorMsg); err != nil && !isCancelled(err) {
+		s.logger.WithError(err).WithField("payment_id", payment.ID).Error("Failed to mark payment as failed")
+		return
+	}
+	s.void(ctx, payment, authorization)
+}
+
+// isCancelled informa se uma transição falhou porque o pagamento foi
+// cancelado durante o processamento
+func isCancelled(err error) bool {
+	var transitionErr *model.TransitionError
+	return errors.As(err, &transitionErr) && transitionErr.Current == model.PaymentStatusCancelled
+}
+
+func (s *paymentService) GetAccountHolds(ctx context.Context, accountID uuid.UUID, status model.HoldStatus, limit, offset int) (*model.Account, []*model.Hold, error) {
+	account, err := s.repo.GetAccountByID(ctx, accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	holds, err := s.repo.GetHoldsByAccount(ctx, accountID, status, limit, offset)
+	if err != nil {
+		s.logger.WithError(err).WithField("account_id", accountID).Error("Failed to get account holds")
+		return nil, nil, err
+	}
+
+	return account, holds, nil
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/vault"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+func (s *paymentService) CreateRefund(ctx context.Context, paymentID uuid.UUID, req *model.RefundRequest) (*model.Refund, error) {
+	payment, err := s.repo.GetByID(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	refund := &model.Refund{
+		ID:        uuid.New(),
+		PaymentID: paymentID,
+		Status:    model.RefundStatusPending,
+		Reason:    req.Reason,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if req.Amount != nil {
+		if err := req.Amount.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid amount: %w", err)
+		}
+		refund.Amount = *req.Amount
+	}
+
+	// O estorno e a sua mensagem de processamento são gravados juntos, como
+	// na criação do pagamento. O valor do estorno total só é conhecido com o
+	// pagamento travado, então a mensagem é montada depois do CreateRefund
+	err = s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		if err := tx.CreateRefund(ctx, refund); err != nil {
+			return err
+		}
+
+		message, err := queue.NewRefundOutboxMessage(s.paymentTopic, payment, refund)
+		if err != nil {
+			return err
+		}
+		return tx.EnqueueOutbox(ctx, message)
+	})
+	if err != nil {
+		s.logger.WithError(err).WithField("payment_id", paymentID).Warn("Failed to create refund")
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"payment_id": paymentID,
+		"refund_id":  refund.ID,
+		"amount":     refund.Amount.String(),
+	}).Info("Refund created and queued for processing")
+
+	return refund, nil
+}
+
+func (s *paymentService) GetRefunds(ctx context.Context, paymentID uuid.UUID) ([]*model.Refund, error) {
+	if _, err := s.repo.GetByID(ctx, paymentID); err != nil {
+		return nil, err
+	}
+
+	refunds, err := s.repo.GetRefundsByPayment(ctx, paymentID)
+	if err != nil {
+		s.logger.WithError(err).WithField("payment_id", paymentID).Error("Failed to get refunds")
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+func (s *paymentService) ProcessRefundAsync(ctx context.Context, refundID string, delivery *model.MessageDelivery) error {
+	id, err := uuid.Parse(refundID)
+	if err != nil {
+		return fmt.Errorf("invalid refund ID: %w", err)
+	}
+
+	refund, err := s.repo.GetRefund(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrRefundNotFound) {
+			return err
+		}
+		return model.Retryable(fmt.Errorf("failed to load refund: %w", err))
+	}
+
+	// O estorno só sai de pending uma vez, com um compare-and-set na
+	// transação do crédito, então reentregas depois dele são descartadas
+	if refund.Status.IsTerminal() {
+		s.logger.WithField("refund_id", id).WithField("status", refund.Status).Info("Skipping refund that is no longer pending")
+		return nil
+	}
+
+	attempts, err := s.repo.RecordRefundAttempt(ctx, id)
+	if err != nil {
+		return model.Retryable(fmt.Errorf("failed to record refund attempt: %w", err))
+	}
+
+	// Uma entrega anterior que já teve a resposta do adquirente gravada é
+	// retomada a partir dela: pedir o estorno de novo devolveria o valor duas
+	// vezes
+	recorded := recordedRefund(refund)
+
+	payment, err := s.repo.GetByID(ctx, refund.PaymentID)
+	if err != nil {
+		return s.retryRefund(ctx, refund, recorded, attempts, "Failed to load payment", err)
+	}
+
+	// A conta creditada é a do cartão do pagamento, resolvida antes do
+	// estorno no adquirente
+	card, err := s.cardVault.Detokenize(ctx, payment.MerchantID, payment.CardToken)
+	if err != nil {
+		if errors.Is(err, vault.ErrTokenNotFound) {
+			if recorded != nil && recorded.Approved {
+				s.reconcileRefund(ctx, id, "Failed to resolve card token")
+			} else {
+				s.failRefund(ctx, id, "Failed to resolve card token")
+			}
+			return nil
+		}
+		return s.retryRefund(ctx, refund, recorded, attempts, "Failed to resolve card token",
+			fmt.Errorf("failed to detokenize card: %w", err))
+	}
+
+	response := recorded
+	if response != nil {
+		s.logger.WithFields(logrus.Fields{
+			"refund_id":     id,
+			"response_code": response.ResponseCode,
+		}).Info("Resuming refund from the recorded acquirer response")
+	} else {
+		reference := ""
+		if payment.NetworkReference != nil {
+			reference = *payment.NetworkReference
+		}
+
+		// O ID do estorno vai em todas as tentativas: um pedido que o
+		// adquirente executou sem responder é reconhecido como reenvio
+		response, err = s.acquirer.Refund(ctx, &model.AcquirerRequest{
+			PaymentID:        payment.ID,
+			Amount:           refund.Amount,
+			NetworkReference: reference,
+			Reference:        id.String(),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				s.logger.WithField("refund_id", id).Warn("Refund processing interrupted, left pending for recovery")
+				return ctx.Err()
+			}
+			// Sem resposta no prazo o estorno pode ter sido executado, então
+			// esgotadas as tentativas ele não pode simplesmente ir para failed
+			if errors.Is(err, gateway.ErrTimeout) {
+				return s.retryRefundOr(ctx, refund, model.RefundStatusNeedsReconciliation, attempts,
+					"Refund unanswered by acquirer", fmt.Errorf("failed to refund payment: %w", err))
+			}
+			return s.retryOrFailRefund(ctx, refund, attempts, "Refund failed due to external service error",
+				fmt.Errorf("failed to refund payment: %w", err))
+		}
+
+		if err := s.repo.RecordRefundAcquirerResponse(ctx, id, s.acquirer.Name(), response); err != nil {
+			return s.retryRefund(ctx, refund, response, attempts, "Failed to record acquirer response",
+				fmt.Errorf("failed to record acquirer response: %w", err))
+		}
+	}
+
+	if !response.Approved {
+		reason := "Refund declined"
+		if response.DeclineReason != "" {
+			reason += ": " + response.DeclineReason
+		}
+		s.failRefund(ctx, id, reason)
+		s.logger.WithFields(logrus.Fields{
+			"refund_id":     id,
+			"response_code": response.ResponseCode,
+		}).Warn("Refund declined by acquirer")
+		return nil
+	}
+
+	// Creditar a conta, lançar no razão e concluir o estorno na mesma transação
+	err = s.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+		if err := tx.TransitionRefundStatus(ctx, id, model.RefundStatusSucceeded, nil); err != nil {
+			return err
+		}
+
+		account, err := tx.GetAccountByCardNumber(ctx, card.Number)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreditAccount(ctx, account.ID, refund.Amount); err != nil {
+			return err
+		}
+		return s.postRefund(ctx, tx, payment, refund, account.ID)
+	})
+	if err != nil {
+		// Outra entrega concluiu o estorno antes desta
+		if errors.Is(err, repository.ErrRefundNotPending) {
+			s.logger.WithField("refund_id", id).Warn("Refund concluded by another delivery")
+			return nil
+		}
+		// Sem a conta do cartão o crédito não acontece numa nova tentativa,
+		// mas o valor já foi devolvido no adquirente
+		if errors.Is(err, repository.ErrAccountNotFound) || errors.Is(err, model.ErrCurrencyMismatch) {
+			s.reconcileRefund(ctx, id, debitFailureReason(err))
+			s.logger.WithError(err).WithField("refund_id", id).Error("Refund approved by acquirer but not credited")
+			return nil
+		}
+		return s.retryRefund(ctx, refund, response, attempts, "Failed to credit account", fmt.Errorf("failed to credit account: %w", err))
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"payment_id": payment.ID,
+		"refund_id":  id,
+		"amount":     refund.Amount.String(),
+	}).Info("Refund processed successfully")
+
+	return nil
+}
+
+// retryOrFailRefund é o retryOrFail dos estornos: com tentativas restantes o
+// estorno fica pendente e o erro é marcado como retentável; na última ele
+// vai para failed
+func (s *paymentService) retryOrFailRefund(ctx context.Context, refund *model.Refund, attempts int, reason string, cause error) error {
+	return s.retryRefundOr(ctx, refund, model.RefundStatusFailed, attempts, reason, cause)
+}
+
+// retryRefund é o retryOrFailRefund de quem já tem a resposta do adquirente:
+// esgotadas as tentativas, um estorno aprovado vai para needs_reconciliation
+// em vez de failed, porque o valor já foi devolvido no emissor
+func (s *paymentService) retryRefund(ctx context.Context, refund *model.Refund, response *model.AcquirerResponse, attempts int, reason string, cause error) error {
+	status := model.RefundStatusFailed
+	if response != nil && response.Approved {
+		status = model.RefundStatusNeedsReconciliation
+	}
+	return s.retryRefundOr(ctx, refund, status, attempts, reason, cause)
+}
+
+// retryRefundOr mantém o estorno pendente enquanto houver tentativas e, na
+// última, o leva a status
+func (s *paymentService) retryRefundOr(ctx context.Context, refund *model.Refund, status model.RefundStatus, attempts int, reason string, cause error) error {
+	fields := logrus.Fields{
+		"refund_id":    refund.ID,
+		"payment_id":   refund.PaymentID,
+		"attempt":      attempts,
+		"max_attempts": s.maxAttempts,
+	}
+
+	if attempts < s.maxAttempts {
+		s.logger.WithError(cause).WithFields(fields).Warn("Refund processing failed, will retry")
+		return model.Retryable(cause)
+	}
+
+	err := s.repo.TransitionRefundStatus(ctx, refund.ID, status, &reason)
+	if err != nil && !errors.Is(err, repository.ErrRefundNotPending) {
+		return model.Retryable(fmt.Errorf("failed to mark refund as %s: %w", status, err))
+	}
+
+	s.logger.WithError(cause).WithFields(fields).WithField("status", status).Warn("Refund stopped after exhausting retries")
+	return fmt.Errorf("%w after %d attempts: %v", model.ErrRetriesExhausted, attempts, cause)
+}
+
+// failRefund marca o estorno como falho, apenas registrando falhas
+func (s *paymentService) failRefund(ctx context.Context, id uuid.UUID, reason string) {
+	err := s.repo.TransitionRefundStatus(ctx, id, model.RefundStatusFailed, &reason)
+	if err != nil && !errors.Is(err, repository.ErrRefundNotPending) {
+		s.logger.WithError(err).WithField("refund_id", id).Error("Failed to mark refund as failed")
+		return
+	}
+	s.logger.WithField("refund_id", id).WithField("reason", reason).Warn("Refund failed")
+}
+
+// reconcileRefund leva a needs_reconciliation o estorno aprovado no adquirente
+// que não pode ser creditado, apenas registrando falhas
+func (s *paymentService) reconcileRefund(ctx context.Context, id uuid.UUID, reason string) {
+	err := s.repo.TransitionRefundStatus(ctx, id, model.RefundStatusNeedsReconciliation, &reason)
+	if err != nil && !errors.Is(err, repository.ErrRefundNotPending) {
+		s.logger.WithError(err).WithField("refund_id", id).Error("Failed to mark refund for reconciliation")
+		return
+	}
+	s.logger.WithField("refund_id", id).WithField("reason", reason).Error("Refund approved by acquirer needs reconciliation")
+}
+
+// recordedRefund reconstrói a resposta do adquirente que uma entrega anterior
+// gravou no estorno, ou retorna nil se não houver uma
+func recordedRefund(refund *model.Refund) *model.AcquirerResponse {
+	if refund.ResponseCode == nil {
+		return nil
+	}
+
+	response := &model.AcquirerResponse{
+		Approved:     refund.IsAcquirerApproved(),
+		ResponseCode: model.AcquirerResponseCode(*refund.ResponseCode),
+	}
+	if refund.NetworkReference != nil {
+		response.NetworkReference = *refund.NetworkReference
+	}
+	return response
+}
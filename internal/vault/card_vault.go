@@ -0,0 +1,119 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-payment-microservice/internal/kms"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+)
+
+var ErrTokenNotFound = errors.New("card token not found")
+
+const tokenPrefix = "tok_"
+
+// CardVault guarda os dados sensíveis do cartão e emite tokens opacos. Cada
+// token pertence a um merchant; o CVV não passa pelo cofre, fica só em
+// memória por pagamento no CVVCache
+type CardVault interface {
+	// Tokenize cifra o PAN e retorna o token do cartão para o merchant,
+	// reaproveitando o que ele já tiver para o mesmo cartão
+	Tokenize(ctx context.Context, merchantID string, card *model.Card) (*model.VaultedCard, error)
+	// Detokenize retorna o cartão do token, com o fingerprint e sem o CVV. Um
+	// token de outro merchant é tratado como inexistente
+	Detokenize(ctx context.Context, merchantID, token string) (*model.Card, error)
+}
+
+type cardVault struct {
+	repo repository.CardRepository
+	keys kms.KeyManager
+}
+
+func NewCardVault(repo repository.CardRepository, keys kms.KeyManager) CardVault {
+	return &cardVault{
+		repo: repo,
+		keys: keys,
+	}
+}
+
+func (v *cardVault) Tokenize(ctx context.Context, merchantID string, card *model.Card) (*model.VaultedCard, error) {
+	fingerprint := v.keys.BlindIndex(card.Number)
+
+	// Reutilizar o token se o merchant já tiver o mesmo cartão no cofre
+	existing, err := v.repo.GetCardByFingerprint(ctx, merchantID, fingerprint, card.ExpiryMonth, card.ExpiryYear)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, repository.ErrCardNotFound) {
+		return nil, fmt.Errorf("failed to look up card: %w", err)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// O token é usado como dado associado, impedindo que um ciphertext seja
+	// trocado entre tokens
+	ciphertext, err := v.keys.Encrypt(ctx, []byte(card.Number), []byte(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt card: %w", err)
+	}
+
+	vaulted := &model.VaultedCard{
+		Token:          token,
+		MerchantID:     merchantID,
+		PANCiphertext:  ciphertext.Data,
+		PANKeyID:       ciphertext.KeyID,
+		PANFingerprint: fingerprint,
+		BIN:            card.BIN(),
+		Last4:          card.Last4(),
+		ExpiryMonth:    card.ExpiryMonth,
+		ExpiryYear:     card.ExpiryYear,
+		CreatedAt:      time.Now(),
+	}
+
+	if err := v.repo.SaveCard(ctx, vaulted); err != nil {
+		return nil, fmt.Errorf("failed to save card: %w", err)
+	}
+
+	return vaulted, nil
+}
+
+func (v *cardVault) Detokenize(ctx context.Context, merchantID, token string) (*model.Card, error) {
+	vaulted, err := v.repo.GetCardByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, repository.ErrCardNotFound) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	if vaulted.MerchantID != merchantID {
+		return nil, ErrTokenNotFound
+	}
+
+	pan, err := v.keys.Decrypt(ctx, kms.Ciphertext{KeyID: vaulted.PANKeyID, Data: vaulted.PANCiphertext}, []byte(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt card: %w", err)
+	}
+
+	return &model.Card{
+		Number:      string(pan),
+		ExpiryMonth: vaulted.ExpiryMonth,
+		ExpiryYear:  vaulted.ExpiryYear,
+		Fingerprint: vaulted.PANFingerprint,
+	}, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return tokenPrefix + hex.EncodeToString(b), nil
+}
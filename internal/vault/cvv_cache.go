@@ -0,0 +1,76 @@
+package vault
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CVVCache retém o CVV de cada pagamento apenas na memória do processo, até
+// a autorização. O CVV nunca é gravado no banco nem na mensagem do pagamento:
+// some quando o pagamento chega a um desfecho ou quando vence. Uma réplica
+// que não recebeu o pagamento não o vê, e a autorização segue sem CVV
+type CVVCache struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	cvvs map[uuid.UUID]cvvEntry
+}
+
+type cvvEntry struct {
+	cvv       string
+	expiresAt time.Time
+}
+
+func NewCVVCache(ttl time.Duration) *CVVCache {
+	return &CVVCache{
+		ttl:  ttl,
+		cvvs: make(map[uuid.UUID]cvvEntry),
+	}
+}
+
+// Hold retém o CVV do pagamento pelo TTL do cache
+func (c *CVVCache) Hold(paymentID uuid.UUID, cvv string) {
+	if cvv == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purgeExpiredLocked()
+	c.cvvs[paymentID] = cvvEntry{cvv: cvv, expiresAt: time.Now().Add(c.ttl)}
+}
+
+// Get retorna o CVV retido do pagamento, ou vazio se não houver um ou se ele
+// já tiver vencido
+func (c *CVVCache) Get(paymentID uuid.UUID) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cvvs[paymentID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(c.cvvs, paymentID)
+		return ""
+	}
+
+	return entry.cvv
+}
+
+// Release descarta o CVV do pagamento
+func (c *CVVCache) Release(paymentID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.cvvs, paymentID)
+}
+
+func (c *CVVCache) purgeExpiredLocked() {
+	now := time.Now()
+	for paymentID, entry := range c.cvvs {
+		if now.After(entry.expiresAt) {
+			delete(c.cvvs, paymentID)
+		}
+	}
+}
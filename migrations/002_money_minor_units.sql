@@ -0,0 +1,38 @@
+-- Valores monetários passam a ser armazenados em unidades menores (inteiros),
+-- respeitando o expoente ISO 4217 de cada moeda
+
+-- Expoente (casas decimais) de cada moeda suportada
+CREATE OR REPLACE FUNCTION currency_exponent(code VARCHAR)
+RETURNS INTEGER AS $$
+BEGIN
+    RETURN CASE code
+        WHEN 'JPY' THEN 0
+        WHEN 'KRW' THEN 0
+        WHEN 'CLP' THEN 0
+        WHEN 'PYG' THEN 0
+        WHEN 'BHD' THEN 3
+        WHEN 'KWD' THEN 3
+        WHEN 'JOD' THEN 3
+        WHEN 'OMR' THEN 3
+        WHEN 'TND' THEN 3
+        ELSE 2
+    END;
+END;
+$$ LANGUAGE 'plpgsql' IMMUTABLE;
+
+-- Pagamentos
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_amount_check;
+ALTER TABLE payments
+    ALTER COLUMN amount TYPE BIGINT
+    USING ROUND(amount * POWER(10, currency_exponent(currency)))::BIGINT;
+ALTER TABLE payments ADD CONSTRAINT payments_amount_check CHECK (amount > 0);
+
+-- Contas
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'BRL';
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_balance_check;
+ALTER TABLE accounts ALTER COLUMN balance DROP DEFAULT;
+ALTER TABLE accounts
+    ALTER COLUMN balance TYPE BIGINT
+    USING ROUND(balance * POWER(10, currency_exponent(currency)))::BIGINT;
+ALTER TABLE accounts ALTER COLUMN balance SET DEFAULT 0;
+ALTER TABLE accounts ADD CONSTRAINT accounts_balance_check CHECK (balance >= 0);
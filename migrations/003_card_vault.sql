@@ -0,0 +1,41 @@
+-- Cofre de cartões: o PAN é armazenado cifrado e referenciado por token;
+-- o CVV nunca é persistido
+
+CREATE TABLE IF NOT EXISTS card_tokens (
+    token VARCHAR(64) PRIMARY KEY,
+    pan_ciphertext BYTEA NOT NULL,
+    pan_fingerprint BYTEA NOT NULL,
+    bin VARCHAR(8) NOT NULL,
+    last4 VARCHAR(4) NOT NULL,
+    expiry_month INTEGER NOT NULL CHECK (expiry_month >= 1 AND expiry_month <= 12),
+    expiry_year INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_card_tokens_fingerprint ON card_tokens(pan_fingerprint);
+
+-- Pagamentos passam a guardar apenas token, BIN e últimos 4 dígitos
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS card_token VARCHAR(64) REFERENCES card_tokens(token);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS card_bin VARCHAR(8);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS card_last4 VARCHAR(4);
+
+UPDATE payments
+SET card_bin = LEFT(card_number, 6),
+    card_last4 = RIGHT(card_number, 4)
+WHERE card_bin IS NULL;
+
+ALTER TABLE payments ALTER COLUMN card_bin SET NOT NULL;
+ALTER TABLE payments ALTER COLUMN card_last4 SET NOT NULL;
+
+-- Pagamentos antigos ainda em aberto não têm token e não podem mais ser processados
+UPDATE payments
+SET status = 'failed',
+    error_msg = 'Card data purged during card vault migration',
+    processed_at = NOW()
+WHERE status IN ('pending', 'processing');
+
+DROP INDEX IF EXISTS idx_payments_card_number;
+ALTER TABLE payments DROP COLUMN IF EXISTS cvv;
+ALTER TABLE payments DROP COLUMN IF EXISTS card_number;
+
+CREATE INDEX IF NOT EXISTS idx_payments_card_token ON payments(card_token);
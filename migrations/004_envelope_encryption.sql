@@ -0,0 +1,44 @@
+-- Criptografia de envelope: colunas sensíveis são cifradas com chaves de dados
+-- versionadas, e as chaves de dados são cifradas pela chave mestra do keyfile.
+-- O ID da chave fica ao lado de cada ciphertext (<coluna>_key_id) e as buscas
+-- por igualdade usam um hash com chave (blind index).
+
+CREATE TABLE IF NOT EXISTS data_keys (
+    id VARCHAR(32) PRIMARY KEY,
+    version INTEGER NOT NULL UNIQUE,
+    wrapped_key BYTEA NOT NULL,
+    master_key_id VARCHAR(64) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- No máximo uma chave de dados ativa
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_keys_active ON data_keys(active) WHERE active;
+
+-- Pagamentos: nome do portador cifrado. A coluna em texto claro é mantida
+-- apenas até o job de recifragem migrar as linhas existentes
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS card_holder_ciphertext BYTEA;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS card_holder_key_id VARCHAR(32);
+ALTER TABLE payments ALTER COLUMN card_holder DROP NOT NULL;
+ALTER TABLE payments ADD CONSTRAINT payments_card_holder_present
+    CHECK (card_holder IS NOT NULL OR card_holder_ciphertext IS NOT NULL);
+
+-- Contas: o PAN deixa de ser a chave primária e passa a ser cifrado, com
+-- busca pelo blind index
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS id UUID NOT NULL DEFAULT uuid_generate_v4();
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_pkey;
+ALTER TABLE accounts ADD PRIMARY KEY (id);
+ALTER TABLE accounts ALTER COLUMN card_number DROP NOT NULL;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS card_number_ciphertext BYTEA;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS card_number_key_id VARCHAR(32);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS card_number_hash BYTEA;
+ALTER TABLE accounts ADD CONSTRAINT accounts_card_number_present
+    CHECK (card_number IS NOT NULL OR card_number_ciphertext IS NOT NULL);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_card_number_hash ON accounts(card_number_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_card_number ON accounts(card_number) WHERE card_number IS NOT NULL;
+
+-- Cofre: PANs cifrados antes desta migração usam a chave legada do cofre
+-- (pan_key_id nulo) e são recifrados pelo job. O pan_fingerprint passa a ser
+-- o blind index do PAN e é recalculado na recifragem
+ALTER TABLE card_tokens ADD COLUMN IF NOT EXISTS pan_key_id VARCHAR(32);
@@ -0,0 +1,75 @@
+-- Validação de cartão por bandeira: PANs de 13 a 19 dígitos e CVV de 3 ou 4.
+-- As colunas card_number/cvv de payments já foram removidas pelo cofre (003);
+-- restam as colunas de contas, que passam a aceitar PANs de até 19 dígitos
+
+ALTER TABLE accounts ALTER COLUMN card_number TYPE VARCHAR(19);
+
+-- Bandeira detectada pelo BIN no momento do pagamento
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS card_brand VARCHAR(20);
+
+-- Preencher a bandeira de pagamentos antigos a partir do BIN guardado. As
+-- faixas são as de internal/cardvalidation/brand.go, na mesma ordem: as mais
+-- específicas (Elo, Hipercard) vencem as genéricas com que se sobrepõem
+UPDATE payments p SET card_brand = (
+    SELECT r.brand
+    FROM (VALUES
+        (1, '401178', '401179', 'elo'),
+        (2, '431274', '431274', 'elo'),
+        (3, '438935', '438935', 'elo'),
+        (4, '451416', '451416', 'elo'),
+        (5, '457393', '457393', 'elo'),
+        (6, '457631', '457632', 'elo'),
+        (7, '504175', '504175', 'elo'),
+        (8, '506699', '506778', 'elo'),
+        (9, '509000', '509999', 'elo'),
+        (10, '627780', '627780', 'elo'),
+        (11, '636297', '636297', 'elo'),
+        (12, '636368', '636368', 'elo'),
+        (13, '650031', '650033', 'elo'),
+        (14, '650035', '650051', 'elo'),
+        (15, '650405', '650439', 'elo'),
+        (16, '650485', '650538', 'elo'),
+        (17, '650541', '650598', 'elo'),
+        (18, '650700', '650718', 'elo'),
+        (19, '650720', '650727', 'elo'),
+        (20, '650901', '650978', 'elo'),
+        (21, '651652', '651679', 'elo'),
+        (22, '655000', '655019', 'elo'),
+        (23, '655021', '655058', 'elo'),
+        (24, '384100', '384100', 'hipercard'),
+        (25, '384140', '384140', 'hipercard'),
+        (26, '384160', '384160', 'hipercard'),
+        (27, '606282', '606282', 'hipercard'),
+        (28, '637095', '637095', 'hipercard'),
+        (29, '637568', '637568', 'hipercard'),
+        (30, '637599', '637599', 'hipercard'),
+        (31, '637609', '637609', 'hipercard'),
+        (32, '637612', '637612', 'hipercard'),
+        (33, '34', '34', 'amex'),
+        (34, '37', '37', 'amex'),
+        (35, '300', '305', 'diners'),
+        (36, '3095', '3095', 'diners'),
+        (37, '36', '36', 'diners'),
+        (38, '38', '39', 'diners'),
+        (39, '51', '55', 'mastercard'),
+        (40, '2221', '2720', 'mastercard'),
+        (41, '4', '4', 'visa')
+    ) AS r(priority, low, high, brand)
+    WHERE length(p.card_bin) >= length(r.low)
+      AND substring(p.card_bin, 1, length(r.low)) BETWEEN r.low AND r.high
+    ORDER BY r.priority
+    LIMIT 1
+)
+WHERE card_brand IS NULL AND card_bin IS NOT NULL;
+
+-- Contas de teste com números que passam no Luhn, uma por bandeira. As contas
+-- antigas (1234567890123456...) não passam na validação e ficam só como
+-- histórico. O job de recifragem cifra e indexa os novos números
+INSERT INTO accounts (card_number, balance, currency, is_active) VALUES
+    ('4111111111111111', 100000, 'BRL', true),
+    ('5555555555554444', 50000, 'BRL', true),
+    ('378282246310005', 200000, 'BRL', true),
+    ('6362970000457013', 10000, 'BRL', true),
+    ('6062825624254001', 0, 'BRL', true),
+    ('36490102462661', 500000, 'BRL', false)
+ON CONFLICT DO NOTHING;
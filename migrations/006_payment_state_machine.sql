@@ -0,0 +1,21 @@
+-- Máquina de estados de pagamentos: cada fase tem o próprio timestamp e
+-- processed_at passa a indicar apenas a chegada a um estado final
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS processing_started_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS failed_at TIMESTAMP WITH TIME ZONE;
+
+-- Preencher as fases de pagamentos existentes a partir do processed_at
+UPDATE payments SET completed_at = processed_at
+WHERE status = 'completed' AND completed_at IS NULL;
+
+UPDATE payments SET failed_at = processed_at
+WHERE status = 'failed' AND failed_at IS NULL;
+
+-- Pagamentos não finalizados não têm processed_at; o valor antigo era apenas
+-- o horário da última atualização de status
+UPDATE payments SET processing_started_at = processed_at, processed_at = NULL
+WHERE status = 'processing';
+
+UPDATE payments SET processed_at = NULL
+WHERE status = 'pending';
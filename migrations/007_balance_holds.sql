@@ -0,0 +1,35 @@
+-- Reservas de saldo: o valor de um pagamento fica reservado no saldo
+-- disponível desde a criação até a conclusão, falha, cancelamento ou expiração.
+-- accounts.balance continua sendo o saldo contábil
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS available_balance BIGINT;
+UPDATE accounts SET available_balance = balance WHERE available_balance IS NULL;
+ALTER TABLE accounts ALTER COLUMN available_balance SET NOT NULL;
+ALTER TABLE accounts ALTER COLUMN available_balance SET DEFAULT 0;
+ALTER TABLE accounts ADD CONSTRAINT accounts_available_balance_check
+    CHECK (available_balance >= 0 AND available_balance <= balance);
+
+CREATE TABLE IF NOT EXISTS holds (
+    id UUID PRIMARY KEY,
+    account_id UUID NOT NULL REFERENCES accounts(id),
+    payment_id UUID NOT NULL REFERENCES payments(id),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'captured', 'released', 'expired')),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    released_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Um pagamento tem no máximo uma reserva ativa
+CREATE UNIQUE INDEX IF NOT EXISTS idx_holds_active_payment ON holds(payment_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_holds_account_id ON holds(account_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_holds_active_expires_at ON holds(expires_at) WHERE status = 'active';
+
+CREATE TRIGGER update_holds_updated_at BEFORE UPDATE ON holds
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Pagamentos pendentes criados antes desta migração não têm reserva e são
+-- debitados diretamente da conta no processamento
@@ -0,0 +1,125 @@
+-- Razão de partidas dobradas: cada movimentação de saldo gera um lançamento
+-- imutável cujas pernas somam zero. accounts.balance é conferido contra a
+-- conta de portador correspondente pelo verificador de invariantes
+
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id UUID PRIMARY KEY,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('cardholder', 'merchant', 'fees', 'suspense')),
+    owner_id VARCHAR(100) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    balance BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (type, owner_id, currency)
+);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id UUID PRIMARY KEY,
+    kind VARCHAR(30) NOT NULL
+        CHECK (kind IN ('opening_balance', 'payment_completed', 'refund', 'settlement')),
+    payment_id UUID REFERENCES payments(id),
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id UUID PRIMARY KEY,
+    entry_id UUID NOT NULL REFERENCES journal_entries(id),
+    ledger_account_id UUID NOT NULL REFERENCES ledger_accounts(id),
+    direction VARCHAR(6) NOT NULL CHECK (direction IN ('debit', 'credit')),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_journal_entries_payment_id ON journal_entries(payment_id);
+CREATE INDEX IF NOT EXISTS idx_postings_entry_id ON postings(entry_id);
+CREATE INDEX IF NOT EXISTS idx_postings_ledger_account_id ON postings(ledger_account_id, created_at);
+
+CREATE TRIGGER update_ledger_accounts_updated_at BEFORE UPDATE ON ledger_accounts
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Um lançamento desbalanceado não chega ao commit. A verificação é adiada
+-- para o fim da transação porque as pernas são inseridas uma a uma
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced()
+RETURNS TRIGGER AS $$
+DECLARE
+    total BIGINT;
+BEGIN
+    SELECT COALESCE(SUM(CASE direction WHEN 'credit' THEN amount ELSE -amount END), 0)
+    INTO total
+    FROM postings
+    WHERE entry_id = NEW.entry_id;
+
+    IF total <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is unbalanced by %', NEW.entry_id, total;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE CONSTRAINT TRIGGER check_postings_balanced
+    AFTER INSERT ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_journal_entry_balanced();
+
+-- Lançamentos são imutáveis: correções entram como novos lançamentos
+CREATE OR REPLACE FUNCTION reject_ledger_mutation()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER postings_append_only BEFORE UPDATE OR DELETE ON postings
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+CREATE TRIGGER journal_entries_append_only BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+-- Saldos de abertura: o saldo atual de cada conta ainda sem conta de
+-- portador entra no razão como crédito nela contra a suspense do sistema.
+-- Migrações que criam contas chamam a mesma função
+CREATE OR REPLACE FUNCTION post_opening_balances()
+RETURNS VOID AS $$
+DECLARE
+    acc RECORD;
+    cardholder_id UUID;
+    suspense_id UUID;
+    entry_id UUID;
+BEGIN
+    FOR acc IN
+        SELECT a.id, a.balance, a.currency
+        FROM accounts a
+        WHERE NOT EXISTS (
+            SELECT 1 FROM ledger_accounts la
+            WHERE la.type = 'cardholder' AND la.owner_id = a.id::text AND la.currency = a.currency
+        )
+    LOOP
+        cardholder_id := uuid_generate_v4();
+        INSERT INTO ledger_accounts (id, type, owner_id, currency, balance)
+        VALUES (cardholder_id, 'cardholder', acc.id::text, acc.currency, acc.balance);
+
+        CONTINUE WHEN acc.balance = 0;
+
+        INSERT INTO ledger_accounts (id, type, owner_id, currency)
+        VALUES (uuid_generate_v4(), 'suspense', 'system', acc.currency)
+        ON CONFLICT (type, owner_id, currency) DO NOTHING;
+
+        UPDATE ledger_accounts SET balance = balance - acc.balance
+        WHERE type = 'suspense' AND owner_id = 'system' AND currency = acc.currency
+        RETURNING id INTO suspense_id;
+
+        entry_id := uuid_generate_v4();
+        INSERT INTO journal_entries (id, kind, description)
+        VALUES (entry_id, 'opening_balance', 'opening balance for account ' || acc.id);
+
+        INSERT INTO postings (id, entry_id, ledger_account_id, direction, amount, currency) VALUES
+            (uuid_generate_v4(), entry_id, suspense_id, 'debit', acc.balance, acc.currency),
+            (uuid_generate_v4(), entry_id, cardholder_id, 'credit', acc.balance, acc.currency);
+    END LOOP;
+END;
+$$ language 'plpgsql';
+
+SELECT post_opening_balances();
@@ -0,0 +1,16 @@
+-- Idempotency-Keys de POST /api/v1/payments, isoladas por merchant. A linha é
+-- criada quando a requisição começa (completed_at nulo = em andamento) e
+-- recebe a resposta quando ela termina
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    merchant_id VARCHAR(100) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    response_status INTEGER,
+    response_body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (merchant_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
@@ -0,0 +1,12 @@
+-- Mensagens do Kafka já aplicadas, por pagamento e posição no tópico. A linha
+-- é gravada na mesma transação que leva o pagamento ao estado final, então o
+-- consumidor reconhece reentregas após crash ou rebalanceamento
+CREATE TABLE IF NOT EXISTS processed_messages (
+    payment_id UUID NOT NULL REFERENCES payments(id),
+    topic VARCHAR(255) NOT NULL,
+    kafka_partition INTEGER NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    processed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (payment_id, topic, kafka_partition, kafka_offset)
+);
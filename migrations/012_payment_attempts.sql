@@ -0,0 +1,4 @@
+-- Tentativas de processamento de cada pagamento. Falhas transitórias são
+-- repetidas pelos tópicos de retry e o pagamento só vai para failed quando as
+-- tentativas se esgotam
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
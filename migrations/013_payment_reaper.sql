@@ -0,0 +1,20 @@
+-- Reenvios feitos pelo reaper a pagamentos parados em pending ou processing.
+-- Esgotado o limite, o pagamento é cancelado ou falha
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS requeues INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_payments_stuck ON payments(status, updated_at)
+    WHERE status IN ('pending', 'processing');
+
+-- Histórico de ações sobre cada pagamento, com quem as executou e o motivo
+CREATE TABLE IF NOT EXISTS payment_events (
+    id BIGSERIAL PRIMARY KEY,
+    payment_id UUID NOT NULL REFERENCES payments(id),
+    event_type VARCHAR(30) NOT NULL,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_events_payment_id ON payment_events(payment_id, id);
@@ -0,0 +1,7 @@
+-- Resposta do adquirente à autorização do pagamento: o gateway usado, o
+-- código de resposta, o código de aprovação do emissor e a referência da
+-- transação na rede, usada para capturar, cancelar ou estornar depois
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS acquirer VARCHAR(50);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS response_code VARCHAR(4);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS approval_code VARCHAR(12);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS network_reference VARCHAR(64);
@@ -0,0 +1,16 @@
+-- Contas dos cartões mágicos do sandbox (ACQUIRER_GATEWAY=sandbox), para que
+-- cada resultado do adquirente possa ser testado de ponta a ponta. O resultado
+-- vem do PAN, não do saldo: todas começam com R$ 1.000,00 disponíveis. O job
+-- de recifragem cifra e indexa os números
+INSERT INTO accounts (card_number, balance, available_balance, currency, is_active) VALUES
+    ('4242424242424242', 100000, 100000, 'BRL', true),
+    ('4000000000009995', 100000, 100000, 'BRL', true),
+    ('4000000000009979', 100000, 100000, 'BRL', true),
+    ('4000000000000002', 100000, 100000, 'BRL', true),
+    ('4000000000000069', 100000, 100000, 'BRL', true),
+    ('4000000000003220', 100000, 100000, 'BRL', true),
+    ('4000000000000119', 100000, 100000, 'BRL', true)
+ON CONFLICT DO NOTHING;
+
+-- Saldos de abertura das contas novas, pela função criada em 008
+SELECT post_opening_balances();
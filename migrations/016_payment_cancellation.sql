@@ -0,0 +1,8 @@
+-- Cancelamento de pagamentos pela API: o cancelamento ganha o próprio
+-- timestamp, como as outras fases, e pode acontecer também em processing
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP WITH TIME ZONE;
+
+-- Pagamentos já cancelados pelo reaper
+UPDATE payments SET cancelled_at = processed_at
+WHERE status = 'cancelled' AND cancelled_at IS NULL;
@@ -0,0 +1,24 @@
+-- Estornos de pagamentos concluídos, totais ou parciais. Cada estorno tem o
+-- próprio ciclo de vida e é processado pelo consumidor do Kafka, como os
+-- pagamentos. A soma dos estornos pendentes e concluídos de um pagamento
+-- nunca passa do valor capturado; a conferência é feita com o pagamento
+-- travado, na transação que cria o estorno
+CREATE TABLE IF NOT EXISTS refunds (
+    id UUID PRIMARY KEY,
+    payment_id UUID NOT NULL REFERENCES payments(id),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'succeeded', 'failed')),
+    reason TEXT,
+    error_msg TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    acquirer VARCHAR(50),
+    response_code VARCHAR(4),
+    network_reference VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    processed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_payment_id ON refunds(payment_id, created_at);
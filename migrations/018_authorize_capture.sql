@@ -0,0 +1,21 @@
+-- Autorização e captura em duas fases: com capture_method manual o pagamento
+-- para em authorized, com a reserva de saldo mantida até a captura, o
+-- cancelamento (voided) ou o fim de authorization_expires_at. A captura pode
+-- ser parcial; captured_amount guarda o valor capturado e limita os estornos
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'processing', 'authorized', 'completed', 'failed', 'cancelled', 'voided'));
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS capture_method VARCHAR(10) NOT NULL DEFAULT 'automatic'
+    CHECK (capture_method IN ('automatic', 'manual'));
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS captured_amount BIGINT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS authorized_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS authorization_expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS voided_at TIMESTAMP WITH TIME ZONE;
+
+-- Pagamentos já concluídos foram capturados pelo valor inteiro
+UPDATE payments SET captured_amount = amount
+WHERE status = 'completed' AND captured_amount IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_payments_authorization_expiry ON payments(authorization_expires_at)
+    WHERE status = 'authorized';
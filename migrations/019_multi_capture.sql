@@ -0,0 +1,45 @@
+-- Autorização incremental e múltiplas capturas. authorized_amount é o total
+-- autorizado, que cresce com os incrementos; captured_amount passa a ser a
+-- soma das capturas em payment_captures e refunded_amount a soma dos estornos
+-- concluídos. Os totais nunca se invertem: reembolsado <= capturado <=
+-- autorizado
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS authorized_amount BIGINT;
+UPDATE payments SET authorized_amount = amount WHERE authorized_amount IS NULL;
+ALTER TABLE payments ALTER COLUMN authorized_amount SET NOT NULL;
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_amount BIGINT NOT NULL DEFAULT 0;
+UPDATE payments p SET refunded_amount = r.total
+FROM (
+    SELECT payment_id, SUM(amount) AS total
+    FROM refunds
+    WHERE status = 'succeeded'
+    GROUP BY payment_id
+) r
+WHERE p.id = r.payment_id;
+
+ALTER TABLE payments ADD CONSTRAINT payments_totals_check
+    CHECK (COALESCE(captured_amount, 0) <= authorized_amount
+        AND refunded_amount <= COALESCE(captured_amount, 0));
+
+-- Cada captura de um pagamento, parcial ou final
+CREATE TABLE IF NOT EXISTS payment_captures (
+    id UUID PRIMARY KEY,
+    payment_id UUID NOT NULL REFERENCES payments(id),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL,
+    final BOOLEAN NOT NULL DEFAULT TRUE,
+    acquirer VARCHAR(50),
+    response_code VARCHAR(4),
+    network_reference VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_captures_payment_id ON payment_captures(payment_id, created_at);
+
+-- Pagamentos já concluídos tiveram uma única captura
+INSERT INTO payment_captures (id, payment_id, amount, currency, final, acquirer, response_code, network_reference, created_at)
+SELECT uuid_generate_v4(), id, captured_amount, currency, TRUE, acquirer, response_code, network_reference,
+    COALESCE(completed_at, processed_at, updated_at)
+FROM payments p
+WHERE captured_amount IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM payment_captures c WHERE c.payment_id = p.id);
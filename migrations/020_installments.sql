@@ -0,0 +1,18 @@
+-- Parcelamento. O plano calculado na criação (parcelas, juros e vencimentos)
+-- fica com o pagamento; installments é 1 nos pagamentos à vista
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS installments SMALLINT NOT NULL DEFAULT 1
+    CHECK (installments BETWEEN 1 AND 24);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS installment_plan JSONB;
+
+-- Regras de parcelamento por merchant; sem linha, valem as da configuração
+CREATE TABLE IF NOT EXISTS merchant_installment_rules (
+    merchant_id VARCHAR(100) PRIMARY KEY,
+    max_installments SMALLINT NOT NULL CHECK (max_installments BETWEEN 1 AND 24),
+    min_installment_amount BIGINT NOT NULL CHECK (min_installment_amount >= 0),
+    currency VARCHAR(3) NOT NULL DEFAULT 'BRL',
+    interest_free_installments SMALLINT NOT NULL
+        CHECK (interest_free_installments BETWEEN 1 AND max_installments),
+    interest VARCHAR(20) NOT NULL CHECK (interest IN ('issuer', 'merchant')),
+    monthly_rate_bps INTEGER NOT NULL CHECK (monthly_rate_bps BETWEEN 0 AND 10000),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,6 @@
+-- Lease das Idempotency-Keys em andamento. Se a réplica que reservou a chave
+-- morrer antes de gravar a resposta, a mesma requisição pode reservá-la de
+-- novo depois de locked_until, em vez de receber 409 até a chave expirar
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+UPDATE idempotency_keys SET locked_until = created_at WHERE completed_at IS NULL;
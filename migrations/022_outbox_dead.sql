@@ -0,0 +1,9 @@
+-- Mensagens do outbox que esgotaram as tentativas de publicação vão para
+-- 'dead': saem da fila, deixam de segurar as seguintes da mesma chave e
+-- aparecem na métrica outbox_dead_messages até serem reenviadas à mão
+ALTER TABLE outbox DROP CONSTRAINT IF EXISTS outbox_status_check;
+ALTER TABLE outbox ADD CONSTRAINT outbox_status_check
+    CHECK (status IN ('pending', 'sent', 'dead'));
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS dead_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_outbox_dead ON outbox(id) WHERE status = 'dead';
@@ -0,0 +1,15 @@
+-- Heartbeat do processamento. O reaper usava updated_at, mas o trigger
+-- update_updated_at_column o renova em qualquer UPDATE do pagamento (um
+-- estorno, a gravação da resposta do adquirente), o que escondia pagamentos
+-- parados. heartbeat_at só avança na entrada em pending ou processing, em cada
+-- tentativa de processamento e em cada reenvio do reaper
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE payments SET heartbeat_at = COALESCE(updated_at, created_at, NOW()) WHERE heartbeat_at IS NULL;
+
+ALTER TABLE payments ALTER COLUMN heartbeat_at SET DEFAULT NOW();
+ALTER TABLE payments ALTER COLUMN heartbeat_at SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_payments_stuck;
+CREATE INDEX IF NOT EXISTS idx_payments_stuck ON payments(status, heartbeat_at)
+    WHERE status IN ('pending', 'processing');
@@ -0,0 +1,5 @@
+-- STANs do gateway ISO 8583. Um contador em memória repetia STANs e RRNs
+-- entre réplicas no mesmo terminal; a sequência é compartilhada e volta a 1
+-- depois de 999999, como o campo 11
+CREATE SEQUENCE IF NOT EXISTS iso8583_trace_numbers
+    MINVALUE 1 MAXVALUE 999999 CYCLE;
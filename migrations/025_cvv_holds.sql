@@ -0,0 +1,14 @@
+-- CVV retido de cada pagamento até a autorização. Ficava na memória da
+-- réplica que criou o pagamento, indexado pelo token: outra réplica não o
+-- via e dois pagamentos do mesmo cartão trocavam de CVV. Agora é gravado na
+-- transação que cria o pagamento, cifrado com o ID dele como dado associado,
+-- e apagado depois da resposta do adquirente ou ao vencer
+CREATE TABLE IF NOT EXISTS cvv_holds (
+    payment_id UUID PRIMARY KEY REFERENCES payments(id),
+    cvv_ciphertext BYTEA NOT NULL,
+    cvv_key_id VARCHAR(32) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_cvv_holds_expires_at ON cvv_holds(expires_at);
@@ -0,0 +1,17 @@
+-- Tokens de cartão por merchant: um token só vale para o merchant que o
+-- criou, e o mesmo cartão ganha um token diferente em cada merchant. Tokens
+-- existentes ficam com o merchant do primeiro pagamento que os usou; os que
+-- nunca foram usados ficam sem dono e não são mais aceitos
+ALTER TABLE card_tokens ADD COLUMN IF NOT EXISTS merchant_id VARCHAR(100);
+
+UPDATE card_tokens t
+SET merchant_id = (
+    SELECT p.merchant_id FROM payments p
+    WHERE p.card_token = t.token
+    ORDER BY p.created_at
+    LIMIT 1
+)
+WHERE t.merchant_id IS NULL;
+
+DROP INDEX IF EXISTS idx_card_tokens_fingerprint;
+CREATE INDEX IF NOT EXISTS idx_card_tokens_fingerprint ON card_tokens(merchant_id, pan_fingerprint);
@@ -0,0 +1,6 @@
+-- Estornos aprovados no adquirente cujo crédito local não pôde ser concluído.
+-- Iam para failed, o que liberava o valor para um novo estorno e devolvia o
+-- dinheiro duas vezes. needs_reconciliation continua contando no estornável
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_status_check;
+ALTER TABLE refunds ADD CONSTRAINT refunds_status_check
+    CHECK (status IN ('pending', 'succeeded', 'failed', 'needs_reconciliation'));
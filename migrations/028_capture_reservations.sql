@@ -0,0 +1,10 @@
+-- Capturas reservadas antes do adquirente. A captura manual chamava o
+-- adquirente antes de conferir o pagamento e, se a transação local falhasse,
+-- desfazia com um void o que já estava capturado. Agora a captura é gravada
+-- pending com o pagamento travado, e só depois vai ao adquirente. Cada
+-- pagamento tem no máximo uma captura pending
+ALTER TABLE payment_captures ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'succeeded'
+    CHECK (status IN ('pending', 'succeeded', 'failed'));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_captures_pending ON payment_captures(payment_id)
+    WHERE status = 'pending';
@@ -0,0 +1,4 @@
+-- O CVV deixa de ser gravado: fica só na memória da réplica que recebeu o
+-- pagamento até a autorização. A tabela e os CVVs que ainda estiverem nela
+-- são apagados
+DROP TABLE IF EXISTS cvv_holds;
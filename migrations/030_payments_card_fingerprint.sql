@@ -0,0 +1,10 @@
+-- Blind index do PAN no pagamento. Os tokens são por merchant, então o mesmo
+-- cartão em dois merchants tinha chaves diferentes no Kafka e no pool de
+-- workers, e seus pagamentos não eram processados em série. O índice é o
+-- mesmo do cofre, copiado do token nos pagamentos existentes
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS card_fingerprint BYTEA;
+
+UPDATE payments p
+SET card_fingerprint = c.pan_fingerprint
+FROM card_tokens c
+WHERE c.token = p.card_token AND p.card_fingerprint IS NULL;
@@ -0,0 +1,327 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+	"golang-payment-microservice/internal/vault"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Mock do gateway do adquirente
+type MockAcquirerGateway struct {
+	mock.Mock
+}
+
+func (m *MockAcquirerGateway) Name() string {
+	return "mock"
+}
+
+func (m *MockAcquirerGateway) Authorize(ctx context.Context, req *model.AuthorizationRequest) (*model.AcquirerResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.AcquirerResponse), args.Error(1)
+}
+
+func (m *MockAcquirerGateway) Capture(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.AcquirerResponse), args.Error(1)
+}
+
+func (m *MockAcquirerGateway) IncrementAuthorization(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.AcquirerResponse), args.Error(1)
+}
+
+func (m *MockAcquirerGateway) Void(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.AcquirerResponse), args.Error(1)
+}
+
+func (m *MockAcquirerGateway) Refund(ctx context.Context, req *model.AcquirerRequest) (*model.AcquirerResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.AcquirerResponse), args.Error(1)
+}
+
+func approvedResponse(reference string) *model.AcquirerResponse {
+	return &model.AcquirerResponse{Approved: true, ResponseCode: model.ResponseApproved, ApprovalCode: "123456", NetworkReference: reference}
+}
+
+// setupAcquirerProcessing prepara um pagamento pendente até o envio ao adquirente
+func setupAcquirerProcessing(mockRepo *MockPaymentRepository, mockVault *MockCardVault) *model.Payment {
+	payment := newPayment(model.PaymentStatusProcessing).build()
+
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusPending, model.PaymentStatusProcessing, (*string)(nil)).Return(nil)
+	mockRepo.On("RecordProcessingAttempt", mock.Anything, payment.ID).Return(1, nil)
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+
+	return payment
+}
+
+func TestSimulator_ApprovesAndEchoesNetworkReference(t *testing.T) {
+	simulator := gateway.NewSimulator(gateway.WithLatency(0, 0), gateway.WithFailureRate(0))
+
+	auth, err := simulator.Authorize(context.Background(), &model.AuthorizationRequest{PaymentID: uuid.New(), Amount: model.NewMoney(100, "BRL")})
+	require.NoError(t, err)
+	assert.True(t, auth.Approved)
+	assert.Equal(t, model.ResponseApproved, auth.ResponseCode)
+	assert.Len(t, auth.ApprovalCode, 6)
+	assert.Len(t, auth.NetworkReference, 12)
+
+	capture, err := simulator.Capture(context.Background(), &model.AcquirerRequest{NetworkReference: auth.NetworkReference})
+	require.NoError(t, err)
+	assert.True(t, capture.Approved)
+	assert.Equal(t, auth.NetworkReference, capture.NetworkReference)
+}
+
+func TestSimulator_FailureAndDeclineRates(t *testing.T) {
+	failing := gateway.NewSimulator(gateway.WithLatency(0, 0), gateway.WithFailureRate(1))
+	_, err := failing.Authorize(context.Background(), &model.AuthorizationRequest{})
+	assert.ErrorIs(t, err, gateway.ErrUnavailable)
+
+	declining := gateway.NewSimulator(gateway.WithLatency(0, 0), gateway.WithFailureRate(0), gateway.WithDeclineRate(1))
+	response, err := declining.Authorize(context.Background(), &model.AuthorizationRequest{})
+	require.NoError(t, err)
+	assert.False(t, response.Approved)
+	assert.Equal(t, model.ResponseDoNotHonor, response.ResponseCode)
+	assert.Empty(t, response.ApprovalCode)
+}
+
+func TestSimulator_StopsWaitingWhenContextIsCancelled(t *testing.T) {
+	simulator := gateway.NewSimulator(gateway.WithLatency(time.Hour, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := simulator.Authorize(ctx, &model.AuthorizationRequest{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPaymentService_ProcessPayment_ApprovedByAcquirer(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	cvvs := vault.NewCVVCache(time.Minute)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+		service.WithAcquirer(mockAcquirer), service.WithCVVCache(cvvs))
+
+	payment := setupAcquirerProcessing(mockRepo, mockVault)
+	cvvs.Hold(payment.ID, "123")
+	auth := approvedResponse("000000000042")
+
+	mockAcquirer.On("Authorize", mock.Anything, mock.MatchedBy(func(req *model.AuthorizationRequest) bool {
+		return req.PaymentID == payment.ID && req.Card.CVV == "123" && req.Amount == payment.Amount
+	})).Return(auth, nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.NetworkReference == "000000000042"
+	})).Return(approvedResponse("000000000042"), nil)
+	mockRepo.On("RecordAcquirerResponse", mock.Anything, payment.ID, "mock", auth).Return(nil)
+
+	mockRepo.On("CaptureHold", mock.Anything, payment.ID).Return(&model.Hold{AccountID: uuid.New(), Amount: payment.Amount}, nil)
+	mockRepo.On("RecordCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.Amount == payment.Amount && capture.Final && capture.NetworkReference == "000000000042"
+	})).Return(nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.AnythingOfType("*model.JournalEntry")).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusCompleted, (*string)(nil)).Return(nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertNotCalled(t, "Void", mock.Anything, mock.Anything)
+	assert.Empty(t, cvvs.Get(payment.ID))
+	// Sem taxa configurada a conta de taxas não entra na transação
+	mockRepo.AssertNotCalled(t, "EnsureLedgerAccount", mock.Anything, model.LedgerAccountFees, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessPayment_DeclinedByAcquirer(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	cvvs := vault.NewCVVCache(time.Minute)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+		service.WithAcquirer(mockAcquirer), service.WithCVVCache(cvvs))
+
+	payment := setupAcquirerProcessing(mockRepo, mockVault)
+	cvvs.Hold(payment.ID, "123")
+	declined := &model.AcquirerResponse{ResponseCode: model.ResponseDoNotHonor, DeclineReason: "Do not honor", NetworkReference: "000000000043"}
+
+	mockAcquirer.On("Authorize", mock.Anything, mock.Anything).Return(declined, nil)
+	mockRepo.On("RecordAcquirerResponse", mock.Anything, payment.ID, "mock", declined).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusFailed, mock.MatchedBy(func(msg *string) bool {
+		return msg != nil && *msg == "Payment declined: Do not honor"
+	})).Return(nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	// Uma recusa é terminal: não há nova tentativa
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertNotCalled(t, "Capture", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "CaptureHold", mock.Anything, mock.Anything)
+	assert.Empty(t, cvvs.Get(payment.ID))
+}
+
+func TestPaymentService_ProcessPayment_AcquirerUnavailableIsRetried(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	cvvs := vault.NewCVVCache(time.Minute)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+		service.WithAcquirer(mockAcquirer), service.WithCVVCache(cvvs))
+
+	payment := setupAcquirerProcessing(mockRepo, mockVault)
+	cvvs.Hold(payment.ID, "123")
+	mockAcquirer.On("Authorize", mock.Anything, mock.Anything).Return(nil, gateway.ErrUnavailable)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	assert.True(t, model.IsRetryable(err))
+	assert.ErrorIs(t, err, gateway.ErrUnavailable)
+	mockRepo.AssertNotCalled(t, "RecordAcquirerResponse", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	// A nova tentativa ainda precisa do CVV
+	assert.Equal(t, "123", cvvs.Get(payment.ID))
+}
+
+func TestPaymentService_ProcessPayment_KeepsAuthorizationWhenLocalDebitFailsTransiently(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := setupAcquirerProcessing(mockRepo, mockVault)
+	auth := approvedResponse("000000000044")
+
+	mockAcquirer.On("Authorize", mock.Anything, mock.Anything).Return(auth, nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.Anything).Return(approvedResponse("000000000044"), nil)
+	mockRepo.On("RecordAcquirerResponse", mock.Anything, payment.ID, "mock", auth).Return(nil)
+	mockRepo.On("CaptureHold", mock.Anything, payment.ID).Return(nil, errors.New("connection reset"))
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	// A próxima tentativa retoma a partir da autorização gravada; cancelá-la
+	// agora levaria a uma segunda autorização
+	assert.True(t, model.IsRetryable(err))
+	mockAcquirer.AssertNotCalled(t, "Void", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessPayment_VoidsWhenLocalDebitIsDeclined(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := setupAcquirerProcessing(mockRepo, mockVault)
+	auth := approvedResponse("000000000044")
+
+	mockAcquirer.On("Authorize", mock.Anything, mock.Anything).Return(auth, nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.Anything).Return(approvedResponse("000000000044"), nil)
+	mockRepo.On("RecordAcquirerResponse", mock.Anything, payment.ID, "mock", auth).Return(nil)
+	mockRepo.On("CaptureHold", mock.Anything, payment.ID).Return(nil, repository.ErrInsufficientFunds)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusFailed, mock.MatchedBy(func(msg *string) bool {
+		return msg != nil && *msg == "Insufficient funds"
+	})).Return(nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockAcquirer.On("Void", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.NetworkReference == "000000000044"
+	})).Return(approvedResponse("000000000044"), nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertExpectations(t)
+}
+
+func TestPaymentService_ProcessPayment_ResumesFromRecordedAuthorization(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	// A entrega anterior gravou a aprovação e caiu antes do débito
+	payment := newPayment(model.PaymentStatusProcessing).approved().build()
+
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusPending, model.PaymentStatusProcessing, (*string)(nil)).
+		Return(&model.TransitionError{PaymentID: payment.ID.String(), From: model.PaymentStatusPending, To: model.PaymentStatusProcessing, Current: model.PaymentStatusProcessing})
+	mockRepo.On("RecordProcessingAttempt", mock.Anything, payment.ID).Return(2, nil)
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+
+	mockRepo.On("CaptureHold", mock.Anything, payment.ID).Return(&model.Hold{AccountID: uuid.New(), Amount: payment.Amount}, nil)
+	mockRepo.On("RecordCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.Final && capture.NetworkReference == testNetworkReference
+	})).Return(nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.AnythingOfType("*model.JournalEntry")).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusCompleted, (*string)(nil)).Return(nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertNotCalled(t, "Authorize", mock.Anything, mock.Anything)
+	mockAcquirer.AssertNotCalled(t, "Capture", mock.Anything, mock.Anything)
+	mockAcquirer.AssertNotCalled(t, "Void", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "RecordAcquirerResponse", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessPayment_VoidsUnapprovedReferenceBeforeReauthorizing(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := setupAcquirerProcessing(mockRepo, mockVault)
+	reference, code := "000000000045", string(model.ResponseDoNotHonor)
+	payment.NetworkReference, payment.ResponseCode = &reference, &code
+	auth := approvedResponse("000000000046")
+
+	mockAcquirer.On("Void", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.NetworkReference == "000000000045"
+	})).Return(approvedResponse("000000000045"), nil).Once()
+	mockAcquirer.On("Authorize", mock.Anything, mock.Anything).Return(auth, nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.Anything).Return(approvedResponse("000000000046"), nil)
+	mockRepo.On("RecordAcquirerResponse", mock.Anything, payment.ID, "mock", auth).Return(nil)
+	mockRepo.On("CaptureHold", mock.Anything, payment.ID).Return(&model.Hold{AccountID: uuid.New(), Amount: payment.Amount}, nil)
+	mockRepo.On("RecordCapture", mock.Anything, mock.AnythingOfType("*model.PaymentCapture")).Return(nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.AnythingOfType("*model.JournalEntry")).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusCompleted, (*string)(nil)).Return(nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockAcquirer.AssertExpectations(t)
+}
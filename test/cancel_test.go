@@ -0,0 +1,196 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+	"golang-payment-microservice/internal/vault"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentService_CancelPayment_Pending(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	cvvs := vault.NewCVVCache(time.Minute)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithCVVCache(cvvs))
+
+	payment := &model.Payment{ID: uuid.New(), Status: model.PaymentStatusPending}
+	cvvs.Hold(payment.ID, "123")
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusPending, model.PaymentStatusCancelled, mock.MatchedBy(func(msg *string) bool {
+		return msg != nil && *msg == "Customer changed their mind"
+	})).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventCancelled && event.FromStatus == model.PaymentStatusPending &&
+			event.Actor == "support:alice" && event.Reason == "Customer changed their mind"
+	})).Return(nil)
+
+	_, err := paymentService.CancelPayment(context.Background(), payment.ID, "Customer changed their mind", "support:alice")
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	assert.Empty(t, cvvs.Get(payment.ID))
+}
+
+func TestPaymentService_CancelPayment_RetriesFromProcessingWhenConsumerWinsTheRace(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New())
+
+	payment := &model.Payment{ID: uuid.New(), Status: model.PaymentStatusPending}
+
+	// O consumidor tira o pagamento de pending entre a leitura e o cancelamento
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusPending, model.PaymentStatusCancelled, mock.Anything).
+		Return(&model.TransitionError{PaymentID: payment.ID.String(), From: model.PaymentStatusPending, To: model.PaymentStatusCancelled, Current: model.PaymentStatusProcessing})
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusCancelled, mock.Anything).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.FromStatus == model.PaymentStatusProcessing && event.Actor == model.ActorAPI
+	})).Return(nil)
+
+	_, err := paymentService.CancelPayment(context.Background(), payment.ID, "Duplicate order", "")
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentService_CancelPayment_VoidsRecordedAuthorizationBetweenAttempts(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	// O pagamento espera uma nova tentativa com a aprovação já gravada
+	payment := newPayment(model.PaymentStatusProcessing).approved().build()
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusCancelled, mock.Anything).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.AnythingOfType("*model.PaymentEvent")).Return(nil)
+	mockAcquirer.On("Void", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.NetworkReference == testNetworkReference
+	})).Return(approvedResponse(testNetworkReference), nil)
+
+	_, err := paymentService.CancelPayment(context.Background(), payment.ID, "Customer request", "")
+
+	require.NoError(t, err)
+	mockAcquirer.AssertExpectations(t)
+}
+
+func TestPaymentService_CancelPayment_RejectsFinalStatus(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New())
+
+	payment := &model.Payment{ID: uuid.New(), Status: model.PaymentStatusCompleted}
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+
+	_, err := paymentService.CancelPayment(context.Background(), payment.ID, "Too late", "")
+
+	var transitionErr *model.TransitionError
+	require.ErrorAs(t, err, &transitionErr)
+	assert.Equal(t, model.PaymentStatusCompleted, transitionErr.Current)
+	mockRepo.AssertNotCalled(t, "ReleaseHold", mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessPayment_CancelledDuringAuthorizationIsVoided(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := setupAcquirerProcessing(mockRepo, mockVault)
+	auth := approvedResponse("000000000045")
+
+	mockAcquirer.On("Authorize", mock.Anything, mock.Anything).Return(auth, nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.Anything).Return(approvedResponse("000000000045"), nil)
+	mockRepo.On("RecordAcquirerResponse", mock.Anything, payment.ID, "mock", auth).Return(nil)
+	mockRepo.On("CaptureHold", mock.Anything, payment.ID).Return(&model.Hold{AccountID: uuid.New(), Amount: payment.Amount}, nil)
+	mockRepo.On("RecordCapture", mock.Anything, mock.AnythingOfType("*model.PaymentCapture")).Return(nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.AnythingOfType("*model.JournalEntry")).Return(nil)
+	// O cancelamento pela API chegou antes da conclusão
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusCompleted, (*string)(nil)).
+		Return(&model.TransitionError{PaymentID: payment.ID.String(), From: model.PaymentStatusProcessing, To: model.PaymentStatusCompleted, Current: model.PaymentStatusCancelled})
+	mockAcquirer.On("Void", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.NetworkReference == "000000000045"
+	})).Return(approvedResponse("000000000045"), nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	// O processamento é abortado sem nova tentativa nem dead-letter
+	require.NoError(t, err)
+	mockAcquirer.AssertExpectations(t)
+}
+
+func TestCancelPaymentHandler(t *testing.T) {
+	payment := &model.Payment{ID: uuid.New(), Status: model.PaymentStatusCancelled}
+
+	tests := []struct {
+		name   string
+		body   string
+		setup  func(m *MockPaymentService)
+		status int
+	}{
+		{
+			name: "cancelled",
+			body: `{"reason":"Customer request","cancelled_by":"support:alice"}`,
+			setup: func(m *MockPaymentService) {
+				m.On("CancelPayment", mock.Anything, payment.ID, "Customer request", "support:alice").Return(payment, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:   "missing reason",
+			body:   `{}`,
+			setup:  func(m *MockPaymentService) {},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "final status",
+			body: `{"reason":"Customer request"}`,
+			setup: func(m *MockPaymentService) {
+				m.On("CancelPayment", mock.Anything, payment.ID, "Customer request", "").
+					Return(nil, &model.TransitionError{From: model.PaymentStatusCompleted, To: model.PaymentStatusCancelled, Current: model.PaymentStatusCompleted})
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "not found",
+			body: `{"reason":"Customer request"}`,
+			setup: func(m *MockPaymentService) {
+				m.On("CancelPayment", mock.Anything, payment.ID, "Customer request", "").Return(nil, repository.ErrPaymentNotFound)
+			},
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockPaymentService)
+			tt.setup(mockService)
+			router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/payments/"+payment.ID.String()+"/cancel", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentService_ProcessPayment_ManualCaptureStopsAtAuthorized(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+		service.WithAcquirer(mockAcquirer), service.WithAuthorizationTTL(48*time.Hour))
+
+	payment := setupAcquirerProcessing(mockRepo, mockVault)
+	payment.CaptureMethod = model.CaptureManual
+	auth := approvedResponse("000000000042")
+
+	mockAcquirer.On("Authorize", mock.Anything, mock.AnythingOfType("*model.AuthorizationRequest")).Return(auth, nil)
+	mockRepo.On("RecordAcquirerResponse", mock.Anything, payment.ID, "mock", auth).Return(nil)
+	mockRepo.On("RecordAuthorization", mock.Anything, payment.ID, mock.MatchedBy(func(expiresAt time.Time) bool {
+		return time.Until(expiresAt) > 47*time.Hour
+	})).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusAuthorized, (*string)(nil)).Return(nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertNotCalled(t, "Capture", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "CaptureHold", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "PostEntry", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_CapturePayment_PartialCaptureDebitsCapturedAmount(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	amount := model.NewMoney(6000, "BRL")
+	accountID := uuid.New()
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReserveCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.PaymentID == payment.ID && capture.Status == model.CaptureStatusPending
+	})).Return(nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.PaymentID == payment.ID && req.Amount == amount && req.NetworkReference == "000000000042"
+	})).Return(approvedResponse("000000000042"), nil)
+	mockRepo.On("CaptureHoldAmount", mock.Anything, payment.ID, amount).
+		Return(&model.Hold{AccountID: accountID, Amount: payment.Amount}, nil)
+	mockRepo.On("RecordCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.PaymentID == payment.ID && capture.Amount == amount && capture.Final
+	})).Return(nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.MatchedBy(func(entry *model.JournalEntry) bool {
+		return *entry.PaymentID == payment.ID && entry.Postings[0].Amount == amount
+	})).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusAuthorized, model.PaymentStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventCaptured && event.ToStatus == model.PaymentStatusCompleted
+	})).Return(nil)
+
+	_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{Amount: &amount})
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertNotCalled(t, "Void", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_CapturePayment_Rejections(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	above := model.NewMoney(15000, "BRL")
+
+	tests := []struct {
+		name    string
+		prepare func(payment *model.Payment)
+		amount  *model.Money
+		reserve error
+		check   func(t *testing.T, err error)
+	}{
+		{
+			name:    "not authorized",
+			prepare: func(payment *model.Payment) { payment.Status = model.PaymentStatusCompleted },
+			check: func(t *testing.T, err error) {
+				var transitionErr *model.TransitionError
+				require.ErrorAs(t, err, &transitionErr)
+				assert.Equal(t, model.PaymentStatusCompleted, transitionErr.Current)
+			},
+		},
+		{
+			name:    "authorization expired",
+			prepare: func(payment *model.Payment) { payment.AuthorizationExpiresAt = &expired },
+			check:   func(t *testing.T, err error) { assert.ErrorIs(t, err, service.ErrAuthorizationExpired) },
+		},
+		{
+			name:    "amount above authorized",
+			amount:  &above,
+			reserve: fmt.Errorf("%w: 100.00 BRL remaining", repository.ErrCaptureExceedsAuthorization),
+			check:   func(t *testing.T, err error) { assert.ErrorIs(t, err, service.ErrInvalidCaptureAmount) },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockPaymentRepository)
+			mockAcquirer := new(MockAcquirerGateway)
+			paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+			payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+			if tc.prepare != nil {
+				tc.prepare(payment)
+			}
+			mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+			if tc.reserve != nil {
+				mockRepo.On("ReserveCapture", mock.Anything, mock.AnythingOfType("*model.PaymentCapture")).Return(tc.reserve)
+			}
+
+			_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{Amount: tc.amount})
+
+			tc.check(t, err)
+			mockAcquirer.AssertNotCalled(t, "Capture", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestPaymentService_CapturePayment_RefundsWhenLocalCaptureFails(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	var reserved *model.PaymentCapture
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReserveCapture", mock.Anything, mock.AnythingOfType("*model.PaymentCapture")).
+		Run(func(args mock.Arguments) {
+			reserveAgainst(payment)(args)
+			reserved = args.Get(1).(*model.PaymentCapture)
+		}).Return(nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.AnythingOfType("*model.AcquirerRequest")).Return(approvedResponse("000000000043"), nil)
+	mockRepo.On("CaptureHoldAmount", mock.Anything, payment.ID, payment.Amount).Return(nil, errors.New("connection reset"))
+	// O valor já capturado é devolvido pela referência da captura
+	mockAcquirer.On("Refund", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.PaymentID == payment.ID && req.Amount == payment.Amount && req.NetworkReference == "000000000043"
+	})).Return(approvedResponse("000000000044"), nil)
+	mockRepo.On("FailCapture", mock.Anything, mock.AnythingOfType("uuid.UUID")).Return(nil)
+
+	_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{})
+
+	require.Error(t, err)
+	mockAcquirer.AssertExpectations(t)
+	mockAcquirer.AssertNotCalled(t, "Void", mock.Anything, mock.Anything)
+	mockRepo.AssertCalled(t, "FailCapture", mock.Anything, reserved.ID)
+}
+
+func TestPaymentService_CapturePayment_ReservesBeforeTheAcquirer(t *testing.T) {
+	t.Run("capture in progress", func(t *testing.T) {
+		mockRepo := new(MockPaymentRepository)
+		mockAcquirer := new(MockAcquirerGateway)
+		paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+		payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+
+		mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+		mockRepo.On("ReserveCapture", mock.Anything, mock.AnythingOfType("*model.PaymentCapture")).Return(repository.ErrCaptureInProgress)
+
+		_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{})
+
+		assert.ErrorIs(t, err, repository.ErrCaptureInProgress)
+		mockAcquirer.AssertNotCalled(t, "Capture", mock.Anything, mock.Anything)
+	})
+
+	t.Run("declined releases the reservation", func(t *testing.T) {
+		mockRepo := new(MockPaymentRepository)
+		mockAcquirer := new(MockAcquirerGateway)
+		paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+		payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+		var reserved *model.PaymentCapture
+
+		mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+		mockRepo.On("ReserveCapture", mock.Anything, mock.AnythingOfType("*model.PaymentCapture")).
+			Run(func(args mock.Arguments) { reserved = args.Get(1).(*model.PaymentCapture) }).Return(nil)
+		mockAcquirer.On("Capture", mock.Anything, mock.Anything).
+			Return(&model.AcquirerResponse{ResponseCode: model.ResponseDoNotHonor, DeclineReason: "Do not honor"}, nil)
+		mockRepo.On("FailCapture", mock.Anything, mock.AnythingOfType("uuid.UUID")).Return(nil)
+
+		_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{})
+
+		assert.ErrorIs(t, err, service.ErrCaptureDeclined)
+		mockRepo.AssertCalled(t, "FailCapture", mock.Anything, reserved.ID)
+		mockRepo.AssertNotCalled(t, "CaptureHoldAmount", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestPaymentService_VoidPayment_ReleasesHoldAndVoidsAuthorization(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	reason := "Order cancelled"
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockRepo.On("HasPendingCapture", mock.Anything, payment.ID).Return(false, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusAuthorized, model.PaymentStatusVoided, &reason).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventVoided && event.Actor == "merchant-backoffice" && event.Reason == reason
+	})).Return(nil)
+	mockAcquirer.On("Void", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.PaymentID == payment.ID && req.NetworkReference == "000000000042"
+	})).Return(approvedResponse("000000000042"), nil)
+
+	_, err := paymentService.VoidPayment(context.Background(), payment.ID, reason, "merchant-backoffice")
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertExpectations(t)
+}
+
+func TestAuthorizationExpirer_VoidsExpiredAuthorizations(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	expirer := service.NewAuthorizationExpirer(mockRepo, mockAcquirer, time.Minute, 10, logrus.New())
+
+	expired := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	captured := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	capturing := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+
+	mockRepo.On("GetExpiredAuthorizations", mock.Anything, mock.AnythingOfType("time.Time"), 10).
+		Return([]*model.Payment{expired, captured, capturing}, nil)
+	mockRepo.On("ReleaseHold", mock.Anything, mock.Anything, model.HoldStatusExpired).Return(&model.Hold{}, nil)
+	mockRepo.On("HasPendingCapture", mock.Anything, expired.ID).Return(false, nil)
+	mockRepo.On("HasPendingCapture", mock.Anything, captured.ID).Return(false, nil)
+	// Captura em andamento no adquirente: fica para a próxima execução
+	mockRepo.On("HasPendingCapture", mock.Anything, capturing.ID).Return(true, nil)
+	mockRepo.On("GetByID", mock.Anything, expired.ID).Return(expired, nil)
+	mockRepo.On("GetByID", mock.Anything, captured.ID).Return(captured, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, expired.ID, model.PaymentStatusAuthorized, model.PaymentStatusVoided, mock.Anything).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.PaymentID == expired.ID && event.Actor == model.ActorAuthorizationExpirer
+	})).Return(nil)
+	// Capturado entre a consulta e a expiração
+	mockRepo.On("TransitionStatus", mock.Anything, captured.ID, model.PaymentStatusAuthorized, model.PaymentStatusVoided, mock.Anything).
+		Return(&model.TransitionError{PaymentID: captured.ID.String(), From: model.PaymentStatusAuthorized, To: model.PaymentStatusVoided, Current: model.PaymentStatusCompleted})
+	mockAcquirer.On("Void", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.PaymentID == expired.ID
+	})).Return(approvedResponse("000000000042"), nil)
+
+	var voided []*model.Payment
+	expirer.OnExpired(func(payment *model.Payment) { voided = append(voided, payment) })
+
+	count, err := expirer.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []*model.Payment{expired}, voided)
+	mockAcquirer.AssertNumberOfCalls(t, "Void", 1)
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, capturing.ID, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCaptureHandler_Responses(t *testing.T) {
+	paymentID := uuid.New()
+
+	tests := []struct {
+		name   string
+		body   string
+		err    error
+		status int
+	}{
+		{name: "full capture without body", body: "", status: http.StatusOK},
+		{name: "partial capture", body: `{"amount":{"value":6000,"currency":"BRL"}}`, status: http.StatusOK},
+		{name: "non-positive amount", body: `{"amount":{"value":0,"currency":"BRL"}}`, status: http.StatusBadRequest},
+		{name: "payment not found", err: repository.ErrPaymentNotFound, status: http.StatusNotFound},
+		{name: "not authorized", err: &model.TransitionError{From: model.PaymentStatusPending, To: model.PaymentStatusCompleted, Current: model.PaymentStatusPending}, status: http.StatusConflict},
+		{name: "authorization expired", err: service.ErrAuthorizationExpired, status: http.StatusConflict},
+		{name: "capture in progress", err: repository.ErrCaptureInProgress, status: http.StatusConflict},
+		{name: "above authorized", err: fmt.Errorf("%w: 100.00 BRL authorized", service.ErrInvalidCaptureAmount), status: http.StatusBadRequest},
+		{name: "declined", err: service.ErrCaptureDeclined, status: http.StatusPaymentRequired},
+		{name: "acquirer unavailable", err: fmt.Errorf("failed to capture payment: %w", gateway.ErrTimeout), status: http.StatusBadGateway},
+		{name: "unexpected error", err: errors.New("connection refused"), status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockPaymentService)
+			router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+			if tc.err != nil {
+				mockService.On("CapturePayment", mock.Anything, paymentID, mock.Anything).Return(nil, tc.err)
+			} else {
+				mockService.On("CapturePayment", mock.Anything, paymentID, mock.Anything).
+					Return(&model.Payment{ID: paymentID, Status: model.PaymentStatusCompleted}, nil)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/payments/"+paymentID.String()+"/capture", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestVoidHandler_ConflictOutsideAuthorized(t *testing.T) {
+	mockService := new(MockPaymentService)
+	router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+	paymentID := uuid.New()
+	mockService.On("VoidPayment", mock.Anything, paymentID, "", "").
+		Return(nil, &model.TransitionError{From: model.PaymentStatusCompleted, To: model.PaymentStatusVoided, Current: model.PaymentStatusCompleted})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/payments/"+paymentID.String()+"/void", nil))
+
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"completed"`)
+}
+
+// reserveAgainst faz o ReserveCapture do mock decidir o valor e o Final da
+// captura como o repositório, pelo saldo do pagamento travado
+func reserveAgainst(locked *model.Payment) func(mock.Arguments) {
+	return func(args mock.Arguments) {
+		capture := args.Get(1).(*model.PaymentCapture)
+		remaining := locked.AuthorizedAmount
+		if locked.CapturedAmount != nil {
+			remaining.Value -= locked.CapturedAmount.Value
+		}
+		if capture.Amount == (model.Money{}) {
+			capture.Amount = remaining
+		}
+		capture.Final = capture.Final || capture.Amount.Value == remaining.Value
+	}
+}
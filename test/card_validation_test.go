@@ -0,0 +1,162 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/cardvalidation"
+	"golang-payment-microservice/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLuhn(t *testing.T) {
+	assert.True(t, cardvalidation.Luhn("4111111111111111"))
+	assert.True(t, cardvalidation.Luhn("378282246310005"))
+	assert.True(t, cardvalidation.Luhn("36490102462661"))
+	assert.False(t, cardvalidation.Luhn("4111111111111112"))
+	assert.False(t, cardvalidation.Luhn("1234567890123456"))
+	assert.False(t, cardvalidation.Luhn(""))
+	assert.False(t, cardvalidation.Luhn("4111a11111111111"))
+}
+
+func TestDetectBrand(t *testing.T) {
+	tests := []struct {
+		pan      string
+		expected cardvalidation.Brand
+	}{
+		{"4111111111111111", cardvalidation.BrandVisa},
+		{"5555555555554444", cardvalidation.BrandMastercard},
+		{"2223000048400011", cardvalidation.BrandMastercard},
+		{"378282246310005", cardvalidation.BrandAmex},
+		{"6362970000457013", cardvalidation.BrandElo},
+		{"4389351648020055", cardvalidation.BrandElo},
+		{"5067224275805500", cardvalidation.BrandElo},
+		{"6062825624254001", cardvalidation.BrandHipercard},
+		{"36490102462661", cardvalidation.BrandDiners},
+		{"30569309025904", cardvalidation.BrandDiners},
+		{"9999999999999995", cardvalidation.BrandUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pan, func(t *testing.T) {
+			assert.Equal(t, tt.expected, cardvalidation.DetectBrand(tt.pan))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2026, time.June, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		pan     string
+		cvv     string
+		month   int
+		year    int
+		brand   cardvalidation.Brand
+		reasons []cardvalidation.Reason
+	}{
+		{name: "Visa 16 digits", pan: "4111111111111111", cvv: "123", month: 12, year: 2027, brand: cardvalidation.BrandVisa},
+		{name: "Amex with 4-digit CVV", pan: "378282246310005", cvv: "1234", month: 1, year: 2028, brand: cardvalidation.BrandAmex},
+		{name: "Diners 14 digits", pan: "36490102462661", cvv: "123", month: 6, year: 2026, brand: cardvalidation.BrandDiners},
+		{name: "Elo", pan: "6362970000457013", cvv: "123", month: 12, year: 2027, brand: cardvalidation.BrandElo},
+		{
+			name: "Amex with 3-digit CVV", pan: "378282246310005", cvv: "123", month: 1, year: 2028,
+			brand: cardvalidation.BrandAmex, reasons: []cardvalidation.Reason{cardvalidation.ReasonInvalidCVV},
+		},
+		{
+			name: "Luhn failure", pan: "4111111111111112", cvv: "123", month: 12, year: 2027,
+			brand: cardvalidation.BrandVisa, reasons: []cardvalidation.Reason{cardvalidation.ReasonLuhnFailed},
+		},
+		{
+			name: "Mastercard with 15 digits", pan: "555555555555444", cvv: "123", month: 12, year: 2027,
+			brand:   cardvalidation.BrandMastercard,
+			reasons: []cardvalidation.Reason{cardvalidation.ReasonInvalidLength, cardvalidation.ReasonLuhnFailed},
+		},
+		{
+			name: "Unknown brand", pan: "9999999999999995", cvv: "123", month: 12, year: 2027,
+			brand: cardvalidation.BrandUnknown, reasons: []cardvalidation.Reason{cardvalidation.ReasonUnknownBrand},
+		},
+		{
+			name: "Non numeric", pan: "4111-1111-1111-1111", cvv: "123", month: 12, year: 2027,
+			brand: cardvalidation.BrandUnknown, reasons: []cardvalidation.Reason{cardvalidation.ReasonNonNumeric},
+		},
+		{
+			name: "Expired last month", pan: "4111111111111111", cvv: "123", month: 5, year: 2026,
+			brand: cardvalidation.BrandVisa, reasons: []cardvalidation.Reason{cardvalidation.ReasonExpired},
+		},
+		{
+			name: "Invalid month", pan: "4111111111111111", cvv: "123", month: 13, year: 2027,
+			brand: cardvalidation.BrandVisa, reasons: []cardvalidation.Reason{cardvalidation.ReasonInvalidExpiry},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			brand, err := cardvalidation.Validate(tt.pan, tt.cvv, tt.month, tt.year, true, now)
+			assert.Equal(t, tt.brand, brand)
+
+			if len(tt.reasons) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var verr *cardvalidation.ValidationError
+			require.True(t, errors.As(err, &verr))
+			reasons := make([]cardvalidation.Reason, 0, len(verr.Failures))
+			for _, f := range verr.Failures {
+				reasons = append(reasons, f.Reason)
+			}
+			assert.Equal(t, tt.reasons, reasons)
+		})
+	}
+}
+
+func TestValidate_OptionalCVV(t *testing.T) {
+	now := time.Date(2026, time.June, 15, 0, 0, 0, 0, time.UTC)
+
+	_, err := cardvalidation.Validate("4111111111111111", "", 12, 2027, false, now)
+	assert.NoError(t, err)
+
+	_, err = cardvalidation.Validate("4111111111111111", "", 12, 2027, true, now)
+	assert.Error(t, err)
+}
+
+func TestCard_Validate_ReturnsReasons(t *testing.T) {
+	card := model.Card{
+		Number:      "1234567890123456",
+		ExpiryMonth: 12,
+		ExpiryYear:  time.Now().Year() + 1,
+		CVV:         "123",
+	}
+
+	var verr *cardvalidation.ValidationError
+	require.True(t, errors.As(card.Validate(), &verr))
+	assert.True(t, verr.HasReason(cardvalidation.ReasonUnknownBrand))
+	assert.True(t, verr.HasReason(cardvalidation.ReasonLuhnFailed))
+}
+
+// As faixas do backfill da migração 005 precisam detectar a mesma bandeira
+// que DetectBrand
+func TestCardBrandBackfillMatchesDetectBrand(t *testing.T) {
+	migration, err := os.ReadFile("../migrations/005_card_validation.sql")
+	require.NoError(t, err)
+
+	rows := regexp.MustCompile(`\(\d+, '(\d+)', '(\d+)', '(\w+)'\)`).FindAllStringSubmatch(string(migration), -1)
+	require.NotEmpty(t, rows)
+
+	for _, row := range rows {
+		low := row[1] + strings.Repeat("0", 6-len(row[1]))
+		high := row[2] + strings.Repeat("9", 6-len(row[2]))
+		brand := cardvalidation.Brand(row[3])
+
+		assert.Equal(t, brand, cardvalidation.DetectBrand(low+"0000000000"), low)
+		assert.Equal(t, brand, cardvalidation.DetectBrand(high+"0000000000"), high)
+	}
+}
@@ -0,0 +1,243 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Mock do dead-letter para os testes de serviço e handler
+type MockDeadLetterQueue struct {
+	mock.Mock
+}
+
+func (m *MockDeadLetterQueue) Send(ctx context.Context, message kafka.Message, failure *model.MessageFailure) error {
+	args := m.Called(ctx, message, failure)
+	return args.Error(0)
+}
+
+func (m *MockDeadLetterQueue) Partitions(ctx context.Context) ([]*model.DeadLetterPartition, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*model.DeadLetterPartition), args.Error(1)
+}
+
+func (m *MockDeadLetterQueue) List(ctx context.Context, partition int, offset int64, limit int) ([]*model.DeadLetter, error) {
+	args := m.Called(ctx, partition, offset, limit)
+	return args.Get(0).([]*model.DeadLetter), args.Error(1)
+}
+
+func (m *MockDeadLetterQueue) Get(ctx context.Context, partition int, offset int64) (*model.DeadLetter, error) {
+	args := m.Called(ctx, partition, offset)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.DeadLetter), args.Error(1)
+}
+
+func TestDeadLetterQueue_SendKeepsOriginalMessageAndAddsHeaders(t *testing.T) {
+	mockProducer := new(MockKafkaProducer)
+	dlq := queue.NewDeadLetterQueue(mockProducer, []string{"localhost:9092"}, "payment-processing.dlq")
+
+	original := kafka.Message{
+		Topic:     "payment-processing",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("tok_abc"),
+		Value:     []byte(`{"payment_id":"not-a-uuid"}`),
+	}
+	failedAt := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	var headers []kafka.Header
+	mockProducer.On("Publish", mock.Anything, "payment-processing.dlq", original.Key, original.Value, mock.Anything).
+		Run(func(args mock.Arguments) { headers = args.Get(4).([]kafka.Header) }).Return(nil)
+
+	err := dlq.Send(context.Background(), original, &model.MessageFailure{
+		Class:    model.DeadLetterInvalidPaymentID,
+		Err:      errors.New("invalid UUID length: 10"),
+		Attempts: 1,
+		FailedAt: failedAt,
+	})
+	require.NoError(t, err)
+
+	// A mensagem lida do dead-letter traz de volta os metadados da falha
+	letter := queue.DecodeDeadLetter(kafka.Message{Partition: 0, Offset: 7, Key: original.Key, Value: original.Value, Headers: headers})
+
+	assert.Equal(t, "tok_abc", letter.Key)
+	assert.Equal(t, original.Value, letter.Value)
+	assert.Equal(t, "payment-processing", letter.OriginalTopic)
+	assert.Equal(t, 3, letter.OriginalPartition)
+	assert.Equal(t, int64(42), letter.OriginalOffset)
+	assert.Equal(t, model.DeadLetterInvalidPaymentID, letter.ErrorClass)
+	assert.Equal(t, "invalid UUID length: 10", letter.ErrorMessage)
+	assert.Equal(t, 1, letter.Attempts)
+	assert.True(t, failedAt.Equal(letter.FailedAt))
+}
+
+func TestFailureHeaders_ReplacePreviousFailure(t *testing.T) {
+	// Mensagem que já passou pelo dead-letter e foi reenviada com os cabeçalhos
+	previous := kafka.Message{
+		Topic: "payment-processing",
+		Headers: []kafka.Header{
+			{Key: "trace-id", Value: []byte("abc")},
+			{Key: queue.HeaderAttempts, Value: []byte("2")},
+			{Key: queue.HeaderErrorClass, Value: []byte(model.DeadLetterTimeout)},
+		},
+	}
+
+	attempts := queue.MessageAttempts(previous) + 1
+	headers := queue.FailureHeaders(previous, &model.MessageFailure{
+		Class:    model.DeadLetterProcessingError,
+		Err:      errors.New("connection reset"),
+		Attempts: attempts,
+	})
+
+	letter := queue.DecodeDeadLetter(kafka.Message{Headers: headers})
+	assert.Equal(t, 3, letter.Attempts)
+	assert.Equal(t, model.DeadLetterProcessingError, letter.ErrorClass)
+	assert.False(t, letter.FailedAt.IsZero())
+
+	classes := 0
+	for _, header := range headers {
+		if header.Key == queue.HeaderErrorClass {
+			classes++
+		}
+	}
+	assert.Equal(t, 1, classes)
+	assert.Contains(t, headers, kafka.Header{Key: "trace-id", Value: []byte("abc")})
+}
+
+func TestDecodeDeadLetter_RedactsPayload(t *testing.T) {
+	letter := queue.DecodeDeadLetter(kafka.Message{
+		Value: []byte(`{"card_number":"4111111111111111","cvv":"123"}`),
+	})
+
+	assert.NotContains(t, letter.Payload, "4111111111111111")
+	assert.NotContains(t, letter.Payload, `"123"`)
+	// O original fica intacto para o reenvio
+	assert.Contains(t, string(letter.Value), "4111111111111111")
+}
+
+func TestDeadLetterService_RedriveEnqueuesOriginalMessage(t *testing.T) {
+	mockDLQ := new(MockDeadLetterQueue)
+	mockRepo := new(MockPaymentRepository)
+	deadLetters := service.NewDeadLetterService(mockDLQ, mockRepo, "payment-processing", logrus.New())
+
+	letter := &model.DeadLetter{
+		Partition:     1,
+		Offset:        9,
+		Key:           "tok_abc",
+		Value:         []byte(`{"payment_id":"p1"}`),
+		OriginalTopic: "payment-processing",
+		ErrorClass:    model.DeadLetterProcessingError,
+	}
+
+	var enqueued *model.OutboxMessage
+	mockDLQ.On("Get", mock.Anything, 1, int64(9)).Return(letter, nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).
+		Run(func(args mock.Arguments) {
+			enqueued = args.Get(1).(*model.OutboxMessage)
+			enqueued.ID = 77
+		}).Return(nil)
+
+	redrive, err := deadLetters.Redrive(context.Background(), 1, 9)
+
+	require.NoError(t, err)
+	require.NotNil(t, enqueued)
+	assert.Equal(t, "payment-processing", enqueued.Topic)
+	assert.Equal(t, "tok_abc", enqueued.Key)
+	assert.Equal(t, letter.Value, enqueued.Payload)
+	assert.Equal(t, int64(77), redrive.OutboxMessageID)
+}
+
+func TestDeadLetterService_RedriveWithoutOriginalTopicUsesPaymentTopic(t *testing.T) {
+	mockDLQ := new(MockDeadLetterQueue)
+	mockRepo := new(MockPaymentRepository)
+	deadLetters := service.NewDeadLetterService(mockDLQ, mockRepo, "payments-test", logrus.New())
+
+	mockDLQ.On("Get", mock.Anything, 0, int64(1)).Return(&model.DeadLetter{Key: "k", Value: []byte("{}")}, nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.MatchedBy(func(message *model.OutboxMessage) bool {
+		return message.Topic == "payments-test"
+	})).Return(nil)
+
+	redrive, err := deadLetters.Redrive(context.Background(), 0, 1)
+
+	require.NoError(t, err)
+	assert.Equal(t, "payments-test", redrive.Topic)
+	mockRepo.AssertExpectations(t)
+}
+
+func newDeadLetterRouter(dlq *MockDeadLetterQueue, repo *MockPaymentRepository) http.Handler {
+	deadLetters := service.NewDeadLetterService(dlq, repo, "payment-processing", logrus.New())
+	h := handler.NewHTTPHandler(new(MockPaymentService), logrus.New(), handler.WithDeadLetters(deadLetters))
+	return h.SetupRoutes()
+}
+
+func TestDeadLetterHandler_ListAndInspect(t *testing.T) {
+	mockDLQ := new(MockDeadLetterQueue)
+	router := newDeadLetterRouter(mockDLQ, new(MockPaymentRepository))
+
+	letter := &model.DeadLetter{Partition: 0, Offset: 5, Key: "tok_abc", Payload: "{}", ErrorClass: model.DeadLetterMalformed}
+	mockDLQ.On("List", mock.Anything, 0, int64(5), 10).Return([]*model.DeadLetter{letter}, nil)
+	mockDLQ.On("Get", mock.Anything, 0, int64(5)).Return(letter, nil)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/dlq/0?offset=5&limit=10", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Messages []model.DeadLetter `json:"messages"`
+		Count    int                `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, 1, page.Count)
+	assert.Equal(t, model.DeadLetterMalformed, page.Messages[0].ErrorClass)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/dlq/0/5", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"error_class":"malformed_message"`)
+}
+
+func TestDeadLetterHandler_RedriveMissingMessageReturns404(t *testing.T) {
+	mockDLQ := new(MockDeadLetterQueue)
+	mockRepo := new(MockPaymentRepository)
+	router := newDeadLetterRouter(mockDLQ, mockRepo)
+
+	mockDLQ.On("Get", mock.Anything, 2, int64(99)).Return(nil, queue.ErrDeadLetterNotFound)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/dlq/2/99/redrive", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	mockRepo.AssertNotCalled(t, "EnqueueOutbox", mock.Anything, mock.Anything)
+}
+
+func TestDeadLetterHandler_RedriveReturns202(t *testing.T) {
+	mockDLQ := new(MockDeadLetterQueue)
+	mockRepo := new(MockPaymentRepository)
+	router := newDeadLetterRouter(mockDLQ, mockRepo)
+
+	mockDLQ.On("Get", mock.Anything, 0, int64(3)).Return(&model.DeadLetter{Key: "k", Value: []byte("{}"), OriginalTopic: "payment-processing"}, nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.Anything).Return(nil)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/dlq/0/3/redrive", nil))
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	mockRepo.AssertExpectations(t)
+}
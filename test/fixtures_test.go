@@ -0,0 +1,89 @@
+package test
+
+import (
+	"time"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// testNetworkReference é o RRN das autorizações aprovadas nos testes
+const testNetworkReference = "000000000042"
+
+// paymentBuilder monta os pagamentos dos testes. Sem ajustes o pagamento é
+// de R$ 100,00 do merchant123 com o cartão tok_abc, de captura automática
+type paymentBuilder struct {
+	payment *model.Payment
+}
+
+func newPayment(status model.PaymentStatus) *paymentBuilder {
+	now := time.Now()
+	return &paymentBuilder{payment: &model.Payment{
+		ID:               uuid.New(),
+		CardToken:        "tok_abc",
+		CardBIN:          "411111",
+		CardLast4:        "1111",
+		CardBrand:        "visa",
+		CardHolder:       "John Doe",
+		ExpiryMonth:      12,
+		ExpiryYear:       2030,
+		Amount:           model.NewMoney(10000, "BRL"),
+		AuthorizedAmount: model.NewMoney(10000, "BRL"),
+		MerchantID:       "merchant123",
+		Status:           status,
+		CaptureMethod:    model.CaptureAutomatic,
+		Installments:     1,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		HeartbeatAt:      now,
+	}}
+}
+
+// amount define o valor do pagamento, que também é o autorizado
+func (b *paymentBuilder) amount(value int64) *paymentBuilder {
+	b.payment.Amount = model.NewMoney(value, "BRL")
+	b.payment.AuthorizedAmount = b.payment.Amount
+	return b
+}
+
+// approved grava a resposta aprovada do adquirente, com o RRN dos testes
+func (b *paymentBuilder) approved() *paymentBuilder {
+	reference := testNetworkReference
+	code := string(model.ResponseApproved)
+	acquirer := "mock"
+	b.payment.NetworkReference = &reference
+	b.payment.ResponseCode = &code
+	b.payment.Acquirer = &acquirer
+	return b
+}
+
+// manualCapture torna o pagamento de captura manual, com a autorização
+// válida por mais uma hora
+func (b *paymentBuilder) manualCapture() *paymentBuilder {
+	expiresAt := time.Now().Add(time.Hour)
+	b.payment.CaptureMethod = model.CaptureManual
+	b.payment.AuthorizationExpiresAt = &expiresAt
+	return b
+}
+
+// captured define o total já capturado
+func (b *paymentBuilder) captured(value int64) *paymentBuilder {
+	captured := model.NewMoney(value, "BRL")
+	b.payment.CapturedAmount = &captured
+	return b
+}
+
+// stuck deixa o pagamento sem atualização nem heartbeat há d, com requeues
+// reenvios
+func (b *paymentBuilder) stuck(d time.Duration, requeues int) *paymentBuilder {
+	b.payment.CreatedAt = time.Now().Add(-d)
+	b.payment.UpdatedAt = b.payment.CreatedAt
+	b.payment.HeartbeatAt = b.payment.CreatedAt
+	b.payment.Requeues = requeues
+	return b
+}
+
+func (b *paymentBuilder) build() *model.Payment {
+	return b.payment
+}
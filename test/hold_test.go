@@ -0,0 +1,149 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentService_CreatePayment_PlacesHoldWithTTL(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+		service.WithHoldTTL(10*time.Minute))
+
+	account := &model.Account{
+		ID:               uuid.New(),
+		CardNumber:       "4111111111111111",
+		AvailableBalance: model.NewMoney(100000, "BRL"),
+		IsActive:         true,
+	}
+
+	req := &model.PaymentRequest{
+		CardNumber:  "4111111111111111",
+		CardHolder:  "John Doe",
+		ExpiryMonth: 12,
+		ExpiryYear:  nextYear,
+		CVV:         "123",
+		Amount:      model.NewMoney(10000, "BRL"),
+		MerchantID:  "merchant123",
+	}
+
+	var created *model.Payment
+	var placed *model.Hold
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, req.CardNumber).Return(account, nil)
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*model.Payment")).
+		Run(func(args mock.Arguments) { created = args.Get(1).(*model.Payment) }).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.AnythingOfType("*model.Hold")).
+		Run(func(args mock.Arguments) { placed = args.Get(1).(*model.Hold) }).Return(nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).Return(nil)
+
+	_, err := paymentService.CreatePayment(context.Background(), req)
+
+	require.NoError(t, err)
+	require.NotNil(t, placed)
+	assert.Equal(t, created.ID, placed.PaymentID)
+	assert.Equal(t, account.ID, placed.AccountID)
+	assert.Equal(t, created.CreatedAt.Add(10*time.Minute), placed.ExpiresAt)
+}
+
+func TestPaymentService_CreatePayment_HoldRejected(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	// O saldo lido cobre o valor, mas outra reserva o consumiu antes do UPDATE
+	account := &model.Account{
+		ID:               uuid.New(),
+		CardNumber:       "4111111111111111",
+		AvailableBalance: model.NewMoney(10000, "BRL"),
+		IsActive:         true,
+	}
+
+	req := &model.PaymentRequest{
+		CardNumber:  "4111111111111111",
+		CardHolder:  "John Doe",
+		ExpiryMonth: 12,
+		ExpiryYear:  nextYear,
+		CVV:         "123",
+		Amount:      model.NewMoney(10000, "BRL"),
+		MerchantID:  "merchant123",
+	}
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, req.CardNumber).Return(account, nil)
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*model.Payment")).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.AnythingOfType("*model.Hold")).Return(repository.ErrInsufficientFunds)
+
+	response, err := paymentService.CreatePayment(context.Background(), req)
+
+	assert.Error(t, err)
+	assert.Nil(t, response)
+	assert.Contains(t, err.Error(), "insufficient balance")
+	mockRepo.AssertNotCalled(t, "EnqueueOutbox", mock.Anything, mock.Anything)
+}
+
+func TestHoldExpirer_RunOnce(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+
+	expired := &model.Hold{ID: uuid.New(), PaymentID: uuid.New(), Amount: model.NewMoney(500, "BRL"), Status: model.HoldStatusActive}
+	processing := &model.Hold{ID: uuid.New(), PaymentID: uuid.New(), Amount: model.NewMoney(700, "BRL"), Status: model.HoldStatusActive}
+
+	mockRepo.On("GetExpiredHolds", mock.Anything, mock.AnythingOfType("time.Time"), 50).Return([]*model.Hold{expired, processing}, nil)
+
+	// Primeira reserva: pagamento ainda pendente, expira normalmente
+	mockRepo.On("ReleaseHold", mock.Anything, expired.PaymentID, model.HoldStatusExpired).Return(expired, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, expired.PaymentID, model.PaymentStatusPending, model.PaymentStatusFailed, mock.Anything).Return(nil)
+
+	// Segunda: o pagamento começou a ser processado depois da consulta
+	mockRepo.On("ReleaseHold", mock.Anything, processing.PaymentID, model.HoldStatusExpired).Return(processing, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, processing.PaymentID, model.PaymentStatusPending, model.PaymentStatusFailed, mock.Anything).
+		Return(&model.TransitionError{From: model.PaymentStatusPending, To: model.PaymentStatusFailed, Current: model.PaymentStatusProcessing})
+
+	var recorded []*model.Hold
+	expirer := service.NewHoldExpirer(mockRepo, time.Minute, 50, logrus.New())
+	expirer.OnExpired(func(hold *model.Hold) { recorded = append(recorded, hold) })
+
+	n, err := expirer.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []*model.Hold{expired}, recorded)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccount_HasSufficientBalance_UsesAvailableBalance(t *testing.T) {
+	account := model.Account{
+		LedgerBalance:    model.NewMoney(100000, "BRL"),
+		AvailableBalance: model.NewMoney(20000, "BRL"),
+		IsActive:         true,
+	}
+
+	assert.True(t, account.HasSufficientBalance(model.NewMoney(20000, "BRL")))
+	assert.False(t, account.HasSufficientBalance(model.NewMoney(20001, "BRL")))
+}
+
+func TestHold_IsExpired(t *testing.T) {
+	now := time.Now()
+	hold := model.Hold{Status: model.HoldStatusActive, ExpiresAt: now}
+
+	assert.True(t, hold.IsExpired(now))
+	assert.False(t, hold.IsExpired(now.Add(-time.Second)))
+
+	hold.Status = model.HoldStatusCaptured
+	assert.False(t, hold.IsExpired(now.Add(time.Hour)))
+}
@@ -0,0 +1,515 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/kms"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Mock do serviço para os testes de handler
+type MockPaymentService struct {
+	mock.Mock
+}
+
+func (m *MockPaymentService) CreatePayment(ctx context.Context, req *model.PaymentRequest) (*model.PaymentResponse, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.PaymentResponse), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPayment(ctx context.Context, id uuid.UUID) (*model.Payment, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPaymentsByMerchant(ctx context.Context, merchantID string, limit, offset int) ([]*model.Payment, error) {
+	args := m.Called(ctx, merchantID, limit, offset)
+	return args.Get(0).([]*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPaymentHistory(ctx context.Context, id uuid.UUID) (*model.Payment, []*model.PaymentEvent, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*model.Payment), args.Get(1).([]*model.PaymentEvent), args.Error(2)
+}
+
+func (m *MockPaymentService) CancelPayment(ctx context.Context, id uuid.UUID, reason, actor string) (*model.Payment, error) {
+	args := m.Called(ctx, id, reason, actor)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) CapturePayment(ctx context.Context, id uuid.UUID, req *model.CaptureRequest) (*model.Payment, error) {
+	args := m.Called(ctx, id, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) IncrementAuthorization(ctx context.Context, id uuid.UUID, req *model.IncrementAuthorizationRequest) (*model.Payment, error) {
+	args := m.Called(ctx, id, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetCaptures(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentCapture, error) {
+	args := m.Called(ctx, paymentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.PaymentCapture), args.Error(1)
+}
+
+func (m *MockPaymentService) VoidPayment(ctx context.Context, id uuid.UUID, reason, actor string) (*model.Payment, error) {
+	args := m.Called(ctx, id, reason, actor)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) ProcessPaymentAsync(ctx context.Context, paymentID string, delivery *model.MessageDelivery) error {
+	args := m.Called(ctx, paymentID, delivery)
+	return args.Error(0)
+}
+
+func (m *MockPaymentService) CreateRefund(ctx context.Context, paymentID uuid.UUID, req *model.RefundRequest) (*model.Refund, error) {
+	args := m.Called(ctx, paymentID, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Refund), args.Error(1)
+}
+
+func (m *MockPaymentService) GetRefunds(ctx context.Context, paymentID uuid.UUID) ([]*model.Refund, error) {
+	args := m.Called(ctx, paymentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.Refund), args.Error(1)
+}
+
+func (m *MockPaymentService) GetInstallmentRules(ctx context.Context, merchantID string) (*model.InstallmentRules, error) {
+	args := m.Called(ctx, merchantID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.InstallmentRules), args.Error(1)
+}
+
+func (m *MockPaymentService) SetInstallmentRules(ctx context.Context, rules *model.InstallmentRules) (*model.InstallmentRules, error) {
+	args := m.Called(ctx, rules)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.InstallmentRules), args.Error(1)
+}
+
+func (m *MockPaymentService) ProcessRefundAsync(ctx context.Context, refundID string, delivery *model.MessageDelivery) error {
+	args := m.Called(ctx, refundID, delivery)
+	return args.Error(0)
+}
+
+func (m *MockPaymentService) GetAccountHolds(ctx context.Context, accountID uuid.UUID, status model.HoldStatus, limit, offset int) (*model.Account, []*model.Hold, error) {
+	args := m.Called(ctx, accountID, status, limit, offset)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*model.Account), args.Get(1).([]*model.Hold), args.Error(2)
+}
+
+func (m *MockPaymentService) SettleMerchant(ctx context.Context, merchantID string, currency model.Currency) (*model.JournalEntry, error) {
+	args := m.Called(ctx, merchantID, currency)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.JournalEntry), args.Error(1)
+}
+
+func (m *MockPaymentService) CheckLedger(ctx context.Context) (*model.LedgerReport, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.LedgerReport), args.Error(1)
+}
+
+// memoryIdempotencyStore reproduz em memória a semântica da tabela idempotency_keys
+type memoryIdempotencyStore struct {
+	mu   sync.Mutex
+	keys map[string]*model.IdempotencyKey
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{keys: map[string]*model.IdempotencyKey{}}
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := key.MerchantID + "/" + key.Key
+	if existing, ok := s.keys[id]; ok && existing.ExpiresAt.After(key.CreatedAt) {
+		reclaimable := existing.RequestHash == key.RequestHash && existing.IsLeaseExpired(key.CreatedAt)
+		if !reclaimable {
+			copied := *existing
+			return &copied, false, nil
+		}
+	}
+
+	copied := *key
+	s.keys[id] = &copied
+	reserved := copied
+	return &reserved, true, nil
+}
+
+// holds indica se key ainda é a dona do lease do registro em andamento
+func holds(record, key *model.IdempotencyKey) bool {
+	return !record.IsCompleted() && record.LockedUntil != nil && key.LockedUntil != nil &&
+		record.LockedUntil.Equal(*key.LockedUntil)
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, key *model.IdempotencyKey, status int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.keys[key.MerchantID+"/"+key.Key]
+	if !ok || !holds(record, key) {
+		return repository.ErrIdempotencyKeyNotFound
+	}
+
+	now := time.Now()
+	record.ResponseStatus = status
+	record.ResponseBody = append([]byte(nil), body...)
+	record.CompletedAt = &now
+	record.LockedUntil = nil
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key *model.IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.keys[key.MerchantID+"/"+key.Key]; ok && holds(record, key) {
+		delete(s.keys, key.MerchantID+"/"+key.Key)
+	}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time, limit int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, record := range s.keys {
+		if deleted == int64(limit) {
+			break
+		}
+		if !record.ExpiresAt.After(now) {
+			delete(s.keys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+const idempotentPaymentBody = `{
+	"card_token": "tok_abc",
+	"card_holder": "John Doe",
+	"expiry_month": 12,
+	"expiry_year": 2030,
+	"cvv": "123",
+	"amount": {"value": 10000, "currency": "BRL"},
+	"merchant_id": "merchant123"
+}`
+
+func newIdempotentRouter(svc service.PaymentService, store repository.IdempotencyRepository, ttl time.Duration, opts ...handler.Option) http.Handler {
+	keys, err := kms.NewKeyManager(context.Background(), &memoryDataKeyStore{}, testKeyfile("mk-1"))
+	if err != nil {
+		panic(err)
+	}
+
+	opts = append([]handler.Option{handler.WithIdempotency(store, keys, ttl)}, opts...)
+	h := handler.NewHTTPHandler(svc, logrus.New(), opts...)
+	return h.SetupRoutes()
+}
+
+func postPayment(router http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/payments", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency_RetryReplaysOriginalResponse(t *testing.T) {
+	svc := new(MockPaymentService)
+	router := newIdempotentRouter(svc, newMemoryIdempotencyStore(), time.Hour)
+
+	response := &model.PaymentResponse{ID: uuid.New(), Status: model.PaymentStatusPending, Message: "Payment created successfully"}
+	svc.On("CreatePayment", mock.Anything, mock.AnythingOfType("*model.PaymentRequest")).Return(response, nil).Once()
+
+	first := postPayment(router, "key-1", idempotentPaymentBody)
+	retry := postPayment(router, "key-1", idempotentPaymentBody)
+
+	require.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	svc.AssertNumberOfCalls(t, "CreatePayment", 1)
+}
+
+func TestIdempotency_DifferentBodyReturns422(t *testing.T) {
+	svc := new(MockPaymentService)
+	router := newIdempotentRouter(svc, newMemoryIdempotencyStore(), time.Hour)
+
+	svc.On("CreatePayment", mock.Anything, mock.Anything).Return(&model.PaymentResponse{ID: uuid.New()}, nil).Once()
+
+	postPayment(router, "key-1", idempotentPaymentBody)
+	w := postPayment(router, "key-1", strings.Replace(idempotentPaymentBody, "10000", "20000", 1))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	svc.AssertNumberOfCalls(t, "CreatePayment", 1)
+}
+
+func TestIdempotency_SameRequestFormattedDifferentlyIsReplayed(t *testing.T) {
+	svc := new(MockPaymentService)
+	router := newIdempotentRouter(svc, newMemoryIdempotencyStore(), time.Hour)
+
+	svc.On("CreatePayment", mock.Anything, mock.Anything).Return(&model.PaymentResponse{ID: uuid.New()}, nil).Once()
+
+	compact := `{"merchant_id":"merchant123","amount":{"currency":"BRL","value":10000},"cvv":"123",` +
+		`"expiry_year":2030,"expiry_month":12,"card_holder":"John Doe","card_token":"tok_abc"}`
+
+	postPayment(router, "key-1", idempotentPaymentBody)
+	w := postPayment(router, "key-1", compact+"\n")
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	svc.AssertNumberOfCalls(t, "CreatePayment", 1)
+}
+
+func TestIdempotency_RequestHashIsKeyed(t *testing.T) {
+	svc := new(MockPaymentService)
+	store := newMemoryIdempotencyStore()
+	router := newIdempotentRouter(svc, store, time.Hour)
+
+	svc.On("CreatePayment", mock.Anything, mock.Anything).Return(&model.PaymentResponse{ID: uuid.New()}, nil)
+
+	body := `{"card_number":"4111111111111111","cvv":"123","merchant_id":"merchant123"}`
+	postPayment(router, "key-1", body)
+
+	record := store.keys["merchant123/key-1"]
+	require.NotNil(t, record)
+
+	// Sem a chave de índice não dá para recalcular o hash a partir de um
+	// palpite do cartão
+	plain := sha256.Sum256([]byte(body))
+	assert.NotEqual(t, hex.EncodeToString(plain[:]), record.RequestHash)
+	assert.Len(t, record.RequestHash, 64)
+}
+
+func TestIdempotency_InternalFailureReleasesKeyForRetry(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+	router := newIdempotentRouter(paymentService, newMemoryIdempotencyStore(), time.Hour)
+
+	card := &model.Card{Number: "4111111111111111", ExpiryMonth: 12, ExpiryYear: 2030}
+	account := &model.Account{
+		ID:               uuid.New(),
+		CardNumber:       card.Number,
+		AvailableBalance: model.NewMoney(100000, "BRL"),
+		IsActive:         true,
+	}
+
+	// O banco cai na primeira tentativa e volta na segunda
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(card, nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, card.Number).Return(nil, errors.New("connection refused")).Once()
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, card.Number).Return(account, nil).Once()
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*model.Payment")).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.AnythingOfType("*model.Hold")).Return(nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).Return(nil)
+
+	first := postPayment(router, "key-1", idempotentPaymentBody)
+	retry := postPayment(router, "key-1", idempotentPaymentBody)
+
+	assert.Equal(t, http.StatusInternalServerError, first.Code)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Empty(t, retry.Header().Get("Idempotent-Replayed"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestIdempotency_RejectedRequestIsReplayed(t *testing.T) {
+	svc := new(MockPaymentService)
+	router := newIdempotentRouter(svc, newMemoryIdempotencyStore(), time.Hour)
+
+	svc.On("CreatePayment", mock.Anything, mock.Anything).
+		Return(nil, &service.RequestError{Err: errors.New("insufficient balance")}).Once()
+
+	first := postPayment(router, "key-1", idempotentPaymentBody)
+	retry := postPayment(router, "key-1", idempotentPaymentBody)
+
+	// Uma recusa do pedido não muda numa repetição e fica gravada
+	assert.Equal(t, http.StatusBadRequest, first.Code)
+	assert.Equal(t, http.StatusBadRequest, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	svc.AssertNumberOfCalls(t, "CreatePayment", 1)
+}
+
+func TestIdempotency_InFlightDuplicateReturns409(t *testing.T) {
+	svc := new(MockPaymentService)
+	router := newIdempotentRouter(svc, newMemoryIdempotencyStore(), time.Hour)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	svc.On("CreatePayment", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			close(started)
+			<-release
+		}).
+		Return(&model.PaymentResponse{ID: uuid.New()}, nil).Once()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postPayment(router, "key-1", idempotentPaymentBody) }()
+
+	<-started
+	duplicate := postPayment(router, "key-1", idempotentPaymentBody)
+	close(release)
+	first := <-done
+
+	assert.Equal(t, http.StatusConflict, duplicate.Code)
+	assert.Equal(t, http.StatusCreated, first.Code)
+}
+
+func TestIdempotency_ExpiredLeaseIsReclaimedBySameRequest(t *testing.T) {
+	svc := new(MockPaymentService)
+	store := newMemoryIdempotencyStore()
+	router := newIdempotentRouter(svc, store, time.Hour, handler.WithIdempotencyLease(-time.Second))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	svc.On("CreatePayment", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			close(started)
+			<-release
+		}).
+		Return(&model.PaymentResponse{ID: uuid.New()}, nil).Once()
+	svc.On("CreatePayment", mock.Anything, mock.Anything).Return(&model.PaymentResponse{ID: uuid.New()}, nil).Once()
+
+	// A primeira requisição trava depois de reservar, como uma réplica que
+	// morreu; com o lease vencido a repetição reserva a chave de novo
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postPayment(router, "key-1", idempotentPaymentBody) }()
+	<-started
+
+	retry := postPayment(router, "key-1", idempotentPaymentBody)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+
+	// Outro corpo com a mesma chave continua recusado
+	other := postPayment(router, "key-1", strings.Replace(idempotentPaymentBody, "10000", "20000", 1))
+	assert.Equal(t, http.StatusUnprocessableEntity, other.Code)
+
+	// A requisição original perdeu o lease: a resposta guardada é a da repetição
+	close(release)
+	<-done
+	record := store.keys["merchant123/key-1"]
+	require.NotNil(t, record)
+	require.True(t, record.IsCompleted())
+	assert.Equal(t, retry.Body.String(), string(record.ResponseBody))
+}
+
+func TestIdempotency_KeysAreScopedPerMerchant(t *testing.T) {
+	svc := new(MockPaymentService)
+	router := newIdempotentRouter(svc, newMemoryIdempotencyStore(), time.Hour)
+
+	svc.On("CreatePayment", mock.Anything, mock.Anything).Return(&model.PaymentResponse{ID: uuid.New()}, nil)
+
+	other := bytes.Replace([]byte(idempotentPaymentBody), []byte("merchant123"), []byte("merchant456"), 1)
+
+	assert.Equal(t, http.StatusCreated, postPayment(router, "key-1", idempotentPaymentBody).Code)
+	assert.Equal(t, http.StatusCreated, postPayment(router, "key-1", string(other)).Code)
+	svc.AssertNumberOfCalls(t, "CreatePayment", 2)
+}
+
+func TestIdempotency_ExpiredKeyIsReused(t *testing.T) {
+	svc := new(MockPaymentService)
+	router := newIdempotentRouter(svc, newMemoryIdempotencyStore(), -time.Second)
+
+	svc.On("CreatePayment", mock.Anything, mock.Anything).Return(&model.PaymentResponse{ID: uuid.New()}, nil)
+
+	postPayment(router, "key-1", idempotentPaymentBody)
+	w := postPayment(router, "key-1", idempotentPaymentBody)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	svc.AssertNumberOfCalls(t, "CreatePayment", 2)
+}
+
+func TestIdempotency_WithoutHeaderIsNotDeduplicated(t *testing.T) {
+	svc := new(MockPaymentService)
+	router := newIdempotentRouter(svc, newMemoryIdempotencyStore(), time.Hour)
+
+	svc.On("CreatePayment", mock.Anything, mock.Anything).Return(&model.PaymentResponse{ID: uuid.New()}, nil)
+
+	postPayment(router, "", idempotentPaymentBody)
+	postPayment(router, "", idempotentPaymentBody)
+
+	svc.AssertNumberOfCalls(t, "CreatePayment", 2)
+}
+
+func TestIdempotencyPurger_RunOnce(t *testing.T) {
+	store := newMemoryIdempotencyStore()
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		store.Reserve(context.Background(), &model.IdempotencyKey{
+			MerchantID: "merchant123", Key: uuid.NewString(), CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour),
+		})
+	}
+	store.Reserve(context.Background(), &model.IdempotencyKey{
+		MerchantID: "merchant123", Key: "live", CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+	})
+
+	purged, err := service.NewIdempotencyPurger(store, time.Minute, 2, logrus.New()).RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), purged)
+	assert.Len(t, store.keys, 1)
+}
@@ -0,0 +1,285 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/installment"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func installmentRules() *model.InstallmentRules {
+	return &model.InstallmentRules{
+		MerchantID:               "merchant123",
+		MaxInstallments:          12,
+		MinInstallmentAmount:     model.NewMoney(1000, "BRL"),
+		InterestFreeInstallments: 6,
+		Interest:                 model.InstallmentInterestMerchant,
+		MonthlyRateBPS:           199,
+	}
+}
+
+func TestInstallmentPlan_InterestFreeSplitsRemainderOnFirst(t *testing.T) {
+	start := time.Date(2026, 1, 15, 14, 30, 0, 0, time.UTC)
+
+	plan, err := installment.Plan(model.NewMoney(10000, "BRL"), 3, installmentRules(), start)
+
+	require.NoError(t, err)
+	assert.Equal(t, model.InstallmentInterestFree, plan.Interest)
+	assert.Zero(t, plan.MonthlyRateBPS)
+	assert.Equal(t, model.NewMoney(10000, "BRL"), plan.Total)
+	assert.Equal(t, plan.Principal, plan.Charged())
+	require.Len(t, plan.Schedule, 3)
+	assert.Equal(t, int64(3334), plan.Schedule[0].Amount.Value)
+	assert.Equal(t, int64(3333), plan.Schedule[2].Amount.Value)
+	assert.Equal(t, time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC), plan.Schedule[0].DueDate)
+	assert.Equal(t, time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC), plan.Schedule[2].DueDate)
+}
+
+func TestInstallmentPlan_DueDatesClampToEndOfMonth(t *testing.T) {
+	start := time.Date(2027, 12, 31, 9, 0, 0, 0, time.UTC)
+
+	plan, err := installment.Plan(model.NewMoney(120000, "BRL"), 6, installmentRules(), start)
+
+	require.NoError(t, err)
+	due := make([]time.Time, 0, len(plan.Schedule))
+	for _, installment := range plan.Schedule {
+		due = append(due, installment.DueDate)
+	}
+	// 2028 é bissexto; os meses curtos não empurram o vencimento para o seguinte
+	assert.Equal(t, []time.Time{
+		time.Date(2028, 1, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		time.Date(2028, 3, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2028, 4, 30, 0, 0, 0, 0, time.UTC),
+		time.Date(2028, 5, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2028, 6, 30, 0, 0, 0, 0, time.UTC),
+	}, due)
+}
+
+func TestInstallmentPlan_InterestAboveInterestFreeUsesPriceTable(t *testing.T) {
+	plan, err := installment.Plan(model.NewMoney(100000, "BRL"), 12, installmentRules(), time.Now())
+
+	require.NoError(t, err)
+	assert.Equal(t, model.InstallmentInterestMerchant, plan.Interest)
+	assert.Equal(t, int64(199), plan.MonthlyRateBPS)
+	for _, installment := range plan.Schedule {
+		assert.Equal(t, model.NewMoney(9450, "BRL"), installment.Amount)
+	}
+	assert.Equal(t, model.NewMoney(113400, "BRL"), plan.Total)
+	// Com juros do lojista o cartão é cobrado pelo total das parcelas
+	assert.Equal(t, plan.Total, plan.Charged())
+
+	rules := installmentRules()
+	rules.Interest = model.InstallmentInterestIssuer
+	plan, err = installment.Plan(model.NewMoney(100000, "BRL"), 12, rules, time.Now())
+
+	require.NoError(t, err)
+	assert.Equal(t, model.NewMoney(100000, "BRL"), plan.Charged())
+}
+
+func TestInstallmentPlan_Rejections(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount model.Money
+		count  int
+		err    error
+	}{
+		{name: "above merchant maximum", amount: model.NewMoney(100000, "BRL"), count: 13, err: installment.ErrInvalidInstallments},
+		{name: "zero installments", amount: model.NewMoney(100000, "BRL"), count: 0, err: installment.ErrInvalidInstallments},
+		{name: "installment below minimum", amount: model.NewMoney(5000, "BRL"), count: 6, err: installment.ErrInstallmentBelowMinimum},
+		{name: "currency other than BRL", amount: model.NewMoney(100000, "USD"), count: 3, err: installment.ErrCurrencyNotSupported},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := installment.Plan(tc.amount, tc.count, installmentRules(), time.Now())
+
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestPaymentService_CreatePayment_WithMerchantInterestInstallments(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	account := &model.Account{
+		ID:               uuid.New(),
+		CardNumber:       "4111111111111111",
+		AvailableBalance: model.NewMoney(500000, "BRL"),
+		IsActive:         true,
+	}
+	req := &model.PaymentRequest{
+		CardNumber:   "4111111111111111",
+		CardHolder:   "John Doe",
+		ExpiryMonth:  12,
+		ExpiryYear:   nextYear,
+		CVV:          "123",
+		Amount:       model.NewMoney(100000, "BRL"),
+		MerchantID:   "merchant123",
+		Installments: 12,
+	}
+	charged := model.NewMoney(113400, "BRL")
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
+	mockRepo.On("GetInstallmentRules", mock.Anything, "merchant123").Return(installmentRules(), nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, req.CardNumber).Return(account, nil)
+	mockRepo.On("Create", mock.Anything, mock.MatchedBy(func(p *model.Payment) bool {
+		return p.Amount == charged && p.Installments == 12 && p.InstallmentPlan.Principal == req.Amount
+	})).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.MatchedBy(func(h *model.Hold) bool {
+		return h.Amount == charged
+	})).Return(nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.MatchedBy(func(message *model.OutboxMessage) bool {
+		var payload queue.PaymentMessage
+		return json.Unmarshal(message.Payload, &payload) == nil &&
+			payload.InstallmentPlan != nil && payload.InstallmentPlan.Count == 12
+	})).Return(nil)
+
+	response, err := paymentService.CreatePayment(context.Background(), req)
+
+	require.NoError(t, err)
+	assert.Equal(t, charged, response.Amount)
+	require.NotNil(t, response.InstallmentPlan)
+	assert.Len(t, response.InstallmentPlan.Schedule, 12)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentService_CreatePayment_InstallmentsUseDefaultRules(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+		service.WithInstallmentRules(model.InstallmentRules{
+			MaxInstallments:          6,
+			MinInstallmentAmount:     model.NewMoney(500, "BRL"),
+			InterestFreeInstallments: 6,
+			Interest:                 model.InstallmentInterestIssuer,
+		}))
+
+	req := &model.PaymentRequest{
+		CardNumber:   "4111111111111111",
+		CardHolder:   "John Doe",
+		ExpiryMonth:  12,
+		ExpiryYear:   nextYear,
+		CVV:          "123",
+		Amount:       model.NewMoney(100000, "BRL"),
+		MerchantID:   "merchant123",
+		Installments: 10,
+	}
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
+	mockRepo.On("GetInstallmentRules", mock.Anything, "merchant123").Return(nil, repository.ErrInstallmentRulesNotFound)
+
+	_, err := paymentService.CreatePayment(context.Background(), req)
+
+	assert.ErrorIs(t, err, installment.ErrInvalidInstallments)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_CreatePayment_RejectsInstallmentsWithManualCapture(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	req := &model.PaymentRequest{
+		CardNumber:    "4111111111111111",
+		CardHolder:    "John Doe",
+		ExpiryMonth:   12,
+		ExpiryYear:    nextYear,
+		CVV:           "123",
+		Amount:        model.NewMoney(100000, "BRL"),
+		MerchantID:    "merchant123",
+		CaptureMethod: model.CaptureManual,
+		Installments:  3,
+	}
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
+
+	_, err := paymentService.CreatePayment(context.Background(), req)
+
+	assert.ErrorIs(t, err, installment.ErrInvalidInstallments)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_CreatePayment_RejectsNegativeInstallments(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	req := &model.PaymentRequest{
+		CardNumber:   "4111111111111111",
+		CardHolder:   "John Doe",
+		ExpiryMonth:  12,
+		ExpiryYear:   nextYear,
+		CVV:          "123",
+		Amount:       model.NewMoney(100000, "BRL"),
+		MerchantID:   "merchant123",
+		Installments: -3,
+	}
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
+
+	_, err := paymentService.CreatePayment(context.Background(), req)
+
+	// Erro do pedido, respondido com 400
+	var requestErr *service.RequestError
+	require.ErrorAs(t, err, &requestErr)
+	assert.ErrorIs(t, err, installment.ErrInvalidInstallments)
+	mockRepo.AssertNotCalled(t, "GetAccountByCardNumber", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_SetInstallmentRules_RejectsInvalidRules(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New())
+
+	rules := installmentRules()
+	rules.InterestFreeInstallments = 18
+
+	_, err := paymentService.SetInstallmentRules(context.Background(), rules)
+
+	assert.ErrorIs(t, err, model.ErrInvalidInstallmentRules)
+	mockRepo.AssertNotCalled(t, "SaveInstallmentRules", mock.Anything, mock.Anything)
+}
+
+func TestInstallmentRulesHandler(t *testing.T) {
+	mockService := new(MockPaymentService)
+	router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+	mockService.On("SetInstallmentRules", mock.Anything, mock.MatchedBy(func(rules *model.InstallmentRules) bool {
+		return rules.MerchantID == "merchant123" && rules.MaxInstallments == 12
+	})).Return(installmentRules(), nil)
+	mockService.On("GetInstallmentRules", mock.Anything, "merchant123").Return(installmentRules(), nil)
+
+	body := `{"max_installments":12,"min_installment_amount":{"value":1000,"currency":"BRL"},` +
+		`"interest_free_installments":6,"interest":"merchant","monthly_rate_bps":199}`
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/merchants/merchant123/installment-rules", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/merchants/merchant123/installment-rules", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"interest_free_installments":6`)
+}
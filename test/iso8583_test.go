@@ -0,0 +1,390 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/iso8583"
+	"golang-payment-microservice/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestISO8583_PackUnpackRoundTrip(t *testing.T) {
+	msg := iso8583.NewMessage(iso8583.MTIAuthorizationRequest).
+		Set(iso8583.FieldPAN, "4242424242424242").
+		Set(iso8583.FieldAmount, "10000").
+		Set(iso8583.FieldSTAN, "000042").
+		Set(iso8583.FieldRRN, "628914000042").
+		Set(iso8583.FieldTerminalID, "TERM1").
+		Set(iso8583.FieldAdditionalData, "payment 123")
+
+	data, err := iso8583.DefaultSpec.Pack(msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "0100", string(data[:4]))
+	// Campos 2, 4, 11, 37, 41 e 48, sem bitmap secundário
+	assert.Equal(t, []byte{0x50, 0x20, 0x00, 0x00, 0x08, 0x81, 0x00, 0x00}, data[4:12])
+	assert.True(t, bytes.HasPrefix(data[12:], []byte("164242424242424242000000010000")))
+	assert.True(t, bytes.HasSuffix(data, []byte("TERM1   011payment 123")))
+
+	decoded, err := iso8583.DefaultSpec.Unpack(data)
+	require.NoError(t, err)
+	assert.Equal(t, iso8583.MTIAuthorizationRequest, decoded.MTI)
+	assert.Equal(t, []int{2, 4, 11, 37, 41, 48}, decoded.Fields())
+	assert.Equal(t, "4242424242424242", decoded.Get(iso8583.FieldPAN))
+	assert.Equal(t, "000000010000", decoded.Get(iso8583.FieldAmount))
+	assert.Equal(t, "TERM1   ", decoded.Get(iso8583.FieldTerminalID))
+	assert.Equal(t, "payment 123", decoded.Get(iso8583.FieldAdditionalData))
+}
+
+func TestISO8583_SecondaryBitmap(t *testing.T) {
+	msg := iso8583.NewMessage(iso8583.MTIReversalRequest).
+		Set(iso8583.FieldSTAN, "000043").
+		Set(iso8583.FieldOriginalData, "010000004210161200000000000000000000000000")
+
+	data, err := iso8583.DefaultSpec.Pack(msg)
+	require.NoError(t, err)
+	// Bit 1 indica o bitmap secundário, em que o campo 90 é o bit 26
+	assert.Equal(t, byte(0x80), data[4]&0x80)
+	assert.Equal(t, byte(0x40), data[12+3])
+	assert.Len(t, data, 4+16+6+42)
+
+	decoded, err := iso8583.DefaultSpec.Unpack(data)
+	require.NoError(t, err)
+	assert.Equal(t, msg.Get(iso8583.FieldOriginalData), decoded.Get(iso8583.FieldOriginalData))
+}
+
+func TestISO8583_InvalidMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *iso8583.Message
+		err  error
+	}{
+		{"bad MTI", iso8583.NewMessage("01A0"), iso8583.ErrInvalidMTI},
+		{"PAN too long", iso8583.NewMessage("0100").Set(iso8583.FieldPAN, "42424242424242424242"), iso8583.ErrInvalidValue},
+		{"non numeric amount", iso8583.NewMessage("0100").Set(iso8583.FieldAmount, "10.00"), iso8583.ErrInvalidValue},
+		{"symbol in alphanumeric field", iso8583.NewMessage("0110").Set(iso8583.FieldResponseCode, "0-"), iso8583.ErrInvalidValue},
+		{"field outside spec", iso8583.NewMessage("0100").Set(70, "301"), iso8583.ErrUnknownField},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := iso8583.DefaultSpec.Pack(tt.msg)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	data, err := iso8583.DefaultSpec.Pack(iso8583.NewMessage("0100").Set(iso8583.FieldAdditionalData, "abc"))
+	require.NoError(t, err)
+
+	_, err = iso8583.DefaultSpec.Unpack(data[:len(data)-1])
+	assert.ErrorIs(t, err, iso8583.ErrMalformed)
+	_, err = iso8583.DefaultSpec.Unpack(append(data, '0'))
+	assert.ErrorIs(t, err, iso8583.ErrMalformed)
+}
+
+func TestISO8583_ResponseMTIAndFraming(t *testing.T) {
+	for request, response := range map[string]string{"0100": "0110", "0200": "0210", "0400": "0410"} {
+		mti, err := iso8583.ResponseMTI(request)
+		require.NoError(t, err)
+		assert.Equal(t, response, mti)
+		assert.True(t, iso8583.IsResponse(mti))
+	}
+	_, err := iso8583.ResponseMTI("0110")
+	assert.ErrorIs(t, err, iso8583.ErrInvalidMTI)
+
+	var buf bytes.Buffer
+	require.NoError(t, iso8583.WriteFrame(&buf, []byte("first")))
+	require.NoError(t, iso8583.WriteFrame(&buf, []byte("second")))
+	assert.Equal(t, []byte{0x00, 0x05}, buf.Bytes()[:2])
+
+	first, err := iso8583.ReadFrame(&buf)
+	require.NoError(t, err)
+	second, err := iso8583.ReadFrame(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(first))
+	assert.Equal(t, "second", string(second))
+}
+
+// startISO8583Issuer sobe o simulador do emissor numa porta livre e retorna
+// um gateway conectado a ele
+func startISO8583Issuer(t *testing.T, opts ...gateway.ISO8583Option) *gateway.ISO8583Gateway {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	issuer := gateway.NewISO8583Issuer(logrus.New(), gateway.WithIssuerLatency(gateway.LatencyNone))
+	go issuer.Serve(ctx, listener)
+
+	g := gateway.NewISO8583Gateway(listener.Addr().String(), logrus.New(), opts...)
+	t.Cleanup(func() {
+		g.Close()
+		cancel()
+	})
+	return g
+}
+
+func iso8583Authorization(pan string, amount int64) *model.AuthorizationRequest {
+	return &model.AuthorizationRequest{
+		PaymentID:  uuid.New(),
+		MerchantID: "merchant_123",
+		Amount:     model.NewMoney(amount, "BRL"),
+		Card:       &model.Card{Number: pan, ExpiryMonth: 12, ExpiryYear: 2030},
+	}
+}
+
+func TestISO8583Gateway_AuthorizeAgainstIssuerSimulator(t *testing.T) {
+	g := startISO8583Issuer(t, gateway.WithResponseTimeout(200*time.Millisecond))
+
+	tests := []struct {
+		name     string
+		pan      string
+		amount   int64
+		approved bool
+		code     model.AcquirerResponseCode
+		err      error
+	}{
+		{"approved", "4242424242424242", 10000, true, model.ResponseApproved, nil},
+		{"insufficient funds", "4000000000009995", 10000, false, model.ResponseInsufficientFunds, nil},
+		{"stolen card by amount", "4111111111111111", 10043, false, model.ResponseStolenCard, nil},
+		{"3ds challenge", "4000000000003220", 10000, false, model.ResponseAuthenticationRequired, nil},
+		{"timeout", "4000000000000069", 10000, false, "", gateway.ErrTimeout},
+		{"network error", "4000000000000119", 10000, false, "", gateway.ErrNetwork},
+		// A conexão derrubada pelo erro de rede é reaberta no pedido seguinte
+		{"approved after reconnect", "4111111111111111", 10000, true, model.ResponseApproved, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := g.Authorize(context.Background(), iso8583Authorization(tt.pan, tt.amount))
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				assert.ErrorIs(t, err, gateway.ErrUnavailable)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.approved, response.Approved)
+			assert.Equal(t, tt.code, response.ResponseCode)
+			assert.Len(t, response.NetworkReference, 12)
+			if tt.approved {
+				assert.Len(t, response.ApprovalCode, 6)
+			} else {
+				assert.NotEmpty(t, response.DeclineReason)
+			}
+		})
+	}
+}
+
+func TestISO8583Gateway_FollowUpsReferToAuthorization(t *testing.T) {
+	g := startISO8583Issuer(t)
+
+	auth := iso8583Authorization("4242424242424242", 10000)
+	authorization, err := g.Authorize(context.Background(), auth)
+	require.NoError(t, err)
+
+	req := &model.AcquirerRequest{PaymentID: auth.PaymentID, Amount: auth.Amount, NetworkReference: authorization.NetworkReference}
+	for name, call := range map[string]func(context.Context, *model.AcquirerRequest) (*model.AcquirerResponse, error){
+		"capture": g.Capture,
+		"void":    g.Void,
+		"refund":  g.Refund,
+	} {
+		response, err := call(context.Background(), req)
+		require.NoError(t, err, name)
+		assert.True(t, response.Approved, name)
+		assert.Equal(t, authorization.NetworkReference, response.NetworkReference, name)
+	}
+}
+
+func TestISO8583Gateway_RefundRetriesCarryTheSameReference(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// Rede que lê os pedidos e nunca responde
+	references := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			data, err := iso8583.ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			msg, _ := iso8583.DefaultSpec.Unpack(data)
+			references <- msg.Get(iso8583.FieldAdditionalData)
+		}
+	}()
+
+	g := gateway.NewISO8583Gateway(listener.Addr().String(), logrus.New(), gateway.WithResponseTimeout(100*time.Millisecond))
+	defer g.Close()
+
+	refundID := uuid.New().String()
+	req := &model.AcquirerRequest{
+		PaymentID:        uuid.New(),
+		Amount:           model.NewMoney(2500, "BRL"),
+		NetworkReference: "000000000042",
+		Reference:        refundID,
+	}
+	for i := 0; i < 2; i++ {
+		_, err := g.Refund(context.Background(), req)
+		require.ErrorIs(t, err, gateway.ErrTimeout)
+		assert.Equal(t, refundID, <-references)
+	}
+}
+
+// traceNumbers é uma origem de STANs compartilhada de mentira, que começa em
+// next ou falha com err
+type traceNumbers struct {
+	next int64
+	err  error
+}
+
+func (s *traceNumbers) NextTraceNumber(ctx context.Context) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.next++
+	return s.next, nil
+}
+
+func TestISO8583Gateway_TakesSTANAndRRNFromTraceNumberSource(t *testing.T) {
+	g := startISO8583Issuer(t, gateway.WithTraceNumbers(&traceNumbers{next: 123455}))
+
+	response, err := g.Authorize(context.Background(), iso8583Authorization("4242424242424242", 10000))
+
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(response.NetworkReference, "123456"), response.NetworkReference)
+}
+
+func TestISO8583Gateway_TraceNumberFailureSendsNothing(t *testing.T) {
+	g := startISO8583Issuer(t, gateway.WithTraceNumbers(&traceNumbers{err: errors.New("connection refused")}))
+
+	_, err := g.Authorize(context.Background(), iso8583Authorization("4242424242424242", 10000))
+
+	assert.ErrorIs(t, err, gateway.ErrUnavailable)
+}
+
+func TestISO8583Gateway_ReversesAuthorizationWhenConnectionDropsAfterWrite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// Rede que lê a autorização e derruba a conexão sem responder; a
+	// reversão chega numa conexão nova
+	reversals := make(chan *iso8583.Message, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		iso8583.ReadFrame(conn)
+		conn.Close()
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, err := iso8583.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		msg, _ := iso8583.DefaultSpec.Unpack(data)
+		reversals <- msg
+	}()
+
+	g := gateway.NewISO8583Gateway(listener.Addr().String(), logrus.New(),
+		gateway.WithResponseTimeout(time.Second), gateway.WithTraceNumbers(&traceNumbers{next: 41}))
+	defer g.Close()
+
+	_, err = g.Authorize(context.Background(), iso8583Authorization("4242424242424242", 10000))
+	require.ErrorIs(t, err, gateway.ErrNetwork)
+
+	select {
+	case reversal := <-reversals:
+		assert.Equal(t, iso8583.MTIReversalRequest, reversal.MTI)
+		assert.True(t, strings.HasPrefix(reversal.Get(iso8583.FieldOriginalData), "0100000042"))
+	case <-time.After(2 * time.Second):
+		t.Fatal("authorization was not reversed")
+	}
+}
+
+func TestISO8583Gateway_MatchesOutOfOrderResponsesBySTAN(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// Rede que responde aos dois primeiros pedidos na ordem inversa, com uma
+	// resposta de RRN trocado antes, que deve ser descartada
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var requests []*iso8583.Message
+		for len(requests) < 2 {
+			data, err := iso8583.ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			msg, _ := iso8583.DefaultSpec.Unpack(data)
+			requests = append(requests, msg)
+		}
+
+		respond := func(request *iso8583.Message, rrn, code string) {
+			response := iso8583.NewMessage(iso8583.MTIAuthorizationResponse).
+				Set(iso8583.FieldSTAN, request.Get(iso8583.FieldSTAN)).
+				Set(iso8583.FieldRRN, rrn).
+				Set(iso8583.FieldResponseCode, code).
+				Set(iso8583.FieldApprovalCode, "123456")
+			data, _ := iso8583.DefaultSpec.Pack(response)
+			iso8583.WriteFrame(conn, data)
+		}
+
+		respond(requests[1], "999999999999", "00")
+		respond(requests[1], requests[1].Get(iso8583.FieldRRN), "51")
+		respond(requests[0], requests[0].Get(iso8583.FieldRRN), "00")
+		time.Sleep(time.Second)
+	}()
+
+	g := gateway.NewISO8583Gateway(listener.Addr().String(), logrus.New(), gateway.WithResponseTimeout(2*time.Second))
+	defer g.Close()
+
+	type result struct {
+		response *model.AcquirerResponse
+		err      error
+	}
+	first := make(chan result, 1)
+	go func() {
+		response, err := g.Authorize(context.Background(), iso8583Authorization("4242424242424242", 10000))
+		first <- result{response, err}
+	}()
+	// O segundo pedido sai depois do primeiro, na mesma conexão
+	time.Sleep(50 * time.Millisecond)
+	second, err := g.Authorize(context.Background(), iso8583Authorization("4242424242424242", 20000))
+
+	require.NoError(t, err)
+	assert.False(t, second.Approved)
+	assert.Equal(t, model.ResponseInsufficientFunds, second.ResponseCode)
+
+	r := <-first
+	require.NoError(t, r.err)
+	assert.True(t, r.response.Approved)
+	assert.Equal(t, "123456", r.response.ApprovalCode)
+}
@@ -0,0 +1,213 @@
+package test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/config"
+	"golang-payment-microservice/internal/kms"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Armazenamento de chaves de dados em memória
+type memoryDataKeyStore struct {
+	mu   sync.Mutex
+	keys []*kms.DataKey
+}
+
+func (s *memoryDataKeyStore) ListDataKeys(_ context.Context) ([]*kms.DataKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]*kms.DataKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	return keys, nil
+}
+
+func (s *memoryDataKeyStore) CreateDataKey(_ context.Context, key *kms.DataKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key.Active {
+		for _, existing := range s.keys {
+			existing.Active = false
+		}
+	}
+	copied := *key
+	s.keys = append(s.keys, &copied)
+	return nil
+}
+
+func (s *memoryDataKeyStore) UpdateWrappedKey(_ context.Context, id string, wrappedKey []byte, masterKeyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.ID == id {
+			key.WrappedKey = wrappedKey
+			key.MasterKeyID = masterKeyID
+		}
+	}
+	return nil
+}
+
+func testKey(b byte) string {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func testKeyfile(active string) *kms.Keyfile {
+	return &kms.Keyfile{
+		ActiveMasterKey: active,
+		MasterKeys: map[string]string{
+			"mk-1": testKey(1),
+			"mk-2": testKey(2),
+		},
+		IndexKey: testKey(9),
+	}
+}
+
+func TestKeyManager_EncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	keys, err := kms.NewKeyManager(ctx, &memoryDataKeyStore{}, testKeyfile("mk-1"))
+	require.NoError(t, err)
+
+	ciphertext, err := keys.Encrypt(ctx, []byte("4111111111111111"), []byte("row-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "dk-v1", ciphertext.KeyID)
+	assert.NotContains(t, string(ciphertext.Data), "4111111111111111")
+
+	plaintext, err := keys.Decrypt(ctx, ciphertext, []byte("row-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "4111111111111111", string(plaintext))
+
+	// O ciphertext fica amarrado à linha pelo dado associado
+	_, err = keys.Decrypt(ctx, ciphertext, []byte("row-2"))
+	assert.ErrorIs(t, err, kms.ErrInvalidPayload)
+}
+
+func TestKeyManager_RotationKeepsOldKeysReadable(t *testing.T) {
+	ctx := context.Background()
+	keys, err := kms.NewKeyManager(ctx, &memoryDataKeyStore{}, testKeyfile("mk-1"))
+	require.NoError(t, err)
+
+	old, err := keys.Encrypt(ctx, []byte("John Doe"), []byte("p1"))
+	require.NoError(t, err)
+
+	newKeyID, err := keys.Rotate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "dk-v2", newKeyID)
+	assert.Equal(t, "dk-v2", keys.ActiveKeyID())
+
+	current, err := keys.Encrypt(ctx, []byte("John Doe"), []byte("p1"))
+	require.NoError(t, err)
+	assert.Equal(t, "dk-v2", current.KeyID)
+
+	plaintext, err := keys.Decrypt(ctx, old, []byte("p1"))
+	require.NoError(t, err)
+	assert.Equal(t, "John Doe", string(plaintext))
+
+	rotated, err := keys.RotateIfOlderThan(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.False(t, rotated)
+}
+
+func TestKeyManager_MasterKeyRotationRewrapsDataKeys(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryDataKeyStore{}
+
+	keys, err := kms.NewKeyManager(ctx, store, testKeyfile("mk-1"))
+	require.NoError(t, err)
+
+	ciphertext, err := keys.Encrypt(ctx, []byte("secret"), nil)
+	require.NoError(t, err)
+
+	// Reiniciar com uma nova chave mestra ativa
+	keys, err = kms.NewKeyManager(ctx, store, testKeyfile("mk-2"))
+	require.NoError(t, err)
+
+	stored, _ := store.ListDataKeys(ctx)
+	require.Len(t, stored, 1)
+	assert.Equal(t, "mk-2", stored[0].MasterKeyID)
+
+	plaintext, err := keys.Decrypt(ctx, ciphertext, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+}
+
+func TestKeyManager_BlindIndexIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	keys, err := kms.NewKeyManager(ctx, &memoryDataKeyStore{}, testKeyfile("mk-1"))
+	require.NoError(t, err)
+
+	assert.Equal(t, keys.BlindIndex("4111111111111111"), keys.BlindIndex("4111111111111111"))
+	assert.NotEqual(t, keys.BlindIndex("4111111111111111"), keys.BlindIndex("4111111111111112"))
+
+	// A rotação da chave de dados não altera o índice de busca
+	_, err = keys.Rotate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, keys.BlindIndex("4111111111111111"), keys.BlindIndex("4111111111111111"))
+}
+
+func TestConfig_LoadKeyfileRequiresPath(t *testing.T) {
+	cfg := &config.Config{Env: "development"}
+
+	_, err := cfg.LoadKeyfile()
+	assert.ErrorIs(t, err, config.ErrKeyfileRequired)
+}
+
+func TestConfig_LoadKeyfileRefusesDevKeysOutsideDevelopment(t *testing.T) {
+	cfg := &config.Config{Env: "production", KMS: config.KMSConfig{KeyfilePath: "../config/dev-keyfile.json"}}
+
+	_, err := cfg.LoadKeyfile()
+	assert.ErrorIs(t, err, config.ErrDevKeyfile)
+
+	// Uma cópia com outro ID de chave continua sendo o keyfile de desenvolvimento
+	dev, err := kms.LoadKeyfile("../config/dev-keyfile.json")
+	require.NoError(t, err)
+	copied := testKeyfile("mk-1")
+	copied.IndexKey = dev.IndexKey
+	cfg.KMS.KeyfilePath = writeKeyfile(t, copied)
+
+	_, err = cfg.LoadKeyfile()
+	assert.ErrorIs(t, err, config.ErrDevKeyfile)
+
+	for _, env := range []string{"development", "sandbox"} {
+		cfg.Env = env
+		_, err = cfg.LoadKeyfile()
+		assert.NoError(t, err, env)
+	}
+}
+
+func TestConfig_LoadKeyfileAcceptsOwnKeys(t *testing.T) {
+	cfg := &config.Config{Env: "production", KMS: config.KMSConfig{KeyfilePath: writeKeyfile(t, testKeyfile("mk-2"))}}
+
+	keyfile, err := cfg.LoadKeyfile()
+	require.NoError(t, err)
+	assert.Equal(t, "mk-2", keyfile.ActiveMasterKey)
+}
+
+func writeKeyfile(t *testing.T, keyfile *kms.Keyfile) string {
+	t.Helper()
+
+	data, err := json.Marshal(keyfile)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "keyfile.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
@@ -0,0 +1,183 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"golang-payment-microservice/internal/ledger"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalEntry_Validate(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name     string
+		postings []model.Posting
+		wantErr  error
+	}{
+		{
+			name: "balanced",
+			postings: []model.Posting{
+				{LedgerAccountID: a, Direction: model.PostingDebit, Amount: model.NewMoney(1000, "BRL")},
+				{LedgerAccountID: b, Direction: model.PostingCredit, Amount: model.NewMoney(1000, "BRL")},
+			},
+		},
+		{
+			name: "single posting",
+			postings: []model.Posting{
+				{LedgerAccountID: a, Direction: model.PostingDebit, Amount: model.NewMoney(1000, "BRL")},
+			},
+			wantErr: model.ErrUnbalancedEntry,
+		},
+		{
+			name: "debits differ from credits",
+			postings: []model.Posting{
+				{LedgerAccountID: a, Direction: model.PostingDebit, Amount: model.NewMoney(1000, "BRL")},
+				{LedgerAccountID: b, Direction: model.PostingCredit, Amount: model.NewMoney(999, "BRL")},
+			},
+			wantErr: model.ErrUnbalancedEntry,
+		},
+		{
+			name: "mixed currencies",
+			postings: []model.Posting{
+				{LedgerAccountID: a, Direction: model.PostingDebit, Amount: model.NewMoney(1000, "BRL")},
+				{LedgerAccountID: b, Direction: model.PostingCredit, Amount: model.NewMoney(1000, "USD")},
+			},
+			wantErr: model.ErrCurrencyMismatch,
+		},
+		{
+			name: "zero amount",
+			postings: []model.Posting{
+				{LedgerAccountID: a, Direction: model.PostingDebit, Amount: model.NewMoney(0, "BRL")},
+				{LedgerAccountID: b, Direction: model.PostingCredit, Amount: model.NewMoney(0, "BRL")},
+			},
+			wantErr: model.ErrInvalidPosting,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &model.JournalEntry{Postings: tt.postings}
+			err := entry.Validate()
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLedger_Fee(t *testing.T) {
+	tests := []struct {
+		amount      int64
+		basisPoints int64
+		want        int64
+	}{
+		{10000, 0, 0},
+		{10000, 250, 250},
+		{199, 250, 5}, // 4.975 arredonda para 5
+		{180, 250, 5}, // 4.5 arredonda para 5
+		{179, 250, 4}, // 4.475 arredonda para 4
+		{10000, 10000, 10000},
+	}
+
+	for _, tt := range tests {
+		fee, err := ledger.Fee(model.NewMoney(tt.amount, "BRL"), tt.basisPoints)
+		require.NoError(t, err)
+		assert.Equal(t, model.NewMoney(tt.want, "BRL"), fee, "amount=%d bps=%d", tt.amount, tt.basisPoints)
+	}
+
+	_, err := ledger.Fee(model.NewMoney(100, "BRL"), 10001)
+	assert.Error(t, err)
+}
+
+func TestLedger_PaymentCompleted(t *testing.T) {
+	paymentID := uuid.New()
+	cardholder, merchant, fees := uuid.New(), uuid.New(), uuid.New()
+
+	entry, err := ledger.PaymentCompleted(paymentID, model.NewMoney(10000, "BRL"), model.NewMoney(250, "BRL"),
+		cardholder, merchant, fees)
+
+	require.NoError(t, err)
+	assert.Equal(t, model.JournalEntryPaymentCompleted, entry.Kind)
+	assert.Equal(t, &paymentID, entry.PaymentID)
+
+	balances := map[uuid.UUID]int64{}
+	for _, p := range entry.Postings {
+		assert.Equal(t, entry.ID, p.EntryID)
+		balances[p.LedgerAccountID] += p.SignedAmount()
+	}
+	assert.Equal(t, map[uuid.UUID]int64{cardholder: -10000, merchant: 9750, fees: 250}, balances)
+}
+
+func TestLedger_PaymentCompleted_WithoutFee(t *testing.T) {
+	entry, err := ledger.PaymentCompleted(uuid.New(), model.NewMoney(10000, "BRL"), model.NewMoney(0, "BRL"),
+		uuid.New(), uuid.New(), uuid.New())
+
+	require.NoError(t, err)
+	assert.Len(t, entry.Postings, 2)
+}
+
+func TestPaymentService_SettleMerchant(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New())
+
+	merchant := &model.LedgerAccount{ID: uuid.New(), Type: model.LedgerAccountMerchant, OwnerID: "merchant123", Balance: model.NewMoney(9750, "BRL")}
+	suspense := &model.LedgerAccount{ID: uuid.New(), Type: model.LedgerAccountSuspense, OwnerID: model.SystemLedgerOwner, Balance: model.NewMoney(0, "BRL")}
+
+	var posted *model.JournalEntry
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, model.LedgerAccountMerchant, "merchant123", model.Currency("BRL")).Return(merchant, nil)
+	mockRepo.On("LockLedgerAccount", mock.Anything, merchant.ID).Return(merchant, nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, model.LedgerAccountSuspense, model.SystemLedgerOwner, model.Currency("BRL")).Return(suspense, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.AnythingOfType("*model.JournalEntry")).
+		Run(func(args mock.Arguments) { posted = args.Get(1).(*model.JournalEntry) }).Return(nil)
+
+	entry, err := paymentService.SettleMerchant(context.Background(), "merchant123", "BRL")
+
+	require.NoError(t, err)
+	assert.Same(t, posted, entry)
+	assert.Equal(t, model.JournalEntrySettlement, entry.Kind)
+	require.Len(t, entry.Postings, 2)
+	assert.Equal(t, merchant.ID, entry.Postings[0].LedgerAccountID)
+	assert.Equal(t, model.PostingDebit, entry.Postings[0].Direction)
+	assert.Equal(t, model.NewMoney(9750, "BRL"), entry.Postings[0].Amount)
+	assert.Equal(t, suspense.ID, entry.Postings[1].LedgerAccountID)
+}
+
+func TestPaymentService_SettleMerchant_NothingToSettle(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New())
+
+	merchant := &model.LedgerAccount{ID: uuid.New(), Type: model.LedgerAccountMerchant, OwnerID: "merchant123", Balance: model.NewMoney(0, "BRL")}
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, model.LedgerAccountMerchant, "merchant123", model.Currency("BRL")).Return(merchant, nil)
+	mockRepo.On("LockLedgerAccount", mock.Anything, merchant.ID).Return(merchant, nil)
+
+	entry, err := paymentService.SettleMerchant(context.Background(), "merchant123", "BRL")
+
+	assert.ErrorIs(t, err, service.ErrNothingToSettle)
+	assert.Nil(t, entry)
+	mockRepo.AssertNotCalled(t, "PostEntry", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_CheckLedger(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New())
+
+	drift := model.LedgerDrift{Check: "cardholder_matches_account", Subject: uuid.NewString(), Currency: "BRL", Expected: 100000, Actual: 90000}
+	mockRepo.On("CheckLedgerInvariants", mock.Anything).Return([]model.LedgerDrift{drift}, nil)
+
+	report, err := paymentService.CheckLedger(context.Background())
+
+	require.NoError(t, err)
+	assert.False(t, report.Balanced)
+	assert.Equal(t, []model.LedgerDrift{drift}, report.Drifts)
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"testing"
+
+	"golang-payment-microservice/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		currency model.Currency
+		expected int64
+		wantErr  error
+	}{
+		{name: "BRL with cents", amount: "100.50", currency: "BRL", expected: 10050},
+		{name: "BRL without cents", amount: "100", currency: "BRL", expected: 10000},
+		{name: "BRL single decimal", amount: "0.5", currency: "BRL", expected: 50},
+		{name: "JPY has no decimals", amount: "1500", currency: "JPY", expected: 1500},
+		{name: "JPY rejects decimals", amount: "1500.5", currency: "JPY", wantErr: model.ErrInvalidAmount},
+		{name: "BHD has three decimals", amount: "12.345", currency: "BHD", expected: 12345},
+		{name: "BRL rejects three decimals", amount: "1.005", currency: "BRL", wantErr: model.ErrInvalidAmount},
+		{name: "Negative amount", amount: "-2.10", currency: "USD", expected: -210},
+		{name: "Garbage", amount: "1e3", currency: "BRL", wantErr: model.ErrInvalidAmount},
+		{name: "Unknown currency", amount: "10", currency: "XXX", wantErr: model.ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := model.ParseMoney(tt.amount, tt.currency)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, model.NewMoney(tt.expected, tt.currency), result)
+		})
+	}
+}
+
+func TestMoney_Decimal(t *testing.T) {
+	tests := []struct {
+		money    model.Money
+		expected string
+	}{
+		{model.NewMoney(10050, "BRL"), "100.50"},
+		{model.NewMoney(5, "BRL"), "0.05"},
+		{model.NewMoney(-5, "BRL"), "-0.05"},
+		{model.NewMoney(1500, "JPY"), "1500"},
+		{model.NewMoney(12345, "BHD"), "12.345"},
+		{model.NewMoney(7, "BHD"), "0.007"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.money.Decimal())
+		})
+	}
+}
+
+func TestMoney_Arithmetic(t *testing.T) {
+	brl := model.NewMoney(10000, "BRL")
+
+	sum, err := brl.Add(model.NewMoney(50, "BRL"))
+	assert.NoError(t, err)
+	assert.Equal(t, model.NewMoney(10050, "BRL"), sum)
+
+	diff, err := brl.Sub(model.NewMoney(2500, "BRL"))
+	assert.NoError(t, err)
+	assert.Equal(t, model.NewMoney(7500, "BRL"), diff)
+
+	_, err = brl.Add(model.NewMoney(100, "USD"))
+	assert.ErrorIs(t, err, model.ErrCurrencyMismatch)
+
+	_, err = brl.Sub(model.NewMoney(100, "USD"))
+	assert.ErrorIs(t, err, model.ErrCurrencyMismatch)
+
+	_, err = brl.Cmp(model.NewMoney(100, "JPY"))
+	assert.ErrorIs(t, err, model.ErrCurrencyMismatch)
+}
@@ -0,0 +1,364 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentService_CapturePayment_PartialCaptureKeepsAuthorization(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	amount := model.NewMoney(4000, "BRL")
+	final := false
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReserveCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.PaymentID == payment.ID && capture.Status == model.CaptureStatusPending
+	})).Return(nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.Amount == amount
+	})).Return(approvedResponse("000000000042"), nil)
+	mockRepo.On("DrawHold", mock.Anything, payment.ID, amount).
+		Return(&model.Hold{AccountID: uuid.New(), Amount: model.NewMoney(6000, "BRL")}, nil)
+	mockRepo.On("RecordCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.Amount == amount && !capture.Final && capture.Acquirer == "mock"
+	})).Return(nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.MatchedBy(func(entry *model.JournalEntry) bool {
+		return entry.Postings[0].Amount == amount
+	})).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventCaptured && event.ToStatus == model.PaymentStatusAuthorized
+	})).Return(nil)
+
+	_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{Amount: &amount, FinalCapture: &final})
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "CaptureHoldAmount", mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_CapturePayment_CapturesRemainingAmount(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	captured := model.NewMoney(4000, "BRL")
+	payment.CapturedAmount = &captured
+	remaining := model.NewMoney(6000, "BRL")
+	final := false
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReserveCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.PaymentID == payment.ID && capture.Status == model.CaptureStatusPending
+	})).Run(reserveAgainst(payment)).Return(nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.Amount == remaining
+	})).Return(approvedResponse("000000000042"), nil)
+	// Capturar o restante encerra a autorização mesmo sem final_capture
+	mockRepo.On("CaptureHoldAmount", mock.Anything, payment.ID, remaining).
+		Return(&model.Hold{AccountID: uuid.New(), Amount: remaining}, nil)
+	mockRepo.On("RecordCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.Amount == remaining && capture.Final
+	})).Return(nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.AnythingOfType("*model.JournalEntry")).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusAuthorized, model.PaymentStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{Amount: &remaining, FinalCapture: &final})
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentService_CapturePayment_RejectsAmountAboveRemaining(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	captured := model.NewMoney(7000, "BRL")
+	payment.CapturedAmount = &captured
+	amount := model.NewMoney(5000, "BRL")
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReserveCapture", mock.Anything, mock.AnythingOfType("*model.PaymentCapture")).
+		Return(fmt.Errorf("%w: 30.00 BRL remaining", repository.ErrCaptureExceedsAuthorization))
+
+	_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{Amount: &amount})
+
+	assert.ErrorIs(t, err, service.ErrInvalidCaptureAmount)
+	assert.Contains(t, err.Error(), "30.00 BRL remaining")
+	mockAcquirer.AssertNotCalled(t, "Capture", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_CapturePayment_FinalIsDecidedUnderTheLock(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	// Outra captura de R$ 40,00 foi confirmada entre a leitura e a trava
+	locked := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().captured(4000).build()
+	amount := model.NewMoney(6000, "BRL")
+	final := false
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReserveCapture", mock.Anything, mock.AnythingOfType("*model.PaymentCapture")).Run(reserveAgainst(locked)).Return(nil)
+	mockAcquirer.On("Capture", mock.Anything, mock.Anything).Return(approvedResponse("000000000042"), nil)
+	mockRepo.On("CaptureHoldAmount", mock.Anything, payment.ID, amount).
+		Return(&model.Hold{AccountID: uuid.New(), Amount: amount}, nil)
+	mockRepo.On("RecordCapture", mock.Anything, mock.MatchedBy(func(capture *model.PaymentCapture) bool {
+		return capture.Amount == amount && capture.Final
+	})).Return(nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.AnythingOfType("*model.JournalEntry")).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusAuthorized, model.PaymentStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.ToStatus == model.PaymentStatusCompleted
+	})).Return(nil)
+
+	_, err := paymentService.CapturePayment(context.Background(), payment.ID, &model.CaptureRequest{Amount: &amount, FinalCapture: &final})
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "DrawHold", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_VoidPayment_ClosesPartiallyCapturedPayment(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	captured := model.NewMoney(4000, "BRL")
+	payment.CapturedAmount = &captured
+	reason := "Remaining items out of stock"
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockRepo.On("HasPendingCapture", mock.Anything, payment.ID).Return(false, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusAuthorized, model.PaymentStatusCompleted, &reason).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventVoided && event.ToStatus == model.PaymentStatusCompleted
+	})).Return(nil)
+	// Só a parte não capturada é cancelada no adquirente
+	mockAcquirer.On("Void", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.Amount == model.NewMoney(6000, "BRL")
+	})).Return(approvedResponse("000000000042"), nil)
+
+	_, err := paymentService.VoidPayment(context.Background(), payment.ID, reason, "")
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertExpectations(t)
+}
+
+func TestPaymentService_IncrementAuthorization_IncreasesHoldAndAuthorizedAmount(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	increment := model.NewMoney(2500, "BRL")
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("HasPendingCapture", mock.Anything, payment.ID).Return(false, nil)
+	mockAcquirer.On("IncrementAuthorization", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.Amount == increment && req.NetworkReference == "000000000042"
+	})).Return(approvedResponse("000000000042"), nil)
+	mockRepo.On("IncreaseHold", mock.Anything, payment.ID, increment).Return(&model.Hold{}, nil)
+	mockRepo.On("IncrementAuthorization", mock.Anything, payment.ID, increment).Return(model.NewMoney(12500, "BRL"), nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventAuthorizationIncreased && strings.HasSuffix(event.Reason, ": Minibar")
+	})).Return(nil)
+
+	_, err := paymentService.IncrementAuthorization(context.Background(), payment.ID, &model.IncrementAuthorizationRequest{Amount: increment, Reason: "Minibar"})
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertNotCalled(t, "Void", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_IncrementAuthorization_VoidsIncrementWithoutFunds(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+	increment := model.NewMoney(2500, "BRL")
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("HasPendingCapture", mock.Anything, payment.ID).Return(false, nil)
+	mockAcquirer.On("IncrementAuthorization", mock.Anything, mock.Anything).Return(approvedResponse("000000000042"), nil)
+	mockRepo.On("IncreaseHold", mock.Anything, payment.ID, increment).Return(nil, repository.ErrInsufficientFunds)
+	mockAcquirer.On("Void", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.Amount == increment
+	})).Return(approvedResponse("000000000042"), nil)
+
+	_, err := paymentService.IncrementAuthorization(context.Background(), payment.ID, &model.IncrementAuthorizationRequest{Amount: increment})
+
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+	mockAcquirer.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "IncrementAuthorization", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_IncrementAuthorization_Rejections(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    model.PaymentStatus
+		increment model.Money
+		declined  bool
+		capturing bool
+		check     func(t *testing.T, err error)
+	}{
+		{
+			name:      "not authorized",
+			status:    model.PaymentStatusCompleted,
+			increment: model.NewMoney(1000, "BRL"),
+			check: func(t *testing.T, err error) {
+				var transitionErr *model.TransitionError
+				assert.ErrorAs(t, err, &transitionErr)
+			},
+		},
+		{
+			name:      "non-positive amount",
+			increment: model.NewMoney(0, "BRL"),
+			check:     func(t *testing.T, err error) { assert.ErrorIs(t, err, service.ErrInvalidIncrementAmount) },
+		},
+		{
+			name:      "currency mismatch",
+			increment: model.NewMoney(1000, "USD"),
+			check:     func(t *testing.T, err error) { assert.ErrorIs(t, err, model.ErrCurrencyMismatch) },
+		},
+		{
+			name:      "declined by acquirer",
+			increment: model.NewMoney(1000, "BRL"),
+			declined:  true,
+			check:     func(t *testing.T, err error) { assert.ErrorIs(t, err, service.ErrIncrementDeclined) },
+		},
+		{
+			name:      "capture in progress",
+			increment: model.NewMoney(1000, "BRL"),
+			capturing: true,
+			check:     func(t *testing.T, err error) { assert.ErrorIs(t, err, repository.ErrCaptureInProgress) },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockPaymentRepository)
+			mockAcquirer := new(MockAcquirerGateway)
+			paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+			payment := newPayment(model.PaymentStatusAuthorized).approved().manualCapture().build()
+			if tc.status != "" {
+				payment.Status = tc.status
+			}
+			mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+			mockRepo.On("HasPendingCapture", mock.Anything, payment.ID).Return(tc.capturing, nil)
+			if tc.declined {
+				mockAcquirer.On("IncrementAuthorization", mock.Anything, mock.Anything).
+					Return(&model.AcquirerResponse{ResponseCode: model.ResponseInsufficientFunds, DeclineReason: "Insufficient funds"}, nil)
+			}
+
+			_, err := paymentService.IncrementAuthorization(context.Background(), payment.ID, &model.IncrementAuthorizationRequest{Amount: tc.increment})
+
+			tc.check(t, err)
+			mockRepo.AssertNotCalled(t, "IncreaseHold", mock.Anything, mock.Anything, mock.Anything)
+			if tc.capturing {
+				mockAcquirer.AssertNotCalled(t, "IncrementAuthorization", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestIncrementAuthorizationHandler_Responses(t *testing.T) {
+	paymentID := uuid.New()
+	body := `{"amount":{"value":2500,"currency":"BRL"},"reason":"Minibar"}`
+
+	tests := []struct {
+		name   string
+		body   string
+		err    error
+		status int
+	}{
+		{name: "increased", body: body, status: http.StatusOK},
+		{name: "missing body", body: "", status: http.StatusBadRequest},
+		{name: "payment not found", body: body, err: repository.ErrPaymentNotFound, status: http.StatusNotFound},
+		{name: "not authorized", body: body, err: &model.TransitionError{From: model.PaymentStatusCompleted, To: model.PaymentStatusAuthorized, Current: model.PaymentStatusCompleted}, status: http.StatusConflict},
+		{name: "invalid amount", body: body, err: fmt.Errorf("%w: must be positive", service.ErrInvalidIncrementAmount), status: http.StatusBadRequest},
+		{name: "capture in progress", body: body, err: repository.ErrCaptureInProgress, status: http.StatusConflict},
+		{name: "declined", body: body, err: service.ErrIncrementDeclined, status: http.StatusPaymentRequired},
+		{name: "insufficient funds", body: body, err: repository.ErrInsufficientFunds, status: http.StatusPaymentRequired},
+		{name: "acquirer unavailable", body: body, err: fmt.Errorf("failed to increment authorization: %w", gateway.ErrTimeout), status: http.StatusBadGateway},
+		{name: "unexpected error", body: body, err: errors.New("connection refused"), status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockPaymentService)
+			router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+			if tc.err != nil {
+				mockService.On("IncrementAuthorization", mock.Anything, paymentID, mock.Anything).Return(nil, tc.err)
+			} else {
+				mockService.On("IncrementAuthorization", mock.Anything, paymentID, mock.MatchedBy(func(req *model.IncrementAuthorizationRequest) bool {
+					return req.Amount == model.NewMoney(2500, "BRL") && req.Reason == "Minibar"
+				})).Return(&model.Payment{ID: paymentID, Status: model.PaymentStatusAuthorized}, nil)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/payments/"+paymentID.String()+"/authorization/increment", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestGetCapturesHandler(t *testing.T) {
+	mockService := new(MockPaymentService)
+	router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+	paymentID := uuid.New()
+	captures := []*model.PaymentCapture{
+		{ID: uuid.New(), PaymentID: paymentID, Amount: model.NewMoney(4000, "BRL")},
+		{ID: uuid.New(), PaymentID: paymentID, Amount: model.NewMoney(6000, "BRL"), Final: true},
+	}
+	mockService.On("GetCaptures", mock.Anything, paymentID).Return(captures, nil)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/payments/"+paymentID.String()+"/captures", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"count":2`)
+	assert.Contains(t, rec.Body.String(), `"final":true`)
+}
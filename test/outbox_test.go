@@ -0,0 +1,237 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newOutboxPaymentRequest() *model.PaymentRequest {
+	return &model.PaymentRequest{
+		CardNumber:  "4111111111111111",
+		CardHolder:  "John Doe",
+		ExpiryMonth: 12,
+		ExpiryYear:  nextYear,
+		CVV:         "123",
+		Amount:      model.NewMoney(10000, "BRL"),
+		MerchantID:  "merchant123",
+	}
+}
+
+func TestPaymentService_CreatePayment_EnqueuesOutboxMessage(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+		service.WithPaymentTopic("payments-test"))
+
+	req := newOutboxPaymentRequest()
+	account := &model.Account{ID: uuid.New(), AvailableBalance: model.NewMoney(100000, "BRL"), IsActive: true}
+
+	var enqueued *model.OutboxMessage
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, req.CardNumber).Return(account, nil)
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*model.Payment")).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.AnythingOfType("*model.Hold")).Return(nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).
+		Run(func(args mock.Arguments) { enqueued = args.Get(1).(*model.OutboxMessage) }).Return(nil)
+
+	response, err := paymentService.CreatePayment(context.Background(), req)
+
+	require.NoError(t, err)
+	require.NotNil(t, enqueued)
+	assert.Equal(t, "payments-test", enqueued.Topic)
+	// A chave é o cartão, para que pagamentos dele fiquem na mesma partição
+	assert.Equal(t, "tok_abc", enqueued.Key)
+
+	var message queue.PaymentMessage
+	require.NoError(t, json.Unmarshal(enqueued.Payload, &message))
+	assert.Equal(t, response.ID.String(), message.PaymentID)
+	assert.Equal(t, "tok_abc", message.CardToken)
+	assert.Equal(t, req.Amount, message.Amount)
+}
+
+func TestPaymentService_CreatePayment_KeysMessageByCardFingerprint(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	req := newOutboxPaymentRequest()
+	account := &model.Account{ID: uuid.New(), AvailableBalance: model.NewMoney(100000, "BRL"), IsActive: true}
+	fingerprint := []byte{0xca, 0xfe}
+
+	var enqueued *model.OutboxMessage
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).
+		Return(&model.VaultedCard{Token: "tok_abc", PANFingerprint: fingerprint}, nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, req.CardNumber).Return(account, nil)
+	mockRepo.On("Create", mock.Anything, mock.MatchedBy(func(payment *model.Payment) bool {
+		return string(payment.CardFingerprint) == string(fingerprint)
+	})).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.AnythingOfType("*model.Hold")).Return(nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).
+		Run(func(args mock.Arguments) { enqueued = args.Get(1).(*model.OutboxMessage) }).Return(nil)
+
+	_, err := paymentService.CreatePayment(context.Background(), req)
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	require.NotNil(t, enqueued)
+	assert.Equal(t, "cafe", enqueued.Key)
+}
+
+func TestPaymentOutboxMessage_KeysByCardAcrossMerchants(t *testing.T) {
+	fingerprint := []byte{0xca, 0xfe}
+	first := newPayment(model.PaymentStatusPending).build()
+	first.CardFingerprint = fingerprint
+	other := newPayment(model.PaymentStatusPending).build()
+	other.MerchantID, other.CardToken, other.CardFingerprint = "merchant456", "tok_def", fingerprint
+
+	firstMessage, err := queue.NewPaymentOutboxMessage("payments-test", first)
+	require.NoError(t, err)
+	otherMessage, err := queue.NewRefundOutboxMessage("payments-test", other, &model.Refund{ID: uuid.New(), Amount: other.Amount})
+	require.NoError(t, err)
+
+	// Tokens de merchants diferentes, mesmo cartão: mesma partição e worker
+	assert.Equal(t, "cafe", firstMessage.Key)
+	assert.Equal(t, firstMessage.Key, otherMessage.Key)
+
+	var message queue.PaymentMessage
+	require.NoError(t, json.Unmarshal(otherMessage.Payload, &message))
+	assert.Equal(t, "cafe", message.CardFingerprint)
+	assert.Equal(t, firstMessage.Key, message.CardKey())
+
+	// Mensagens anteriores ao fingerprint seguem pelo token
+	legacy := queue.PaymentMessage{PaymentID: first.ID.String(), CardToken: "tok_abc"}
+	assert.Equal(t, "tok_abc", legacy.CardKey())
+}
+
+func TestPaymentService_CreatePayment_OutboxFailureFailsCreation(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	req := newOutboxPaymentRequest()
+	account := &model.Account{ID: uuid.New(), AvailableBalance: model.NewMoney(100000, "BRL"), IsActive: true}
+
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, req.CardNumber).Return(account, nil)
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*model.Payment")).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.AnythingOfType("*model.Hold")).Return(nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).Return(errors.New("connection reset"))
+
+	// A transação é desfeita: sem mensagem no outbox, não há pagamento
+	response, err := paymentService.CreatePayment(context.Background(), req)
+
+	assert.Error(t, err)
+	assert.Nil(t, response)
+}
+
+func TestOutboxRelay_RunOnce_PublishesInOrderAndMarksSent(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockProducer := new(MockKafkaProducer)
+
+	messages := []*model.OutboxMessage{
+		{ID: 1, Topic: "payment-processing", Key: "pay-1", Payload: []byte(`{"n":1}`)},
+		{ID: 2, Topic: "payment-processing", Key: "pay-2", Payload: []byte(`{"n":2}`)},
+	}
+
+	var published []string
+	mockRepo.On("ClaimOutbox", mock.Anything, mock.Anything, mock.Anything, 10).Return(messages, nil).Once()
+	mockProducer.On("Publish", mock.Anything, "payment-processing", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { published = append(published, string(args.Get(2).([]byte))) }).
+		Return(nil)
+	mockRepo.On("MarkOutboxSent", mock.Anything, int64(1), mock.Anything).Return(nil)
+	mockRepo.On("MarkOutboxSent", mock.Anything, int64(2), mock.Anything).Return(nil)
+
+	relay := service.NewOutboxRelay(mockRepo, mockProducer, time.Second, 10, time.Minute, 0, 0, logrus.New())
+	sent, err := relay.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"pay-1", "pay-2"}, published)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOutboxRelay_RunOnce_FailureIsRescheduledWithBackoff(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockProducer := new(MockKafkaProducer)
+
+	// Uma mensagem que já falhou três vezes espera 1s * 2^3
+	message := &model.OutboxMessage{ID: 7, Topic: "payment-processing", Key: "pay-1", Attempts: 3}
+
+	mockRepo.On("ClaimOutbox", mock.Anything, mock.Anything, mock.Anything, 1).Return([]*model.OutboxMessage{message}, nil).Once()
+	mockProducer.On("Publish", mock.Anything, "payment-processing", []byte("pay-1"), mock.Anything, mock.Anything).Return(errors.New("broker unavailable"))
+
+	var nextAttempt time.Time
+	mockRepo.On("MarkOutboxFailed", mock.Anything, int64(7), mock.MatchedBy(func(msg string) bool {
+		return msg != ""
+	}), mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) { nextAttempt = args.Get(3).(time.Time) }).Return(nil)
+
+	relay := service.NewOutboxRelay(mockRepo, mockProducer, time.Second, 1, time.Minute, 0, 0, logrus.New())
+	before := time.Now()
+	sent, err := relay.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.WithinDuration(t, before.Add(8*time.Second), nextAttempt, time.Second)
+	mockRepo.AssertNotCalled(t, "MarkOutboxSent", mock.Anything, mock.Anything, mock.Anything)
+	// O lote estava cheio, mas com falha o relay não busca o próximo
+	mockRepo.AssertNumberOfCalls(t, "ClaimOutbox", 1)
+}
+
+func TestOutboxRelay_RunOnce_BackoffIsCapped(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockProducer := new(MockKafkaProducer)
+
+	message := &model.OutboxMessage{ID: 7, Topic: "payment-processing", Key: "pay-1", Attempts: 40}
+
+	mockRepo.On("ClaimOutbox", mock.Anything, mock.Anything, mock.Anything, 10).Return([]*model.OutboxMessage{message}, nil).Once()
+	mockProducer.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("broker unavailable"))
+
+	var nextAttempt time.Time
+	mockRepo.On("MarkOutboxFailed", mock.Anything, int64(7), mock.Anything, mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) { nextAttempt = args.Get(3).(time.Time) }).Return(nil)
+
+	relay := service.NewOutboxRelay(mockRepo, mockProducer, time.Second, 10, time.Minute, 0, 0, logrus.New())
+	before := time.Now()
+	_, err := relay.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.WithinDuration(t, before.Add(time.Minute), nextAttempt, time.Second)
+}
+
+func TestOutboxRelay_RunOnce_ExhaustedMessageIsMovedToDead(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockProducer := new(MockKafkaProducer)
+
+	// Com limite de 5 tentativas, a quinta falha é a última
+	message := &model.OutboxMessage{ID: 7, Topic: "payment-processing", Key: "pay-1", Attempts: 4}
+
+	mockRepo.On("ClaimOutbox", mock.Anything, mock.Anything, mock.Anything, 10).Return([]*model.OutboxMessage{message}, nil).Once()
+	mockProducer.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("message too large"))
+	mockRepo.On("MarkOutboxDead", mock.Anything, int64(7), "message too large", mock.AnythingOfType("time.Time")).Return(nil)
+
+	relay := service.NewOutboxRelay(mockRepo, mockProducer, time.Second, 10, time.Minute, 5, 0, logrus.New())
+	sent, err := relay.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "MarkOutboxFailed", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -6,14 +6,20 @@ import (
 	"time"
 
 	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
 	"golang-payment-microservice/internal/service"
+	"golang-payment-microservice/internal/vault"
 
 	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
+// Ano de expiração sempre no futuro para os cartões de teste
+var nextYear = time.Now().Year() + 1
+
 // Mock Repository
 type MockPaymentRepository struct {
 	mock.Mock
@@ -29,8 +35,18 @@ func (m *MockPaymentRepository) GetByID(ctx context.Context, id uuid.UUID) (*mod
 	return args.Get(0).(*model.Payment), args.Error(1)
 }
 
-func (m *MockPaymentRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status model.PaymentStatus, errorMsg *string) error {
-	args := m.Called(ctx, id, status, errorMsg)
+func (m *MockPaymentRepository) TransitionStatus(ctx context.Context, id uuid.UUID, from, to model.PaymentStatus, errorMsg *string) error {
+	args := m.Called(ctx, id, from, to, errorMsg)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) RecordProcessingAttempt(ctx context.Context, id uuid.UUID) (int, error) {
+	args := m.Called(ctx, id)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockPaymentRepository) RecordAcquirerResponse(ctx context.Context, id uuid.UUID, acquirer string, response *model.AcquirerResponse) error {
+	args := m.Called(ctx, id, acquirer, response)
 	return args.Error(0)
 }
 
@@ -47,18 +63,274 @@ func (m *MockPaymentRepository) GetAccountByCardNumber(ctx context.Context, card
 	return args.Get(0).(*model.Account), args.Error(1)
 }
 
-func (m *MockPaymentRepository) UpdateAccountBalance(ctx context.Context, cardNumber string, newBalance float64) error {
-	args := m.Called(ctx, cardNumber, newBalance)
+func (m *MockPaymentRepository) DebitAccount(ctx context.Context, accountID uuid.UUID, amount model.Money) (model.Money, error) {
+	args := m.Called(ctx, accountID, amount)
+	return args.Get(0).(model.Money), args.Error(1)
+}
+
+func (m *MockPaymentRepository) CreditAccount(ctx context.Context, accountID uuid.UUID, amount model.Money) (model.Money, error) {
+	args := m.Called(ctx, accountID, amount)
+	return args.Get(0).(model.Money), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetAccountByID(ctx context.Context, accountID uuid.UUID) (*model.Account, error) {
+	args := m.Called(ctx, accountID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Account), args.Error(1)
+}
+
+func (m *MockPaymentRepository) PlaceHold(ctx context.Context, hold *model.Hold) error {
+	args := m.Called(ctx, hold)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) CaptureHold(ctx context.Context, paymentID uuid.UUID) (*model.Hold, error) {
+	args := m.Called(ctx, paymentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Hold), args.Error(1)
+}
+
+func (m *MockPaymentRepository) CaptureHoldAmount(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error) {
+	args := m.Called(ctx, paymentID, amount)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Hold), args.Error(1)
+}
+
+func (m *MockPaymentRepository) DrawHold(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error) {
+	args := m.Called(ctx, paymentID, amount)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Hold), args.Error(1)
+}
+
+func (m *MockPaymentRepository) IncreaseHold(ctx context.Context, paymentID uuid.UUID, amount model.Money) (*model.Hold, error) {
+	args := m.Called(ctx, paymentID, amount)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Hold), args.Error(1)
+}
+
+func (m *MockPaymentRepository) ReleaseHold(ctx context.Context, paymentID uuid.UUID, status model.HoldStatus) (*model.Hold, error) {
+	args := m.Called(ctx, paymentID, status)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Hold), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetExpiredHolds(ctx context.Context, now time.Time, limit int) ([]*model.Hold, error) {
+	args := m.Called(ctx, now, limit)
+	return args.Get(0).([]*model.Hold), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetHoldsByAccount(ctx context.Context, accountID uuid.UUID, status model.HoldStatus, limit, offset int) ([]*model.Hold, error) {
+	args := m.Called(ctx, accountID, status, limit, offset)
+	return args.Get(0).([]*model.Hold), args.Error(1)
+}
+
+func (m *MockPaymentRepository) EnsureLedgerAccount(ctx context.Context, accountType model.LedgerAccountType, ownerID string, currency model.Currency) (*model.LedgerAccount, error) {
+	args := m.Called(ctx, accountType, ownerID, currency)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.LedgerAccount), args.Error(1)
+}
+
+func (m *MockPaymentRepository) LockLedgerAccount(ctx context.Context, id uuid.UUID) (*model.LedgerAccount, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.LedgerAccount), args.Error(1)
+}
+
+func (m *MockPaymentRepository) PostEntry(ctx context.Context, entry *model.JournalEntry) error {
+	args := m.Called(ctx, entry)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) CheckLedgerInvariants(ctx context.Context) ([]model.LedgerDrift, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.LedgerDrift), args.Error(1)
+}
+
+func (m *MockPaymentRepository) EnqueueOutbox(ctx context.Context, message *model.OutboxMessage) error {
+	args := m.Called(ctx, message)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) ClaimOutbox(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.OutboxMessage, error) {
+	args := m.Called(ctx, now, lease, limit)
+	return args.Get(0).([]*model.OutboxMessage), args.Error(1)
+}
+
+func (m *MockPaymentRepository) MarkOutboxSent(ctx context.Context, id int64, sentAt time.Time) error {
+	args := m.Called(ctx, id, sentAt)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) MarkOutboxFailed(ctx context.Context, id int64, errorMsg string, nextAttemptAt time.Time) error {
+	args := m.Called(ctx, id, errorMsg, nextAttemptAt)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) MarkOutboxDead(ctx context.Context, id int64, errorMsg string, deadAt time.Time) error {
+	args := m.Called(ctx, id, errorMsg, deadAt)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) GetOutboxBacklog(ctx context.Context) (*model.OutboxBacklog, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.OutboxBacklog), args.Error(1)
+}
+
+func (m *MockPaymentRepository) DeleteSentOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	args := m.Called(ctx, before, limit)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockPaymentRepository) RecordProcessedMessage(ctx context.Context, message *model.ProcessedMessage) error {
+	args := m.Called(ctx, message)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) IsMessageProcessed(ctx context.Context, paymentID uuid.UUID, delivery model.MessageDelivery) (bool, error) {
+	args := m.Called(ctx, paymentID, delivery)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPaymentRepository) RecordPaymentEvent(ctx context.Context, event *model.PaymentEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) GetPaymentEvents(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentEvent, error) {
+	args := m.Called(ctx, paymentID)
+	return args.Get(0).([]*model.PaymentEvent), args.Error(1)
+}
+
+func (m *MockPaymentRepository) ClaimStuckPayments(ctx context.Context, status model.PaymentStatus, before time.Time, limit int) ([]*model.Payment, error) {
+	args := m.Called(ctx, status, before, limit)
+	return args.Get(0).([]*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentRepository) RecordRequeue(ctx context.Context, id uuid.UUID) (int, error) {
+	args := m.Called(ctx, id)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockPaymentRepository) CreateRefund(ctx context.Context, refund *model.Refund) error {
+	args := m.Called(ctx, refund)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) GetRefund(ctx context.Context, id uuid.UUID) (*model.Refund, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Refund), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetRefundsByPayment(ctx context.Context, paymentID uuid.UUID) ([]*model.Refund, error) {
+	args := m.Called(ctx, paymentID)
+	return args.Get(0).([]*model.Refund), args.Error(1)
+}
+
+func (m *MockPaymentRepository) RecordRefundAttempt(ctx context.Context, id uuid.UUID) (int, error) {
+	args := m.Called(ctx, id)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockPaymentRepository) RecordRefundAcquirerResponse(ctx context.Context, id uuid.UUID, acquirer string, response *model.AcquirerResponse) error {
+	args := m.Called(ctx, id, acquirer, response)
 	return args.Error(0)
 }
 
+func (m *MockPaymentRepository) TransitionRefundStatus(ctx context.Context, id uuid.UUID, to model.RefundStatus, errorMsg *string) error {
+	args := m.Called(ctx, id, to, errorMsg)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) RecordAuthorization(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	args := m.Called(ctx, id, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) IncrementAuthorization(ctx context.Context, id uuid.UUID, amount model.Money) (model.Money, error) {
+	args := m.Called(ctx, id, amount)
+	return args.Get(0).(model.Money), args.Error(1)
+}
+
+func (m *MockPaymentRepository) ReserveCapture(ctx context.Context, capture *model.PaymentCapture) error {
+	args := m.Called(ctx, capture)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) RecordCapture(ctx context.Context, capture *model.PaymentCapture) error {
+	args := m.Called(ctx, capture)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) FailCapture(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) HasPendingCapture(ctx context.Context, paymentID uuid.UUID) (bool, error) {
+	args := m.Called(ctx, paymentID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetCaptures(ctx context.Context, paymentID uuid.UUID) ([]*model.PaymentCapture, error) {
+	args := m.Called(ctx, paymentID)
+	return args.Get(0).([]*model.PaymentCapture), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetExpiredAuthorizations(ctx context.Context, now time.Time, limit int) ([]*model.Payment, error) {
+	args := m.Called(ctx, now, limit)
+	return args.Get(0).([]*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentRepository) GetInstallmentRules(ctx context.Context, merchantID string) (*model.InstallmentRules, error) {
+	args := m.Called(ctx, merchantID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.InstallmentRules), args.Error(1)
+}
+
+func (m *MockPaymentRepository) SaveInstallmentRules(ctx context.Context, rules *model.InstallmentRules) error {
+	args := m.Called(ctx, rules)
+	return args.Error(0)
+}
+
+// WithTx executa fn com o próprio mock, simulando a transação
+func (m *MockPaymentRepository) WithTx(ctx context.Context, fn func(tx repository.PaymentRepository) error) error {
+	return fn(m)
+}
+
 // Mock Kafka Producer
 type MockKafkaProducer struct {
 	mock.Mock
 }
 
-func (m *MockKafkaProducer) SendPaymentMessage(ctx context.Context, payment *model.Payment) error {
-	args := m.Called(ctx, payment)
+func (m *MockKafkaProducer) Publish(ctx context.Context, topic string, key, value []byte, headers ...kafka.Header) error {
+	args := m.Called(ctx, topic, key, value, headers)
 	return args.Error(0)
 }
 
@@ -67,36 +339,64 @@ func (m *MockKafkaProducer) Close() error {
 	return args.Error(0)
 }
 
+// Mock Card Vault
+type MockCardVault struct {
+	mock.Mock
+}
+
+func (m *MockCardVault) Tokenize(ctx context.Context, merchantID string, card *model.Card) (*model.VaultedCard, error) {
+	args := m.Called(ctx, merchantID, card)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.VaultedCard), args.Error(1)
+}
+
+func (m *MockCardVault) Detokenize(ctx context.Context, merchantID, token string) (*model.Card, error) {
+	args := m.Called(ctx, merchantID, token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Card), args.Error(1)
+}
+
 func TestPaymentService_CreatePayment_Success(t *testing.T) {
 	// Setup
 	mockRepo := new(MockPaymentRepository)
-	mockProducer := new(MockKafkaProducer)
+	mockVault := new(MockCardVault)
 	logger := logrus.New()
-	
-	paymentService := service.NewPaymentService(mockRepo, mockProducer, logger)
+	cvvs := vault.NewCVVCache(time.Minute)
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logger, service.WithCVVCache(cvvs))
 
 	// Mock data
 	account := &model.Account{
-		CardNumber: "1234567890123456",
-		Balance:    1000.00,
-		IsActive:   true,
+		ID:               uuid.New(),
+		CardNumber:       "4111111111111111",
+		AvailableBalance: model.NewMoney(100000, "BRL"),
+		IsActive:         true,
 	}
 
 	req := &model.PaymentRequest{
-		CardNumber:  "1234567890123456",
+		CardNumber:  "4111111111111111",
 		CardHolder:  "John Doe",
 		ExpiryMonth: 12,
-		ExpiryYear:  2025,
+		ExpiryYear:  nextYear,
 		CVV:         "123",
-		Amount:      100.00,
-		Currency:    "BRL",
+		Amount:      model.NewMoney(10000, "BRL"),
 		MerchantID:  "merchant123",
 	}
 
 	// Setup expectations
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
 	mockRepo.On("GetAccountByCardNumber", mock.Anything, req.CardNumber).Return(account, nil)
-	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*model.Payment")).Return(nil)
-	mockProducer.On("SendPaymentMessage", mock.Anything, mock.AnythingOfType("*model.Payment")).Return(nil)
+	mockRepo.On("Create", mock.Anything, mock.MatchedBy(func(p *model.Payment) bool {
+		return p.CardToken == "tok_abc" && p.CardBIN == "411111" && p.CardLast4 == "1111" && p.CardBrand == "visa"
+	})).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.MatchedBy(func(h *model.Hold) bool {
+		return h.AccountID == account.ID && h.Amount == req.Amount && h.Status == model.HoldStatusActive
+	})).Return(nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).Return(nil)
 
 	// Execute
 	response, err := paymentService.CreatePayment(context.Background(), req)
@@ -106,40 +406,121 @@ func TestPaymentService_CreatePayment_Success(t *testing.T) {
 	assert.NotNil(t, response)
 	assert.Equal(t, model.PaymentStatusPending, response.Status)
 	assert.Equal(t, req.Amount, response.Amount)
-	assert.Equal(t, req.Currency, response.Currency)
+	assert.Equal(t, "tok_abc", response.CardToken)
+	assert.Equal(t, "visa", response.CardBrand)
+	// O CVV fica só em memória, à espera da autorização
+	assert.Equal(t, "123", cvvs.Get(response.ID))
 
 	// Verify mocks
 	mockRepo.AssertExpectations(t)
-	mockProducer.AssertExpectations(t)
+	mockVault.AssertExpectations(t)
+}
+
+func TestPaymentService_CreatePayment_WithCardToken(t *testing.T) {
+	// Setup
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	logger := logrus.New()
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logger)
+
+	// Mock data
+	card := &model.Card{
+		Number:      "4111111111111111",
+		ExpiryMonth: 12,
+		ExpiryYear:  nextYear,
+	}
+
+	account := &model.Account{
+		ID:               uuid.New(),
+		CardNumber:       "4111111111111111",
+		AvailableBalance: model.NewMoney(100000, "BRL"),
+		IsActive:         true,
+	}
+
+	req := &model.PaymentRequest{
+		CardToken:  "tok_abc",
+		CardHolder: "John Doe",
+		CVV:        "123",
+		Amount:     model.NewMoney(10000, "BRL"),
+		MerchantID: "merchant123",
+	}
+
+	// Setup expectations
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(card, nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, card.Number).Return(account, nil)
+	mockRepo.On("Create", mock.Anything, mock.MatchedBy(func(p *model.Payment) bool {
+		return p.CardToken == "tok_abc" && p.CardLast4 == "1111" && p.ExpiryYear == nextYear
+	})).Return(nil)
+	mockRepo.On("PlaceHold", mock.Anything, mock.AnythingOfType("*model.Hold")).Return(nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).Return(nil)
+
+	// Execute
+	response, err := paymentService.CreatePayment(context.Background(), req)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotNil(t, response)
+	assert.Equal(t, "tok_abc", response.CardToken)
+
+	// Verify mocks
+	mockRepo.AssertExpectations(t)
+	mockVault.AssertExpectations(t)
+}
+
+func TestPaymentService_CreatePayment_UnknownCardToken(t *testing.T) {
+	// Setup
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	logger := logrus.New()
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logger)
+
+	req := &model.PaymentRequest{
+		CardToken:  "tok_missing",
+		CardHolder: "John Doe",
+		Amount:     model.NewMoney(10000, "BRL"),
+		MerchantID: "merchant123",
+	}
+
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_missing").Return(nil, vault.ErrTokenNotFound)
+
+	// Execute
+	response, err := paymentService.CreatePayment(context.Background(), req)
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, response)
+	assert.Contains(t, err.Error(), "invalid card token")
 }
 
 func TestPaymentService_CreatePayment_InsufficientBalance(t *testing.T) {
 	// Setup
 	mockRepo := new(MockPaymentRepository)
-	mockProducer := new(MockKafkaProducer)
+	mockVault := new(MockCardVault)
 	logger := logrus.New()
-	
-	paymentService := service.NewPaymentService(mockRepo, mockProducer, logger)
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logger)
 
 	// Mock data
 	account := &model.Account{
-		CardNumber: "1234567890123456",
-		Balance:    50.00, // Insufficient balance
-		IsActive:   true,
+		CardNumber:       "4111111111111111",
+		AvailableBalance: model.NewMoney(5000, "BRL"), // Insufficient balance
+		IsActive:         true,
 	}
 
 	req := &model.PaymentRequest{
-		CardNumber:  "1234567890123456",
+		CardNumber:  "4111111111111111",
 		CardHolder:  "John Doe",
 		ExpiryMonth: 12,
-		ExpiryYear:  2025,
+		ExpiryYear:  nextYear,
 		CVV:         "123",
-		Amount:      100.00,
-		Currency:    "BRL",
+		Amount:      model.NewMoney(10000, "BRL"),
 		MerchantID:  "merchant123",
 	}
 
 	// Setup expectations
+	mockVault.On("Tokenize", mock.Anything, "merchant123", mock.AnythingOfType("*model.Card")).Return(&model.VaultedCard{Token: "tok_abc"}, nil)
 	mockRepo.On("GetAccountByCardNumber", mock.Anything, req.CardNumber).Return(account, nil)
 
 	// Execute
@@ -157,19 +538,18 @@ func TestPaymentService_CreatePayment_InsufficientBalance(t *testing.T) {
 func TestPaymentService_CreatePayment_InvalidCard(t *testing.T) {
 	// Setup
 	mockRepo := new(MockPaymentRepository)
-	mockProducer := new(MockKafkaProducer)
+	mockVault := new(MockCardVault)
 	logger := logrus.New()
-	
-	paymentService := service.NewPaymentService(mockRepo, mockProducer, logger)
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logger)
 
 	req := &model.PaymentRequest{
 		CardNumber:  "123", // Invalid card number
 		CardHolder:  "John Doe",
 		ExpiryMonth: 12,
-		ExpiryYear:  2025,
+		ExpiryYear:  nextYear,
 		CVV:         "123",
-		Amount:      100.00,
-		Currency:    "BRL",
+		Amount:      model.NewMoney(10000, "BRL"),
 		MerchantID:  "merchant123",
 	}
 
@@ -185,20 +565,19 @@ func TestPaymentService_CreatePayment_InvalidCard(t *testing.T) {
 func TestPaymentService_GetPayment_Success(t *testing.T) {
 	// Setup
 	mockRepo := new(MockPaymentRepository)
-	mockProducer := new(MockKafkaProducer)
+	mockVault := new(MockCardVault)
 	logger := logrus.New()
-	
-	paymentService := service.NewPaymentService(mockRepo, mockProducer, logger)
+
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logger)
 
 	// Mock data
 	paymentID := uuid.New()
 	payment := &model.Payment{
-		ID:         paymentID,
-		Amount:     100.00,
-		Currency:   "BRL",
-		Status:     model.PaymentStatusCompleted,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		ID:        paymentID,
+		Amount:    model.NewMoney(10000, "BRL"),
+		Status:    model.PaymentStatusCompleted,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	}
 
 	// Setup expectations
@@ -226,10 +605,10 @@ func TestCard_IsValid(t *testing.T) {
 		{
 			name: "Valid card",
 			card: model.Card{
-				Number:      "1234567890123456",
+				Number:      "4111111111111111",
 				Holder:      "John Doe",
 				ExpiryMonth: 12,
-				ExpiryYear:  2025,
+				ExpiryYear:  nextYear,
 				CVV:         "123",
 			},
 			expected: true,
@@ -240,7 +619,29 @@ func TestCard_IsValid(t *testing.T) {
 				Number:      "123456789012345",
 				Holder:      "John Doe",
 				ExpiryMonth: 12,
-				ExpiryYear:  2025,
+				ExpiryYear:  nextYear,
+				CVV:         "123",
+			},
+			expected: false,
+		},
+		{
+			name: "Valid Amex card with 4-digit CVV",
+			card: model.Card{
+				Number:      "378282246310005",
+				Holder:      "John Doe",
+				ExpiryMonth: 12,
+				ExpiryYear:  nextYear,
+				CVV:         "1234",
+			},
+			expected: true,
+		},
+		{
+			name: "Luhn check failed",
+			card: model.Card{
+				Number:      "4111111111111112",
+				Holder:      "John Doe",
+				ExpiryMonth: 12,
+				ExpiryYear:  nextYear,
 				CVV:         "123",
 			},
 			expected: false,
@@ -248,7 +649,7 @@ func TestCard_IsValid(t *testing.T) {
 		{
 			name: "Expired card",
 			card: model.Card{
-				Number:      "1234567890123456",
+				Number:      "4111111111111111",
 				Holder:      "John Doe",
 				ExpiryMonth: 1,
 				ExpiryYear:  2020,
@@ -259,10 +660,10 @@ func TestCard_IsValid(t *testing.T) {
 		{
 			name: "Invalid CVV",
 			card: model.Card{
-				Number:      "1234567890123456",
+				Number:      "4111111111111111",
 				Holder:      "John Doe",
 				ExpiryMonth: 12,
-				ExpiryYear:  2025,
+				ExpiryYear:  nextYear,
 				CVV:         "12",
 			},
 			expected: false,
@@ -281,34 +682,43 @@ func TestAccount_HasSufficientBalance(t *testing.T) {
 	tests := []struct {
 		name     string
 		account  model.Account
-		amount   float64
+		amount   model.Money
 		expected bool
 	}{
 		{
 			name: "Sufficient balance",
 			account: model.Account{
-				Balance:  1000.00,
-				IsActive: true,
+				AvailableBalance: model.NewMoney(100000, "BRL"),
+				IsActive:         true,
 			},
-			amount:   500.00,
+			amount:   model.NewMoney(50000, "BRL"),
 			expected: true,
 		},
 		{
 			name: "Insufficient balance",
 			account: model.Account{
-				Balance:  100.00,
-				IsActive: true,
+				AvailableBalance: model.NewMoney(10000, "BRL"),
+				IsActive:         true,
 			},
-			amount:   500.00,
+			amount:   model.NewMoney(50000, "BRL"),
+			expected: false,
+		},
+		{
+			name: "Different currency",
+			account: model.Account{
+				AvailableBalance: model.NewMoney(100000, "BRL"),
+				IsActive:         true,
+			},
+			amount:   model.NewMoney(50000, "USD"),
 			expected: false,
 		},
 		{
 			name: "Inactive account",
 			account: model.Account{
-				Balance:  1000.00,
-				IsActive: false,
+				AvailableBalance: model.NewMoney(100000, "BRL"),
+				IsActive:         false,
 			},
-			amount:   500.00,
+			amount:   model.NewMoney(50000, "BRL"),
 			expected: false,
 		},
 	}
@@ -319,4 +729,4 @@ func TestAccount_HasSufficientBalance(t *testing.T) {
 			assert.Equal(t, tt.expected, result)
 		})
 	}
-} 
\ No newline at end of file
+}
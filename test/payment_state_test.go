@@ -0,0 +1,95 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentStatus_Transitions(t *testing.T) {
+	tests := []struct {
+		from    model.PaymentStatus
+		to      model.PaymentStatus
+		allowed bool
+	}{
+		{model.PaymentStatusPending, model.PaymentStatusProcessing, true},
+		{model.PaymentStatusPending, model.PaymentStatusFailed, true},
+		{model.PaymentStatusPending, model.PaymentStatusCancelled, true},
+		{model.PaymentStatusPending, model.PaymentStatusCompleted, false},
+		{model.PaymentStatusProcessing, model.PaymentStatusCompleted, true},
+		{model.PaymentStatusProcessing, model.PaymentStatusFailed, true},
+		{model.PaymentStatusProcessing, model.PaymentStatusCancelled, true},
+		{model.PaymentStatusProcessing, model.PaymentStatusPending, false},
+		{model.PaymentStatusCompleted, model.PaymentStatusProcessing, false},
+		{model.PaymentStatusCompleted, model.PaymentStatusFailed, false},
+		{model.PaymentStatusFailed, model.PaymentStatusCompleted, false},
+		{model.PaymentStatusCancelled, model.PaymentStatusProcessing, false},
+		{model.PaymentStatusCompleted, model.PaymentStatusCancelled, false},
+		{model.PaymentStatusProcessing, model.PaymentStatusAuthorized, true},
+		{model.PaymentStatusPending, model.PaymentStatusAuthorized, false},
+		{model.PaymentStatusAuthorized, model.PaymentStatusCompleted, true},
+		{model.PaymentStatusAuthorized, model.PaymentStatusVoided, true},
+		{model.PaymentStatusAuthorized, model.PaymentStatusCancelled, false},
+		{model.PaymentStatusAuthorized, model.PaymentStatusFailed, false},
+		{model.PaymentStatusVoided, model.PaymentStatusCompleted, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			assert.Equal(t, tt.allowed, tt.from.CanTransitionTo(tt.to))
+
+			err := model.ValidateTransition(tt.from, tt.to)
+			if tt.allowed {
+				assert.NoError(t, err)
+				return
+			}
+
+			var transitionErr *model.TransitionError
+			require.True(t, errors.As(err, &transitionErr))
+			assert.Equal(t, tt.from, transitionErr.From)
+			assert.Equal(t, tt.to, transitionErr.To)
+		})
+	}
+}
+
+func TestPaymentStatus_IsTerminal(t *testing.T) {
+	assert.False(t, model.PaymentStatusPending.IsTerminal())
+	assert.False(t, model.PaymentStatusProcessing.IsTerminal())
+	assert.True(t, model.PaymentStatusCompleted.IsTerminal())
+	assert.True(t, model.PaymentStatusFailed.IsTerminal())
+	assert.True(t, model.PaymentStatusCancelled.IsTerminal())
+	assert.False(t, model.PaymentStatusAuthorized.IsTerminal())
+	assert.True(t, model.PaymentStatusVoided.IsTerminal())
+}
+
+func TestPaymentService_ProcessPayment_SkipsRedeliveredMessage(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	paymentID := uuid.New()
+	conflict := &model.TransitionError{
+		PaymentID: paymentID.String(),
+		From:      model.PaymentStatusPending,
+		To:        model.PaymentStatusProcessing,
+		Current:   model.PaymentStatusCompleted,
+	}
+
+	mockRepo.On("TransitionStatus", mock.Anything, paymentID, model.PaymentStatusPending, model.PaymentStatusProcessing, (*string)(nil)).Return(conflict)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), paymentID.String(), nil)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+	mockVault.AssertNotCalled(t, "Detokenize", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPaymentService_ProcessPayment_SkipsAlreadyProcessedDelivery(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	paymentID := uuid.New()
+	delivery := &model.MessageDelivery{Topic: "payment-processing", Partition: 2, Offset: 41}
+
+	mockRepo.On("IsMessageProcessed", mock.Anything, paymentID, *delivery).Return(true, nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), paymentID.String(), delivery)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessPayment_DedupCheckFailureIsNotAcknowledged(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	paymentID := uuid.New()
+	delivery := &model.MessageDelivery{Topic: "payment-processing", Partition: 0, Offset: 7}
+
+	mockRepo.On("IsMessageProcessed", mock.Anything, paymentID, *delivery).Return(false, errors.New("connection refused"))
+
+	// O erro volta para o consumidor como transitório, para uma nova tentativa
+	err := paymentService.ProcessPaymentAsync(context.Background(), paymentID.String(), delivery)
+
+	assert.Error(t, err)
+	assert.True(t, model.IsRetryable(err))
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessPayment_RedeliveryOfFinishedPaymentIsSkipped(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New())
+
+	paymentID := uuid.New()
+	// A mesma mensagem publicada duas vezes pelo outbox chega com outro offset
+	delivery := &model.MessageDelivery{Topic: "payment-processing", Partition: 0, Offset: 8}
+	conflict := &model.TransitionError{
+		PaymentID: paymentID.String(),
+		From:      model.PaymentStatusPending,
+		To:        model.PaymentStatusProcessing,
+		Current:   model.PaymentStatusFailed,
+	}
+
+	mockRepo.On("IsMessageProcessed", mock.Anything, paymentID, *delivery).Return(false, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, paymentID, model.PaymentStatusPending, model.PaymentStatusProcessing, (*string)(nil)).Return(conflict)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), paymentID.String(), delivery)
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "RecordProcessedMessage", mock.Anything, mock.Anything)
+}
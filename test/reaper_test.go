@@ -0,0 +1,191 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newPaymentReaper(repo *MockPaymentRepository) *service.PaymentReaper {
+	return service.NewPaymentReaper(repo, "payment-processing", time.Minute, 100, 10*time.Minute, 15*time.Minute, 3, logrus.New())
+}
+
+func TestPaymentReaper_RequeuesStuckPayment(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	reaper := newPaymentReaper(mockRepo)
+
+	payment := newPayment(model.PaymentStatusProcessing).amount(1000).stuck(time.Hour, 1).build()
+
+	var reaped []*model.PaymentEvent
+	reaper.OnReaped(func(event *model.PaymentEvent) { reaped = append(reaped, event) })
+
+	mockRepo.On("GetOutboxBacklog", mock.Anything).Return(&model.OutboxBacklog{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusPending, mock.Anything, 100).Return([]*model.Payment{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusProcessing, mock.Anything, 100).Return([]*model.Payment{payment}, nil)
+	mockRepo.On("RecordRequeue", mock.Anything, payment.ID).Return(2, nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.MatchedBy(func(message *model.OutboxMessage) bool {
+		return message.Topic == "payment-processing" && message.Key == "tok_abc"
+	})).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.AnythingOfType("*model.PaymentEvent")).Return(nil)
+
+	n, err := reaper.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, reaped, 1)
+	assert.Equal(t, model.PaymentEventRequeued, reaped[0].Type)
+	assert.Equal(t, model.PaymentStatusProcessing, reaped[0].ToStatus)
+	assert.Equal(t, model.ActorReaper, reaped[0].Actor)
+	assert.Contains(t, reaped[0].Reason, "(2/3)")
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentReaper_CancelsPendingAfterMaxRequeues(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	reaper := newPaymentReaper(mockRepo)
+
+	payment := newPayment(model.PaymentStatusPending).amount(1000).stuck(time.Hour, 3).build()
+
+	mockRepo.On("GetOutboxBacklog", mock.Anything).Return(&model.OutboxBacklog{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusPending, mock.Anything, 100).Return([]*model.Payment{payment}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusProcessing, mock.Anything, 100).Return([]*model.Payment{}, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusPending, model.PaymentStatusCancelled, mock.MatchedBy(func(msg *string) bool {
+		return msg != nil && *msg != ""
+	})).Return(nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventCancelled && event.FromStatus == model.PaymentStatusPending
+	})).Return(nil)
+
+	n, err := reaper.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	mockRepo.AssertNotCalled(t, "EnqueueOutbox", mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentReaper_FailsProcessingAfterMaxRequeues(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	reaper := newPaymentReaper(mockRepo)
+
+	payment := newPayment(model.PaymentStatusProcessing).amount(1000).stuck(time.Hour, 3).build()
+
+	mockRepo.On("GetOutboxBacklog", mock.Anything).Return(&model.OutboxBacklog{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusPending, mock.Anything, 100).Return([]*model.Payment{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusProcessing, mock.Anything, 100).Return([]*model.Payment{payment}, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusFailed, mock.AnythingOfType("*string")).Return(nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventFailed && event.ToStatus == model.PaymentStatusFailed
+	})).Return(nil)
+
+	n, err := reaper.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentReaper_RequeuesApprovedProcessingAfterMaxRequeues(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	reaper := newPaymentReaper(mockRepo)
+
+	// O adquirente aprovou antes de o consumidor cair: falhar deixaria o valor
+	// autorizado no emissor
+	payment := newPayment(model.PaymentStatusProcessing).amount(1000).approved().stuck(time.Hour, 3).build()
+
+	mockRepo.On("GetOutboxBacklog", mock.Anything).Return(&model.OutboxBacklog{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusPending, mock.Anything, 100).Return([]*model.Payment{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusProcessing, mock.Anything, 100).Return([]*model.Payment{payment}, nil)
+	mockRepo.On("RecordRequeue", mock.Anything, payment.ID).Return(4, nil)
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.AnythingOfType("*model.OutboxMessage")).Return(nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.MatchedBy(func(event *model.PaymentEvent) bool {
+		return event.Type == model.PaymentEventRequeued && event.ToStatus == model.PaymentStatusProcessing
+	})).Return(nil)
+
+	n, err := reaper.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "ReleaseHold", mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentReaper_FailsDeclinedProcessingAfterMaxRequeues(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	reaper := newPaymentReaper(mockRepo)
+
+	payment := newPayment(model.PaymentStatusProcessing).amount(1000).stuck(time.Hour, 3).build()
+	declined := string(model.ResponseDoNotHonor)
+	payment.ResponseCode = &declined
+
+	mockRepo.On("GetOutboxBacklog", mock.Anything).Return(&model.OutboxBacklog{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusPending, mock.Anything, 100).Return([]*model.Payment{}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusProcessing, mock.Anything, 100).Return([]*model.Payment{payment}, nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusFailed, mock.AnythingOfType("*string")).Return(nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+	mockRepo.On("RecordPaymentEvent", mock.Anything, mock.AnythingOfType("*model.PaymentEvent")).Return(nil)
+
+	n, err := reaper.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	mockRepo.AssertNotCalled(t, "RecordRequeue", mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentReaper_SkipsPendingWhileOutboxIsBehind(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	reaper := newPaymentReaper(mockRepo)
+
+	// O relay está parado há mais tempo que o limite dos pendentes
+	oldest := time.Now().Add(-30 * time.Minute)
+	mockRepo.On("GetOutboxBacklog", mock.Anything).Return(&model.OutboxBacklog{Pending: 40, OldestCreatedAt: &oldest}, nil)
+	mockRepo.On("ClaimStuckPayments", mock.Anything, model.PaymentStatusProcessing, mock.Anything, 100).Return([]*model.Payment{}, nil)
+
+	n, err := reaper.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	mockRepo.AssertNotCalled(t, "ClaimStuckPayments", mock.Anything, model.PaymentStatusPending, mock.Anything, mock.Anything)
+}
+
+func TestPaymentHistoryHandler_ReturnsEvents(t *testing.T) {
+	mockService := new(MockPaymentService)
+	router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+	payment := newPayment(model.PaymentStatusCancelled).amount(1000).stuck(time.Hour, 3).build()
+	events := []*model.PaymentEvent{
+		{ID: 1, PaymentID: payment.ID, Type: model.PaymentEventRequeued, FromStatus: model.PaymentStatusPending, ToStatus: model.PaymentStatusPending, Actor: model.ActorReaper},
+		{ID: 2, PaymentID: payment.ID, Type: model.PaymentEventCancelled, FromStatus: model.PaymentStatusPending, ToStatus: model.PaymentStatusCancelled, Actor: model.ActorReaper},
+	}
+	mockService.On("GetPaymentHistory", mock.Anything, payment.ID).Return(payment, events, nil)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/payments/"+payment.ID.String()+"/history", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var history struct {
+		Status model.PaymentStatus   `json:"status"`
+		Events []*model.PaymentEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	assert.Equal(t, model.PaymentStatusCancelled, history.Status)
+	require.Len(t, history.Events, 2)
+	assert.Equal(t, model.PaymentEventCancelled, history.Events[1].Type)
+}
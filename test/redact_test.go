@@ -0,0 +1,122 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/redact"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskPAN(t *testing.T) {
+	assert.Equal(t, "411111******1111", redact.MaskPAN("4111111111111111"))
+	assert.Equal(t, "378282*****0005", redact.MaskPAN("378282246310005"))
+	assert.Equal(t, "411111******1111", redact.MaskPAN("4111 1111 1111 1111"))
+	assert.Equal(t, "***", redact.MaskPAN("123"))
+	assert.Equal(t, "411111...1111", redact.MaskedPAN("411111", "1111"))
+	assert.Equal(t, "378282...0005", redact.MaskedPAN("378282", "0005"))
+}
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "PAN in message",
+			input:    "lookup failed for 4111111111111111",
+			expected: "lookup failed for 411111******1111",
+		},
+		{
+			name:     "PAN with separators",
+			input:    "card 4111-1111-1111-1111 declined",
+			expected: "card 411111******1111 declined",
+		},
+		{
+			name:     "CVV key value",
+			input:    "payload cvv=123 rejected",
+			expected: "payload cvv=[REDACTED] rejected",
+		},
+		{
+			name:     "CVV in JSON",
+			input:    `{"card_number":"5555555555554444","cvv":"1234"}`,
+			expected: `{"card_number":"555555******4444","cvv":"[REDACTED]"}`,
+		},
+		{
+			name:     "Short numbers are kept",
+			input:    "payment amount 10050 for merchant 123",
+			expected: "payment amount 10050 for merchant 123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, redact.String(tt.input))
+		})
+	}
+}
+
+func TestRedactHook(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(redact.NewHook())
+
+	logger.WithFields(logrus.Fields{
+		"card_number": "4111111111111111",
+		"cvv":         "123",
+		"payload":     `{"number":"5555555555554444"}`,
+	}).WithError(errors.New("account 4111111111111111 not found")).Error("failed for 4111111111111111")
+
+	output := buf.String()
+	assert.NotContains(t, output, "4111111111111111")
+	assert.NotContains(t, output, "5555555555554444")
+	assert.NotContains(t, output, `"123"`)
+	assert.Contains(t, output, "411111******1111")
+	assert.Contains(t, output, redact.Redacted)
+}
+
+type loggedCard struct {
+	Number string
+	CVV    string
+}
+
+type loggedRequest struct{ pan string }
+
+func (r loggedRequest) String() string { return "request for " + r.pan }
+
+func TestRedactField_ScrubsStructsAndStringers(t *testing.T) {
+	card := loggedCard{Number: "4111111111111111", CVV: "123"}
+
+	assert.Equal(t, "{Number:411111******1111 CVV:[REDACTED]}", redact.Field("card", card))
+	assert.Equal(t, "&{Number:411111******1111 CVV:[REDACTED]}", redact.Field("card", &card))
+	assert.Equal(t, "map[pan:411111******1111]", redact.Field("cards", map[string]string{"pan": "4111111111111111"}))
+	assert.Equal(t, "request for 411111******1111", redact.Field("request", loggedRequest{pan: "4111111111111111"}))
+
+	// Valores sem dados sensíveis são mantidos como estão
+	amount := model.NewMoney(10000, "BRL")
+	assert.Equal(t, amount, redact.Field("amount", amount))
+	assert.Equal(t, 42, redact.Field("attempts", 42))
+}
+
+func TestNewPaymentDetails_MasksCardData(t *testing.T) {
+	payment := &model.Payment{
+		CardToken:  "tok_abc",
+		CardBIN:    "411111",
+		CardLast4:  "1111",
+		CardHolder: "John Doe",
+		Amount:     model.NewMoney(10000, "BRL"),
+	}
+
+	details := model.NewPaymentDetails(payment)
+
+	assert.Equal(t, "411111...1111", details.MaskedPAN)
+	assert.Equal(t, "J*** D**", details.CardHolder)
+	assert.Equal(t, "tok_abc", details.CardToken)
+}
@@ -0,0 +1,376 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/handler"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func pendingRefund(payment *model.Payment, value int64) *model.Refund {
+	return &model.Refund{
+		ID:        uuid.New(),
+		PaymentID: payment.ID,
+		Amount:    model.NewMoney(value, "BRL"),
+		Status:    model.RefundStatusPending,
+	}
+}
+
+func TestPaymentService_CreateRefund_FullRefundIsQueued(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New())
+
+	payment := newPayment(model.PaymentStatusCompleted).approved().build()
+
+	// Sem valor, o repositório preenche o saldo ainda não estornado
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("CreateRefund", mock.Anything, mock.MatchedBy(func(refund *model.Refund) bool {
+		return refund.PaymentID == payment.ID && refund.Amount.IsZero() && refund.Status == model.RefundStatusPending
+	})).Run(func(args mock.Arguments) {
+		args.Get(1).(*model.Refund).Amount = model.NewMoney(7500, "BRL")
+	}).Return(nil)
+
+	var message queue.PaymentMessage
+	mockRepo.On("EnqueueOutbox", mock.Anything, mock.MatchedBy(func(outbox *model.OutboxMessage) bool {
+		return outbox.Topic == "payment-processing" && outbox.Key == "tok_abc" && json.Unmarshal(outbox.Payload, &message) == nil
+	})).Return(nil)
+
+	refund, err := paymentService.CreateRefund(context.Background(), payment.ID, &model.RefundRequest{Reason: "Item returned"})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.NewMoney(7500, "BRL"), refund.Amount)
+	assert.Equal(t, "Item returned", refund.Reason)
+	assert.Equal(t, payment.ID.String(), message.PaymentID)
+	assert.Equal(t, refund.ID.String(), message.RefundID)
+	assert.Equal(t, refund.Amount, message.Amount)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentService_CreateRefund_RejectsAmountAboveRefundable(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New())
+
+	payment := newPayment(model.PaymentStatusCompleted).approved().build()
+	amount := model.NewMoney(20000, "BRL")
+
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockRepo.On("CreateRefund", mock.Anything, mock.AnythingOfType("*model.Refund")).
+		Return(fmt.Errorf("%w: 100.00 BRL refundable", repository.ErrRefundExceedsPayment))
+
+	_, err := paymentService.CreateRefund(context.Background(), payment.ID, &model.RefundRequest{Amount: &amount})
+
+	assert.ErrorIs(t, err, repository.ErrRefundExceedsPayment)
+	mockRepo.AssertNotCalled(t, "EnqueueOutbox", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessRefund_CreditsAccountAndPostsLedger(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusCompleted).approved().build()
+	refund := pendingRefund(payment, 2500)
+	accountID := uuid.New()
+	response := approvedResponse("000000000042")
+
+	mockRepo.On("GetRefund", mock.Anything, refund.ID).Return(refund, nil)
+	mockRepo.On("RecordRefundAttempt", mock.Anything, refund.ID).Return(1, nil)
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+	mockAcquirer.On("Refund", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+		return req.PaymentID == payment.ID && req.Amount == refund.Amount && req.NetworkReference == "000000000042"
+	})).Return(response, nil)
+	mockRepo.On("RecordRefundAcquirerResponse", mock.Anything, refund.ID, "mock", response).Return(nil)
+
+	mockRepo.On("TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusSucceeded, (*string)(nil)).Return(nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, "4111111111111111").Return(&model.Account{ID: accountID}, nil)
+	mockRepo.On("CreditAccount", mock.Anything, accountID, refund.Amount).Return(model.NewMoney(12500, "BRL"), nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.MatchedBy(func(entry *model.JournalEntry) bool {
+		return entry.Kind == model.JournalEntryRefund && *entry.PaymentID == payment.ID && entry.Postings[0].Amount == refund.Amount
+	})).Return(nil)
+
+	err := paymentService.ProcessRefundAsync(context.Background(), refund.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertExpectations(t)
+}
+
+func TestPaymentService_ProcessRefund_DeclinedByAcquirer(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusCompleted).approved().build()
+	refund := pendingRefund(payment, 2500)
+	response := &model.AcquirerResponse{ResponseCode: model.ResponseDoNotHonor, DeclineReason: "Do not honor"}
+
+	mockRepo.On("GetRefund", mock.Anything, refund.ID).Return(refund, nil)
+	mockRepo.On("RecordRefundAttempt", mock.Anything, refund.ID).Return(1, nil)
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+	mockAcquirer.On("Refund", mock.Anything, mock.Anything).Return(response, nil)
+	mockRepo.On("RecordRefundAcquirerResponse", mock.Anything, refund.ID, "mock", response).Return(nil)
+	mockRepo.On("TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusFailed, mock.MatchedBy(func(msg *string) bool {
+		return msg != nil && *msg == "Refund declined: Do not honor"
+	})).Return(nil)
+
+	err := paymentService.ProcessRefundAsync(context.Background(), refund.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "CreditAccount", mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentService_ProcessRefund_SkipsConcludedRefund(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, new(MockCardVault), logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	refund := pendingRefund(newPayment(model.PaymentStatusCompleted).approved().build(), 2500)
+	refund.Status = model.RefundStatusSucceeded
+
+	mockRepo.On("GetRefund", mock.Anything, refund.ID).Return(refund, nil)
+
+	err := paymentService.ProcessRefundAsync(context.Background(), refund.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockAcquirer.AssertNotCalled(t, "Refund", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "RecordRefundAttempt", mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessRefund_TransientFailureIsRetriedThenFails(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		attempts  int
+		retryable bool
+	}{
+		{name: "attempts left", attempts: 1, retryable: true},
+		{name: "last attempt", attempts: 3, retryable: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockPaymentRepository)
+			mockVault := new(MockCardVault)
+			mockAcquirer := new(MockAcquirerGateway)
+			paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+				service.WithAcquirer(mockAcquirer), service.WithMaxAttempts(3))
+
+			payment := newPayment(model.PaymentStatusCompleted).approved().build()
+			refund := pendingRefund(payment, 2500)
+
+			mockRepo.On("GetRefund", mock.Anything, refund.ID).Return(refund, nil)
+			mockRepo.On("RecordRefundAttempt", mock.Anything, refund.ID).Return(tc.attempts, nil)
+			mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+			mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+			mockAcquirer.On("Refund", mock.Anything, mock.Anything).Return((*model.AcquirerResponse)(nil), gateway.ErrUnavailable)
+			mockRepo.On("TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusFailed, mock.AnythingOfType("*string")).Return(nil)
+
+			err := paymentService.ProcessRefundAsync(context.Background(), refund.ID.String(), nil)
+
+			require.Error(t, err)
+			assert.Equal(t, tc.retryable, model.IsRetryable(err))
+			if tc.retryable {
+				mockRepo.AssertNotCalled(t, "TransitionRefundStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				assert.ErrorIs(t, err, model.ErrRetriesExhausted)
+				mockRepo.AssertCalled(t, "TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusFailed, mock.AnythingOfType("*string"))
+			}
+		})
+	}
+}
+
+func TestPaymentService_ProcessRefund_UnansweredRefundIsRetriedWithTheSameReference(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		attempts  int
+		retryable bool
+	}{
+		{name: "attempts left", attempts: 1, retryable: true},
+		{name: "last attempt", attempts: 3, retryable: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockPaymentRepository)
+			mockVault := new(MockCardVault)
+			mockAcquirer := new(MockAcquirerGateway)
+			paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+				service.WithAcquirer(mockAcquirer), service.WithMaxAttempts(3))
+
+			payment := newPayment(model.PaymentStatusCompleted).approved().build()
+			refund := pendingRefund(payment, 2500)
+
+			mockRepo.On("GetRefund", mock.Anything, refund.ID).Return(refund, nil)
+			mockRepo.On("RecordRefundAttempt", mock.Anything, refund.ID).Return(tc.attempts, nil)
+			mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+			mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+			// Cada tentativa leva o ID do estorno, para o adquirente reconhecer o reenvio
+			mockAcquirer.On("Refund", mock.Anything, mock.MatchedBy(func(req *model.AcquirerRequest) bool {
+				return req.Reference == refund.ID.String()
+			})).Return((*model.AcquirerResponse)(nil), gateway.ErrTimeout)
+			mockRepo.On("TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusNeedsReconciliation, mock.AnythingOfType("*string")).Return(nil)
+
+			err := paymentService.ProcessRefundAsync(context.Background(), refund.ID.String(), nil)
+
+			require.Error(t, err)
+			assert.Equal(t, tc.retryable, model.IsRetryable(err))
+			mockAcquirer.AssertExpectations(t)
+			if tc.retryable {
+				mockRepo.AssertNotCalled(t, "TransitionRefundStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				// Sem resposta o estorno pode ter sido executado: não vai para failed
+				mockRepo.AssertCalled(t, "TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusNeedsReconciliation, mock.AnythingOfType("*string"))
+			}
+		})
+	}
+}
+
+func TestPaymentService_ProcessRefund_ResumesFromRecordedResponse(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	payment := newPayment(model.PaymentStatusCompleted).approved().build()
+	refund := pendingRefund(payment, 2500)
+	code := string(model.ResponseApproved)
+	reference := "000000000077"
+	refund.ResponseCode = &code
+	refund.NetworkReference = &reference
+	accountID := uuid.New()
+
+	// A entrega anterior gravou a aprovação e caiu antes do crédito
+	mockRepo.On("GetRefund", mock.Anything, refund.ID).Return(refund, nil)
+	mockRepo.On("RecordRefundAttempt", mock.Anything, refund.ID).Return(2, nil)
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+
+	mockRepo.On("TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusSucceeded, (*string)(nil)).Return(nil)
+	mockRepo.On("GetAccountByCardNumber", mock.Anything, "4111111111111111").Return(&model.Account{ID: accountID}, nil)
+	mockRepo.On("CreditAccount", mock.Anything, accountID, refund.Amount).Return(model.NewMoney(12500, "BRL"), nil)
+	mockRepo.On("EnsureLedgerAccount", mock.Anything, mock.Anything, mock.Anything, model.Currency("BRL")).
+		Return(&model.LedgerAccount{ID: uuid.New()}, nil)
+	mockRepo.On("PostEntry", mock.Anything, mock.AnythingOfType("*model.JournalEntry")).Return(nil)
+
+	err := paymentService.ProcessRefundAsync(context.Background(), refund.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAcquirer.AssertNotCalled(t, "Refund", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "RecordRefundAcquirerResponse", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessRefund_ApprovedButNotCreditedNeedsReconciliation(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		creditErr error
+		attempts  int
+	}{
+		{name: "card account not found", creditErr: repository.ErrAccountNotFound, attempts: 1},
+		{name: "retries exhausted", creditErr: errors.New("connection refused"), attempts: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockPaymentRepository)
+			mockVault := new(MockCardVault)
+			mockAcquirer := new(MockAcquirerGateway)
+			paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(),
+				service.WithAcquirer(mockAcquirer), service.WithMaxAttempts(3))
+
+			payment := newPayment(model.PaymentStatusCompleted).approved().build()
+			refund := pendingRefund(payment, 2500)
+			response := approvedResponse("000000000042")
+
+			mockRepo.On("GetRefund", mock.Anything, refund.ID).Return(refund, nil)
+			mockRepo.On("RecordRefundAttempt", mock.Anything, refund.ID).Return(tc.attempts, nil)
+			mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+			mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+			mockAcquirer.On("Refund", mock.Anything, mock.Anything).Return(response, nil)
+			mockRepo.On("RecordRefundAcquirerResponse", mock.Anything, refund.ID, "mock", response).Return(nil)
+			mockRepo.On("TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusSucceeded, (*string)(nil)).Return(nil)
+			mockRepo.On("GetAccountByCardNumber", mock.Anything, "4111111111111111").Return(nil, tc.creditErr)
+			mockRepo.On("TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusNeedsReconciliation, mock.AnythingOfType("*string")).Return(nil)
+
+			_ = paymentService.ProcessRefundAsync(context.Background(), refund.ID.String(), nil)
+
+			// O valor já voltou ao portador: o estorno não pode ir para failed
+			mockRepo.AssertCalled(t, "TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusNeedsReconciliation, mock.AnythingOfType("*string"))
+			mockRepo.AssertNotCalled(t, "TransitionRefundStatus", mock.Anything, refund.ID, model.RefundStatusFailed, mock.Anything)
+		})
+	}
+}
+
+func TestRefundHandler_Responses(t *testing.T) {
+	paymentID := uuid.New()
+
+	tests := []struct {
+		name   string
+		body   string
+		err    error
+		status int
+	}{
+		{name: "full refund without body", body: "", status: http.StatusCreated},
+		{name: "partial refund", body: `{"amount":{"value":2500,"currency":"BRL"},"reason":"Item returned"}`, status: http.StatusCreated},
+		{name: "non-positive amount", body: `{"amount":{"value":0,"currency":"BRL"}}`, status: http.StatusBadRequest},
+		{name: "payment not found", err: repository.ErrPaymentNotFound, status: http.StatusNotFound},
+		{name: "currency mismatch", body: `{"amount":{"value":2500,"currency":"USD"}}`, err: fmt.Errorf("%w: BRL and USD", model.ErrCurrencyMismatch), status: http.StatusBadRequest},
+		{name: "nothing captured", err: fmt.Errorf("%w: payment is authorized with nothing captured", repository.ErrPaymentNotRefundable), status: http.StatusConflict},
+		{name: "above refundable", err: repository.ErrRefundExceedsPayment, status: http.StatusConflict},
+		{name: "unexpected error", err: errors.New("connection refused"), status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockPaymentService)
+			router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+			if tc.err != nil {
+				mockService.On("CreateRefund", mock.Anything, paymentID, mock.Anything).Return(nil, tc.err)
+			} else {
+				mockService.On("CreateRefund", mock.Anything, paymentID, mock.Anything).
+					Return(&model.Refund{ID: uuid.New(), PaymentID: paymentID, Status: model.RefundStatusPending}, nil)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/payments/"+paymentID.String()+"/refunds", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestRefundHandler_FullRefundHasNoAmount(t *testing.T) {
+	mockService := new(MockPaymentService)
+	router := handler.NewHTTPHandler(mockService, logrus.New()).SetupRoutes()
+
+	paymentID := uuid.New()
+	mockService.On("CreateRefund", mock.Anything, paymentID, mock.MatchedBy(func(req *model.RefundRequest) bool {
+		return req.Amount == nil
+	})).Return(&model.Refund{ID: uuid.New(), PaymentID: paymentID, Status: model.RefundStatusPending}, nil)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/payments/"+paymentID.String()+"/refunds", nil))
+
+	require.Equal(t, http.StatusCreated, rec.Code)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,511 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgres_TransitionStatusIsCompareAndSet(t *testing.T) {
+	db := openTestDB(t)
+	payment := db.createPayment(t, newPayment(model.PaymentStatusPending))
+
+	errs := concurrently(8, func(int) error {
+		return db.repo.TransitionStatus(context.Background(), payment.ID,
+			model.PaymentStatusPending, model.PaymentStatusProcessing, nil)
+	})
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		var transitionErr *model.TransitionError
+		require.ErrorAs(t, err, &transitionErr)
+		assert.Equal(t, model.PaymentStatusProcessing, transitionErr.Current)
+	}
+	assert.Equal(t, 1, succeeded)
+
+	stored, err := db.repo.GetByID(context.Background(), payment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.PaymentStatusProcessing, stored.Status)
+	assert.NotNil(t, stored.ProcessingStartedAt)
+}
+
+func TestPostgres_CreateRefundNeverOverRefunds(t *testing.T) {
+	db := openTestDB(t)
+	payment := db.createPayment(t, newPayment(model.PaymentStatusPending).captured(10000))
+	_, err := db.pool.Exec(context.Background(),
+		`UPDATE payments SET status = 'completed' WHERE id = $1`, payment.ID)
+	require.NoError(t, err)
+
+	errs := concurrently(8, func(int) error {
+		now := time.Now()
+		return db.repo.CreateRefund(context.Background(), &model.Refund{
+			ID:        uuid.New(),
+			PaymentID: payment.ID,
+			Amount:    model.NewMoney(3000, "BRL"),
+			Status:    model.RefundStatusPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	})
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(t, err, repository.ErrRefundExceedsPayment)
+	}
+	assert.Equal(t, 3, succeeded)
+
+	refunds, err := db.repo.GetRefundsByPayment(context.Background(), payment.ID)
+	require.NoError(t, err)
+	var total int64
+	for _, refund := range refunds {
+		total += refund.Amount.Value
+	}
+	assert.Equal(t, int64(9000), total)
+}
+
+func TestPostgres_CreateRefundIsBoundedByCapturedAmount(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	refund := func(paymentID uuid.UUID, value int64) error {
+		now := time.Now()
+		return db.repo.CreateRefund(ctx, &model.Refund{
+			ID:        uuid.New(),
+			PaymentID: paymentID,
+			Amount:    model.NewMoney(value, "BRL"),
+			Status:    model.RefundStatusPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+
+	// Autorizado sem capturas: nada a estornar
+	uncaptured := db.createPayment(t, newPayment(model.PaymentStatusPending).manualCapture())
+	_, err := db.pool.Exec(ctx, `UPDATE payments SET status = 'authorized' WHERE id = $1`, uncaptured.ID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, refund(uncaptured.ID, 1000), repository.ErrPaymentNotRefundable)
+
+	// Ainda em authorized, com uma captura parcial: estorna até o capturado
+	partial := db.createPayment(t, newPayment(model.PaymentStatusPending).manualCapture().captured(4000))
+	_, err = db.pool.Exec(ctx, `UPDATE payments SET status = 'authorized' WHERE id = $1`, partial.ID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, refund(partial.ID, 5000), repository.ErrRefundExceedsPayment)
+	assert.NoError(t, refund(partial.ID, 4000))
+}
+
+func TestPostgres_RefundAwaitingReconciliationStaysCommitted(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	payment := db.createPayment(t, newPayment(model.PaymentStatusPending).captured(10000))
+	_, err := db.pool.Exec(ctx, `UPDATE payments SET status = 'completed' WHERE id = $1`, payment.ID)
+	require.NoError(t, err)
+
+	now := time.Now()
+	refund := &model.Refund{
+		ID:        uuid.New(),
+		PaymentID: payment.ID,
+		Amount:    model.NewMoney(6000, "BRL"),
+		Status:    model.RefundStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	require.NoError(t, db.repo.CreateRefund(ctx, refund))
+	reason := "Card account not found"
+	require.NoError(t, db.repo.TransitionRefundStatus(ctx, refund.ID, model.RefundStatusNeedsReconciliation, &reason))
+
+	// O estorno aprovado sem crédito ainda consome o estornável
+	err = db.repo.CreateRefund(ctx, &model.Refund{
+		ID:        uuid.New(),
+		PaymentID: payment.ID,
+		Amount:    model.NewMoney(6000, "BRL"),
+		Status:    model.RefundStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	assert.ErrorIs(t, err, repository.ErrRefundExceedsPayment)
+
+	stored, err := db.repo.GetRefund(ctx, refund.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.RefundStatusNeedsReconciliation, stored.Status)
+}
+
+func TestPostgres_ReserveCaptureAllowsOneCaptureInFlight(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	payment := db.createPayment(t, newPayment(model.PaymentStatusPending).amount(10000).manualCapture())
+	_, err := db.pool.Exec(ctx, `UPDATE payments SET status = 'authorized' WHERE id = $1`, payment.ID)
+	require.NoError(t, err)
+
+	captures := make([]*model.PaymentCapture, 5)
+	errs := concurrently(len(captures), func(i int) error {
+		captures[i] = &model.PaymentCapture{
+			ID:        uuid.New(),
+			PaymentID: payment.ID,
+			Amount:    model.NewMoney(2000, "BRL"),
+			CreatedAt: time.Now(),
+		}
+		return db.repo.ReserveCapture(ctx, captures[i])
+	})
+
+	var reserved *model.PaymentCapture
+	for i, err := range errs {
+		if err == nil {
+			require.Nil(t, reserved, "two captures reserved")
+			reserved = captures[i]
+			continue
+		}
+		assert.ErrorIs(t, err, repository.ErrCaptureInProgress)
+	}
+	require.NotNil(t, reserved)
+
+	// A captura reservada bloqueia quem encerra a autorização
+	pending, err := db.repo.HasPendingCapture(ctx, payment.ID)
+	require.NoError(t, err)
+	assert.True(t, pending)
+
+	reserved.Acquirer = "mock"
+	reserved.ResponseCode = model.ResponseApproved
+	reserved.NetworkReference = "000000000043"
+	require.NoError(t, db.repo.RecordCapture(ctx, reserved))
+
+	stored, err := db.repo.GetCaptures(ctx, payment.ID)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, model.CaptureStatusSucceeded, stored[0].Status)
+	assert.Equal(t, "000000000043", stored[0].NetworkReference)
+
+	updated, err := db.repo.GetByID(ctx, payment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.NewMoney(2000, "BRL"), *updated.CapturedAmount)
+
+	pending, err = db.repo.HasPendingCapture(ctx, payment.ID)
+	require.NoError(t, err)
+	assert.False(t, pending)
+
+	// Sem valor a captura leva o saldo da linha travada e encerra a autorização
+	rest := &model.PaymentCapture{ID: uuid.New(), PaymentID: payment.ID, CreatedAt: time.Now()}
+	require.NoError(t, db.repo.ReserveCapture(ctx, rest))
+	assert.Equal(t, model.NewMoney(8000, "BRL"), rest.Amount)
+	assert.True(t, rest.Final)
+}
+
+func TestPostgres_DrawHoldKeepsHoldPositive(t *testing.T) {
+	db := openTestDB(t)
+	accountID := db.createAccount(t, 10000)
+	payment := db.createPayment(t, newPayment(model.PaymentStatusPending).amount(5000).manualCapture())
+	db.placeHold(t, accountID, payment.ID, 5000)
+
+	errs := concurrently(5, func(int) error {
+		_, err := db.repo.DrawHold(context.Background(), payment.ID, model.NewMoney(2000, "BRL"))
+		return err
+	})
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(t, err, repository.ErrHoldNotFound)
+	}
+	// 5000 → 3000 → 1000; uma terceira retirada zeraria a reserva
+	assert.Equal(t, 2, succeeded)
+
+	holds, err := db.repo.GetHoldsByAccount(context.Background(), accountID, model.HoldStatusActive, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, int64(1000), holds[0].Amount.Value)
+
+	balance, available := db.balances(t, accountID)
+	assert.Equal(t, int64(6000), balance)
+	assert.Equal(t, int64(5000), available)
+}
+
+func TestPostgres_IncreaseHoldNeverOverdrawsAvailable(t *testing.T) {
+	db := openTestDB(t)
+	accountID := db.createAccount(t, 10000)
+	payment := db.createPayment(t, newPayment(model.PaymentStatusPending).amount(5000).manualCapture())
+	db.placeHold(t, accountID, payment.ID, 5000)
+
+	errs := concurrently(5, func(int) error {
+		_, err := db.repo.IncreaseHold(context.Background(), payment.ID, model.NewMoney(2000, "BRL"))
+		return err
+	})
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+	}
+	// Sobram 5000 disponíveis: cabem dois incrementos de 2000
+	assert.Equal(t, 2, succeeded)
+
+	holds, err := db.repo.GetHoldsByAccount(context.Background(), accountID, model.HoldStatusActive, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, int64(9000), holds[0].Amount.Value)
+
+	balance, available := db.balances(t, accountID)
+	assert.Equal(t, int64(10000), balance)
+	assert.Equal(t, int64(1000), available)
+}
+
+func TestPostgres_ClaimOutboxPreservesPerKeyOrder(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	enqueue := func(key string) *model.OutboxMessage {
+		payload, err := json.Marshal(map[string]string{"key": key})
+		require.NoError(t, err)
+		message := &model.OutboxMessage{Topic: "payments", Key: key, Payload: payload, CreatedAt: now.Add(-time.Minute)}
+		require.NoError(t, db.repo.EnqueueOutbox(ctx, message))
+		return message
+	}
+
+	a1 := enqueue("a")
+	b1 := enqueue("b")
+	a2 := enqueue("a")
+	enqueue("b")
+
+	// Só a mais antiga de cada chave é reservada
+	claimed, err := db.repo.ClaimOutbox(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{a1.ID, b1.ID}, outboxIDs(claimed))
+
+	// Com o lease ativo nada mais sai, nem as próximas das mesmas chaves
+	claimed, err = db.repo.ClaimOutbox(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	// a1 publicada libera a2; b1 falhou e continua segurando b2
+	require.NoError(t, db.repo.MarkOutboxSent(ctx, a1.ID, now))
+	require.NoError(t, db.repo.MarkOutboxFailed(ctx, b1.ID, "broker down", now.Add(time.Hour)))
+
+	claimed, err = db.repo.ClaimOutbox(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{a2.ID}, outboxIDs(claimed))
+
+	// Lease vencido: a2 volta a ser reservável por outra réplica
+	claimed, err = db.repo.ClaimOutbox(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{a2.ID}, outboxIDs(claimed))
+}
+
+func TestPostgres_ClaimOutboxSkipsRowsLockedByAnotherRelay(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 20; i++ {
+		message := &model.OutboxMessage{Topic: "payments", Key: uuid.NewString(), Payload: []byte(`{}`), CreatedAt: now.Add(-time.Minute)}
+		require.NoError(t, db.repo.EnqueueOutbox(ctx, message))
+	}
+
+	batches := make([][]*model.OutboxMessage, 4)
+	errs := concurrently(len(batches), func(i int) error {
+		var err error
+		batches[i], err = db.repo.ClaimOutbox(ctx, now, time.Minute, 10)
+		return err
+	})
+	require.NoError(t, errors.Join(errs...))
+
+	// Cada mensagem fica com um relay só
+	seen := make(map[int64]bool)
+	for _, batch := range batches {
+		for _, message := range batch {
+			assert.False(t, seen[message.ID], "message %d claimed twice", message.ID)
+			seen[message.ID] = true
+		}
+	}
+
+	// Um relay que pulou linhas travadas pode voltar com menos que o limite;
+	// o que sobrou sai na rodada seguinte
+	rest, err := db.repo.ClaimOutbox(ctx, now, time.Minute, 20)
+	require.NoError(t, err)
+	for _, message := range rest {
+		assert.False(t, seen[message.ID], "message %d claimed twice", message.ID)
+		seen[message.ID] = true
+	}
+	assert.Len(t, seen, 20)
+}
+
+func TestPostgres_DeadOutboxMessageReleasesItsKey(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	first := &model.OutboxMessage{Topic: "payments", Key: "a", Payload: []byte(`{}`), CreatedAt: now.Add(-time.Minute)}
+	second := &model.OutboxMessage{Topic: "payments", Key: "a", Payload: []byte(`{}`), CreatedAt: now.Add(-time.Minute)}
+	require.NoError(t, db.repo.EnqueueOutbox(ctx, first))
+	require.NoError(t, db.repo.EnqueueOutbox(ctx, second))
+
+	claimed, err := db.repo.ClaimOutbox(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{first.ID}, outboxIDs(claimed))
+
+	require.NoError(t, db.repo.MarkOutboxDead(ctx, first.ID, "message too large", now))
+
+	claimed, err = db.repo.ClaimOutbox(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{second.ID}, outboxIDs(claimed))
+
+	backlog, err := db.repo.GetOutboxBacklog(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), backlog.Pending)
+	assert.Equal(t, int64(1), backlog.Dead)
+}
+
+func TestPostgres_ClaimStuckPaymentsUsesHeartbeat(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	payment := db.createPayment(t, newPayment(model.PaymentStatusPending).stuck(time.Hour, 0))
+	require.NoError(t, db.repo.TransitionStatus(ctx, payment.ID, model.PaymentStatusPending, model.PaymentStatusProcessing, nil))
+
+	// A transição para processing renova o heartbeat
+	_, err := db.pool.Exec(ctx, `UPDATE payments SET heartbeat_at = $2 WHERE id = $1`, payment.ID, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+
+	// Atualizações que não são do processamento renovam updated_at pelo
+	// trigger, mas não escondem o pagamento parado
+	require.NoError(t, db.repo.RecordAcquirerResponse(ctx, payment.ID, "mock", &model.AcquirerResponse{ResponseCode: model.ResponseDoNotHonor}))
+
+	claim := func() []*model.Payment {
+		var claimed []*model.Payment
+		require.NoError(t, db.repo.WithTx(ctx, func(tx repository.PaymentRepository) error {
+			var err error
+			claimed, err = tx.ClaimStuckPayments(ctx, model.PaymentStatusProcessing, time.Now().Add(-time.Minute), 10)
+			return err
+		}))
+		return claimed
+	}
+
+	claimed := claim()
+	require.Len(t, claimed, 1)
+	assert.Equal(t, payment.ID, claimed[0].ID)
+
+	// Uma nova tentativa é sinal de vida
+	_, err = db.repo.RecordProcessingAttempt(ctx, payment.ID)
+	require.NoError(t, err)
+	assert.Empty(t, claim())
+}
+
+func TestPostgres_SandboxAccountsHaveOpeningBalances(t *testing.T) {
+	db := openTestDB(t)
+
+	var accountID string
+	var available, ledgerBalance, credited int64
+	err := db.pool.QueryRow(context.Background(), `
+		SELECT a.id::text, a.available_balance, la.balance,
+		       (SELECT COALESCE(SUM(p.amount), 0) FROM postings p
+		        WHERE p.ledger_account_id = la.id AND p.direction = 'credit')
+		FROM accounts a
+		JOIN ledger_accounts la ON la.type = 'cardholder' AND la.owner_id = a.id::text
+		WHERE a.card_number = '4242424242424242'
+	`).Scan(&accountID, &available, &ledgerBalance, &credited)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(100000), available)
+	assert.Equal(t, int64(100000), ledgerBalance)
+	assert.Equal(t, int64(100000), credited, "opening balance of account %s", accountID)
+}
+
+func TestPostgres_TraceNumbersAreUniqueAcrossReplicas(t *testing.T) {
+	db := openTestDB(t)
+	// Cada gateway é uma réplica com a sua origem, todas sobre a mesma sequência
+	sources := make([]gateway.TraceNumberSource, 4)
+	for i := range sources {
+		sources[i] = repository.NewTraceNumberRepository(db.pool)
+	}
+
+	numbers := make([][]int64, len(sources))
+	errs := concurrently(len(sources), func(i int) error {
+		for j := 0; j < 25; j++ {
+			stan, err := sources[i].NextTraceNumber(context.Background())
+			if err != nil {
+				return err
+			}
+			numbers[i] = append(numbers[i], stan)
+		}
+		return nil
+	})
+	require.NoError(t, errors.Join(errs...))
+
+	seen := make(map[int64]bool)
+	for _, batch := range numbers {
+		for _, stan := range batch {
+			assert.False(t, seen[stan], "STAN %d issued twice", stan)
+			seen[stan] = true
+		}
+	}
+	assert.Len(t, seen, 100)
+}
+
+func outboxIDs(messages []*model.OutboxMessage) []int64 {
+	ids := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+func TestPostgres_IdempotencyLeaseIsReclaimedAndFenced(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	store := repository.NewIdempotencyRepository(db.pool)
+	now := time.Now()
+
+	reserve := func(at time.Time, hash string) (*model.IdempotencyKey, bool) {
+		lockedUntil := at.Add(30 * time.Second)
+		record, reserved, err := store.Reserve(ctx, &model.IdempotencyKey{
+			MerchantID: "merchant123", Key: "key-1", RequestHash: hash,
+			CreatedAt: at, ExpiresAt: at.Add(time.Hour), LockedUntil: &lockedUntil,
+		})
+		require.NoError(t, err)
+		return record, reserved
+	}
+
+	first, reserved := reserve(now, "hash-a")
+	require.True(t, reserved)
+
+	// Dentro do lease a repetição vê a requisição em andamento
+	_, reserved = reserve(now.Add(10*time.Second), "hash-a")
+	assert.False(t, reserved)
+
+	// Lease vencido: outro corpo continua recusado, o mesmo reserva de novo
+	_, reserved = reserve(now.Add(time.Minute), "hash-b")
+	assert.False(t, reserved)
+	second, reserved := reserve(now.Add(time.Minute), "hash-a")
+	require.True(t, reserved)
+
+	// Quem perdeu o lease não grava a resposta nem libera a chave
+	assert.ErrorIs(t, store.Complete(ctx, first, 201, []byte(`{}`)), repository.ErrIdempotencyKeyNotFound)
+	require.NoError(t, store.Release(ctx, first))
+	require.NoError(t, store.Complete(ctx, second, 201, []byte(`{"id":"second"}`)))
+
+	existing, reserved := reserve(now.Add(2*time.Minute), "hash-a")
+	assert.False(t, reserved)
+	assert.True(t, existing.IsCompleted())
+	assert.Equal(t, `{"id":"second"}`, string(existing.ResponseBody))
+}
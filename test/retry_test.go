@@ -0,0 +1,191 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/queue"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newRetryPolicy() queue.RetryPolicy {
+	return queue.RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    5 * time.Minute,
+		Tiers:       []time.Duration{30 * time.Second, 5 * time.Minute},
+	}
+}
+
+func TestRetryPolicy_BackoffIsExponentialAndCapped(t *testing.T) {
+	policy := newRetryPolicy()
+
+	assert.Equal(t, 30*time.Second, policy.Backoff(1))
+	assert.Equal(t, time.Minute, policy.Backoff(2))
+	assert.Equal(t, 2*time.Minute, policy.Backoff(3))
+	assert.Equal(t, 4*time.Minute, policy.Backoff(4))
+	assert.Equal(t, 5*time.Minute, policy.Backoff(5))
+	assert.Equal(t, 5*time.Minute, policy.Backoff(40))
+}
+
+func TestRetryPolicy_BackoffJitterStaysInRange(t *testing.T) {
+	policy := newRetryPolicy()
+	policy.Jitter = 0.2
+
+	for i := 0; i < 100; i++ {
+		delay := policy.Backoff(2)
+		assert.GreaterOrEqual(t, delay, 48*time.Second)
+		assert.LessOrEqual(t, delay, 72*time.Second)
+	}
+}
+
+func TestRetryPolicy_TierNeverReleasesEarly(t *testing.T) {
+	policy := newRetryPolicy()
+
+	assert.Equal(t, 30*time.Second, policy.Tier(25*time.Second))
+	assert.Equal(t, 30*time.Second, policy.Tier(4*time.Minute))
+	assert.Equal(t, 5*time.Minute, policy.Tier(5*time.Minute))
+
+	assert.Equal(t, "payment-processing.retry.30s", queue.RetryTopic("payment-processing", 30*time.Second))
+	assert.Equal(t, "payment-processing.retry.5m", queue.RetryTopic("payment-processing", 5*time.Minute))
+}
+
+func TestRetryPolicy_WithoutTiersIsRejected(t *testing.T) {
+	policy := newRetryPolicy()
+	policy.Tiers = nil
+
+	assert.ErrorIs(t, policy.Validate(), queue.ErrInvalidRetryPolicy)
+	assert.Zero(t, policy.Tier(time.Minute))
+
+	_, err := queue.NewRetryQueue(new(MockKafkaProducer), "payment-processing", policy)
+	assert.ErrorIs(t, err, queue.ErrInvalidRetryPolicy)
+
+	policy.Tiers = []time.Duration{0}
+	assert.ErrorIs(t, policy.Validate(), queue.ErrInvalidRetryPolicy)
+}
+
+func TestRetryQueue_SchedulePublishesToTierWithRetryAt(t *testing.T) {
+	mockProducer := new(MockKafkaProducer)
+	retries, err := queue.NewRetryQueue(mockProducer, "payment-processing", newRetryPolicy())
+	require.NoError(t, err)
+
+	message := kafka.Message{Topic: "payment-processing", Partition: 1, Offset: 10, Key: []byte("tok_abc"), Value: []byte(`{}`)}
+	failedAt := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	var headers []kafka.Header
+	mockProducer.On("Publish", mock.Anything, "payment-processing.retry.30s", message.Key, message.Value, mock.Anything).
+		Run(func(args mock.Arguments) { headers = args.Get(4).([]kafka.Header) }).Return(nil)
+
+	topic, err := retries.Schedule(context.Background(), message, &model.MessageFailure{
+		Class:    model.DeadLetterProcessingError,
+		Err:      errors.New("connection reset"),
+		Attempts: 2,
+		FailedAt: failedAt,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "payment-processing.retry.30s", topic)
+
+	scheduled := kafka.Message{Headers: headers}
+	assert.Equal(t, 2, queue.MessageAttempts(scheduled))
+	assert.True(t, failedAt.Add(time.Minute).Equal(queue.RetryAt(scheduled)))
+}
+
+func TestRetryForwarder_ForwardsToMainTopicWithoutRetryAt(t *testing.T) {
+	mockProducer := new(MockKafkaProducer)
+	forwarder := queue.NewRetryForwarder([]string{"localhost:9092"}, "payment-processing.retry.30s",
+		"payment-processor-retry", "payment-processing", mockProducer, logrus.New())
+	defer forwarder.Close()
+
+	message := kafka.Message{
+		Topic: "payment-processing.retry.30s",
+		Key:   []byte("tok_abc"),
+		Value: []byte(`{}`),
+		Headers: []kafka.Header{
+			{Key: queue.HeaderAttempts, Value: []byte("1")},
+			{Key: queue.HeaderRetryAt, Value: []byte(time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano))},
+		},
+	}
+
+	var headers []kafka.Header
+	mockProducer.On("Publish", mock.Anything, "payment-processing", message.Key, message.Value, mock.Anything).
+		Run(func(args mock.Arguments) { headers = args.Get(4).([]kafka.Header) }).Return(nil)
+
+	require.NoError(t, forwarder.Forward(context.Background(), message))
+
+	// A contagem de tentativas segue com a mensagem de volta ao tópico principal
+	forwarded := kafka.Message{Headers: headers}
+	assert.Equal(t, 1, queue.MessageAttempts(forwarded))
+	assert.True(t, queue.RetryAt(forwarded).IsZero())
+}
+
+func TestRetryForwarder_WaitsUntilRetryAt(t *testing.T) {
+	mockProducer := new(MockKafkaProducer)
+	forwarder := queue.NewRetryForwarder([]string{"localhost:9092"}, "payment-processing.retry.30s",
+		"payment-processor-retry", "payment-processing", mockProducer, logrus.New())
+	defer forwarder.Close()
+
+	message := kafka.Message{
+		Headers: []kafka.Header{
+			{Key: queue.HeaderRetryAt, Value: []byte(time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano))},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := forwarder.Forward(ctx, message)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	mockProducer.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPaymentService_ProcessPayment_TransientFailureIsRetried(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithMaxAttempts(3))
+
+	paymentID := uuid.New()
+
+	mockRepo.On("TransitionStatus", mock.Anything, paymentID, model.PaymentStatusPending, model.PaymentStatusProcessing, (*string)(nil)).Return(nil)
+	mockRepo.On("RecordProcessingAttempt", mock.Anything, paymentID).Return(1, nil)
+	mockRepo.On("GetByID", mock.Anything, paymentID).Return((*model.Payment)(nil), errors.New("timeout: context deadline exceeded"))
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), paymentID.String(), nil)
+
+	// Com tentativas restantes o pagamento continua em processing
+	assert.True(t, model.IsRetryable(err))
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, paymentID, model.PaymentStatusProcessing, model.PaymentStatusFailed, mock.Anything)
+}
+
+func TestPaymentService_ProcessPayment_LastAttemptFailsPayment(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithMaxAttempts(3))
+
+	paymentID := uuid.New()
+
+	// Retomada de um pagamento deixado em processing pela tentativa anterior
+	mockRepo.On("TransitionStatus", mock.Anything, paymentID, model.PaymentStatusPending, model.PaymentStatusProcessing, (*string)(nil)).
+		Return(&model.TransitionError{PaymentID: paymentID.String(), From: model.PaymentStatusPending, To: model.PaymentStatusProcessing, Current: model.PaymentStatusProcessing})
+	mockRepo.On("RecordProcessingAttempt", mock.Anything, paymentID).Return(3, nil)
+	mockRepo.On("GetByID", mock.Anything, paymentID).Return((*model.Payment)(nil), errors.New("timeout: context deadline exceeded"))
+	mockRepo.On("TransitionStatus", mock.Anything, paymentID, model.PaymentStatusProcessing, model.PaymentStatusFailed, mock.AnythingOfType("*string")).Return(nil)
+	mockRepo.On("ReleaseHold", mock.Anything, paymentID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), paymentID.String(), nil)
+
+	assert.ErrorIs(t, err, model.ErrRetriesExhausted)
+	assert.False(t, model.IsRetryable(err))
+	mockRepo.AssertExpectations(t)
+}
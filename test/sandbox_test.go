@@ -0,0 +1,156 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/gateway"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Cartão e valor sem regra no sandbox
+const (
+	sandboxPlainPAN    = "4111111111111111"
+	sandboxPlainAmount = 10000
+)
+
+func TestSandbox_Outcomes(t *testing.T) {
+	tests := []struct {
+		name     string
+		pan      string
+		amount   int64
+		outcome  gateway.SandboxOutcome
+		approved bool
+		code     model.AcquirerResponseCode
+		err      error
+	}{
+		{"approve card", "4242424242424242", 10000, gateway.SandboxApprove, true, model.ResponseApproved, nil},
+		{"approve card wins over amount", "4242424242424242", 10051, gateway.SandboxApprove, true, model.ResponseApproved, nil},
+		{"plain card and amount", sandboxPlainPAN, sandboxPlainAmount, gateway.SandboxApprove, true, model.ResponseApproved, nil},
+		{"insufficient funds card", "4000000000009995", 10000, gateway.SandboxInsufficientFunds, false, model.ResponseInsufficientFunds, nil},
+		{"stolen card", "4000000000009979", 10000, gateway.SandboxStolenCard, false, model.ResponseStolenCard, nil},
+		{"do not honor card", "4000000000000002", 10000, gateway.SandboxDoNotHonor, false, model.ResponseDoNotHonor, nil},
+		{"3ds challenge card", "4000000000003220", 10000, gateway.SandboxChallenge, false, model.ResponseAuthenticationRequired, nil},
+		{"timeout card", "4000000000000069", 10000, gateway.SandboxTimeout, false, "", gateway.ErrTimeout},
+		{"network error card", "4000000000000119", 10000, gateway.SandboxNetworkError, false, "", gateway.ErrNetwork},
+		{"amount ending in 05", sandboxPlainPAN, 10005, gateway.SandboxDoNotHonor, false, model.ResponseDoNotHonor, nil},
+		{"amount ending in 43", sandboxPlainPAN, 10043, gateway.SandboxStolenCard, false, model.ResponseStolenCard, nil},
+		{"amount ending in 51", sandboxPlainPAN, 10051, gateway.SandboxInsufficientFunds, false, model.ResponseInsufficientFunds, nil},
+		{"amount ending in 65", sandboxPlainPAN, 10065, gateway.SandboxChallenge, false, model.ResponseAuthenticationRequired, nil},
+		{"amount ending in 68", sandboxPlainPAN, 10068, gateway.SandboxTimeout, false, "", gateway.ErrTimeout},
+		{"amount ending in 96", sandboxPlainPAN, 10096, gateway.SandboxNetworkError, false, "", gateway.ErrNetwork},
+		{"magic card wins over amount", "4000000000000002", 10051, gateway.SandboxDoNotHonor, false, model.ResponseDoNotHonor, nil},
+	}
+
+	sandbox := gateway.NewSandbox(gateway.WithLatencyProfile(gateway.LatencyNone), gateway.WithSandboxTimeout(0))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount := model.NewMoney(tt.amount, "BRL")
+			assert.Equal(t, tt.outcome, gateway.SandboxOutcomeFor(tt.pan, amount))
+
+			response, err := sandbox.Authorize(context.Background(), &model.AuthorizationRequest{
+				PaymentID: uuid.New(),
+				Amount:    amount,
+				Card:      &model.Card{Number: tt.pan},
+			})
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				// Falhas sem resposta são transitórias
+				assert.ErrorIs(t, err, gateway.ErrUnavailable)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.approved, response.Approved)
+			assert.Equal(t, tt.code, response.ResponseCode)
+			if tt.approved {
+				assert.Len(t, response.ApprovalCode, 6)
+				assert.Empty(t, response.DeclineReason)
+			} else {
+				assert.Empty(t, response.ApprovalCode)
+				assert.NotEmpty(t, response.DeclineReason)
+			}
+		})
+	}
+}
+
+func TestSandbox_ResponsesAreDeterministic(t *testing.T) {
+	sandbox := gateway.NewSandbox(gateway.WithLatencyProfile(gateway.LatencyNone))
+	req := &model.AuthorizationRequest{PaymentID: uuid.New(), Amount: model.NewMoney(10000, "BRL"), Card: &model.Card{Number: "4242424242424242"}}
+
+	first, err := sandbox.Authorize(context.Background(), req)
+	require.NoError(t, err)
+	second, err := sandbox.Authorize(context.Background(), req)
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+
+	capture, err := sandbox.Capture(context.Background(), &model.AcquirerRequest{PaymentID: req.PaymentID, NetworkReference: first.NetworkReference})
+	require.NoError(t, err)
+	assert.True(t, capture.Approved)
+	assert.Equal(t, first.NetworkReference, capture.NetworkReference)
+}
+
+func TestSandbox_LatencyProfiles(t *testing.T) {
+	tests := []struct {
+		profile string
+		valid   bool
+	}{
+		{"none", true},
+		{"fast", true},
+		{"normal", true},
+		{"slow", true},
+		{"instant", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			_, err := gateway.ParseSandboxLatency(tt.profile)
+			assert.Equal(t, tt.valid, err == nil)
+		})
+	}
+
+	// A latência respeita o cancelamento do contexto
+	sandbox := gateway.NewSandbox(gateway.WithSandboxLatency(time.Hour, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := sandbox.Authorize(ctx, &model.AuthorizationRequest{Amount: model.NewMoney(100, "BRL")})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPaymentService_ProcessPayment_SandboxChallengeFailsPayment(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	sandbox := gateway.NewSandbox(gateway.WithLatencyProfile(gateway.LatencyNone))
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(sandbox))
+
+	// Valor terminado em 65: o emissor pede a autenticação 3DS
+	payment := &model.Payment{ID: uuid.New(), MerchantID: "merchant123", CardToken: "tok_abc", Amount: model.NewMoney(10065, "BRL"), Status: model.PaymentStatusProcessing}
+
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusPending, model.PaymentStatusProcessing, (*string)(nil)).Return(nil)
+	mockRepo.On("RecordProcessingAttempt", mock.Anything, payment.ID).Return(1, nil)
+	mockRepo.On("GetByID", mock.Anything, payment.ID).Return(payment, nil)
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: sandboxPlainPAN}, nil)
+	mockRepo.On("RecordAcquirerResponse", mock.Anything, payment.ID, gateway.SandboxName, mock.MatchedBy(func(response *model.AcquirerResponse) bool {
+		return response.ResponseCode == model.ResponseAuthenticationRequired
+	})).Return(nil)
+	mockRepo.On("TransitionStatus", mock.Anything, payment.ID, model.PaymentStatusProcessing, model.PaymentStatusFailed, mock.MatchedBy(func(msg *string) bool {
+		return msg != nil && *msg == "Payment declined: Authentication required (3DS challenge)"
+	})).Return(nil)
+	mockRepo.On("ReleaseHold", mock.Anything, payment.ID, model.HoldStatusReleased).Return(&model.Hold{}, nil)
+
+	err := paymentService.ProcessPaymentAsync(context.Background(), payment.ID.String(), nil)
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPaymentService_ProcessPayment_InterruptedLeavesPaymentProcessing(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+	mockAcquirer := new(MockAcquirerGateway)
+	paymentService := service.NewPaymentService(mockRepo, mockVault, logrus.New(), service.WithAcquirer(mockAcquirer))
+
+	paymentID := uuid.New()
+	payment := &model.Payment{ID: paymentID, MerchantID: "merchant123", CardToken: "tok_abc", Amount: model.NewMoney(10000, "BRL"), Status: model.PaymentStatusProcessing}
+
+	mockRepo.On("TransitionStatus", mock.Anything, paymentID, model.PaymentStatusPending, model.PaymentStatusProcessing, (*string)(nil)).Return(nil)
+	mockRepo.On("RecordProcessingAttempt", mock.Anything, paymentID).Return(1, nil)
+	mockRepo.On("GetByID", mock.Anything, paymentID).Return(payment, nil)
+	mockVault.On("Detokenize", mock.Anything, "merchant123", "tok_abc").Return(&model.Card{Number: "4111111111111111"}, nil)
+	mockAcquirer.On("Authorize", mock.Anything, mock.Anything).Return(nil, context.Canceled)
+
+	// Prazo do shutdown vencido durante o processamento
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := paymentService.ProcessPaymentAsync(ctx, paymentID.String(), nil)
+
+	// O erro impede o commit do offset; nada foi debitado nem marcado como falho
+	assert.ErrorIs(t, err, context.Canceled)
+	mockRepo.AssertNotCalled(t, "CaptureHold", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "RecordAcquirerResponse", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "TransitionStatus", mock.Anything, paymentID, model.PaymentStatusProcessing, mock.Anything, mock.Anything)
+	mockVault.AssertExpectations(t)
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/kms"
+	"golang-payment-microservice/internal/model"
+	"golang-payment-microservice/internal/repository"
+	"golang-payment-microservice/internal/vault"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Cofre de cartões em memória
+type memoryCardRepository struct {
+	mu    sync.Mutex
+	cards []*model.VaultedCard
+}
+
+func (r *memoryCardRepository) SaveCard(_ context.Context, card *model.VaultedCard) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cards = append(r.cards, card)
+	return nil
+}
+
+func (r *memoryCardRepository) GetCardByToken(_ context.Context, token string) (*model.VaultedCard, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, card := range r.cards {
+		if card.Token == token {
+			return card, nil
+		}
+	}
+	return nil, repository.ErrCardNotFound
+}
+
+func (r *memoryCardRepository) GetCardByFingerprint(_ context.Context, merchantID string, fingerprint []byte, expiryMonth, expiryYear int) (*model.VaultedCard, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, card := range r.cards {
+		if card.MerchantID == merchantID && bytes.Equal(card.PANFingerprint, fingerprint) &&
+			card.ExpiryMonth == expiryMonth && card.ExpiryYear == expiryYear {
+			return card, nil
+		}
+	}
+	return nil, repository.ErrCardNotFound
+}
+
+func TestCVVCache_HoldsUntilReleasedOrExpired(t *testing.T) {
+	cvvs := vault.NewCVVCache(time.Minute)
+	first, second := uuid.New(), uuid.New()
+
+	cvvs.Hold(first, "123")
+	cvvs.Hold(second, "456")
+
+	// Cada pagamento tem o seu CVV, mesmo com o mesmo cartão
+	assert.Equal(t, "123", cvvs.Get(first))
+	assert.Equal(t, "456", cvvs.Get(second))
+
+	cvvs.Release(first)
+	assert.Empty(t, cvvs.Get(first))
+	assert.Equal(t, "456", cvvs.Get(second))
+
+	expiring := vault.NewCVVCache(time.Millisecond)
+	expiring.Hold(first, "123")
+	time.Sleep(5 * time.Millisecond)
+	assert.Empty(t, expiring.Get(first))
+}
+
+func newTestCardVault(t *testing.T) vault.CardVault {
+	t.Helper()
+
+	keys, err := kms.NewKeyManager(context.Background(), &memoryDataKeyStore{}, testKeyfile("mk-1"))
+	require.NoError(t, err)
+
+	return vault.NewCardVault(&memoryCardRepository{}, keys)
+}
+
+func TestCardVault_TokensAreScopedPerMerchant(t *testing.T) {
+	ctx := context.Background()
+	cardVault := newTestCardVault(t)
+	card := &model.Card{Number: "4111111111111111", ExpiryMonth: 12, ExpiryYear: 2030, CVV: "123"}
+
+	first, err := cardVault.Tokenize(ctx, "merchant123", card)
+	require.NoError(t, err)
+	again, err := cardVault.Tokenize(ctx, "merchant123", card)
+	require.NoError(t, err)
+	other, err := cardVault.Tokenize(ctx, "merchant456", card)
+	require.NoError(t, err)
+
+	// O mesmo merchant reaproveita o token; outro recebe um token próprio
+	assert.Equal(t, first.Token, again.Token)
+	assert.NotEqual(t, first.Token, other.Token)
+	// O fingerprint é o do cartão, igual nos dois merchants
+	assert.Equal(t, first.PANFingerprint, other.PANFingerprint)
+
+	detokenized, err := cardVault.Detokenize(ctx, "merchant123", first.Token)
+	require.NoError(t, err)
+	assert.Equal(t, card.Number, detokenized.Number)
+	assert.Equal(t, first.PANFingerprint, detokenized.Fingerprint)
+	assert.Empty(t, detokenized.CVV)
+
+	// O token de um merchant não resolve o cartão para outro
+	_, err = cardVault.Detokenize(ctx, "merchant456", first.Token)
+	assert.ErrorIs(t, err, vault.ErrTokenNotFound)
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang-payment-microservice/internal/queue"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPool_SameKeyRunsInOrder(t *testing.T) {
+	pool := queue.NewWorkerPool(4, 100)
+	pool.Start()
+
+	var mu sync.Mutex
+	var order []int
+	for i := 0; i < 50; i++ {
+		i := i
+		require.NoError(t, pool.Submit(context.Background(), "tok_same_card", func() {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}))
+	}
+	pool.Stop()
+
+	require.Len(t, order, 50)
+	for i := range order {
+		assert.Equal(t, i, order[i])
+	}
+}
+
+func TestWorkerPool_BoundsConcurrency(t *testing.T) {
+	pool := queue.NewWorkerPool(3, 10)
+	pool.Start()
+
+	var running, peak atomic.Int64
+	for i := 0; i < 30; i++ {
+		key := string(rune('a' + i))
+		require.NoError(t, pool.Submit(context.Background(), key, func() {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		}))
+	}
+	pool.Stop()
+
+	assert.LessOrEqual(t, peak.Load(), int64(3))
+}
+
+func TestWorkerPool_SubmitBlocksWhenQueueIsFull(t *testing.T) {
+	pool := queue.NewWorkerPool(1, 1)
+	pool.Start()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	// Ocupa o worker e a única posição da fila
+	require.NoError(t, pool.Submit(context.Background(), "k", func() {
+		close(started)
+		<-release
+	}))
+	<-started
+	require.NoError(t, pool.Submit(context.Background(), "k", func() {}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := pool.Submit(ctx, "k", func() {})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int64(1), pool.Queued())
+	assert.Equal(t, int64(1), pool.InFlight())
+
+	close(release)
+	pool.Stop()
+
+	assert.Equal(t, int64(0), pool.Queued())
+	assert.Equal(t, int64(0), pool.InFlight())
+}
+
+func TestWorkerPool_ReportsStats(t *testing.T) {
+	pool := queue.NewWorkerPool(1, 4)
+
+	var mu sync.Mutex
+	var maxQueued, maxInFlight int64
+	pool.OnStats(func(queued, inFlight int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if queued > maxQueued {
+			maxQueued = queued
+		}
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+	})
+
+	// Com os workers ainda parados, as tarefas ficam na fila
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pool.Submit(context.Background(), "k", func() {}))
+	}
+	pool.Start()
+	pool.Stop()
+
+	assert.Equal(t, int64(3), maxQueued)
+	assert.Equal(t, int64(1), maxInFlight)
+}